@@ -8,6 +8,7 @@ import (
 	"strconv"
 
 	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/types"
 )
 
 // Node represents any singular piece of a Soy template.  For example, a
@@ -95,6 +96,17 @@ func (c *NamespaceNode) String() string {
 	return "{namespace " + c.Name + "}"
 }
 
+// DelPackageNode registers the delegate package of the Soy file.  All
+// {deltemplate}s in the file belong to it.
+type DelPackageNode struct {
+	Pos
+	Name string
+}
+
+func (c *DelPackageNode) String() string {
+	return "{delpackage " + c.Name + "}"
+}
+
 type AutoescapeType int
 
 const (
@@ -102,6 +114,7 @@ const (
 	AutoescapeOn
 	AutoescapeOff
 	AutoescapeContextual
+	AutoescapeStrict
 )
 
 // TemplateNode holds a template body.
@@ -111,6 +124,28 @@ type TemplateNode struct {
 	Body       *ListNode
 	Autoescape AutoescapeType
 	Private    bool
+	Kind       data.ContentKind
+	Params     []*HeaderParamNode // header params, moved out of Body by the template registry
+	Delegate   *Delegate          // non-nil if this is a {deltemplate}
+}
+
+// Delegate identifies the delegate template implemented by a {deltemplate}.
+// The name of a deltemplate's TemplateNode is generated to be unique, since
+// there may be many implementations of the same delegate.
+type Delegate struct {
+	Name    string // the name of the delegate template, e.g. "my.delegate"
+	Variant string // the variant implemented, or "" for the default
+	Package string // the {delpackage} containing the implementation, or "" if none
+}
+
+// Param returns the header param with the given name, or nil if there is none.
+func (n *TemplateNode) Param(name string) *HeaderParamNode {
+	for _, param := range n.Params {
+		if param.Name == name {
+			return param
+		}
+	}
+	return nil
 }
 
 func (n *TemplateNode) String() string {
@@ -122,13 +157,10 @@ func (n *TemplateNode) Children() []Node {
 }
 
 // TypeNode holds a type definition for a template parameter.
-//
-// Presently this is just a string value which is not validated or processed.
-// Backwards-incompatible changes in the future may elaborate this data model to
-// add functionality.
 type TypeNode struct {
 	Pos
-	Expr string
+	Expr string     // the type expression as written, e.g. "list<string>"
+	Type types.Type // the parsed type, or nil if Expr is empty
 }
 
 func (n TypeNode) String() string {
@@ -322,6 +354,7 @@ type LetContentNode struct {
 	Pos
 	Name string
 	Body Node
+	Kind data.ContentKind
 }
 
 func (n *LetContentNode) String() string {
@@ -481,6 +514,40 @@ func (n *CallNode) Children() []Node {
 	return nodes
 }
 
+// DelCallNode calls the active implementation of a delegate template.
+type DelCallNode struct {
+	CallNode               // Name is the name of the delegate template
+	Variant           Node // the variant to call, or nil for the default
+	AllowEmptyDefault bool // true if it renders nothing when there is no implementation
+}
+
+func (n *DelCallNode) String() string {
+	var expr = fmt.Sprintf("{delcall %s", n.Name)
+	if n.Variant != nil {
+		expr += fmt.Sprintf(` variant="%s"`, n.Variant.String())
+	}
+	if n.AllData {
+		expr += ` data="all"`
+	} else if n.Data != nil {
+		expr += fmt.Sprintf(` data="%s"`, n.Data.String())
+	}
+	if n.AllowEmptyDefault {
+		expr += ` allowemptydefault="true"`
+	}
+	if n.Params == nil {
+		return expr + "/}"
+	}
+	expr += "}"
+	for _, param := range n.Params {
+		expr += param.String()
+	}
+	return expr + "{/delcall}"
+}
+
+func (n *DelCallNode) Children() []Node {
+	return append([]Node{n.Variant}, n.CallNode.Children()...)
+}
+
 type CallParamValueNode struct {
 	Pos
 	Key   string
@@ -499,6 +566,7 @@ type CallParamContentNode struct {
 	Pos
 	Key     string
 	Content Node
+	Kind    data.ContentKind
 }
 
 func (n *CallParamContentNode) String() string {
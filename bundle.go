@@ -1,18 +1,30 @@
 package soy
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/robfig/soy/ast"
 	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/errortypes"
 	"github.com/robfig/soy/parse"
 	"github.com/robfig/soy/parsepasses"
+	"github.com/robfig/soy/plugin"
 	"github.com/robfig/soy/soyhtml"
 	"github.com/robfig/soy/template"
 )
@@ -21,27 +33,103 @@ import (
 // "WatchFiles" feature.
 var Logger = log.New(os.Stderr, "[soy] ", 0)
 
-type soyFile struct{ name, content string }
+// DefaultDebounce is the default time that a watching bundle waits for
+// changes to its files to stop before recompiling.
+const DefaultDebounce = 100 * time.Millisecond
+
+type soyFile struct {
+	name, content string
+	file          bool         // true if the content was read from the named file
+	dir           *templateDir // the directory added to the bundle that contains it
+}
+
+// templateDir is a directory of templates added to a bundle.
+type templateDir struct {
+	fsys fs.FS // nil for the OS file system
+	root string
+}
+
+// osPath returns the path in the OS file system of the given file within the
+// directory, or "" if the directory is not in the OS file system.
+func (d *templateDir) osPath(name string) string {
+	switch fsys := d.fsys.(type) {
+	case nil:
+		return filepath.Join(d.root, filepath.FromSlash(name))
+	case dirFS:
+		return filepath.Join(string(fsys), filepath.FromSlash(name))
+	}
+	return ""
+}
+
+// DirFS returns a file system for the tree of files rooted at the directory
+// dir, like os.DirFS.  Templates added from it with AddTemplateFS are watched
+// for changes when WatchFiles is on, which is not possible for other file
+// systems.  This allows watching templates in development that are embedded
+// in production:
+//
+//	//go:embed views
+//	var views embed.FS
+//
+//	var fsys fs.FS = views
+//	if dev {
+//	    fsys = soy.DirFS(".")
+//	}
+//	soy.NewBundle().WatchFiles(dev).AddTemplateFS(fsys, "views")
+func DirFS(dir string) fs.FS {
+	return dirFS(dir)
+}
+
+type dirFS string
+
+func (dir dirFS) Open(name string) (fs.File, error) {
+	return os.DirFS(string(dir)).Open(name)
+}
 
 // Bundle is a collection of Soy content (templates and globals).  It acts as
 // input for the Soy compiler.
 type Bundle struct {
 	files                 []soyFile
+	dirs                  []*templateDir // directories added by AddTemplateDir or AddTemplateFS
 	globals               data.Map
 	err                   error
-	watcher               *fsnotify.Watcher
 	parsepasses           []func(template.Registry) error
+	plugins               *plugin.Set
+	funcs, directives     map[string][]int // allowed by AllowFuncs
 	recompilationCallback func(*template.Registry)
+	errorCallback         func(error)
+
+	// state for watching files
+	watcher   *fsnotify.Watcher
+	ctx       context.Context
+	debounce  time.Duration
+	mu        sync.Mutex
+	tofus     []*soyhtml.Tofu // updated with each recompiled registry
+	watching  bool            // true once the recompiler has started
+	closed    bool            // true once Close has been called
+	done      chan struct{}   // closed to stop the recompiler
+	stopped   chan struct{}   // closed when the watcher has been closed
+	closeErr  error           // the result of closing the watcher
+	closeOnce sync.Once
+
+	cacheMu sync.Mutex
+	cache   compileCache // the result of the last successful compilation
 }
 
 // NewBundle returns an empty bundle.
 func NewBundle() *Bundle {
-	return &Bundle{globals: make(data.Map)}
+	return &Bundle{
+		globals:  make(data.Map),
+		ctx:      context.Background(),
+		debounce: DefaultDebounce,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
 }
 
 // WatchFiles tells Soy to watch any template files added to this bundle,
 // re-compile as necessary, and propagate the updates to your tofu.  It should
-// be called once, before adding any files.
+// be called once, before adding any files.  Watching continues until the
+// bundle is closed.
 func (b *Bundle) WatchFiles(watch bool) *Bundle {
 	if watch && b.err == nil && b.watcher == nil {
 		b.watcher, b.err = fsnotify.NewWatcher()
@@ -49,26 +137,110 @@ func (b *Bundle) WatchFiles(watch bool) *Bundle {
 	return b
 }
 
+// WatchFilesContext is like WatchFiles(true), but watching also stops when the
+// given context is done.
+func (b *Bundle) WatchFilesContext(ctx context.Context) *Bundle {
+	b.ctx = ctx
+	return b.WatchFiles(true)
+}
+
+// SetDebounce sets the time that a watching bundle waits after a change to its
+// files before recompiling, so that a burst of changes, as made by editors
+// when saving, results in one recompilation.  The default is DefaultDebounce.
+func (b *Bundle) SetDebounce(d time.Duration) *Bundle {
+	b.debounce = d
+	return b
+}
+
+// Close stops watching files for changes, waiting for any recompilation in
+// progress to complete.  It is safe to call more than once, and has no effect
+// on bundles that are not watching files.
+func (b *Bundle) Close() error {
+	if b.watcher == nil {
+		return nil
+	}
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		var watching = b.watching
+		b.closed = true
+		b.mu.Unlock()
+		close(b.done)
+		if !watching {
+			b.closeErr = b.watcher.Close()
+			close(b.stopped)
+		}
+	})
+	<-b.stopped
+	return b.closeErr
+}
+
 // AddTemplateDir adds all *.soy files found within the given directory
-// (including sub-directories) to the bundle.
+// (including sub-directories) to the bundle.  If WatchFiles is on, the
+// directory will be subsequently watched for updates, including files that are
+// created, deleted or renamed within it.
 func (b *Bundle) AddTemplateDir(root string) *Bundle {
-	var err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	return b.addTemplateDir(&templateDir{nil, root})
+}
+
+// AddTemplateFS adds all *.soy files found within the given directory
+// (including sub-directories) of the given file system to the bundle.  The
+// files are named by their path within the file system.  If WatchFiles is on
+// and the file system was returned by DirFS, the directory will be
+// subsequently watched for updates as by AddTemplateDir.
+func (b *Bundle) AddTemplateFS(fsys fs.FS, root string) *Bundle {
+	return b.addTemplateDir(&templateDir{fsys, root})
+}
+
+func (b *Bundle) addTemplateDir(dir *templateDir) *Bundle {
+	var files, err = b.walkDir(dir)
+	if err != nil {
+		b.err = err
+	}
+	b.dirs = append(b.dirs, dir)
+	b.files = append(b.files, files...)
+	return b
+}
+
+// walkDir reads all *.soy files found within the given directory.  If
+// WatchFiles is on, the directory and its sub-directories are watched.
+func (b *Bundle) walkDir(dir *templateDir) ([]soyFile, error) {
+	var fsys, root = dir.fsys, dir.root
+	if fsys == nil {
+		fsys, root = os.DirFS(dir.root), "."
+	}
+	var files []soyFile
+	var err = fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
+			// Files may be deleted while walking when recompiling.
+			if errors.Is(err, fs.ErrNotExist) && path != root {
+				return nil
+			}
 			return err
 		}
-		if info.IsDir() {
+		if d.IsDir() {
+			if osPath := dir.osPath(path); b.watcher != nil && osPath != "" {
+				return b.watcher.Add(osPath)
+			}
 			return nil
 		}
 		if !strings.HasSuffix(path, ".soy") {
 			return nil
 		}
-		b.AddTemplateFile(path)
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		var name = path
+		if dir.fsys == nil {
+			name = dir.osPath(path)
+		}
+		files = append(files, soyFile{name, string(content), true, dir})
 		return nil
 	})
-	if err != nil {
-		b.err = err
-	}
-	return b
+	return files, err
 }
 
 // AddTemplateFile adds the given Soy template file text to this bundle.
@@ -81,14 +253,15 @@ func (b *Bundle) AddTemplateFile(filename string) *Bundle {
 	if b.err == nil && b.watcher != nil {
 		b.err = b.watcher.Add(filename)
 	}
-	return b.AddTemplateString(filename, string(content))
+	b.files = append(b.files, soyFile{filename, string(content), true, nil})
+	return b
 }
 
 // AddTemplateString adds the given template to the bundle. The name is only
 // used for error messages - it does not need to be provided nor does it need to
 // be a real filename.
 func (b *Bundle) AddTemplateString(filename, soyfile string) *Bundle {
-	b.files = append(b.files, soyFile{filename, soyfile})
+	b.files = append(b.files, soyFile{filename, soyfile, false, nil})
 	return b
 }
 
@@ -100,6 +273,21 @@ func (b *Bundle) AddGlobalsFile(filename string) *Bundle {
 		b.err = err
 		return b
 	}
+	return b.addGlobals(f)
+}
+
+// AddGlobalsFS opens and parses the named file of the given file system for
+// Soy globals, and adds the resulting data map to the bundle.
+func (b *Bundle) AddGlobalsFS(fsys fs.FS, name string) *Bundle {
+	var f, err = fsys.Open(name)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.addGlobals(f)
+}
+
+func (b *Bundle) addGlobals(f io.ReadCloser) *Bundle {
 	globals, err := ParseGlobals(f)
 	if err != nil {
 		b.err = err
@@ -126,6 +314,14 @@ func (b *Bundle) SetRecompilationCallback(c func(*template.Registry)) *Bundle {
 	return b
 }
 
+// SetErrorCallback assigns the bundle a function to call with any error
+// encountered while watching files or recompiling, in addition to logging it.
+// The in-use registry is not updated when recompilation fails.
+func (b *Bundle) SetErrorCallback(c func(error)) *Bundle {
+	b.errorCallback = c
+	return b
+}
+
 // AddParsePass adds a function to the bundle that will be called
 // after the Soy is parsed.
 func (b *Bundle) AddParsePass(f func(template.Registry) error) *Bundle {
@@ -133,91 +329,489 @@ func (b *Bundle) AddParsePass(f func(template.Registry) error) *Bundle {
 	return b
 }
 
+// AddPlugins adds the given functions and print directives to those available
+// to the templates, in each Tofu returned by CompileToTofu.  Their calls are
+// checked against the argument and result types they declare.
+func (b *Bundle) AddPlugins(set *plugin.Set) *Bundle {
+	// The templates already compiled were not checked against the plugins.
+	b.cacheMu.Lock()
+	b.cache = compileCache{}
+	b.cacheMu.Unlock()
+	if b.plugins == nil {
+		b.plugins = plugin.NewSet()
+	}
+	for _, fn := range set.Funcs {
+		b.plugins.AddFuncs(fn)
+	}
+	for _, d := range set.PrintDirectives {
+		b.plugins.AddPrintDirectives(d)
+	}
+	return b
+}
+
+// AllowFuncs adds to the functions and print directives that the templates may
+// call, besides those of soyhtml and the plugins, e.g. those of another backend
+// such as soyjs.  Each name is mapped to its valid numbers of arguments, or to
+// nil if any number is valid.
+func (b *Bundle) AllowFuncs(funcs, directives map[string][]int) *Bundle {
+	if b.funcs == nil {
+		b.funcs, b.directives = make(map[string][]int), make(map[string][]int)
+	}
+	for name, lengths := range funcs {
+		addArities(b.funcs, name, lengths)
+	}
+	for name, lengths := range directives {
+		addArities(b.directives, name, lengths)
+	}
+	return b
+}
+
 // Compile parses all of the Soy files in this bundle, verifies a number of
-// rules about data references, and returns the completed template registry.
+// rules about data references and function calls, and returns the completed
+// template registry.  The functions and print directives called must be those
+// of soyhtml, plugins added to the bundle, or those allowed by AllowFuncs.  The
+// soyhtml ones are the defaults, or if the bundle is updating any Tofus as its
+// files change, those available in every one of them.
+//
+// If the bundle is watching files, the returned registry is not modified when
+// they change.  Instead, the recompiled registries are passed to the
+// recompilation callback and to each Tofu returned by CompileToTofu.
 func (b *Bundle) Compile() (*template.Registry, error) {
+	b.mu.Lock()
+	var tofus = append([]*soyhtml.Tofu(nil), b.tofus...)
+	b.mu.Unlock()
+	return b.compileFor(tofus)
+}
+
+// compileFor compiles the bundle's files for rendering by the given Tofus,
+// and starts watching the files if requested.
+func (b *Bundle) compileFor(tofus []*soyhtml.Tofu) (*template.Registry, error) {
 	if b.err != nil {
 		return nil, b.err
 	}
+	var registry, err = b.compile(b.files, tofus)
+	if err != nil {
+		return nil, err
+	}
+	if b.watcher != nil {
+		b.mu.Lock()
+		if !b.watching && !b.closed {
+			b.watching = true
+			go b.recompiler()
+		}
+		b.mu.Unlock()
+	}
+	return registry, nil
+}
 
-	// Compile all the Soy (globals are already parsed).
-	var registry = template.Registry{}
-	for _, soyfile := range b.files {
-		var tree, err = parse.SoyFile(soyfile.name, soyfile.content)
-		if err != nil {
-			return nil, err
+// ErrStaleArtifact is returned by ReadArtifact if the artifact was not compiled
+// from the Soy files of the bundle, as they are now.
+var ErrStaleArtifact = errors.New("artifact does not match the Soy files of the bundle")
+
+// ReadArtifact returns the registry of templates in the given artifact, which
+// was written by template.Registry.WriteArtifact, instead of compiling the
+// bundle.  The artifact must have been compiled from the same Soy files as the
+// bundle, or else ErrStaleArtifact is returned.  The globals and parse passes of the bundle are not applied, since
+// those of the bundle that compiled the artifact already were, and the files
+// are not watched.
+func (b *Bundle) ReadArtifact(r io.Reader) (*template.Registry, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	var registry, err = template.ReadArtifact(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(registry.SoyFiles) != len(b.files) {
+		return nil, ErrStaleArtifact
+	}
+	for i, soyfile := range registry.SoyFiles {
+		if soyfile.Name != b.files[i].name || soyfile.Text != b.files[i].content {
+			return nil, ErrStaleArtifact
 		}
-		if err = registry.Add(tree); err != nil {
-			return nil, err
+	}
+	return registry, nil
+}
+
+// compile parses and checks the given Soy files.  Files that have not changed
+// since the last compilation are not parsed again, and only the templates
+// affected by the changes are checked (see compileCache).  Changed files are
+// parsed concurrently, and all errors found are returned as an ErrList.
+func (b *Bundle) compile(files []soyFile, tofus []*soyhtml.Tofu) (*template.Registry, error) {
+	b.cacheMu.Lock()
+	defer b.cacheMu.Unlock()
+
+	// Find the files that have not changed since the last compilation.
+	var (
+		hashes = make([][sha256.Size]byte, len(files))
+		reused = make([]bool, len(files))
+		names  = make(map[string]bool)
+	)
+	for i, soyfile := range files {
+		hashes[i] = sha256.Sum256([]byte(soyfile.content))
+		if _, ok := b.cache.reusable(soyfile.name, hashes[i]); ok && len(b.parsepasses) == 0 {
+			reused[i] = !names[soyfile.name]
 		}
+		names[soyfile.name] = true
 	}
 
+	// Parse the Soy that has changed (globals are already parsed).
+	var trees, parseErrs = parseFiles(files, reused)
+
+	var (
+		registry = template.Registry{}
+		cache    = compileCache{registry: &registry, files: make(map[string]cachedFile)}
+		changed  []template.Template
+		errs     errortypes.ErrList
+	)
+	for i, soyfile := range files {
+		if reused[i] {
+			var prev = b.cache.files[soyfile.name]
+			if err := registry.AddFrom(b.cache.registry, prev.node); err != nil {
+				errs = append(errs, fileErrors(soyfile.name, err)...)
+				continue
+			}
+			cache.files[soyfile.name] = prev
+			continue
+		}
+		if parseErrs[i] != nil {
+			errs = append(errs, fileErrors(soyfile.name, parseErrs[i])...)
+			continue
+		}
+		var numTemplates = len(registry.Templates)
+		if err := registry.Add(trees[i]); err != nil {
+			errs = append(errs, fileErrors(soyfile.name, err)...)
+			continue
+		}
+		changed = append(changed, registry.Templates[numTemplates:]...)
+		cache.files[soyfile.name] = cachedFile{hashes[i], trees[i]}
+	}
+	if len(errs) > 0 {
+		return nil, errs.Err()
+	}
+	var affected = b.cache.affected(&registry, changed)
+
 	// Apply the post-parse processing
 	for _, parsepass := range b.parsepasses {
 		if err := parsepass(registry); err != nil {
 			return nil, err
 		}
 	}
-	if err := parsepasses.CheckDataRefs(registry); err != nil {
-		return nil, err
+	var err = parsepasses.CheckTemplateDataRefs(registry, affected)
+	errs = append(errs, errortypes.ToErrList(err)...)
+	err = parsepasses.SetTemplateGlobals(registry, changed, b.globals)
+	errs = append(errs, errortypes.ToErrList(err)...)
+	err = parsepasses.CheckTemplateTypes(registry, affected, b.plugins)
+	errs = append(errs, errortypes.ToErrList(err)...)
+	cache.funcs, cache.directives = b.funcArities(tofus)
+	var funcChecked = changed
+	if !reflect.DeepEqual(cache.funcs, b.cache.funcs) || !reflect.DeepEqual(cache.directives, b.cache.directives) {
+		funcChecked = registry.Templates
 	}
-	if err := parsepasses.SetGlobals(registry, b.globals); err != nil {
-		return nil, err
+	err = parsepasses.CheckTemplateFuncs(registry, funcChecked, cache.funcs, cache.directives)
+	errs = append(errs, errortypes.ToErrList(err)...)
+	if len(errs) > 0 {
+		return nil, errs.Err()
 	}
-	parsepasses.ProcessMessages(registry)
+	parsepasses.ProcessMessages(template.Registry{Templates: changed})
 
-	if b.watcher != nil {
-		go b.recompiler(&registry)
-	}
+	b.cache = cache
 	return &registry, nil
 }
 
+// parseFiles parses the given Soy files concurrently, except for those that
+// are to be reused.  The results are in the order of the files.
+func parseFiles(files []soyFile, reused []bool) ([]*ast.SoyFileNode, []error) {
+	var (
+		trees = make([]*ast.SoyFileNode, len(files))
+		errs  = make([]error, len(files))
+		work  = make(chan int)
+		wg    sync.WaitGroup
+	)
+	for n := runtime.GOMAXPROCS(0); n > 0; n-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				trees[i], errs[i] = parse.SoyFile(files[i].name, files[i].content)
+			}
+		}()
+	}
+	for i := range files {
+		if !reused[i] {
+			work <- i
+		}
+	}
+	close(work)
+	wg.Wait()
+	return trees, errs
+}
+
+// fileErrors returns the errors in the given error, which was found in the
+// named file.  Errors without a position are given the position of the file.
+func fileErrors(name string, err error) errortypes.ErrList {
+	if errs := errortypes.ToErrList(err); errs != nil {
+		return errs
+	}
+	return errortypes.ErrList{errortypes.ToErrFilePos(
+		errortypes.NewErrFilePosf(name, 0, 0, "%v", err))}
+}
+
+// funcArities returns the valid numbers of arguments of the functions and print
+// directives that may be called by the templates, by name.  Those of soyhtml
+// are the ones available in every given Tofu, or the defaults if there are
+// none.
+func (b *Bundle) funcArities(tofus []*soyhtml.Tofu) (funcs, directives map[string][]int) {
+	if len(tofus) == 0 {
+		funcs, directives = soyhtml.NewTofu(nil).Arities()
+	} else {
+		funcs, directives = tofus[0].Arities()
+		for _, tofu := range tofus[1:] {
+			var tofuFuncs, tofuDirectives = tofu.Arities()
+			intersectArities(funcs, tofuFuncs)
+			intersectArities(directives, tofuDirectives)
+		}
+	}
+	for name, lengths := range b.funcs {
+		addArities(funcs, name, lengths)
+	}
+	for name, lengths := range b.directives {
+		addArities(directives, name, lengths)
+	}
+	if b.plugins != nil {
+		for name, fn := range b.plugins.Funcs {
+			addArities(funcs, name, fn.ValidArgLengths())
+		}
+		for name, d := range b.plugins.PrintDirectives {
+			addArities(directives, name, d.ValidArgLengths())
+		}
+	}
+	return funcs, directives
+}
+
+// intersectArities removes from m the functions or directives that are not in
+// other, and the numbers of arguments that other does not allow.
+func intersectArities(m, other map[string][]int) {
+	for name, lengths := range m {
+		var otherLengths, ok = other[name]
+		switch {
+		case !ok:
+			delete(m, name)
+		case otherLengths == nil:
+		case lengths == nil:
+			m[name] = otherLengths
+		default:
+			var common = []int{}
+			for _, n := range lengths {
+				if containsInt(otherLengths, n) {
+					common = append(common, n)
+				}
+			}
+			m[name] = common
+		}
+	}
+}
+
+// addArities adds the given numbers of arguments to those valid for the named
+// function or directive in m.
+func addArities(m map[string][]int, name string, lengths []int) {
+	var existing, ok = m[name]
+	if !ok {
+		m[name] = lengths
+		return
+	}
+	if existing == nil || lengths == nil {
+		m[name] = nil
+		return
+	}
+	var all = append([]int(nil), existing...)
+	for _, n := range lengths {
+		if !containsInt(all, n) {
+			all = append(all, n)
+		}
+	}
+	sort.Ints(all)
+	m[name] = all
+}
+
+func containsInt(slice []int, n int) bool {
+	for _, m := range slice {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
 // CompileToTofu returns a soyhtml.Tofu object that allows you to render soy
-// templates to HTML.
+// templates to HTML, with the default functions and print directives and those
+// of the bundle's plugins.  If the bundle is watching files, the Tofu is
+// updated with each successfully recompiled registry.
 func (b *Bundle) CompileToTofu() (*soyhtml.Tofu, error) {
-	var registry, err = b.Compile()
-	// TODO: Verify all used funcs exist and have the right # args.
-	return soyhtml.NewTofu(registry), err
+	var tofu = soyhtml.NewTofu(nil)
+	if b.plugins != nil {
+		tofu.WithPlugins(b.plugins)
+	}
+	return tofu, b.CompileIntoTofu(tofu)
 }
 
-func (b *Bundle) recompiler(reg *template.Registry) {
+// CompileIntoTofu compiles the bundle for rendering by the given Tofu, and sets
+// the resulting registry on it.  The templates are checked against the
+// functions and print directives configured on the Tofu, e.g. by WithFuncs,
+// rather than the defaults of soyhtml.  If the bundle is watching files, the
+// Tofu is updated with each successfully recompiled registry, which must suit
+// every Tofu being updated.
+func (b *Bundle) CompileIntoTofu(tofu *soyhtml.Tofu) error {
+	b.mu.Lock()
+	var tofus = append(append([]*soyhtml.Tofu(nil), b.tofus...), tofu)
+	b.mu.Unlock()
+	var registry, err = b.compileFor(tofus)
+	if err != nil {
+		return err
+	}
+	tofu.SetRegistry(registry)
+	if b.watcher != nil {
+		b.mu.Lock()
+		b.tofus = append(b.tofus, tofu)
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+// recompiler watches the bundle's files until it is closed, recompiling after
+// each burst of changes.
+func (b *Bundle) recompiler() {
+	defer func() {
+		b.closeErr = b.watcher.Close()
+		close(b.stopped)
+	}()
+	var (
+		pending <-chan time.Time // fires once changes have stopped
+		last    fsnotify.Event
+	)
 	for {
 		select {
-		case ev := <-b.watcher.Events:
-			// If it's a rename, then fsnotify has removed the watch.
-			// Add it back, after a delay.
-			if ev.Op == fsnotify.Rename || ev.Op == fsnotify.Remove {
+		case <-b.done:
+			return
+		case <-b.ctx.Done():
+			return
+		case ev, ok := <-b.watcher.Events:
+			if !ok {
+				return
+			}
+			if !b.affects(ev) {
+				continue
+			}
+			// If a file added by name is renamed, e.g. by an editor saving it,
+			// then fsnotify has removed the watch.  Add it back after a delay,
+			// if the file has been replaced.
+			if ev.Op&(fsnotify.Rename|fsnotify.Remove) != 0 && b.addedFile(ev.Name) {
 				time.Sleep(10 * time.Millisecond)
-				if err := b.watcher.Add(ev.Name); err != nil {
-					Logger.Println(err)
+				if _, err := os.Stat(ev.Name); err == nil {
+					if err = b.watcher.Add(ev.Name); err != nil {
+						b.reportError(err)
+					}
 				}
 			}
+			last = ev
+			pending = time.After(b.debounce)
+		case <-pending:
+			pending = nil
+			b.recompile(last)
+		case err, ok := <-b.watcher.Errors:
+			if !ok {
+				return
+			}
+			b.reportError(err)
+		}
+	}
+}
 
-			// Recompile all the Soy.
-			var bundle = NewBundle().
-				AddGlobalsMap(b.globals)
-			for _, soyfile := range b.files {
-				bundle.AddTemplateFile(soyfile.name)
+// recompile re-reads the bundle's files, including those now found in its
+// directories, and compiles them, publishing the new registry to the bundle's
+// tofus if successful.
+func (b *Bundle) recompile(ev fsnotify.Event) {
+	var files []soyFile
+	var walked = make(map[*templateDir]bool)
+	var walk = func(dir *templateDir) bool {
+		if walked[dir] {
+			return true
+		}
+		walked[dir] = true
+		var dirFiles, err = b.walkDir(dir)
+		if err != nil {
+			b.reportError(err)
+			return false
+		}
+		files = append(files, dirFiles...)
+		return true
+	}
+	for _, soyfile := range b.files {
+		switch {
+		case soyfile.dir != nil:
+			if !walk(soyfile.dir) {
+				return
 			}
-			var registry, err = bundle.Compile()
+			continue
+		case soyfile.file:
+			var content, err = ioutil.ReadFile(soyfile.name)
 			if err != nil {
-				Logger.Println(err)
-				continue
+				b.reportError(err)
+				return
 			}
+			soyfile.content = string(content)
+		}
+		files = append(files, soyfile)
+	}
+	for _, dir := range b.dirs {
+		if !walk(dir) {
+			return
+		}
+	}
+	b.mu.Lock()
+	var tofus = append([]*soyhtml.Tofu(nil), b.tofus...)
+	b.mu.Unlock()
+	var registry, err = b.compile(files, tofus)
+	if err != nil {
+		b.reportError(err)
+		return
+	}
 
-			if b.recompilationCallback != nil {
-				b.recompilationCallback(registry)
-			}
+	if b.recompilationCallback != nil {
+		b.recompilationCallback(registry)
+	}
+	b.mu.Lock()
+	for _, tofu := range b.tofus {
+		tofu.SetRegistry(registry)
+	}
+	b.mu.Unlock()
+	Logger.Printf("update successful (%v)", ev)
+}
 
-			// update the existing template registry.
-			// (this is not goroutine-safe, but that seems ok for a development aid,
-			// as long as it works in practice)
-			*reg = *registry
-			Logger.Printf("update successful (%v)", ev)
+// affects returns true if the given event may change the templates in the
+// bundle: it is for a *.soy file, a file added by name, or a directory.
+func (b *Bundle) affects(ev fsnotify.Event) bool {
+	if strings.HasSuffix(ev.Name, ".soy") || b.addedFile(ev.Name) {
+		return true
+	}
+	var info, err = os.Stat(ev.Name)
+	return err == nil && info.IsDir()
+}
 
-		case err := <-b.watcher.Errors:
-			// Nothing to do with errors
-			Logger.Println(err)
+// addedFile returns true if the named file was added by AddTemplateFile.
+func (b *Bundle) addedFile(name string) bool {
+	for _, soyfile := range b.files {
+		if soyfile.file && soyfile.dir == nil && soyfile.name == name {
+			return true
 		}
 	}
+	return false
+}
+
+// reportError logs the given error and passes it to the error callback.
+func (b *Bundle) reportError(err error) {
+	Logger.Println(err)
+	if b.errorCallback != nil {
+		b.errorCallback(err)
+	}
 }
@@ -0,0 +1,496 @@
+package soy
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/errortypes"
+	"github.com/robfig/soy/soyhtml"
+	"github.com/robfig/soy/template"
+)
+
+func TestWatchFiles(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "soy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(logger *log.Logger) { Logger = logger }(Logger)
+	Logger = log.New(ioutil.Discard, "", 0)
+
+	var filename = filepath.Join(dir, "hello.soy")
+	var write = func(greeting string) {
+		var src = "{namespace test}\n{template .hello}" + greeting + "{/template}\n"
+		if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Hello")
+
+	var (
+		recompiled = make(chan *template.Registry, 10)
+		errs       = make(chan error, 10)
+	)
+	var bundle = NewBundle().
+		WatchFiles(true).
+		SetDebounce(200*time.Millisecond).
+		SetRecompilationCallback(func(reg *template.Registry) { recompiled <- reg }).
+		SetErrorCallback(func(err error) { errs <- err }).
+		AddTemplateFile(filename).
+		AddTemplateString("other.soy", "{namespace other}\n{template .hi}Hi{/template}\n")
+	tofu, err := bundle.CompileToTofu()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var original = tofu.Registry()
+
+	// Render continuously while the templates change, so that the race
+	// detector can catch unsynchronized updates.
+	var (
+		wg   sync.WaitGroup
+		stop = make(chan struct{})
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := tofu.Render(ioutil.Discard, "test.hello", nil); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// A burst of writes results in a single recompilation.
+	write("Hi")
+	write("Hey")
+	write("Howdy")
+	select {
+	case <-recompiled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for recompilation")
+	}
+	select {
+	case <-recompiled:
+		t.Error("expected one recompilation for a burst of writes")
+	case <-time.After(500 * time.Millisecond):
+	}
+	close(stop)
+	wg.Wait()
+
+	var buf bytes.Buffer
+	if err = tofu.Render(&buf, "test.hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "Howdy" {
+		t.Errorf("expected %q, got %q", "Howdy", buf.String())
+	}
+	if _, ok := tofu.Registry().Template("other.hi"); !ok {
+		t.Error("template added as a string was lost on recompilation")
+	}
+	if _, ok := original.Template("test.hello"); !ok || original == tofu.Registry() {
+		t.Error("expected the original registry to be replaced, not modified")
+	}
+
+	// Compilation errors are reported, and leave the registry in use.
+	write("{$undefined")
+	select {
+	case err = <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the compilation error")
+	}
+	buf.Reset()
+	if err = tofu.Render(&buf, "test.hello", nil); err != nil || buf.String() != "Howdy" {
+		t.Errorf("expected the last good registry to be in use, got %q, %v", buf.String(), err)
+	}
+
+	// After closing, changes are not picked up.
+	if err = bundle.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = bundle.Close(); err != nil {
+		t.Error(err)
+	}
+	write("Bye")
+	select {
+	case <-recompiled:
+		t.Error("unexpected recompilation after Close")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestWatchFilesContext(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "soy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var filename = filepath.Join(dir, "hello.soy")
+	if err = ioutil.WriteFile(filename, []byte("{namespace test}\n{template .hello}Hello{/template}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var ctx, cancel = context.WithCancel(context.Background())
+	var bundle = NewBundle().
+		WatchFilesContext(ctx).
+		AddTemplateFile(filename)
+	if _, err = bundle.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	var closed = make(chan error)
+	go func() { closed <- bundle.Close() }()
+	select {
+	case err = <-closed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the watcher to stop")
+	}
+}
+
+func TestWatchDir(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "soy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(logger *log.Logger) { Logger = logger }(Logger)
+	Logger = log.New(ioutil.Discard, "", 0)
+
+	var write = func(name, namespace string) {
+		var src = "{namespace " + namespace + "}\n{template .hello}Hello{/template}\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.soy", "a")
+
+	var recompiled = make(chan *template.Registry, 10)
+	var bundle = NewBundle().
+		WatchFiles(true).
+		SetDebounce(50 * time.Millisecond).
+		SetRecompilationCallback(func(reg *template.Registry) { recompiled <- reg }).
+		SetErrorCallback(func(err error) { t.Error(err) }).
+		AddTemplateDir(dir)
+	defer bundle.Close()
+	tofu, err := bundle.CompileToTofu()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		change    func()
+		templates string // the templates after recompiling, "" if no recompilation, "-" if none
+	}{
+		{func() { write("b.soy", "b") }, "a.hello b.hello"},
+		{func() { ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0644) }, ""},
+		{func() {
+			if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			write("sub/c.soy", "c")
+		}, "a.hello b.hello c.hello"},
+		{func() { os.Remove(filepath.Join(dir, "a.soy")) }, "b.hello c.hello"},
+		{func() { os.Rename(filepath.Join(dir, "b.soy"), filepath.Join(dir, "sub", "b.soy")) }, "b.hello c.hello"},
+		{func() { os.RemoveAll(filepath.Join(dir, "sub")) }, "-"},
+	}
+	for i, test := range tests {
+		test.change()
+		if test.templates == "" {
+			select {
+			case <-recompiled:
+				t.Errorf("%d: unexpected recompilation", i)
+			case <-time.After(300 * time.Millisecond):
+			}
+			continue
+		}
+		// Some changes result in events that are delivered separately, so
+		// wait for recompilations to stop.
+		var registry *template.Registry
+		select {
+		case registry = <-recompiled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d: timed out waiting for recompilation", i)
+		}
+	drain:
+		for {
+			select {
+			case registry = <-recompiled:
+			case <-time.After(300 * time.Millisecond):
+				break drain
+			}
+		}
+		var names = []string{"-"}
+		for _, tmpl := range registry.Templates {
+			names = append(names, tmpl.Node.Name)
+		}
+		if len(names) > 1 {
+			names = names[1:]
+		}
+		sort.Strings(names)
+		if actual := strings.Join(names, " "); actual != test.templates {
+			t.Errorf("%d: expected templates %q, got %q", i, test.templates, actual)
+		}
+		if registry != tofu.Registry() {
+			t.Errorf("%d: expected the tofu to be updated", i)
+		}
+	}
+}
+
+func TestAddTemplateFS(t *testing.T) {
+	var fsys = fstest.MapFS{
+		"views/hello.soy":     {Data: []byte("{namespace test}\n{template .hello}\n{@param greeting: string}\n{$greeting} {GREETEE}\n{/template}\n")},
+		"views/sub/other.soy": {Data: []byte("{namespace other}\n{template .hi}Hi{/template}\n")},
+		"views/README":        {Data: []byte("not a template")},
+		"hidden.soy":          {Data: []byte("{namespace hidden}\n{template .hi}Hi{/template}\n")},
+		"globals.txt":         {Data: []byte("GREETEE = 'world'\n")},
+	}
+	var tofu, err = NewBundle().
+		AddTemplateFS(fsys, "views").
+		AddGlobalsFS(fsys, "globals.txt").
+		CompileToTofu()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = tofu.Render(&buf, "test.hello", map[string]interface{}{"greeting": "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "Hello world" {
+		t.Errorf("expected %q, got %q", "Hello world", buf.String())
+	}
+	var filenames []string
+	for _, soyfile := range tofu.Registry().SoyFiles {
+		filenames = append(filenames, soyfile.Name)
+	}
+	if actual := strings.Join(filenames, " "); actual != "views/hello.soy views/sub/other.soy" {
+		t.Errorf("unexpected files: %v", actual)
+	}
+
+	if _, err = NewBundle().AddTemplateFS(fsys, "missing").Compile(); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+	if _, err = NewBundle().AddGlobalsFS(fsys, "missing.txt").Compile(); err == nil {
+		t.Error("expected an error for a missing globals file")
+	}
+}
+
+func TestWatchDirFS(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "soy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(logger *log.Logger) { Logger = logger }(Logger)
+	Logger = log.New(ioutil.Discard, "", 0)
+
+	if err = os.Mkdir(filepath.Join(dir, "views"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var write = func(greeting string) {
+		var src = "{namespace test}\n{template .hello}" + greeting + "{/template}\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, "views", "hello.soy"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Hello")
+
+	var recompiled = make(chan *template.Registry, 10)
+	var bundle = NewBundle().
+		WatchFiles(true).
+		SetDebounce(50*time.Millisecond).
+		SetRecompilationCallback(func(reg *template.Registry) { recompiled <- reg }).
+		AddTemplateFS(DirFS(dir), "views")
+	defer bundle.Close()
+	tofu, err := bundle.CompileToTofu()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("Hi")
+	select {
+	case <-recompiled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for recompilation")
+	}
+	var buf bytes.Buffer
+	if err = tofu.Render(&buf, "test.hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "Hi" {
+		t.Errorf("expected %q, got %q", "Hi", buf.String())
+	}
+	if name := tofu.Registry().SoyFiles[0].Name; name != "views/hello.soy" {
+		t.Errorf("expected the file to be named by its path in the FS, got %q", name)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	type pos struct {
+		file      string
+		line, col int
+	}
+	var tests = []struct {
+		name  string
+		files [][2]string
+		errs  []pos
+	}{
+		{"parse", [][2]string{
+			{"b.soy", "{namespace b}\n{template .main}\n  {if}x{/if}\n{/template}"},
+			{"ok.soy", "{namespace ok}\n{template .main}\n{/template}"},
+			{"a.soy", "{namespace a}\n{template .main}\n  {/if}\n{/template}"},
+		}, []pos{{"a.soy", 3, 7}, {"b.soy", 3, 7}}},
+
+		{"check", [][2]string{
+			{"c.soy", "{namespace c}\n{template .main}\n  {call a.missing /}\n{/template}"},
+			{"a.soy", `{namespace a}
+{template .types}
+  {@param n: int}
+  {$n.field}
+{/template}
+
+{template .unused}
+  {@param name: string}
+  Hello
+{/template}
+
+{template .global}
+  {UNDEFINED}
+{/template}`},
+		}, []pos{{"a.soy", 4, 7}, {"a.soy", 7, 11}, {"a.soy", 13, 14}, {"c.soy", 3, 9}}},
+
+		{"funcs", [][2]string{
+			{"a.soy", `{namespace a}
+{template .main}
+  {@param list: list<int>}
+  {if length($list) > 100}
+    {lenght($list)} {round(1, 2, 3)}
+  {/if}
+  {foreach $x in $list}{$x|truncate}{/foreach}
+  {isFirst($list)}
+{/template}`},
+		}, []pos{{"a.soy", 5, 13}, {"a.soy", 5, 28}, {"a.soy", 7, 29}, {"a.soy", 8, 12}}},
+	}
+
+	for _, test := range tests {
+		var bundle = NewBundle()
+		for _, file := range test.files {
+			bundle.AddTemplateString(file[0], file[1])
+		}
+		var _, err = bundle.Compile()
+		var errs = errortypes.ToErrList(err)
+		if len(errs) != len(test.errs) {
+			t.Errorf("%s: expected %d errors, got: %v", test.name, len(test.errs), err)
+			continue
+		}
+		for i, err := range errs {
+			if actual := (pos{err.File(), err.Line(), err.Col()}); actual != test.errs[i] {
+				t.Errorf("%s: expected error %d at %v, got %v: %v", test.name, i, test.errs[i], actual, err)
+			}
+		}
+	}
+}
+
+// TestCompileIntoTofu checks that the templates are checked against the
+// functions and print directives configured on the Tofu.
+func TestCompileIntoTofu(t *testing.T) {
+	var bundle = NewBundle().AddTemplateString("a.soy", `{namespace a}
+{template .main}
+  {twice('ab')|shout:1}
+{/template}`)
+	if _, err := bundle.Compile(); err == nil {
+		t.Fatal("expected an error for the functions missing from soyhtml")
+	}
+
+	var tofu = soyhtml.NewTofu(nil).
+		WithFuncs(map[string]soyhtml.Func{"twice": {func(args []data.Value) data.Value {
+			return data.String(args[0].String() + args[0].String())
+		}, []int{1}}}).
+		WithPrintDirectives(map[string]soyhtml.PrintDirective{"shout": {func(value data.Value, args []data.Value) data.Value {
+			return data.String(strings.ToUpper(value.String()))
+		}, []int{1}, false}})
+	if err := bundle.CompileIntoTofu(tofu); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tofu.Render(&buf, "a.main", nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ABAB" {
+		t.Errorf("expected ABAB, got %q", buf.String())
+	}
+
+	// The arities are those of the Tofu.
+	tofu.WithPrintDirectives(map[string]soyhtml.PrintDirective{"shout": {nil, []int{0}, false}})
+	if err := bundle.CompileIntoTofu(tofu); err == nil {
+		t.Error("expected an error for the wrong number of directive arguments")
+	}
+}
+
+// TestFeaturesArtifact checks that the features render the same from a
+// registry that was written to an artifact and read back.
+func TestFeaturesArtifact(t *testing.T) {
+	var registry, err = featuresBundle().Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var artifact bytes.Buffer
+	if err = registry.WriteArtifact(&artifact); err != nil {
+		t.Fatal(err)
+	}
+
+	var tofu *soyhtml.Tofu
+	tofu, err = soyhtml.NewTofuFromArtifact(bytes.NewReader(artifact.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rand.Seed(1)
+	runFeatureTestsWith(t, tofu, featureTests)
+
+	// The artifact is only read by a bundle of the same files.
+	if _, err = featuresBundle().ReadArtifact(bytes.NewReader(artifact.Bytes())); err != nil {
+		t.Error(err)
+	}
+	var changed = featuresBundle().AddTemplateString("other.soy", "{namespace other}")
+	if _, err = changed.ReadArtifact(bytes.NewReader(artifact.Bytes())); err != ErrStaleArtifact {
+		t.Errorf("expected ErrStaleArtifact, got %v", err)
+	}
+
+	// Artifacts of other versions are not read.
+	var old bytes.Buffer
+	gob.NewEncoder(&old).Encode(struct {
+		Magic   string
+		Version int
+	}{"soy registry artifact", template.ArtifactVersion - 1})
+	if _, err = template.ReadArtifact(&old); !errors.Is(err, template.ErrArtifactVersion) {
+		t.Errorf("expected ErrArtifactVersion, got %v", err)
+	}
+
+	// Nor are those with sources that do not match their hashes.
+	var corrupt = bytes.Replace(artifact.Bytes(), []byte("namespace soy.examples.simple"), []byte("namespace soy.examples.simplf"), 1)
+	if _, err = template.ReadArtifact(bytes.NewReader(corrupt)); err == nil {
+		t.Error("expected an error reading a corrupt artifact")
+	}
+}
@@ -0,0 +1,140 @@
+package soy
+
+import (
+	"crypto/sha256"
+
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/template"
+)
+
+// compileCache holds the result of the last successful compilation of a
+// bundle, so that recompiling it after some files change only parses and
+// checks what is needed to keep the registry consistent:
+//  1. files whose content is unchanged are not parsed again, and their
+//     templates are shared with the previous registry.
+//  2. changed templates are fully checked and processed.
+//  3. unchanged templates that call a changed or removed template, directly or
+//     through other templates (e.g. by passing data="all" down a chain), have
+//     their data refs and types checked again, since those depend on the
+//     callee.
+//  4. if the functions and print directives that may be called have changed,
+//     the calls to them in all templates are checked again.
+//
+// Since parse passes may depend on any template in the registry, bundles with
+// parse passes are always compiled in full.
+type compileCache struct {
+	registry *template.Registry
+	files    map[string]cachedFile // by file name
+
+	// the valid numbers of arguments of the functions and print directives
+	// that the templates were checked against, by name
+	funcs, directives map[string][]int
+}
+
+type cachedFile struct {
+	hash [sha256.Size]byte // of the file content
+	node *ast.SoyFileNode
+}
+
+// reusable returns the cached file of the given name, and true if its content
+// has the given hash.
+func (c compileCache) reusable(name string, hash [sha256.Size]byte) (cachedFile, bool) {
+	var file, ok = c.files[name]
+	return file, ok && file.hash == hash
+}
+
+// affected returns the templates of the given registry that must be checked,
+// given the templates that have changed since the cached compilation.
+func (c compileCache) affected(registry *template.Registry, changed []template.Template) []template.Template {
+	if c.registry == nil || len(changed) == len(registry.Templates) {
+		return changed
+	}
+
+	// Find the names that may be called with a different result: those of
+	// the changed templates, and those of the templates they replace.
+	var (
+		isChanged = make(map[*ast.TemplateNode]bool)
+		reused    = make(map[*ast.TemplateNode]bool)
+		dirty     = make(map[string]bool)
+	)
+	for _, t := range changed {
+		isChanged[t.Node] = true
+		dirty[callName(t.Node)] = true
+	}
+	for _, t := range registry.Templates {
+		if !isChanged[t.Node] {
+			reused[t.Node] = true
+		}
+	}
+	for _, t := range c.registry.Templates {
+		if !reused[t.Node] {
+			dirty[callName(t.Node)] = true
+		}
+	}
+
+	// Walk the call graph up from the dirty names: each reused template that
+	// calls one is affected, and so in turn are its callers.
+	var (
+		callers = make(map[string][]template.Template) // reused templates, by callee name
+		queue   []string
+	)
+	for _, t := range registry.Templates {
+		if reused[t.Node] {
+			for name := range calls(t.Node, make(map[string]bool)) {
+				callers[name] = append(callers[name], t)
+			}
+		}
+	}
+	for name := range dirty {
+		queue = append(queue, name)
+	}
+	var isAffected = make(map[*ast.TemplateNode]bool)
+	for len(queue) > 0 {
+		var name = queue[0]
+		queue = queue[1:]
+		for _, t := range callers[name] {
+			if isAffected[t.Node] {
+				continue
+			}
+			isAffected[t.Node] = true
+			if !dirty[callName(t.Node)] {
+				dirty[callName(t.Node)] = true
+				queue = append(queue, callName(t.Node))
+			}
+		}
+	}
+
+	var affected = append([]template.Template(nil), changed...)
+	for _, t := range registry.Templates {
+		if isAffected[t.Node] {
+			affected = append(affected, t)
+		}
+	}
+	return affected
+}
+
+// callName returns the name by which the given template is called: its
+// delegate name for {delcall}, or its template name for {call}.
+func callName(node *ast.TemplateNode) string {
+	if node.Delegate != nil {
+		return node.Delegate.Name
+	}
+	return node.Name
+}
+
+// calls adds the names of the templates called by {call} or {delcall} within
+// the given node to names, and returns it.
+func calls(node ast.Node, names map[string]bool) map[string]bool {
+	switch node := node.(type) {
+	case *ast.CallNode:
+		names[node.Name] = true
+	case *ast.DelCallNode:
+		names[node.Name] = true
+	}
+	if parent, ok := node.(ast.ParentNode); ok {
+		for _, child := range parent.Children() {
+			calls(child, names)
+		}
+	}
+	return names
+}
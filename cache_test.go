@@ -0,0 +1,157 @@
+package soy
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/parse"
+	"github.com/robfig/soy/soyhtml"
+	"github.com/robfig/soy/template"
+)
+
+func TestIncrementalCompile(t *testing.T) {
+	var caller = soyFile{name: "caller.soy", content: `{namespace caller}
+{template .main}
+  {call callee.greet}{param name: 'Rob' /}{/call}
+{/template}`}
+	var callee = soyFile{name: "callee.soy", content: `{namespace callee}
+{template .greet}
+  {@param name: string}
+  Hello {$name}
+{/template}`}
+	var other = soyFile{name: "other.soy", content: `{namespace other}
+{template .main}
+  {msg desc="greeting"}Hi{/msg} {GREETING}
+{/template}`}
+
+	var bundle = NewBundle().AddGlobalsMap(data.Map{"GREETING": data.String("hello")})
+	var files = []soyFile{caller, callee, other}
+	first, err := bundle.compile(files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged files are shared with the previous registry.
+	callee.content = strings.Replace(callee.content, "Hello", "Hi", 1)
+	second, err := bundle.compile([]soyFile{caller, callee, other}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.SoyFiles[0] != second.SoyFiles[0] || first.SoyFiles[2] != second.SoyFiles[2] {
+		t.Error("expected unchanged files to be reused")
+	}
+	if first.SoyFiles[1] == second.SoyFiles[1] {
+		t.Error("expected the changed file to be parsed again")
+	}
+	var buf bytes.Buffer
+	if err = soyhtml.NewTofu(second).Render(&buf, "other.main", nil); err != nil || buf.String() != "Hi hello" {
+		t.Errorf("expected the reused template to keep its globals and messages, got %q, %v", buf.String(), err)
+	}
+	if tmpl, ok := second.Template("callee.greet"); !ok || len(tmpl.Node.Params) != 1 {
+		t.Error("expected the changed template to have its header params")
+	}
+
+	// Unchanged callers of changed and removed templates are checked again.
+	var tests = []struct {
+		files []soyFile
+		err   string
+	}{
+		{[]soyFile{caller, {name: "callee.soy", content: `{namespace callee}
+{template .greet}
+  {@param name: string}
+  {@param greeting: string}
+  {$greeting} {$name}
+{/template}`}, other}, `Required params ["greeting"] are not passed`},
+		{[]soyFile{caller, {name: "callee.soy", content: `{namespace callee}
+{template .greet}
+  {@param name: int}
+  {$name}
+{/template}`}, other}, `param "name" has type string, expected int`},
+		{[]soyFile{caller, other}, `template "callee.greet" not found`},
+	}
+	for _, test := range tests {
+		_, err = bundle.compile(test.files, nil)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error containing %q, got %v", test.err, err)
+		}
+	}
+
+	// A failed compilation does not affect the cache.
+	third, err := bundle.compile([]soyFile{caller, callee, other}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.SoyFiles[1] != second.SoyFiles[1] {
+		t.Error("expected the last successfully compiled file to be reused")
+	}
+}
+
+func TestIncrementalCompileAffectsIndirectCallers(t *testing.T) {
+	var files = []soyFile{
+		{name: "top.soy", content: `{namespace top}
+/** @param name */
+{template .main}
+  {call mid.main data="all" /}
+{/template}`},
+		{name: "mid.soy", content: `{namespace mid}
+/** @param name */
+{template .main}
+  {call callee.greet data="all" /}
+{/template}`},
+		{name: "callee.soy", content: `{namespace callee}
+/** @param name */
+{template .greet}
+  Hello {$name}
+{/template}`},
+		{name: "other.soy", content: `{namespace other}
+{template .main}
+  Hi
+{/template}`},
+	}
+	var bundle = NewBundle()
+	var first, err = bundle.compile(files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var registry = *first
+	tree, err := parse.SoyFile("callee.soy", strings.Replace(files[2].content, "Hello", "Hi", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = registry.ReplaceFile(tree); err != nil {
+		t.Fatal(err)
+	}
+	var affected = bundle.cache.affected(&registry, registry.FileTemplates("callee.soy"))
+	var names []string
+	for _, tmpl := range affected {
+		names = append(names, tmpl.Node.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"callee.greet", "top.main", "mid.main"}) {
+		t.Errorf("expected the callee and its direct and indirect callers, got %v", names)
+	}
+}
+
+func TestIncrementalCompileParsePasses(t *testing.T) {
+	var passes int
+	var bundle = NewBundle().
+		AddParsePass(func(reg template.Registry) error {
+			passes++
+			return nil
+		})
+	var files = []soyFile{{name: "a.soy", content: "{namespace a}\n{template .a}A{/template}"}}
+	first, err := bundle.compile(files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := bundle.compile(files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.SoyFiles[0] == second.SoyFiles[0] || passes != 2 {
+		t.Error("expected bundles with parse passes to be compiled in full")
+	}
+}
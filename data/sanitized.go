@@ -0,0 +1,111 @@
+package data
+
+// ContentKind identifies the kind of content produced by a template or block
+// with a "kind" attribute, and the context in which that content may be
+// printed without further escaping.
+type ContentKind int
+
+const (
+	KindUnspecified ContentKind = iota
+	KindText
+	KindHTML
+	KindAttributes
+	KindJS
+	KindCSS
+	KindURI
+)
+
+var contentKindNames = map[ContentKind]string{
+	KindText:       "text",
+	KindHTML:       "html",
+	KindAttributes: "attributes",
+	KindJS:         "js",
+	KindCSS:        "css",
+	KindURI:        "uri",
+}
+
+func (k ContentKind) String() string {
+	return contentKindNames[k]
+}
+
+// ParseContentKind returns the ContentKind for the given value of a "kind"
+// attribute.  It returns false if the value is not a recognized kind.
+func ParseContentKind(name string) (ContentKind, bool) {
+	for kind, kindName := range contentKindNames {
+		if kindName == name {
+			return kind, true
+		}
+	}
+	return KindUnspecified, false
+}
+
+// SanitizedContent is implemented by values whose content is known to be safe
+// for printing without escaping in a context matching their Kind.
+type SanitizedContent interface {
+	Value
+	Kind() ContentKind
+}
+
+// The sanitized content types.  They should only be created from trusted
+// content, since they are not escaped when printed in a matching context.
+type (
+	SanitizedHTML           string
+	SanitizedHTMLAttributes string
+	SanitizedJS             string
+	SanitizedCSS            string
+	SanitizedURI            string
+)
+
+// NewSanitizedContent returns the given content as a value of the given kind.
+// Text content and content of unspecified kind is returned as a String.
+func NewSanitizedContent(content string, kind ContentKind) Value {
+	switch kind {
+	case KindHTML:
+		return SanitizedHTML(content)
+	case KindAttributes:
+		return SanitizedHTMLAttributes(content)
+	case KindJS:
+		return SanitizedJS(content)
+	case KindCSS:
+		return SanitizedCSS(content)
+	case KindURI:
+		return SanitizedURI(content)
+	}
+	return String(content)
+}
+
+func (v SanitizedHTML) Kind() ContentKind           { return KindHTML }
+func (v SanitizedHTMLAttributes) Kind() ContentKind { return KindAttributes }
+func (v SanitizedJS) Kind() ContentKind             { return KindJS }
+func (v SanitizedCSS) Kind() ContentKind            { return KindCSS }
+func (v SanitizedURI) Kind() ContentKind            { return KindURI }
+
+func (v SanitizedHTML) Truthy() bool           { return v != "" }
+func (v SanitizedHTMLAttributes) Truthy() bool { return v != "" }
+func (v SanitizedJS) Truthy() bool             { return v != "" }
+func (v SanitizedCSS) Truthy() bool            { return v != "" }
+func (v SanitizedURI) Truthy() bool            { return v != "" }
+
+func (v SanitizedHTML) String() string           { return string(v) }
+func (v SanitizedHTMLAttributes) String() string { return string(v) }
+func (v SanitizedJS) String() string             { return string(v) }
+func (v SanitizedCSS) String() string            { return string(v) }
+func (v SanitizedURI) String() string            { return string(v) }
+
+func (v SanitizedHTML) Equals(other Value) bool           { return contentEquals(v, other) }
+func (v SanitizedHTMLAttributes) Equals(other Value) bool { return contentEquals(v, other) }
+func (v SanitizedJS) Equals(other Value) bool             { return contentEquals(v, other) }
+func (v SanitizedCSS) Equals(other Value) bool            { return contentEquals(v, other) }
+func (v SanitizedURI) Equals(other Value) bool            { return contentEquals(v, other) }
+
+// contentEquals returns true if other is a String or sanitized content of the
+// same kind with the same content as v.
+func contentEquals(v SanitizedContent, other Value) bool {
+	switch o := other.(type) {
+	case String:
+		return v.String() == string(o)
+	case SanitizedContent:
+		return v.Kind() == o.Kind() && v.String() == o.String()
+	}
+	return false
+}
@@ -123,8 +123,11 @@ func (v Bool) Equals(other Value) bool {
 }
 
 func (v String) Equals(other Value) bool {
-	if o, ok := other.(String); ok {
+	switch o := other.(type) {
+	case String:
 		return string(v) == string(o)
+	case SanitizedContent:
+		return string(v) == o.String()
 	}
 	return false
 }
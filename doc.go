@@ -115,6 +115,40 @@ On startup:
       AddTemplateDir("views").              // load *.soy in all sub-directories
       CompileToTofu()
 
+Recompiled templates replace those used by the tofu atomically, so renders in
+progress complete with the templates they started with.  A burst of changes
+results in one recompilation, which only parses the files that changed and
+checks the templates affected by them.  Directories added by AddTemplateDir are watched
+as a whole, so that Soy files created or deleted within them are picked up.
+Watching stops when the bundle is closed, or when the context given to
+WatchFilesContext is done.
+
+Templates and globals may also be loaded from a fs.FS, such as an embed.FS, by
+AddTemplateFS and AddGlobalsFS.  Those loaded from a file system returned by
+soy.DirFS are watched like those added by AddTemplateDir.
+
+To avoid parsing and checking the templates on every start, a compiled registry
+may be written at build time to a versioned artifact, with its globals and
+message IDs already set, and read back into a Tofu:
+
+  registry, _ := soy.NewBundle().
+      AddGlobalsFile("views/globals.txt").
+      AddTemplateDir("views").
+      Compile()
+  registry.WriteArtifact(artifactFile)
+
+  tofu, _ := soyhtml.NewTofuFromArtifact(artifactFile)
+
+Bundle.ReadArtifact additionally checks that the artifact was compiled from the
+bundle's current Soy files.
+
+Compiling a bundle reports every error found, rather than only the first.  The
+Soy files are parsed concurrently, and if any fail to parse, their errors are
+returned without checking the templates.  Otherwise the errors found checking
+each template are returned.  In either case, the error may be converted by
+errortypes.ToErrList to a list of errors with their file positions, sorted by
+file and line.
+
 To render a page:
 
   var obj = map[string]interface{}{
@@ -136,17 +170,70 @@ you can not call methods on it.
 See soyhtml.StructOptions for knobs to control how your structs get converted to
 data maps.
 
+Templates that declare typed params, such as {@param user: [name: string, age:
+int]}, are type checked when the bundle is compiled.  The data passed to them
+may also be validated as each template is rendered:
+
+  tofu.NewRenderer("acme.account.overview").
+      WithTypeChecks(true).
+      Execute(resp, data.New(obj).(data.Map))
+
+Delegate templates declared with {deltemplate} may be overridden by those in a
+{delpackage}.  The packages to use are selected as each template is rendered:
+
+  tofu.NewRenderer("acme.account.overview").
+      WithDelegatePackages("acme.whitelabel").
+      Execute(resp, data.New(obj).(data.Map))
+
+In the generated Javascript, a package is active if its file has been loaded.
+
+Templates declared with private="true" may only be called by templates in the
+same file, which is checked when the bundle is compiled.  A renderer may also
+refuse to render them directly:
+
+  tofu.NewRenderer("acme.account.helper").
+      WithPrivateCheck(true).
+      Execute(resp, data.New(obj).(data.Map)) // returns ErrPrivateTemplate
+
+Custom functions and print directives are defined once as plugins, with a Go
+implementation for rendering and a Javascript emitter for soyjs.  Their names
+may be namespaced, e.g. {acme.formatPrice($price)}:
+
+  var plugins = plugin.NewSet().AddFuncs(plugin.Func{
+      Name: "acme.formatPrice",
+      Args: []types.Type{types.Int},
+      Go:   formatPrice,
+      JS:   formatPriceJS,
+  })
+  tofu, _ := soy.NewBundle().
+      AddTemplateDir("views").
+      AddPlugins(plugins).
+      CompileToTofu()
+
+The same set is given to soyjs in its Options.Plugins.
+
+Templates may also be compiled ahead of time into Go functions that take a
+typed struct of params, using the soygo package and command:
+
+  soygo -pkg views -o views/templates.go views/
+
+
 Project Status
 
 The goal is full compatibility and feature parity with the official Closure
 Templates project.
 
 The server-side templating functionality is well tested and nearly complete,
-except for two notable areas: contextual autoescaping and
-internationalization/bidi support.  Contributions welcome.
+except for one notable area: internationalization/bidi support.  Contributions
+welcome.
+
+Templates declared with autoescape="contextual" or autoescape="strict" are
+escaped according to the HTML, attribute, URI, JS or CSS context that each
+print command appears in, in the same way as the official compiler.
 
 The Javascript generation is early and lacks many generation options, but
-it successfully passes the server-side template test suite. Note that it is
+it successfully passes the server-side template test suite, and implements the
+standard functions with the same semantics as the server-side templates. Note that it is
 possible to run the official Soy compiler to generate your javascript templates
 at build time, even if you use this package for server-side templates.
 
@@ -0,0 +1,68 @@
+package errortypes
+
+import (
+	"sort"
+	"strings"
+)
+
+// ErrList is a list of errors with file positions, such as all of those found
+// when compiling a bundle of Soy files.
+type ErrList []ErrFilePos
+
+// Error returns the messages of the errors in the list, one per line.
+func (l ErrList) Error() string {
+	var msgs = make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the errors in the list.
+func (l ErrList) Unwrap() []error {
+	var errs = make([]error, len(l))
+	for i, err := range l {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Sort sorts the list by file, line and column.  Errors at the same position
+// keep their order.
+func (l ErrList) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		switch {
+		case l[i].File() != l[j].File():
+			return l[i].File() < l[j].File()
+		case l[i].Line() != l[j].Line():
+			return l[i].Line() < l[j].Line()
+		}
+		return l[i].Col() < l[j].Col()
+	})
+}
+
+// Err returns the list sorted as an error, or the only error in the list, or
+// nil if the list is empty.
+func (l ErrList) Err() error {
+	switch len(l) {
+	case 0:
+		return nil
+	case 1:
+		return l[0]
+	}
+	l.Sort()
+	return l
+}
+
+// ToErrList returns the errors with file positions in the given error: those
+// in an ErrList, or the error itself if it is an ErrFilePos.  It returns nil
+// otherwise.
+func ToErrList(err error) ErrList {
+	if list, ok := err.(ErrList); ok {
+		return list
+	}
+	if pos := ToErrFilePos(err); pos != nil {
+		return ErrList{pos}
+	}
+	return nil
+}
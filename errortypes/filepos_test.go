@@ -85,3 +85,36 @@ func TestToErrFilePos(t *testing.T) {
 		}
 	}
 }
+
+func TestErrList(t *testing.T) {
+	var pos = func(file string, line, col int, msg string) errortypes.ErrFilePos {
+		return errortypes.ToErrFilePos(errortypes.NewErrFilePosf(file, line, col, msg))
+	}
+	var list = errortypes.ErrList{
+		pos("b.soy", 1, 1, "b1"),
+		pos("a.soy", 10, 2, "a10"),
+		pos("a.soy", 2, 5, "a2:5"),
+		pos("a.soy", 2, 1, "a2:1"),
+	}
+	var err = list.Err()
+	if err.Error() != "a2:1\na2:5\na10\nb1" {
+		t.Errorf("unexpected error: %q", err.Error())
+	}
+	if got := errortypes.ToErrList(err); len(got) != 4 || got[0].Line() != 2 {
+		t.Errorf("unexpected list: %v", got)
+	}
+
+	if err = (errortypes.ErrList{}).Err(); err != nil {
+		t.Errorf("expected nil for an empty list, got %v", err)
+	}
+	var single = pos("a.soy", 1, 1, "single")
+	if err = (errortypes.ErrList{single}).Err(); err != single {
+		t.Errorf("expected the only error, got %v", err)
+	}
+	if got := errortypes.ToErrList(single); len(got) != 1 || got[0] != single {
+		t.Errorf("expected a list of the single error, got %v", got)
+	}
+	if got := errortypes.ToErrList(errors.New("no position")); got != nil {
+		t.Errorf("expected nil for an error without a position, got %v", got)
+	}
+}
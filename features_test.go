@@ -1,7 +1,6 @@
 package soy
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -14,6 +13,8 @@ import (
 
 	"github.com/robertkrimen/otto"
 	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/internal/ottotest"
+	"github.com/robfig/soy/soyhtml"
 	"github.com/robfig/soy/soyjs"
 )
 
@@ -406,49 +407,33 @@ func TestFeaturesJavascript(t *testing.T) {
 }
 
 func initJs(t *testing.T) *otto.Otto {
-	var otto = otto.New()
-	soyutilsFile, err := os.Open("soyjs/lib/soyutils.js")
-	if err != nil {
-		panic(err)
-	}
-	// remove any non-otto compatible regular expressions
-	var soyutilsBuf bytes.Buffer
-	var scanner = bufio.NewScanner(soyutilsFile)
-	var i = 1
-	for scanner.Scan() {
-		switch i {
-		case 2565, 2579, 2586:
-			// skip these regexes
-			// soy.esc.$$FILTER_FOR_FILTER_CSS_VALUE_
-			// soy.esc.$$FILTER_FOR_FILTER_HTML_ATTRIBUTES_
-			// soy.esc.$$FILTER_FOR_FILTER_HTML_ELEMENT_NAME_
-		default:
-			soyutilsBuf.Write(scanner.Bytes())
-			soyutilsBuf.Write([]byte("\n"))
-		}
-		i++
-	}
-	// load the soyutils library
-	_, err = otto.Run(soyutilsBuf.String())
+	var js, err = ottotest.New("soyjs/lib")
 	if err != nil {
 		t.Errorf("soyutils error: %v", err)
 		panic(err)
 	}
-	return otto
+	return js
 }
 
 func runFeatureTests(t *testing.T, tests []featureTest) {
-	var features = mustReadFile("testdata/features.soy")
-	var tofu, err = NewBundle().
-		AddGlobalsFile("testdata/FeaturesUsage_globals.txt").
-		AddTemplateString("", features).
-		AddTemplateFile("testdata/simple.soy").
-		CompileToTofu()
+	var tofu, err = featuresBundle().CompileToTofu()
 	if err != nil {
 		t.Error(err)
 		return
 	}
+	runFeatureTestsWith(t, tofu, tests)
+}
+
+// featuresBundle returns a bundle of the features.
+func featuresBundle() *Bundle {
+	return NewBundle().
+		AddGlobalsFile("testdata/FeaturesUsage_globals.txt").
+		AddTemplateString("", mustReadFile("testdata/features.soy")).
+		AddTemplateFile("testdata/simple.soy")
+}
 
+func runFeatureTestsWith(t *testing.T, tofu *soyhtml.Tofu, tests []featureTest) {
+	var err error
 	b := new(bytes.Buffer)
 	for _, test := range tests {
 		b.Reset()
@@ -0,0 +1,95 @@
+// Package ottotest loads soyutils.js and soyutils_ext.js into the otto
+// javascript interpreter, for testing the javascript generated by soyjs.
+package ottotest
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robertkrimen/otto"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// regexps are otto compatible replacements for the regular expressions of
+// soyutils.js that use negative lookahead, by the name they are assigned to.
+var regexps = map[string]string{
+	"soy.esc.$$FILTER_FOR_FILTER_CSS_VALUE_": regexp(`/^-*(?:expression|(?:moz-)?binding)/i`,
+		`/^(?:[.#]?-?(?:[_a-z0-9-]+)(?:-[_a-z0-9-]+)*-?|-?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:[a-z]{1,2}|%)?|!important|)$/i`),
+	"soy.esc.$$FILTER_FOR_FILTER_HTML_ATTRIBUTES_": regexp(
+		`/^(?:style|on|action|archive|background|cite|classid|codebase|data|dsync|href|longdesc|src|usemap)/i`,
+		`/^(?:[a-z0-9_$:-]*)$/i`),
+	"soy.esc.$$FILTER_FOR_FILTER_HTML_ELEMENT_NAME_": regexp(
+		`/^(?:script|style|title|textarea|xmp|no)/i`, `/^[a-z0-9_$:-]*$/i`),
+}
+
+// regexp returns an object with a test method matching strings that do not
+// match the banned regular expression, but do match the allowed one.
+func regexp(banned, allowed string) string {
+	return "{test: function(s) { return !" + banned + ".test(s) && " + allowed + ".test(s); }};"
+}
+
+// New returns an interpreter that has run the soyutils.js and soyutils_ext.js
+// files in the given directory.  The regular expressions that otto does not
+// support, which use negative lookahead, are replaced with equivalents that
+// test the lookahead separately.
+func New(libDir string) (*otto.Otto, error) {
+	var soyutilsFile, err = os.Open(filepath.Join(libDir, "soyutils.js"))
+	if err != nil {
+		return nil, err
+	}
+	defer soyutilsFile.Close()
+	var soyutilsBuf bytes.Buffer
+	var scanner = bufio.NewScanner(soyutilsFile)
+	for scanner.Scan() {
+		var line = scanner.Text()
+		for name, repl := range regexps {
+			if strings.HasPrefix(line, name+" = ") {
+				line = name + " = " + repl
+			}
+		}
+		soyutilsBuf.WriteString(line + "\n")
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	ext, err := ioutil.ReadFile(filepath.Join(libDir, "soyutils_ext.js"))
+	if err != nil {
+		return nil, err
+	}
+	var js = otto.New()
+	if _, err = js.Run(soyutilsBuf.String()); err != nil {
+		return nil, err
+	}
+	if _, err = js.Run(string(ext)); err != nil {
+		return nil, err
+	}
+	if err = fixCaseMapping(js); err != nil {
+		return nil, err
+	}
+	return js, nil
+}
+
+// fixCaseMapping replaces String.prototype.toUpperCase and toLowerCase, which
+// otto maps one code point at a time, with the full case mapping that
+// javascript requires, e.g. "ß" to "SS".
+func fixCaseMapping(js *otto.Otto) error {
+	var proto, err = js.Object("String.prototype")
+	if err != nil {
+		return err
+	}
+	var mapCase = func(caser func() cases.Caser) func(call otto.FunctionCall) otto.Value {
+		return func(call otto.FunctionCall) otto.Value {
+			var result, _ = js.ToValue(caser().String(call.This.String()))
+			return result
+		}
+	}
+	if err = proto.Set("toUpperCase", mapCase(func() cases.Caser { return cases.Upper(language.Und) })); err != nil {
+		return err
+	}
+	return proto.Set("toLowerCase", mapCase(func() cases.Caser { return cases.Lower(language.Und) }))
+}
@@ -172,28 +172,31 @@ func (t itemType) isOp() bool {
 }
 
 var builtinIdents = map[string]itemType{
-	"alias":     itemAlias,
-	"call":      itemCall,
-	"case":      itemCase,
-	"css":       itemCss,
-	"debugger":  itemDebugger,
-	"default":   itemDefault,
-	"else":      itemElse,
-	"elseif":    itemElseif,
-	"for":       itemFor,
-	"foreach":   itemForeach,
-	"if":        itemIf,
-	"ifempty":   itemIfempty,
-	"let":       itemLet,
-	"literal":   itemLiteral,
-	"log":       itemLog,
-	"msg":       itemMsg,
-	"namespace": itemNamespace,
-	"param":     itemParam,
-	"plural":    itemPlural,
-	"print":     itemPrint,
-	"switch":    itemSwitch,
-	"template":  itemTemplate,
+	"alias":       itemAlias,
+	"call":        itemCall,
+	"case":        itemCase,
+	"css":         itemCss,
+	"debugger":    itemDebugger,
+	"default":     itemDefault,
+	"delcall":     itemDelcall,
+	"delpackage":  itemDelpackage,
+	"deltemplate": itemDeltemplate,
+	"else":        itemElse,
+	"elseif":      itemElseif,
+	"for":         itemFor,
+	"foreach":     itemForeach,
+	"if":          itemIf,
+	"ifempty":     itemIfempty,
+	"let":         itemLet,
+	"literal":     itemLiteral,
+	"log":         itemLog,
+	"msg":         itemMsg,
+	"namespace":   itemNamespace,
+	"param":       itemParam,
+	"plural":      itemPlural,
+	"print":       itemPrint,
+	"switch":      itemSwitch,
+	"template":    itemTemplate,
 
 	"/call":        itemCallEnd,
 	"/delcall":     itemDelcallEnd,
@@ -309,58 +312,51 @@ type stateFn func(*lexer) stateFn
 // Based on the lexer from the "text/template" package.
 // See http://www.youtube.com/watch?v=HxaD_trXwRE
 type lexer struct {
-	name        string    // the name of the input; used only during errors.
-	input       string    // the string being scanned.
-	state       stateFn   // the next lexing function to enter.
-	pos         ast.Pos   // current position in the input.
-	start       ast.Pos   // start position of this item.
-	width       int       // width of last rune read from input.
-	items       chan item // channel of scanned items.
-	doubleDelim bool      // flag for tags starting with double braces.
-	lastEmit    item      // type of most recent item emitted
+	name        string  // the name of the input; used only during errors.
+	input       string  // the string being scanned.
+	state       stateFn // the next lexing function to enter.
+	pos         ast.Pos // current position in the input.
+	start       ast.Pos // start position of this item.
+	width       int     // width of last rune read from input.
+	items       []item  // items scanned but not yet returned by nextItem.
+	head        int     // index of the next item in items to return.
+	doubleDelim bool    // flag for tags starting with double braces.
+	lastEmit    item    // type of most recent item emitted
 }
 
-// nextItem returns the next item from the input.
+// nextItem returns the next item from the input.  Rather than running
+// concurrently with the parser, the lexer runs its state functions on demand,
+// until at least one item has been emitted.
 func (l *lexer) nextItem() item {
-	return <-l.items
-}
-
-// drain drains the output so the lexing goroutine will exit.
-func (l *lexer) drain() {
-	for range l.items {
+	for l.head == len(l.items) {
+		if l.state == nil {
+			// The input has been lexed, or an error occurred.
+			return item{itemEOF, ast.Pos(len(l.input)), ""}
+		}
+		l.items, l.head = l.items[:0], 0
+		l.state = l.state(l)
 	}
+	i := l.items[l.head]
+	l.head++
+	return i
 }
 
 // lex creates a new scanner for the input string.
 func lex(name, input string) *lexer {
-	l := &lexer{
+	return &lexer{
 		name:  name,
 		input: input,
-		items: make(chan item),
 		state: lexText,
 	}
-	go l.run()
-	return l
 }
 
 // lexExpr lexes a single expression.
 func lexExpr(name, input string) *lexer {
-	l := &lexer{
+	return &lexer{
 		name:  name,
 		input: input,
-		items: make(chan item),
 		state: lexInsideTag,
 	}
-	go l.run()
-	return l
-}
-
-// run runs the state machine for the lexer.
-func (l *lexer) run() {
-	for l.state != nil {
-		l.state = l.state(l)
-	}
-	close(l.items)
 }
 
 // next returns the next rune in the input.
@@ -392,7 +388,7 @@ func (l *lexer) emit(t itemType) {
 		l.pos = ast.Pos(len(l.input))
 	}
 	l.lastEmit = item{t, l.pos, l.input[l.start:l.pos]}
-	l.items <- l.lastEmit
+	l.items = append(l.items, l.lastEmit)
 	l.start = l.pos
 }
 
@@ -437,7 +433,7 @@ func (l *lexer) columnNumber(pos ast.Pos) int {
 // errorf returns an error item and terminates the scan by passing
 // back a nil pointer that will be the next state, terminating l.nextItem.
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
-	l.items <- item{itemError, l.pos, fmt.Sprintf(format, args...)}
+	l.items = append(l.items, item{itemError, l.pos, fmt.Sprintf(format, args...)})
 	return nil
 }
 
@@ -666,6 +666,27 @@ Hello world.
 	}},
 }
 
+// TestLexOnDemand ensures that the lexer only scans as far as the items
+// requested of it, so that an aborted parse leaves nothing running.
+func TestLexOnDemand(t *testing.T) {
+	var text = "{namespace a}\n{template .a}{/template}"
+	var l = lex("", text)
+	if l.pos != 0 {
+		t.Fatalf("expected nothing to be lexed, got %v", l.pos)
+	}
+	if item := l.nextItem(); item.typ != itemLeftDelim || l.pos != 1 {
+		t.Fatalf("expected only the left delimiter to be lexed, got %v at %v", item, l.pos)
+	}
+	for item := l.nextItem(); item.typ != itemEOF; item = l.nextItem() {
+		if item.typ == itemError {
+			t.Fatalf("unexpected error: %v", item)
+		}
+	}
+	if int(l.pos) != len(text) {
+		t.Errorf("expected the input to be lexed, got %v", l.pos)
+	}
+}
+
 // collect gathers the emitted items into a slice.
 func collect(t *lexTest) (items []item) {
 	l := lex(t.name, t.input)
@@ -747,40 +768,40 @@ func TestScanNumber(t *testing.T) {
 
 	for _, v := range validIntegers {
 		l := lexExpr("", v)
-		item := <-l.items
+		item := l.nextItem()
 		if item.typ != itemInteger {
 			t.Fatalf("Expected a valid integer for %q, got %v", v, item.val)
 		}
 		if item.val != v {
 			t.Fatalf("Expected %q, got %q", v, item.val)
 		}
-		if err := <-l.items; err.typ != itemError {
+		if err := l.nextItem(); err.typ != itemError {
 			t.Fatalf("Expected EOF, got %v", err)
 		}
 	}
 	for _, v := range invalidIntegers {
 		l := lexExpr("", v)
-		item := <-l.items
+		item := l.nextItem()
 		if item.typ != itemError {
 			t.Fatalf("Expected an invalid integer for %q, got %v", v, item)
 		}
 	}
 	for _, v := range validFloats {
 		l := lexExpr("", v)
-		item := <-l.items
+		item := l.nextItem()
 		if item.typ != itemFloat {
 			t.Fatalf("Expected a valid float for %q", v)
 		}
 		if item.val != v {
 			t.Fatalf("Expected %q, got %q", v, item.val)
 		}
-		if err := <-l.items; err.typ != itemError {
+		if err := l.nextItem(); err.typ != itemError {
 			t.Fatalf("Expected EOF, got %v", err)
 		}
 	}
 	for _, v := range invalidFloats {
 		l := lexExpr("", v)
-		item := <-l.items
+		item := l.nextItem()
 		if item.typ == itemFloat {
 			t.Fatalf("Expected an invalid float for %q, got %v", v, item.typ)
 		}
@@ -13,23 +13,50 @@ import (
 	"github.com/robfig/soy/ast"
 	"github.com/robfig/soy/data"
 	"github.com/robfig/soy/errortypes"
+	"github.com/robfig/soy/types"
 )
 
 // tree is the parsed representation of a single Soy file.
 type tree struct {
-	name      string            // name provided for the input
-	root      *ast.ListNode     // top-level root of the tree
-	text      string            // the full input text
-	lex       *lexer            // lexer provides a sequence of tokens
-	token     [2]item           // two-token lookahead
-	peekCount int               // how many tokens have we backed up?
-	namespace string            // the current namespace, for fully-qualifying template.
-	aliases   map[string]string // map from alias to namespace e.g. {"c": "a.b.c"}
-	inmsg     bool              // true while parsing children of a message node.
+	name       string             // name provided for the input
+	root       *ast.ListNode      // top-level root of the tree
+	text       string             // the full input text
+	lex        *lexer             // lexer provides a sequence of tokens
+	token      [2]item            // two-token lookahead
+	peekCount  int                // how many tokens have we backed up?
+	namespace  string             // the current namespace, for fully-qualifying template.
+	delpackage string             // the delegate package of the file, if any.
+	aliases    map[string]string  // map from alias to namespace e.g. {"c": "a.b.c"}
+	inmsg      bool               // true while parsing children of a message node.
+	intemplate bool               // true while parsing the body of a template.
+	stopped    bool               // true after a lexical error.
+	errs       errortypes.ErrList // syntax errors recovered from so far.
 }
 
+var (
+	// errAbandoned is panicked to stop parsing the template being parsed after
+	// a syntax error, when the rest of it can not be made sense of.
+	errAbandoned = errors.New("template abandoned")
+
+	// errStopped is panicked to stop parsing after a lexical error, since the
+	// rest of the input can not be read.
+	errStopped = errors.New("parsing stopped")
+)
+
+// templateBoundaries are the commands that start or end a template.  They end
+// any blocks left open within the template before them.
+var templateBoundaries = []itemType{itemTemplate, itemDeltemplate, itemTemplateEnd, itemDeltemplateEnd}
+
 // SoyFile parses the input into a SoyFileNode (the AST).
 // The result may be used as input to a Soy backend to generate HTML or JS.
+//
+// Parsing continues after a syntax error in a tag from the end of the tag, or
+// from the end of the template if the tag leaves a block unclosed.  A block
+// command with a syntax error in its opening tag still opens the block, so that
+// its end tag is not another error.  All of the
+// syntax errors found are returned, as an errortypes.ErrList if there is more
+// than one, along with the SoyFileNode for the parts of the input that could be
+// parsed.
 func SoyFile(name, text string) (node *ast.SoyFileNode, err error) {
 	var t = &tree{
 		name:    name,
@@ -37,29 +64,34 @@ func SoyFile(name, text string) (node *ast.SoyFileNode, err error) {
 		aliases: make(map[string]string),
 		lex:     lex(name, text),
 	}
+	node = &ast.SoyFileNode{Name: t.name, Text: t.text}
+	t.root = &ast.ListNode{}
 	defer t.recover(&err)
-	t.root = t.itemList(itemEOF)
-	t.lex = nil
-	return &ast.SoyFileNode{
-		Name: t.name,
-		Text: t.text,
-		Body: t.root.Nodes,
-	}, nil
+	defer func() { node.Body = t.root.Nodes }()
+	t.itemListInto(t.root, itemEOF)
+	return node, nil
 }
 
 // itemList:
 //	textOrTag*
 // Terminates when it comes across the given end tag.
 func (t *tree) itemList(until ...itemType) *ast.ListNode {
-	var list *ast.ListNode
-	for {
+	var list = &ast.ListNode{}
+	t.itemListInto(list, until...)
+	return list
+}
+
+// itemListInto is like itemList, but appends the nodes read to the given list,
+// so that they are kept if parsing is abandoned.
+func (t *tree) itemListInto(list *ast.ListNode, until ...itemType) {
+	for first := true; ; first = false {
 		var token = t.next()
-		if list == nil {
-			list = &ast.ListNode{token.pos, nil}
+		if first {
+			list.Pos = token.pos
 		}
 		var node, halt = t.textOrTag(token, until)
 		if halt {
-			return list
+			return
 		}
 		if node != nil {
 			list.Nodes = append(list.Nodes, node)
@@ -86,6 +118,15 @@ func (t *tree) textOrTag(token item, until []itemType) (node ast.Node, halt bool
 		return nil, true
 	}
 
+	// A template boundary within a block of a template means that the block was
+	// not closed.
+	if token.typ == itemLeftDelim && t.intemplate && isOneOf(token2.typ, templateBoundaries) &&
+		!isOneOf(itemTemplateEnd, until) && !isOneOf(itemDeltemplateEnd, until) {
+		t.backup2(token)
+		t.errs = append(t.errs, t.newError("unexpected %v in unclosed block", token2))
+		panic(errAbandoned)
+	}
+
 	t.backup()
 	switch token.typ {
 	case itemText:
@@ -105,9 +146,17 @@ func (t *tree) textOrTag(token item, until []itemType) (node ast.Node, halt bool
 		}
 		return &ast.RawTextNode{token.pos, textvalue}, false
 	case itemLeftDelim:
-		return t.beginTag(), false
+		return t.tag(), false
 	case itemSoyDocStart:
 		return t.parseSoyDoc(token), false
+	case itemEOF:
+		if t.intemplate {
+			if !t.stopped {
+				t.errs = append(t.errs, t.unexpectedError(token, "template"))
+			}
+			panic(errAbandoned)
+		}
+		fallthrough
 	default:
 		t.unexpected(token, "input")
 	}
@@ -124,6 +173,84 @@ var specialChars = map[itemType]string{
 	itemRightBrace:     "}",
 }
 
+// tag parses a tag, recovering from a syntax error within it by recording the
+// error and skipping to the end of the tag.
+func (t *tree) tag() ast.Node {
+	defer t.recoverTag(t.inmsg)
+	return t.beginTag()
+}
+
+// recoverTag recovers from a syntax error in the tag being parsed, restoring
+// the state from the start of the tag.
+func (t *tree) recoverTag(inmsg bool) {
+	if e := recover(); e != nil {
+		t.inmsg = inmsg
+		t.skipError(e)
+	}
+}
+
+// header parses the header of a block command with the given function, which
+// reads up to and including the delimiter closing the header.  A syntax error
+// in the header is recorded and the rest of the header skipped, so that the
+// body of the block is still parsed, and its end tag closes it without another
+// error.  The delimiter closing the header is returned.
+func (t *tree) header(parse func() item) (end item) {
+	defer func() {
+		if e := recover(); e != nil {
+			end = t.skipError(e)
+		}
+	}()
+	return parse()
+}
+
+// skipError records the syntax error recovered from, and skips to the end of
+// the tag that it was found in, returning the delimiter closing the tag.
+// Anything else recovered from is panicked again.
+func (t *tree) skipError(e interface{}) item {
+	var err, ok = e.(errortypes.ErrFilePos)
+	if !ok {
+		panic(e)
+	}
+	t.errs = append(t.errs, err)
+	if t.peekCount == 0 && isOneOf(t.token[0].typ, []itemType{itemRightDelim, itemRightDelimEnd}) {
+		return t.token[0] // the error was at the end of the tag
+	}
+	return t.skipTag()
+}
+
+// skipTag skips to the end of the tag being parsed, returning the delimiter
+// closing it.  If the tag is not closed before a template boundary or the end
+// of input, the template containing it is abandoned.
+func (t *tree) skipTag() item {
+	for {
+		switch token := t.next(); token.typ {
+		case itemRightDelim, itemRightDelimEnd:
+			return token
+		case itemEOF:
+			t.backup()
+			t.abandon()
+			return token
+		case itemError:
+			t.stopped = true
+			panic(errStopped)
+		case itemLeftDelim:
+			if isOneOf(t.next().typ, templateBoundaries) {
+				t.backup2(token)
+				t.abandon()
+				return token
+			}
+			t.backup()
+		}
+	}
+}
+
+// abandon stops parsing the template being parsed, if any.
+func (t *tree) abandon() {
+	if t.intemplate {
+		panic(errAbandoned)
+	}
+}
+
 // beginTag parses the contents of delimiters (within a template)
 // The contents could be a command, variable, function call, expression, etc.
 // { already read.
@@ -131,8 +258,12 @@ func (t *tree) beginTag() ast.Node {
 	switch token := t.next(); token.typ {
 	case itemNamespace:
 		return t.parseNamespace(token)
+	case itemDelpackage:
+		return t.parseDelPackage(token)
 	case itemTemplate:
 		return t.parseTemplate(token)
+	case itemDeltemplate:
+		return t.parseDelTemplate(token)
 	case itemHeaderParam, itemHeaderOptionalParam:
 		return t.parseHeaderParam(token)
 	case itemIf:
@@ -151,6 +282,8 @@ func (t *tree) beginTag() ast.Node {
 		return t.parseSwitch(token, itemSwitchEnd)
 	case itemCall:
 		return t.parseCall(token)
+	case itemDelcall:
+		return t.parseDelCall(token)
 	case itemLiteral:
 		t.expect(itemRightDelim, "literal")
 		literalText := t.expect(itemText, "literal")
@@ -162,7 +295,7 @@ func (t *tree) beginTag() ast.Node {
 	case itemCss:
 		return t.parseCss(token)
 	case itemLog:
-		t.expect(itemRightDelim, "log")
+		t.header(func() item { return t.expect(itemRightDelim, "log") })
 		logBody := t.itemList(itemLogEnd)
 		t.expect(itemRightDelim, "log")
 		return &ast.LogNode{token.pos, logBody}
@@ -199,7 +332,8 @@ func (t *tree) parsePrint(token item) ast.Node {
 			return &ast.PrintNode{token.pos, expr, directives}
 		case itemPipe:
 			// read the directive name and see if there are arguments
-			var id = t.expect(itemIdent, "print directive")
+			var id, _ = t.qualifiedName(t.expect(itemIdent, "print directive"))
+			t.backup()
 			var args []ast.Node
 			for {
 				// each argument is preceded by a colon (first arg) or comma (subsequent)
@@ -209,7 +343,7 @@ func (t *tree) parsePrint(token item) ast.Node {
 					continue
 				}
 				t.backup()
-				directives = append(directives, &ast.PrintDirectiveNode{tok.pos, id.val, args})
+				directives = append(directives, &ast.PrintDirectiveNode{tok.pos, id, args})
 				break
 			}
 		default:
@@ -240,23 +374,36 @@ func (t *tree) parseAlias(token item) {
 
 // "let" has just been read.
 func (t *tree) parseLet(token item) ast.Node {
-	var name = t.expect(itemDollarIdent, "let")
-	if t.peek().typ == itemColon {
-		t.next()
-		var node = &ast.LetValueNode{token.pos, name.val[1:], t.parseExpr(0)}
-		t.expect(itemRightDelimEnd, "let")
-		return node
-	}
-	t.parseAttrs("kind")
-	switch next := t.next(); next.typ {
-	case itemRightDelim:
-		var node = &ast.LetContentNode{token.pos, name.val[1:], t.itemList(itemLetEnd)}
-		t.expect(itemRightDelim, "let")
-		return node
-	default:
-		t.unexpected(next, "{let}")
+	var (
+		name    string
+		value   ast.Node
+		kind    data.ContentKind
+		isValue bool
+	)
+	var end = t.header(func() item {
+		name = t.expect(itemDollarIdent, "let").val[1:]
+		if t.peek().typ == itemColon {
+			t.next()
+			isValue = true
+			value = t.parseExpr(0)
+			return t.expect(itemRightDelimEnd, "let")
+		}
+		kind = t.parseKind(t.parseAttrs("kind"))
+		var next = t.next()
+		if next.typ != itemRightDelim {
+			t.unexpected(next, "{let}")
+		}
+		return next
+	})
+	if isValue || end.typ == itemRightDelimEnd {
+		if value == nil {
+			value = &ast.NullNode{token.pos} // in place of the value with a syntax error
+		}
+		return &ast.LetValueNode{token.pos, name, value}
 	}
-	panic("unreachable")
+	var node = &ast.LetContentNode{token.pos, name, t.itemList(itemLetEnd), kind}
+	t.expect(itemRightDelim, "let")
+	return node
 }
 
 // "css" has just been read.
@@ -277,66 +424,107 @@ func (t *tree) parseCss(token item) ast.Node {
 
 // "call" has just been read.
 func (t *tree) parseCall(token item) ast.Node {
-	var templateName string
-	switch tok := t.next(); tok.typ {
-	case itemDotIdent:
-		templateName = tok.val
-	case itemIdent:
-		// this ident could either be {call fully.qualified.name} or attributes.
-		switch tok2 := t.next(); tok2.typ {
+	var call, _ = t.parseCallCommand(token, "call", itemCallEnd)
+	// If it's not a fully qualified template name, apply the namespace or aliases
+	if strings.HasPrefix(call.Name, ".") {
+		call.Name = t.namespace + call.Name
+	} else {
+		call.Name = t.applyAlias(call.Name)
+	}
+	return call
+}
+
+// "delcall" has just been read.
+func (t *tree) parseDelCall(token item) ast.Node {
+	var call, attrs = t.parseCallCommand(token, "delcall", itemDelcallEnd, "variant", "allowemptydefault")
+	if strings.HasPrefix(call.Name, ".") {
+		t.errorf("delcall: delegate name must be fully qualified, got %q", call.Name)
+	}
+	call.Name = t.applyAlias(call.Name)
+
+	var variant ast.Node
+	if str, ok := attrs["variant"]; ok {
+		variant = t.parseQuotedExpr(str)
+	}
+	return &ast.DelCallNode{*call, variant, t.boolAttr(attrs, "allowemptydefault", false)}
+}
+
+// parseCallCommand parses the name, attributes and params of a {call} or
+// {delcall}.  The name is returned as written, without applying the namespace
+// or aliases.  Attributes other than name and data are returned in the map.
+func (t *tree) parseCallCommand(token item, ctx string, end itemType, extraAttrs ...string) (*ast.CallNode, map[string]string) {
+	var (
+		templateName string
+		attrs        map[string]string
+		allData      = false
+		dataNode     ast.Node
+	)
+	var headerEnd = t.header(func() item {
+		switch tok := t.next(); tok.typ {
 		case itemDotIdent:
-			templateName = tok.val + tok2.val
-			for tokn := t.next(); tokn.typ == itemDotIdent; tokn = t.next() {
-				templateName += tokn.val
+			templateName = tok.val
+		case itemIdent:
+			// this ident could either be {call fully.qualified.name} or attributes.
+			switch tok2 := t.next(); tok2.typ {
+			case itemDotIdent:
+				templateName = tok.val + tok2.val
+				for tokn := t.next(); tokn.typ == itemDotIdent; tokn = t.next() {
+					templateName += tokn.val
+				}
+				t.backup()
+			case itemEquals:
+				t.backup2(tok)
+			default:
+				// a name without dots, e.g. {delcall greeting}
+				templateName = tok.val
+				t.backup()
 			}
-			t.backup()
 		default:
-			t.backup2(tok)
+			t.backup()
 		}
-	default:
-		t.backup()
-	}
-	attrs := t.parseAttrs("name", "data")
+		attrs = t.parseAttrs(append([]string{"name", "data"}, extraAttrs...)...)
 
-	if templateName == "" {
-		templateName = attrs["name"]
-	}
-	if templateName == "" {
-		t.errorf("call: template name not found")
-	}
+		if templateName == "" {
+			templateName = attrs["name"]
+		}
+		if templateName == "" {
+			t.errorf("%s: template name not found", ctx)
+		}
 
-	// If it's not a fully qualified template name, apply the namespace or aliases
-	if templateName[0] == '.' {
-		templateName = t.namespace + templateName
-	} else if dot := strings.Index(templateName, "."); dot != -1 {
-		if alias, ok := t.aliases[templateName[:dot]]; ok {
-			templateName = alias + templateName[dot:]
+		if data, ok := attrs["data"]; ok {
+			if data == "all" {
+				allData = true
+			} else {
+				dataNode = t.parseQuotedExpr(data)
+			}
 		}
-	}
 
-	var allData = false
-	var dataNode ast.Node = nil
-	if data, ok := attrs["data"]; ok {
-		if data == "all" {
-			allData = true
-		} else {
-			dataNode = t.parseQuotedExpr(data)
+		var tok = t.next()
+		if tok.typ != itemRightDelimEnd && tok.typ != itemRightDelim {
+			t.unexpected(tok, "error scanning {"+ctx+"}")
 		}
+		return tok
+	})
+
+	if headerEnd.typ == itemRightDelimEnd {
+		return &ast.CallNode{token.pos, templateName, allData, dataNode, nil}, attrs
 	}
+	body := t.parseCallParams(end)
+	t.expect(itemLeftDelim, ctx)
+	t.expect(end, ctx)
+	t.expect(itemRightDelim, ctx)
+	return &ast.CallNode{token.pos, templateName, allData, dataNode, body}, attrs
+}
 
-	switch tok := t.next(); tok.typ {
-	case itemRightDelimEnd:
-		return &ast.CallNode{token.pos, templateName, allData, dataNode, nil}
-	case itemRightDelim:
-		body := t.parseCallParams()
-		t.expect(itemLeftDelim, "call")
-		t.expect(itemCallEnd, "call")
-		t.expect(itemRightDelim, "call")
-		return &ast.CallNode{token.pos, templateName, allData, dataNode, body}
-	default:
-		t.unexpected(tok, "error scanning {call}")
+// applyAlias replaces the first segment of the given name with the namespace
+// that it aliases, if any.
+func (t *tree) applyAlias(name string) string {
+	if dot := strings.Index(name, "."); dot != -1 {
+		if alias, ok := t.aliases[name[:dot]]; ok {
+			return alias + name[dot:]
+		}
 	}
-	panic("unreachable")
+	return name
 }
 
 // parseCallParams collects a list of call params, of which there are many
@@ -345,8 +533,9 @@ func (t *tree) parseCall(token item) ast.Node {
 // {param a}expr{/param}
 // {param key="a" value="'expr'"/}
 // {param key="a"}expr{/param}
-// The closing delimiter of the {call} has just been read.
-func (t *tree) parseCallParams() []ast.Node {
+// The closing delimiter of the {call} has just been read, and the list ends
+// with the given end command.
+func (t *tree) parseCallParams(end itemType) []ast.Node {
 	var params []ast.Node
 	for {
 		var (
@@ -369,7 +558,7 @@ func (t *tree) parseCallParams() []ast.Node {
 		}
 
 		var cmd = t.next()
-		if cmd.typ == itemCallEnd {
+		if cmd.typ == end {
 			t.backup2(initial)
 			return params
 		}
@@ -377,55 +566,66 @@ func (t *tree) parseCallParams() []ast.Node {
 			t.errorf("expected param declaration")
 		}
 
-		var firstIdent = t.expect(itemIdent, "param")
-		switch tok := t.next(); tok.typ {
-		case itemColon:
-			key = firstIdent.val
-			value = t.parseExpr(0)
-			t.expect(itemRightDelimEnd, "param")
-			params = append(params, &ast.CallParamValueNode{initial.pos, key, value})
-			continue
-		case itemRightDelim:
-			key = firstIdent.val
-			value = t.itemList(itemParamEnd)
-			t.expect(itemRightDelim, "param")
-			params = append(params, &ast.CallParamContentNode{initial.pos, key, value})
-			continue
-		case itemIdent:
-			key = firstIdent.val
-			t.backup()
-		case itemEquals:
-			t.backup2(firstIdent)
-		default:
-			t.unexpected(tok, "param. (expected ':', '}', or '=')")
-		}
+		var (
+			kind    = data.KindUnspecified
+			isValue bool
+		)
+		var end = t.header(func() item {
+			var firstIdent = t.expect(itemIdent, "param")
+			switch tok := t.next(); tok.typ {
+			case itemColon:
+				key, isValue = firstIdent.val, true
+				value = t.parseExpr(0)
+				return t.expect(itemRightDelimEnd, "param")
+			case itemRightDelim:
+				key = firstIdent.val
+				return tok
+			case itemIdent:
+				key = firstIdent.val
+				t.backup()
+			case itemEquals:
+				t.backup2(firstIdent)
+			default:
+				t.unexpected(tok, "param. (expected ':', '}', or '=')")
+			}
+
+			attrs := t.parseAttrs("key", "value", "kind")
+			var ok bool
+			if key == "" {
+				if key, ok = attrs["key"]; !ok {
+					t.errorf("param key not found.  (attrs: %v)", attrs)
+				}
+			}
+			if valueStr, ok := attrs["value"]; ok {
+				isValue = true
+				value = t.parseQuotedExpr(valueStr)
+				return t.expect(itemRightDelimEnd, "param")
+			}
+			kind = t.parseKind(attrs)
+			return t.expect(itemRightDelim, "param")
+		})
 
-		attrs := t.parseAttrs("key", "value", "kind")
-		var ok bool
-		if key == "" {
-			if key, ok = attrs["key"]; !ok {
-				t.errorf("param key not found.  (attrs: %v)", attrs)
+		if isValue || end.typ == itemRightDelimEnd {
+			if value == nil {
+				value = &ast.NullNode{initial.pos} // in place of the value with a syntax error
 			}
-		}
-		var valueStr string
-		if valueStr, ok = attrs["value"]; !ok {
-			t.expect(itemRightDelim, "param")
-			value = t.itemList(itemParamEnd)
-			t.expect(itemRightDelim, "param")
-			params = append(params, &ast.CallParamContentNode{initial.pos, key, value})
-		} else {
-			value = t.parseQuotedExpr(valueStr)
-			t.expect(itemRightDelimEnd, "param")
 			params = append(params, &ast.CallParamValueNode{initial.pos, key, value})
+			continue
 		}
+		value = t.itemList(itemParamEnd)
+		t.expect(itemRightDelim, "param")
+		params = append(params, &ast.CallParamContentNode{initial.pos, key, value, kind})
 	}
 }
 
 // "switch" has just been read.
 func (t *tree) parseSwitch(token item, end itemType) ast.Node {
 	const ctx = "switch"
-	var switchValue = t.parseExpr(0)
-	t.expect(itemRightDelim, ctx)
+	var switchValue ast.Node = &ast.NullNode{token.pos} // in place of a value with a syntax error
+	t.header(func() item {
+		switchValue = t.parseExpr(0)
+		return t.expect(itemRightDelim, ctx)
+	})
 
 	var cases []*ast.SwitchCaseNode
 	for {
@@ -448,21 +648,27 @@ func (t *tree) parseSwitch(token item, end itemType) ast.Node {
 // "case" has just been read.
 func (t *tree) parseCase(token item) *ast.SwitchCaseNode {
 	var values []ast.Node
-	for {
-		if token.typ != itemDefault {
-			values = append(values, t.parseExpr(0))
-		}
-		switch tok := t.next(); tok.typ {
-		case itemComma:
-			continue
-		case itemRightDelim:
-			var body = t.itemList(itemCase, itemDefault, itemSwitchEnd, itemPluralEnd)
-			t.backup()
-			return &ast.SwitchCaseNode{token.pos, values, body}
-		default:
-			t.unexpected(tok, "switch case")
+	t.header(func() item {
+		for {
+			if token.typ != itemDefault {
+				values = append(values, t.parseExpr(0))
+			}
+			switch tok := t.next(); tok.typ {
+			case itemComma:
+				continue
+			case itemRightDelim:
+				return tok
+			default:
+				t.unexpected(tok, "switch case")
+			}
 		}
+	})
+	if token.typ != itemDefault && len(values) == 0 {
+		values = append(values, &ast.IntNode{token.pos, 0}) // in place of a value with a syntax error
 	}
+	var body = t.itemList(itemCase, itemDefault, itemSwitchEnd, itemPluralEnd)
+	t.backup()
+	return &ast.SwitchCaseNode{token.pos, values, body}
 }
 
 // "for" or "foreach" has just been read.
@@ -471,25 +677,29 @@ func (t *tree) parseFor(token item) ast.Node {
 	// for and foreach have the same syntax, differing only in the requirement they impose:
 	// - for accepts any list value
 	// - foreach requires the collection to be a variable reference.
-	var vartoken = t.expect(itemDollarIdent, ctx)
-	var intoken = t.expect(itemIdent, ctx)
-	if intoken.val != "in" {
-		t.unexpected(intoken, "for loop (expected 'in')")
-	}
+	var varName string
+	var collection ast.Node = &ast.ListLiteralNode{token.pos, nil} // in place of a list with a syntax error
+	t.header(func() item {
+		varName = t.expect(itemDollarIdent, ctx).val[1:]
+		var intoken = t.expect(itemIdent, ctx)
+		if intoken.val != "in" {
+			t.unexpected(intoken, "for loop (expected 'in')")
+		}
 
-	// get the collection to iterate through and enforce the requirements
-	var collection = t.parseExpr(0)
-	t.expect(itemRightDelim, "for")
+		// get the collection to iterate through and enforce the requirements
+		collection = t.parseExpr(0)
+		return t.expect(itemRightDelim, "for")
+	})
 
 	var body = t.itemList(itemIfempty, itemForeachEnd, itemForEnd)
 	t.backup()
 	var ifempty ast.Node
 	if t.next().typ == itemIfempty {
-		t.expect(itemRightDelim, "ifempty")
+		t.header(func() item { return t.expect(itemRightDelim, "ifempty") })
 		ifempty = t.itemList(itemForeachEnd, itemForEnd)
 	}
 	t.expect(itemRightDelim, "/for")
-	return &ast.ForNode{token.pos, vartoken.val[1:], collection, body, ifempty}
+	return &ast.ForNode{token.pos, varName, collection, body, ifempty}
 }
 
 // "if" has just been read.
@@ -499,9 +709,14 @@ func (t *tree) parseIf(token item) ast.Node {
 	for {
 		var condExpr ast.Node
 		if !isElse {
-			condExpr = t.parseExpr(0)
+			condExpr = &ast.BoolNode{token.pos, false} // in place of a condition with a syntax error
 		}
-		t.expect(itemRightDelim, "if")
+		t.header(func() item {
+			if !isElse {
+				condExpr = t.parseExpr(0)
+			}
+			return t.expect(itemRightDelim, "if")
+		})
 		var body = t.itemList(itemElseif, itemElse, itemIfEnd)
 		conds = append(conds, &ast.IfCondNode{token.pos, condExpr, body})
 		t.backup()
@@ -574,11 +789,14 @@ func (t *tree) parseAttrs(allowedNames ...string) map[string]string {
 // "msg" has just been read.
 func (t *tree) parseMsg(token item) ast.Node {
 	const ctx = "msg"
-	var attrs = t.parseAttrs("desc", "meaning", "hidden")
-	if _, ok := attrs["desc"]; !ok {
-		t.errorf("Tag 'msg' must have a 'desc' attribute")
-	}
-	t.expect(itemRightDelim, ctx)
+	var attrs map[string]string
+	t.header(func() item {
+		attrs = t.parseAttrs("desc", "meaning", "hidden")
+		if _, ok := attrs["desc"]; !ok {
+			t.errorf("Tag 'msg' must have a 'desc' attribute")
+		}
+		return t.expect(itemRightDelim, ctx)
+	})
 
 	// Parse the message body.
 	t.inmsg = true
@@ -702,6 +920,30 @@ func (t *tree) parseNamespace(token item) ast.Node {
 		t.errorf("file may have only one namespace declaration")
 	}
 	const ctx = "namespace"
+	var name = t.parseDottedName(ctx)
+	var autoescape = t.parseAutoescape(t.parseAttrs("autoescape"))
+	t.expect(itemRightDelim, ctx)
+	t.namespace = name
+	return &ast.NamespaceNode{token.pos, name, autoescape}
+}
+
+// "delpackage" has just been read.
+func (t *tree) parseDelPackage(token item) ast.Node {
+	const ctx = "delpackage"
+	if t.namespace != "" {
+		t.errorf("delpackage must precede the namespace declaration")
+	}
+	if t.delpackage != "" {
+		t.errorf("file may have only one delpackage declaration")
+	}
+	t.delpackage = t.parseDottedName(ctx)
+	t.expect(itemRightDelim, ctx)
+	return &ast.DelPackageNode{token.pos, t.delpackage}
+}
+
+// parseDottedName reads a name made of an identifier followed by any number of
+// dotted identifiers, e.g. "a.b.c".
+func (t *tree) parseDottedName(ctx string) string {
 	var name = t.expect(itemIdent, ctx).val
 	for {
 		switch part := t.next(); part.typ {
@@ -709,10 +951,7 @@ func (t *tree) parseNamespace(token item) ast.Node {
 			name += part.val
 		default:
 			t.backup()
-			var autoescape = t.parseAutoescape(t.parseAttrs("autoescape"))
-			t.expect(itemRightDelim, ctx)
-			t.namespace = name
-			return &ast.NamespaceNode{token.pos, name, autoescape}
+			return name
 		}
 	}
 }
@@ -727,40 +966,148 @@ func (t *tree) parseAutoescape(attrs map[string]string) ast.AutoescapeType {
 		return ast.AutoescapeContextual
 	case "deprecated-contextual":
 		return ast.AutoescapeContextual
+	case "strict":
+		return ast.AutoescapeStrict
 	case "true":
 		return ast.AutoescapeOn
 	case "false":
 		return ast.AutoescapeOff
 	default:
-		t.errorf(`expected "true", "false", or "contextual" for autoescape, got %q`, val)
+		t.errorf(`expected "true", "false", "contextual", or "strict" for autoescape, got %q`, val)
 	}
 	panic("unreachable")
 }
 
+// parseKind returns the content kind given by the "kind" attribute, or
+// KindUnspecified if there is none.
+func (t *tree) parseKind(attrs map[string]string) data.ContentKind {
+	var val, ok = attrs["kind"]
+	if !ok {
+		return data.KindUnspecified
+	}
+	kind, ok := data.ParseContentKind(val)
+	if !ok {
+		t.errorf(`expected "html", "attributes", "text", "uri", "js", or "css" for kind, got %q`, val)
+	}
+	return kind
+}
+
 func (t *tree) parseTemplate(token item) ast.Node {
 	const ctx = "template tag"
 	var id = t.expect(itemDotIdent, ctx)
 	var attrs = t.parseAttrs("autoescape", "private", "kind")
 	var autoescape = t.parseAutoescape(attrs)
 	var private = t.boolAttr(attrs, "private", false)
+	var kind = t.parseKind(attrs)
 	t.expect(itemRightDelim, ctx)
 	tmpl := &ast.TemplateNode{
 		token.pos,
 		t.namespace + id.val,
-		t.itemList(itemTemplateEnd),
+		t.templateBody(itemTemplateEnd, ctx),
 		autoescape,
 		private,
+		kind,
+		nil,
+		nil,
+	}
+	return tmpl
+}
+
+// "deltemplate" has just been read.
+func (t *tree) parseDelTemplate(token item) ast.Node {
+	const ctx = "deltemplate tag"
+	var name = t.parseDottedName(ctx)
+	var attrs = t.parseAttrs("variant", "autoescape", "kind")
+	var variant string
+	if str, ok := attrs["variant"]; ok {
+		switch node := t.parseQuotedExpr(str).(type) {
+		case *ast.StringNode:
+			variant = node.Value
+		case *ast.IntNode:
+			variant = strconv.FormatInt(node.Value, 10)
+		default:
+			t.errorf("deltemplate variant must be a string or integer literal, got %v", node)
+		}
 	}
+	var autoescape = t.parseAutoescape(attrs)
+	var kind = t.parseKind(attrs)
 	t.expect(itemRightDelim, ctx)
+	tmpl := &ast.TemplateNode{
+		token.pos,
+		delTemplateName(t.namespace, t.delpackage, name, variant),
+		t.templateBody(itemDeltemplateEnd, ctx),
+		autoescape,
+		false,
+		kind,
+		nil,
+		&ast.Delegate{name, variant, t.delpackage},
+	}
 	return tmpl
 }
 
+// templateBody parses the body of a template, up to and including the given
+// end tag.  If the template is abandoned after a syntax error, or parsing is
+// stopped, the body holds the nodes parsed before the error, and parsing
+// resumes after the end tag.
+func (t *tree) templateBody(end itemType, ctx string) (body *ast.ListNode) {
+	body = &ast.ListNode{}
+	var intemplate = t.intemplate
+	t.intemplate = true
+	defer func() {
+		t.intemplate = intemplate
+		if e := recover(); e != nil {
+			if e != errAbandoned && e != errStopped {
+				panic(e)
+			}
+			t.inmsg = false
+			if token := t.next(); token.typ == itemLeftDelim {
+				if t.next().typ == end {
+					t.expect(itemRightDelim, ctx)
+					return
+				}
+				t.backup2(token)
+				return
+			}
+			t.backup()
+		}
+	}()
+	t.itemListInto(body, end)
+	t.expect(itemRightDelim, ctx)
+	return body
+}
+
+var nonAlphanumChars = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+// delTemplateName returns a unique name for the implementation of the given
+// delegate.  It is a valid identifier in the file's namespace, so that it may
+// be used to refer to the implementation in the registry and generated code.
+// The package, name and variant are separated by double underscores, and each
+// of their characters other than letters and digits is escaped as its hex code
+// point between underscores, so that distinct delegates never share a name.
+// For example, the variant 'a-b' of x.y is __deltemplate____x_2e_y__a_2d_b.
+func delTemplateName(namespace, pkg, name, variant string) string {
+	var parts = []string{"__deltemplate", pkg, name, variant}
+	for i := range parts[1:] {
+		parts[i+1] = nonAlphanumChars.ReplaceAllStringFunc(parts[i+1], func(ch string) string {
+			return fmt.Sprintf("_%x_", []rune(ch)[0])
+		})
+	}
+	return namespace + "." + strings.Join(parts, "__")
+}
+
 func (t *tree) parseHeaderParam(token item) ast.Node {
 	const ctx = "@param tag"
 	var opt = token.typ == itemHeaderOptionalParam
 	var name = t.expect(itemIdent, ctx)
 	t.expect(itemColon, ctx)
 	var typ = t.expect(itemHeaderParamType, ctx)
+	var typeNode = ast.TypeNode{Pos: typ.pos, Expr: typ.val}
+	if typ.val != "" {
+		var err error
+		if typeNode.Type, err = types.Parse(typ.val); err != nil {
+			t.errorf("%v", err)
+		}
+	}
 	var defval ast.Node
 	if tok := t.next(); tok.typ == itemEquals {
 		defval = t.parseExpr(0)
@@ -772,7 +1119,7 @@ func (t *tree) parseHeaderParam(token item) ast.Node {
 		Pos:      token.pos,
 		Optional: opt,
 		Name:     name.val,
-		Type:     ast.TypeNode{Pos: typ.pos, Expr: typ.val},
+		Type:     typeNode,
 		Default:  defval,
 	}
 }
@@ -807,7 +1154,6 @@ func (t *tree) boolAttr(attrs map[string]string, key string, defaultValue bool)
 // string as a standalone expression.
 func (t *tree) parseQuotedExpr(str string) ast.Node {
 	var tt = &tree{lex: lexExpr("", str)}
-	defer tt.lex.drain()
 	return tt.parseExpr(0)
 }
 
@@ -1115,27 +1461,31 @@ func (t *tree) newValueNode(tok item) ast.Node {
 	case itemDollarIdent:
 		return t.parseDataRef(tok)
 	case itemIdent:
-		next := t.next()
+		var name, next = t.qualifiedName(tok)
 		if next.typ != itemLeftParen {
-			return t.newGlobalNode(tok, next)
+			t.backup()
+			return &ast.GlobalNode{tok.pos, name, data.Undefined{}}
 		}
-		return t.newFunctionNode(tok)
+		return t.newFunctionNode(tok, name)
 	}
 	panic("unreachable")
 }
 
-func (t *tree) newGlobalNode(tok, next item) ast.Node {
+// qualifiedName reads the rest of a dotted name that begins with the given
+// identifier, e.g. a global or a namespaced function.  It returns the name
+// along with the token that follows it.
+func (t *tree) qualifiedName(tok item) (string, item) {
 	var name = tok.val
+	var next = t.next()
 	for next.typ == itemDotIdent {
 		name += next.val
 		next = t.next()
 	}
-	t.backup()
-	return &ast.GlobalNode{tok.pos, name, data.Undefined{}}
+	return name, next
 }
 
-func (t *tree) newFunctionNode(tok item) ast.Node {
-	node := &ast.FunctionNode{tok.pos, tok.val, nil}
+func (t *tree) newFunctionNode(tok item, name string) ast.Node {
+	node := &ast.FunctionNode{tok.pos, name, nil}
 	if t.peek().typ == itemRightParen {
 		t.next()
 		return node
@@ -1197,19 +1547,27 @@ func (t *tree) peek() item {
 	return t.token[0]
 }
 
-// recover is the handler that turns panics into returns from the top level of Parse.
+// recover is the handler that turns panics into returns from the top level of
+// Parse, along with any syntax errors that were recovered from.
 func (t *tree) recover(errp *error) {
 	e := recover()
 	if e == nil {
+		if len(t.errs) > 0 {
+			t.lex = nil
+			*errp = t.errs.Err()
+		}
 		return
 	}
 	if _, ok := e.(runtime.Error); ok {
 		panic(e)
 	}
-	t.lex.drain()
 	t.lex = nil
-	if str, ok := e.(string); ok {
+	if e == errStopped {
+		*errp = t.errs.Err()
+	} else if str, ok := e.(string); ok {
 		*errp = errors.New(str)
+	} else if err, ok := e.(errortypes.ErrFilePos); ok {
+		*errp = append(t.errs, err).Err()
 	} else {
 		*errp = e.(error)
 	}
@@ -1227,22 +1585,36 @@ func (t *tree) expect(expected itemType, context string) item {
 // unexpected complains about the token and terminates processing.
 func (t *tree) unexpected(token item, context string) {
 	if token.typ == itemError {
-		t.errorf("lexical error: %v", token)
+		t.errs = append(t.errs, t.unexpectedError(token, context))
+		t.stopped = true
+		panic(errStopped)
 	}
-	t.errorf("unexpected %v in %s", token, context)
+	panic(t.unexpectedError(token, context))
+}
+
+// unexpectedError returns the error for an unexpected token.
+func (t *tree) unexpectedError(token item, context string) errortypes.ErrFilePos {
+	if token.typ == itemError {
+		return t.newError("lexical error: %v", token)
+	}
+	return t.newError("unexpected %v in %s", token, context)
 }
 
 // errorf formats the error and terminates processing.
 func (t *tree) errorf(format string, args ...interface{}) {
+	panic(t.newError(format, args...))
+}
+
+// newError formats an error at the position of the current token.
+func (t *tree) newError(format string, args ...interface{}) errortypes.ErrFilePos {
 	// get current token (taking account of backups)
 	var tok = t.token[0]
 	if t.peekCount > 0 {
 		tok = t.token[t.peekCount-1]
 	}
-	t.root = nil
 	format = fmt.Sprintf("template %s:%d:%d: %s", t.name,
 		t.lex.lineNumber(tok.pos), t.lex.columnNumber(tok.pos), format)
-	panic(
+	return errortypes.ToErrFilePos(
 		errortypes.NewErrFilePosf(
 			t.name,
 			t.lex.lineNumber(tok.pos),
@@ -7,7 +7,9 @@ import (
 	"testing"
 
 	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/data"
 	"github.com/robfig/soy/errortypes"
+	"github.com/robfig/soy/types"
 )
 
 type parseTest struct {
@@ -36,7 +38,7 @@ func tFile(nodes ...ast.Node) ast.Node {
 }
 
 func tTemplate(name string, nodes ...ast.Node) ast.Node {
-	n := &ast.TemplateNode{0, name, nil, ast.AutoescapeOn, false}
+	n := &ast.TemplateNode{0, name, nil, ast.AutoescapeOn, false, data.KindUnspecified, nil, nil}
 	n.Body = newList(0)
 	n.Body.Nodes = nodes
 	return n
@@ -84,6 +86,8 @@ var parseTests = []parseTest{
 			{0, "truncate", []ast.Node{
 				&ast.IntNode{0, 5},
 				&ast.BoolNode{0, false}}}}})},
+	{"namespaced print directive", `{'hello'|acme.shout:1}`, tFile(&ast.PrintNode{0, str("hello"),
+		[]*ast.PrintDirectiveNode{{0, "acme.shout", []ast.Node{&ast.IntNode{0, 1}}}}})},
 
 	{"soydoc", `/**
  * Text
@@ -108,13 +112,14 @@ var parseTests = []parseTest{
 Hello world.
 {/template}
 `, tFile(&ast.TemplateNode{Name: ".main", Body: &ast.ListNode{Nodes: []ast.Node{
-		&ast.HeaderParamNode{0, false, "NAME", ast.TypeNode{0, "?"}, nil},
-		&ast.HeaderParamNode{0, false, "NAME", ast.TypeNode{0, "any"}, nil},
-		&ast.HeaderParamNode{0, false, "NAME", ast.TypeNode{0, ""}, &ast.StringNode{0, "'default'", "default"}},
-		&ast.HeaderParamNode{0, false, "NAME", ast.TypeNode{0, "int"}, &ast.IntNode{0, 10}},
-		&ast.HeaderParamNode{0, true, "NAME", ast.TypeNode{0, "[age: int, name: string]"}, nil},
-		&ast.HeaderParamNode{0, true, "NAME", ast.TypeNode{0, "map<int, string>"}, nil},
-		&ast.HeaderParamNode{0, true, "NAME", ast.TypeNode{0, "list<string>"}, nil},
+		&ast.HeaderParamNode{0, false, "NAME", ast.TypeNode{0, "?", types.Unknown}, nil},
+		&ast.HeaderParamNode{0, false, "NAME", ast.TypeNode{0, "any", types.Any}, nil},
+		&ast.HeaderParamNode{0, false, "NAME", ast.TypeNode{0, "", nil}, &ast.StringNode{0, "'default'", "default"}},
+		&ast.HeaderParamNode{0, false, "NAME", ast.TypeNode{0, "int", types.Int}, &ast.IntNode{0, 10}},
+		&ast.HeaderParamNode{0, true, "NAME", ast.TypeNode{0, "[age: int, name: string]",
+			types.NewRecord(types.Field{"age", types.Int}, types.Field{"name", types.String})}, nil},
+		&ast.HeaderParamNode{0, true, "NAME", ast.TypeNode{0, "map<int, string>", types.Map{types.Int, types.String}}, nil},
+		&ast.HeaderParamNode{0, true, "NAME", ast.TypeNode{0, "list<string>", types.List{types.String}}, nil},
 		&ast.RawTextNode{0, []byte("Hello world.")},
 	}}})},
 
@@ -231,6 +236,8 @@ Hello world.
 	)}, nil})},
 
 	{"function", `{hasData()}`, tFile(&ast.PrintNode{0, &ast.FunctionNode{0, "hasData", nil}, nil})},
+	{"namespaced function", `{acme.formatPrice(1)}`, tFile(&ast.PrintNode{0,
+		&ast.FunctionNode{0, "acme.formatPrice", []ast.Node{&ast.IntNode{0, 1}}}, nil})},
 
 	{"empty list", `{[]}`, tFile(&ast.PrintNode{0, &ast.ListLiteralNode{0, nil}, nil})},
 
@@ -415,14 +422,42 @@ Hello world.
 		&ast.CallNode{0, "foo.goo.mooTemplate", true, nil, nil},
 		&ast.CallNode{0, ".zooTemplate", false, &ast.DataRefNode{0, "animals", nil}, []ast.Node{
 			&ast.CallParamValueNode{0, "yoo", &ast.FunctionNode{0, "round", []ast.Node{&ast.DataRefNode{0, "too", nil}}}},
-			&ast.CallParamContentNode{0, "woo", tList(newText(0, "poo"))},
-			&ast.CallParamContentNode{0, "doo", tList(newText(0, "doopoo"))}}},
+			&ast.CallParamContentNode{0, "woo", tList(newText(0, "poo")), data.KindUnspecified},
+			&ast.CallParamContentNode{0, "doo", tList(newText(0, "doopoo")), data.KindHTML}}},
 		&ast.CallNode{0, "a.long.template.booTemplate_", false, nil, nil},
 		&ast.CallNode{0, ".zooTemplate", false, &ast.DataRefNode{0, "animals", nil}, []ast.Node{
 			&ast.CallParamValueNode{0, "yoo", &ast.FunctionNode{0, "round", []ast.Node{&ast.DataRefNode{0, "too", nil}}}},
-			&ast.CallParamContentNode{0, "woo", tList(newText(0, "poo"))},
+			&ast.CallParamContentNode{0, "woo", tList(newText(0, "poo")), data.KindUnspecified},
 			&ast.CallParamValueNode{0, "zoo", &ast.IntNode{0, 0}},
-			&ast.CallParamContentNode{0, "doo", tList(newText(0, "doopoo"))}}},
+			&ast.CallParamContentNode{0, "doo", tList(newText(0, "doopoo")), data.KindHTML}}},
+	)},
+
+	{"delcall", `
+{delcall foo.goo.moo /}
+{delcall name="foo.goo.moo" data="all" variant="$v" allowemptydefault="true" /}
+{delcall b.moo variant="'alpha'"}
+  {param yoo: 1 /}
+{/delcall}`, tFile(
+		&ast.DelCallNode{ast.CallNode{0, "foo.goo.moo", false, nil, nil}, nil, false},
+		&ast.DelCallNode{ast.CallNode{0, "foo.goo.moo", true, nil, nil}, &ast.DataRefNode{0, "v", nil}, true},
+		&ast.DelCallNode{ast.CallNode{0, "b.moo", false, nil, []ast.Node{
+			&ast.CallParamValueNode{0, "yoo", &ast.IntNode{0, 1}}}}, str("alpha"), false},
+	)},
+
+	{"deltemplate", `
+{delpackage my.pkg}
+{namespace ns}
+{deltemplate foo.bar}a{/deltemplate}
+{deltemplate foo.bar variant="'alpha-1'"}b{/deltemplate}
+{deltemplate foo.bar variant="2" kind="text"}c{/deltemplate}`, tFile(
+		&ast.DelPackageNode{0, "my.pkg"},
+		&ast.NamespaceNode{0, "ns", 0},
+		&ast.TemplateNode{0, "ns.__deltemplate__my_2e_pkg__foo_2e_bar__", tList(newText(0, "a")),
+			0, false, 0, nil, &ast.Delegate{"foo.bar", "", "my.pkg"}},
+		&ast.TemplateNode{0, "ns.__deltemplate__my_2e_pkg__foo_2e_bar__alpha_2d_1", tList(newText(0, "b")),
+			0, false, 0, nil, &ast.Delegate{"foo.bar", "alpha-1", "my.pkg"}},
+		&ast.TemplateNode{0, "ns.__deltemplate__my_2e_pkg__foo_2e_bar__2", tList(newText(0, "c")),
+			0, false, 0, nil, &ast.Delegate{"foo.bar", "2", "my.pkg"}},
 	)},
 
 	{"let", `
@@ -431,8 +466,8 @@ Hello world.
 {let $gamma kind="text"}BOO{/let}
 `, /*{let $delta kind="html"}Boo!{/let}*/ tFile(
 		&ast.LetValueNode{0, "alpha", &ast.DataRefNode{0, "boo", []ast.Node{&ast.DataRefKeyNode{0, false, "foo"}}}},
-		&ast.LetContentNode{0, "beta", tList(newText(0, "Boo!"))},
-		&ast.LetContentNode{0, "gamma", tList(newText(0, "BOO"))},
+		&ast.LetContentNode{0, "beta", tList(newText(0, "Boo!")), data.KindUnspecified},
+		&ast.LetContentNode{0, "gamma", tList(newText(0, "BOO")), data.KindText},
 	)},
 
 	{"comments", `
@@ -576,10 +611,16 @@ func eqTree(t *testing.T, expected, actual ast.Node) bool {
 		return eqNodes(t, expected.(*ast.ListNode).Nodes, actual.(*ast.ListNode).Nodes)
 	case *ast.NamespaceNode:
 		return eqstr(t, "namespace", expected.(*ast.NamespaceNode).Name, actual.(*ast.NamespaceNode).Name)
+	case *ast.DelPackageNode:
+		return eqstr(t, "delpackage", expected.(*ast.DelPackageNode).Name, actual.(*ast.DelPackageNode).Name)
 	case *ast.TemplateNode:
 		if expected.(*ast.TemplateNode).Name != actual.(*ast.TemplateNode).Name {
 			return false
 		}
+		if !reflect.DeepEqual(expected.(*ast.TemplateNode).Delegate, actual.(*ast.TemplateNode).Delegate) {
+			t.Errorf("delegate: expected %v got %v", expected.(*ast.TemplateNode).Delegate, actual.(*ast.TemplateNode).Delegate)
+			return false
+		}
 		return eqTree(t, expected.(*ast.TemplateNode).Body, actual.(*ast.TemplateNode).Body)
 	case *ast.RawTextNode:
 		return eqstr(t, "text", string(expected.(*ast.RawTextNode).Text), string(actual.(*ast.RawTextNode).Text))
@@ -595,6 +636,7 @@ func eqTree(t *testing.T, expected, actual ast.Node) bool {
 			eqTree(t, expected.(*ast.LetValueNode).Expr, actual.(*ast.LetValueNode).Expr)
 	case *ast.LetContentNode:
 		return eqstr(t, "let", expected.(*ast.LetContentNode).Name, actual.(*ast.LetContentNode).Name) &&
+			eqstr(t, "let kind", expected.(*ast.LetContentNode).Kind.String(), actual.(*ast.LetContentNode).Kind.String()) &&
 			eqTree(t, expected.(*ast.LetContentNode).Body, actual.(*ast.LetContentNode).Body)
 
 	case *ast.NullNode:
@@ -681,11 +723,16 @@ func eqTree(t *testing.T, expected, actual ast.Node) bool {
 		return eqstr(t, "call", expected.(*ast.CallNode).Name, actual.(*ast.CallNode).Name) &&
 			eqTree(t, expected.(*ast.CallNode).Data, actual.(*ast.CallNode).Data) &&
 			eqNodes(t, expected.(*ast.CallNode).Params, actual.(*ast.CallNode).Params)
+	case *ast.DelCallNode:
+		return eqTree(t, &expected.(*ast.DelCallNode).CallNode, &actual.(*ast.DelCallNode).CallNode) &&
+			eqTree(t, expected.(*ast.DelCallNode).Variant, actual.(*ast.DelCallNode).Variant) &&
+			eqbool(t, "allowemptydefault", expected.(*ast.DelCallNode).AllowEmptyDefault, actual.(*ast.DelCallNode).AllowEmptyDefault)
 	case *ast.CallParamValueNode:
 		return eqstr(t, "param", expected.(*ast.CallParamValueNode).Key, actual.(*ast.CallParamValueNode).Key) &&
 			eqTree(t, expected.(*ast.CallParamValueNode).Value, actual.(*ast.CallParamValueNode).Value)
 	case *ast.CallParamContentNode:
 		return eqstr(t, "param", expected.(*ast.CallParamContentNode).Key, actual.(*ast.CallParamContentNode).Key) &&
+			eqstr(t, "param kind", expected.(*ast.CallParamContentNode).Kind.String(), actual.(*ast.CallParamContentNode).Kind.String()) &&
 			eqTree(t, expected.(*ast.CallParamContentNode).Content, actual.(*ast.CallParamContentNode).Content)
 
 	case *ast.IfNode:
@@ -709,6 +756,7 @@ func eqTree(t *testing.T, expected, actual ast.Node) bool {
 		return eqbool(t, "@param optional?", expected.(*ast.HeaderParamNode).Optional, actual.(*ast.HeaderParamNode).Optional) &&
 			eqstr(t, "@param name",  expected.(*ast.HeaderParamNode).Name, actual.(*ast.HeaderParamNode).Name) &&
 			eqstr(t, "@param type", expected.(*ast.HeaderParamNode).Type.Expr, actual.(*ast.HeaderParamNode).Type.Expr) &&
+			eqstr(t, "@param parsed type", fmt.Sprint(expected.(*ast.HeaderParamNode).Type.Type), fmt.Sprint(actual.(*ast.HeaderParamNode).Type.Type)) &&
 			eqTree(t, expected.(*ast.HeaderParamNode).Default, actual.(*ast.HeaderParamNode).Default)
 	}
 	panic(fmt.Sprintf("type not implemented: %T", actual))
@@ -888,13 +936,13 @@ func TestRecognizeCommands(t *testing.T) {
 		"  {param key=\"foo\"}blah blah{/param}\n"+
 		"{/call}")
 
-	// TODO: implement delcall
-	// works(t, "{delcall aaa.bbb.ccc data=\"all\" /}")
-	// works(t, ""+
-	// 	"{delcall name=\"ddd.eee\"}\n"+
-	// 	"  {{param key=\"boo\" value=\"$boo\" /}}\n"+
-	// 	"  {param key=\"foo\"}blah blah{/param}\n"+
-	// 	"{/delcall}")
+	works(t, "{delcall aaa.bbb.ccc data=\"all\" /}")
+	works(t, "{delcall aaa variant=\"$v\" /}")
+	works(t, ""+
+		"{delcall name=\"ddd.eee\"}\n"+
+		"  {{param key=\"boo\" value=\"$boo\" /}}\n"+
+		"  {param key=\"foo\"}blah blah{/param}\n"+
+		"{/delcall}")
 
 	// TODO: implement phname
 	// works(t, ""+
@@ -940,6 +988,11 @@ func TestRecognizeCommands(t *testing.T) {
 	fails(t, "{call .aaa.bbb /}")
 	fails(t, "{delcall name=\"ddd.eee\"}{param foo: 0}{/call}")
 	fails(t, "{delcall .dddEee /}")
+	fails(t, "{delcall ddd.eee allowemptydefault=\"yes\" /}")
+	fails(t, "{deltemplate .ddd}{/deltemplate}")
+	fails(t, "{deltemplate ddd variant=\"$v\"}{/deltemplate}")
+	fails(t, "{namespace ns}{delpackage pkg}")
+	fails(t, "{delpackage a}{delpackage b}")
 
 	// TODO: implement phname
 	// fails(t, "{msg desc=\"\"}{$boo phname=\"boo.foo\"}{/msg}")
@@ -987,36 +1040,6 @@ func TestErrorFilePos(t *testing.T) {
 		3, 2)
 }
 
-// regression: ensures that the lexer is drained (and thus its run goroutine cleaned up) on an aborted parse.
-func TestDrainsLexer(t *testing.T) {
-	var (
-		name  = ""
-		text  = "{namespace template alias if}"
-		lexer = lex(name, text)
-		tree  = &tree{
-			name:    name,
-			text:    text,
-			aliases: make(map[string]string),
-			lex:     lexer,
-		}
-		err error
-	)
-
-	func() {
-		defer tree.recover(&err)
-		tree.root = tree.itemList(itemEOF)
-	}()
-
-	if err == nil {
-		t.Error("expected error")
-	}
-
-	token, ok := <-lexer.items
-	if ok {
-		t.Errorf("input was not drained; got %v", token)
-	}
-}
-
 func works(t *testing.T, body string) {
 	_, err := SoyFile("", body)
 	if err != nil {
@@ -1052,3 +1075,104 @@ func failsWithErrFilePos(t *testing.T, body string, expectedLine, expectedCol in
 		t.Errorf("expected col %d, got %d. error was '%v'", expectedCol, efp.Col(), err)
 	}
 }
+
+func TestRecovery(t *testing.T) {
+	var tests = []struct {
+		name      string
+		input     string
+		errs      []int    // lines of the errors
+		templates []string // names of the templates parsed
+	}{
+		{"bad tags", `{namespace test}
+{template .a}
+  {print $x +}
+  Hello {$name}
+  {call .b}{param x: 1 +/}{/call}
+{/template}
+
+{template .b}
+  {if $x}{/foreach}{/if}
+{/template}`, []int{3, 5, 9}, []string{"test.a", "test.b"}},
+
+		{"unclosed block", `{namespace test}
+{template .a}
+  {if $x}
+    {foreach $y in $ys}
+{/template}
+
+{template .b}
+  {$z}
+{/template}
+
+{deltemplate c}
+  {msg desc=""}{if}{/msg}
+{/deltemplate}`, []int{5, 12}, []string{"test.a", "test.b", "test.__deltemplate____c__"}},
+
+		{"lexical error", `{namespace test}
+{template .a}
+  {$x +}
+  {"unterminated}
+{/template}`, []int{3, 5}, []string{"test.a"}},
+	}
+
+	for _, test := range tests {
+		var tree, err = SoyFile(test.name, test.input)
+		var errs = errortypes.ToErrList(err)
+		var lines []int
+		for _, err := range errs {
+			lines = append(lines, err.Line())
+		}
+		if !reflect.DeepEqual(lines, test.errs) {
+			t.Errorf("%s: expected errors on lines %v, got: %v", test.name, test.errs, err)
+		}
+
+		var templates []string
+		for _, node := range tree.Body {
+			if tmpl, ok := node.(*ast.TemplateNode); ok {
+				templates = append(templates, tmpl.Name)
+			}
+		}
+		if !reflect.DeepEqual(templates, test.templates) {
+			t.Errorf("%s: expected templates %v, got %v", test.name, test.templates, templates)
+		}
+	}
+}
+
+func TestRecoveryBlockHeaders(t *testing.T) {
+	// A syntax error in the header of a block does not also make its end tag an
+	// error.  The bodies are on the third line, so each error is reported there.
+	var tests = []struct {
+		body string
+		col  int // the column of the only error
+	}{
+		{"{if}x{/if}", 5},
+		{"{if $a}{else if}b{/if}", 16},
+		{"{if $a}a{elseif}b{else}c{/if}", 17},
+		{"{foreach $x in}y{/foreach}", 16},
+		{"{for $x in}y{/for}", 12},
+		{"{foreach $x in $y}a{ifempty x}b{/foreach}", 30},
+		{"{switch}{case 1}a{/switch}", 9},
+		{"{switch $a}{case 1,}a{default}b{/switch}", 21},
+		{"{let}a{/let}", 6},
+		{"{let $x kind=}a{/let}", 15},
+		{"{let $x: 1 + /}", 16},
+		{"{msg}a{/msg}", 6},
+		{"{log x}a{/log}", 7},
+		{"{call}{param a}b{/param}{/call}", 7},
+		{"{call .x}{param}b{/param}{/call}", 17},
+		{"{call .x}{param a kind=}b{/param}{/call}", 25},
+		{"{call .x}{param a: /}{/call}", 22},
+	}
+	for _, test := range tests {
+		var _, err = SoyFile("blocks.soy", "{namespace test}\n{template .a}\n"+test.body+"\n{/template}")
+		var errs = errortypes.ToErrList(err)
+		if len(errs) != 1 {
+			t.Errorf("%s: expected one error, got %v", test.body, err)
+			continue
+		}
+		if errs[0].Line() != 3 || errs[0].Col() != test.col {
+			t.Errorf("%s: expected the error at 3:%d, got %d:%d: %v",
+				test.body, test.col, errs[0].Line(), errs[0].Col(), errs[0])
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/errortypes"
 	"github.com/robfig/soy/template"
 )
 
@@ -13,43 +14,68 @@ import (
 //  2. any data declared as a @param is used by the template (or passed via {call})
 //  3. all {call} params are declared as @params in the called template soydoc.
 //  4. a {call}'ed template is passed all required @params, or a data="$var"
+//     (params with a default value are not required)
 //  5. {call}'d templates actually exist in the registry.
+//     (checks 3 and 4 apply to every implementation of a {delcall}'d delegate)
 //  6. any variable created by {let} is used somewhere
 //  7. {let} variable names are valid.  ('ij' is not allowed.)
 //  8. Only one parameter declaration mechanism (soydoc vs headers) is used.
+//  9. private templates are only {call}'d from templates in the same file.
 func CheckDataRefs(reg template.Registry) (err error) {
-	var currentTemplate string
+	return CheckTemplateDataRefs(reg, reg.Templates)
+}
+
+// CheckTemplateDataRefs is like CheckDataRefs, but only validates the given
+// templates of the registry.  An error is returned for each problem found.
+func CheckTemplateDataRefs(reg template.Registry, templates []template.Template) error {
+	var errs errortypes.ErrList
+	for _, t := range templates {
+		for _, e := range checkTemplateDataRefs(reg, t) {
+			errs = append(errs, templateError(reg, t.Node.Name, e.node, e.err))
+		}
+	}
+	return errs.Err()
+}
+
+// checkTemplateDataRefs validates the given template, returning the errors
+// found within it.
+func checkTemplateDataRefs(reg template.Registry, t template.Template) (errs []nodeError) {
+	var tc = newTemplateChecker(reg, t)
 	defer func() {
-		if err2 := recover(); err2 != nil {
-			err = fmt.Errorf("template %v: %v", currentTemplate, err2)
+		if err := recover(); err != nil {
+			errs = append(tc.errs, nodeError{tc.node, fmt.Errorf("%v", err)})
 		}
 	}()
 
-	for _, t := range reg.Templates {
-		currentTemplate = t.Node.Name
-		tc := newTemplateChecker(reg, t)
-		tc.checkTemplate(t.Node)
+	tc.checkTemplate(t.Node)
 
-		// check that all params appear in the usedKeys
-		var unusedParamNames []string
-		for _, param := range tc.params {
-			if !contains(tc.usedKeys, param) {
-				unusedParamNames = append(unusedParamNames, param)
-			}
-		}
-		if len(unusedParamNames) > 0 {
-			panic(fmt.Errorf("params %q are unused", unusedParamNames))
+	// check that all params appear in the usedKeys.  This is skipped if there
+	// were other errors, since params may appear unused because of them.
+	var unusedParamNames []string
+	for _, param := range tc.params {
+		if !contains(tc.usedKeys, param) {
+			unusedParamNames = append(unusedParamNames, param)
 		}
 	}
-	return nil
+	if len(unusedParamNames) > 0 && len(tc.errs) == 0 {
+		tc.errorf(t.Node, "params %q are unused", unusedParamNames)
+	}
+	return tc.errs
 }
 
 type templateChecker struct {
 	registry template.Registry
+	name     string // the name of the template being checked
 	params   []string
 	letVars  []string
 	forVars  []string
 	usedKeys []string
+	node     ast.Node // the node being checked
+	errs     []nodeError
+}
+
+func (tc *templateChecker) errorf(node ast.Node, format string, args ...interface{}) {
+	tc.errs = append(tc.errs, nodeError{node, fmt.Errorf(format, args...)})
 }
 
 func newTemplateChecker(reg template.Registry, tpl template.Template) *templateChecker {
@@ -57,25 +83,28 @@ func newTemplateChecker(reg template.Registry, tpl template.Template) *templateC
 	for _, param := range tpl.Doc.Params {
 		paramNames = append(paramNames, param.Name)
 	}
-	return &templateChecker{reg, paramNames, nil, nil, nil}
+	return &templateChecker{reg, tpl.Node.Name, paramNames, nil, nil, nil, tpl.Node, nil}
 }
 
 func (tc *templateChecker) checkTemplate(node ast.Node) {
+	tc.node = node
 	switch node := node.(type) {
 	case *ast.LetValueNode:
-		tc.checkLet(node.Name)
+		tc.checkLet(node, node.Name)
 		tc.letVars = append(tc.letVars, node.Name)
 	case *ast.LetContentNode:
-		tc.checkLet(node.Name)
+		tc.checkLet(node, node.Name)
 		tc.letVars = append(tc.letVars, node.Name)
 	case *ast.CallNode:
 		tc.checkCall(node)
+	case *ast.DelCallNode:
+		tc.checkDelCall(node)
 	case *ast.ForNode:
 		tc.forVars = append(tc.forVars, node.Var)
 	case *ast.DataRefNode:
 		tc.visitKey(node.Key)
 	case *ast.HeaderParamNode:
-		panic(fmt.Errorf("unexpected {@param ...} tag found"))
+		tc.errorf(node, "unexpected {@param ...} tag found")
 	}
 	if parent, ok := node.(ast.ParentNode); ok {
 		tc.recurse(parent)
@@ -83,22 +112,41 @@ func (tc *templateChecker) checkTemplate(node ast.Node) {
 }
 
 // checkLet ensures that the let variable has an allowed name.
-func (tc *templateChecker) checkLet(varName string) {
+func (tc *templateChecker) checkLet(node ast.Node, varName string) {
 	if varName == "ij" {
-		panic("Invalid variable name in 'let' command text: '$ij'")
+		tc.errorf(node, "Invalid variable name in 'let' command text: '$ij'")
 	}
 }
 
 func (tc *templateChecker) checkCall(node *ast.CallNode) {
 	var callee, ok = tc.registry.Template(node.Name)
 	if !ok {
-		panic(fmt.Errorf("{call}: template %q not found", node.Name))
+		tc.errorf(node, "{call}: template %q not found", node.Name)
+		return
+	}
+	if callee.Node.Private && tc.registry.Filename(node.Name) != tc.registry.Filename(tc.name) {
+		tc.errorf(node, "{call}: template %q is private to %s", node.Name, tc.registry.Filename(node.Name))
+		return
 	}
+	tc.checkCallParams(node, callee)
+}
 
+// checkDelCall checks the params of the {delcall} against every implementation
+// of the delegate, since any of them may be selected at runtime.
+func (tc *templateChecker) checkDelCall(node *ast.DelCallNode) {
+	for _, callee := range tc.registry.Delegates(node.Name) {
+		tc.checkCallParams(&node.CallNode, callee)
+	}
+}
+
+func (tc *templateChecker) checkCallParams(node *ast.CallNode, callee template.Template) {
 	// collect callee's list of required/allowed params
 	var allCalleeParamNames, requiredCalleeParamNames []string
 	for _, param := range callee.Doc.Params {
 		allCalleeParamNames = append(allCalleeParamNames, param.Name)
+		if header := callee.Node.Param(param.Name); header != nil && header.Default != nil {
+			continue // params with a default value are optional
+		}
 		if !param.Optional {
 			requiredCalleeParamNames = append(requiredCalleeParamNames, param.Name)
 		}
@@ -137,7 +185,7 @@ func (tc *templateChecker) checkCall(node *ast.CallNode) {
 		}
 	}
 	if len(undeclaredCallParamNames) > 0 {
-		panic(fmt.Errorf("Params %q are not declared by the callee.", undeclaredCallParamNames))
+		tc.errorf(node, "Params %q are not declared by the callee.", undeclaredCallParamNames)
 	}
 
 	// check: a {call}'ed template is passed all required @params, or a data="$var"
@@ -151,8 +199,8 @@ func (tc *templateChecker) checkCall(node *ast.CallNode) {
 		}
 	}
 	if len(missingRequiredParamNames) > 0 {
-		panic(fmt.Errorf("Required params %q are not passed by the call: %v",
-			missingRequiredParamNames, node))
+		tc.errorf(node, "Required params %q are not passed by the call: %v",
+			missingRequiredParamNames, node)
 	}
 }
 
@@ -190,7 +238,7 @@ func (tc *templateChecker) recurse(parent ast.ParentNode) {
 		}
 	}
 	if len(unusedLetVarNames) > 0 {
-		panic(fmt.Errorf("{let} variables %q are not used.", unusedLetVarNames))
+		tc.errorf(tc.node, "{let} variables %q are not used.", unusedLetVarNames)
 	}
 
 	tc.usedKeys = append(tc.usedKeys[:initialUsedKeys], usedKeysToKeep...)
@@ -203,8 +251,8 @@ func (tc *templateChecker) visitKey(key string) {
 
 	// check that the key was provided by a @param or {let}
 	if !tc.checkKey(key) {
-		panic(fmt.Errorf("data ref %q not found. params: %v, let variables: %v",
-			key, tc.params, tc.letVars))
+		tc.errorf(tc.node, "data ref %q not found. params: %v, let variables: %v",
+			key, tc.params, tc.letVars)
 	}
 }
 
@@ -1,9 +1,11 @@
 package parsepasses
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/errortypes"
 	"github.com/robfig/soy/parse"
 	"github.com/robfig/soy/template"
 )
@@ -319,6 +321,30 @@ func TestCalledTemplatesReceiveAllRequiredParams(t *testing.T) {
 {template .Other}
   {$required}
 {/template}
+`, true},
+
+		{`
+{template .NotPassingDefaultedParam}
+  {call .Other/}
+{/template}
+{template .Other}
+  {@param defaulted: int = 5}
+  {@param required: ?}
+  {$defaulted}{$required}
+{/template}
+`, false},
+
+		{`
+{template .NotPassingDefaultedParam}
+  {call .Other}
+    {param required: 1/}
+  {/call}
+{/template}
+{template .Other}
+  {@param defaulted: int = 5}
+  {@param required: ?}
+  {$defaulted}{$required}
+{/template}
 `, true},
 	})
 }
@@ -398,6 +424,112 @@ func TestCrossNamespace(t *testing.T) {
 	})
 }
 
+// Test that private templates may only be called from within their file.
+func TestPrivateTemplates(t *testing.T) {
+	runCheckerTests(t, []checkerTest{
+		{[]string{`
+{namespace ns.a}
+/** */
+{template .Public}
+  {call .Private /}
+{/template}
+
+/** */
+{template .Private private="true"}
+{/template}
+`}, true},
+
+		{[]string{`
+{namespace ns.a}
+/** */
+{template .Caller}
+  {call ns.b.Private /}
+{/template}
+`, `
+{namespace ns.b}
+/** */
+{template .Private private="true"}
+{/template}
+`}, false},
+
+		{[]string{`
+{namespace ns.a}
+/** */
+{template .Caller}
+  {call ns.a.Private /}
+{/template}
+`, `
+{namespace ns.a}
+/** */
+{template .Private private="true"}
+{/template}
+`}, false},
+	})
+}
+
+// Test that {delcall} params are checked against every implementation.
+func TestDelCall(t *testing.T) {
+	runCheckerTests(t, []checkerTest{
+		{[]string{`
+{namespace ns.a}
+/** @param name */
+{template .Caller}
+  {delcall greeting variant="$name" data="all"}
+    {param greeting: 'hi'/}
+  {/delcall}
+{/template}
+`, `
+{delpackage pkg}
+{namespace ns.b}
+/** @param greeting */
+{deltemplate greeting}
+  {$greeting}
+{/deltemplate}
+/**
+ * @param greeting
+ * @param? name
+ */
+{deltemplate greeting variant="'x'"}
+  {$greeting} {$name}
+{/deltemplate}
+`}, true},
+
+		{[]string{`
+{namespace ns.a}
+/** */
+{template .Caller}
+  {delcall greeting}
+    {param name: 'hi'/}
+  {/delcall}
+{/template}
+`, `
+{namespace ns.b}
+/** @param greeting */
+{deltemplate greeting}
+  {$greeting}
+{/deltemplate}
+`}, false},
+
+		{[]string{`
+{namespace ns.a}
+/** */
+{template .Caller}
+  {delcall greeting allowemptydefault="true"/}
+{/template}
+`}, true},
+
+		{[]string{`
+{namespace ns.a}
+/** */
+{deltemplate greeting}{/deltemplate}
+`, `
+{namespace ns.b}
+/** */
+{deltemplate greeting}{/deltemplate}
+`}, false},
+	})
+}
+
 // Test: {let} variables are not named $ij
 func TestLetVariablesNotNamedIJ(t *testing.T) {
 	runSimpleCheckerTests(t, []simpleCheckerTest{
@@ -447,14 +579,20 @@ func runSimpleCheckerTests(t *testing.T, tests []simpleCheckerTest) {
 }
 
 func runCheckerTests(t *testing.T, tests []checkerTest) {
+	runPassTests(t, CheckDataRefs, tests)
+}
+
+// runPassTests parses the given tests into a registry and checks whether the
+// given parse pass accepts each of them.
+func runPassTests(t *testing.T, pass func(template.Registry) error, tests []checkerTest) {
 	for _, test := range tests {
 		var (
 			reg  template.Registry
 			tree *ast.SoyFileNode
 			err  error
 		)
-		for _, body := range test.body {
-			tree, err = parse.SoyFile("", body)
+		for i, body := range test.body {
+			tree, err = parse.SoyFile(fmt.Sprintf("file%d.soy", i), body)
 			if err != nil {
 				break
 			}
@@ -470,7 +608,7 @@ func runCheckerTests(t *testing.T, tests []checkerTest) {
 			continue
 		}
 
-		err = CheckDataRefs(reg)
+		err = pass(reg)
 		if test.success && err != nil {
 			t.Error(err)
 		} else if !test.success && err == nil {
@@ -482,3 +620,30 @@ func runCheckerTests(t *testing.T, tests []checkerTest) {
 		}
 	}
 }
+
+func TestCheckDataRefsReportsAll(t *testing.T) {
+	var tree, err = parse.SoyFile("datarefs.soy", `{namespace test}
+/** @param a */
+{template .a}
+  {$a} {$b}
+  {call .missing /}
+  {$c}
+{/template}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg template.Registry
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+	var errs = errortypes.ToErrList(CheckDataRefs(reg))
+	var expected = [][2]int{{4, 12}, {5, 9}, {6, 7}}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i, err := range errs {
+		if err.Line() != expected[i][0] || err.Col() != expected[i][1] {
+			t.Errorf("expected error %d at %v, got %d:%d: %v", i, expected[i], err.Line(), err.Col(), err)
+		}
+	}
+}
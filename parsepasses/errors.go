@@ -0,0 +1,21 @@
+package parsepasses
+
+import (
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/errortypes"
+	"github.com/robfig/soy/template"
+)
+
+// templateError returns the given error, found at the given node of the named
+// template, with the position of the node in its file.
+func templateError(reg template.Registry, name string, node ast.Node, err interface{}) errortypes.ErrFilePos {
+	return errortypes.ToErrFilePos(errortypes.NewErrFilePosf(
+		reg.Filename(name), reg.LineNumber(name, node), reg.ColNumber(name, node),
+		"template %v: %v", name, err))
+}
+
+// nodeError is an error found at a node of a template.
+type nodeError struct {
+	node ast.Node
+	err  error
+}
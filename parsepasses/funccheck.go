@@ -0,0 +1,146 @@
+package parsepasses
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/errortypes"
+	"github.com/robfig/soy/template"
+)
+
+// loopFuncs are the builtin functions that take a loop variable.
+var loopFuncs = []string{"index", "isFirst", "isLast"}
+
+// numberFormats are the types of format accepted by the formatNum directive.
+var numberFormats = []string{"decimal", "currency", "percent", "scientific"}
+
+// CheckFuncs validates that:
+//  1. all functions called exist in the given funcs
+//  2. all print directives applied exist in the given directives
+//  3. each is given one of its valid numbers of arguments
+//  4. the loop functions (index, isFirst and isLast) are given the variable of
+//     an enclosing {for} loop
+//  5. the literal args of formatNum are a known type of format and integer
+//     numbers of fraction digits
+//
+// The funcs and directives map each name to the valid numbers of arguments, or
+// to nil if any number is valid.
+func CheckFuncs(reg template.Registry, funcs, directives map[string][]int) error {
+	return CheckTemplateFuncs(reg, reg.Templates, funcs, directives)
+}
+
+// CheckTemplateFuncs is like CheckFuncs, but only validates the given
+// templates of the registry.  An error is returned for each invalid call.
+func CheckTemplateFuncs(reg template.Registry, templates []template.Template, funcs, directives map[string][]int) error {
+	var errs errortypes.ErrList
+	for _, t := range templates {
+		var fc = &funcChecker{funcs: funcs, directives: directives}
+		for _, param := range t.Node.Params {
+			if param.Default != nil {
+				fc.check(param.Default)
+			}
+		}
+		fc.check(t.Node)
+		for _, e := range fc.errs {
+			errs = append(errs, templateError(reg, t.Node.Name, e.node, e.err))
+		}
+	}
+	return errs.Err()
+}
+
+type funcChecker struct {
+	funcs      map[string][]int
+	directives map[string][]int
+	forVars    []string // the variables of the enclosing loops
+	errs       []nodeError
+}
+
+func (fc *funcChecker) errorf(node ast.Node, format string, args ...interface{}) {
+	fc.errs = append(fc.errs, nodeError{node, fmt.Errorf(format, args...)})
+}
+
+func (fc *funcChecker) check(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.ForNode:
+		fc.check(node.List)
+		fc.forVars = append(fc.forVars, node.Var)
+		fc.check(node.Body)
+		fc.forVars = fc.forVars[:len(fc.forVars)-1]
+		if node.IfEmpty != nil {
+			fc.check(node.IfEmpty)
+		}
+		return
+	case *ast.FunctionNode:
+		if contains(loopFuncs, node.Name) {
+			fc.checkLoopFunc(node)
+			return
+		}
+		if lengths, ok := fc.funcs[node.Name]; !ok {
+			fc.errorf(node, "unknown function %q", node.Name)
+		} else if !validArgLength(lengths, len(node.Args)) {
+			fc.errorf(node, "function %q called with %v args, expected one of: %v",
+				node.Name, len(node.Args), lengths)
+		}
+	case *ast.PrintDirectiveNode:
+		if lengths, ok := fc.directives[node.Name]; !ok {
+			fc.errorf(node, "unknown print directive %q", node.Name)
+		} else if !validArgLength(lengths, len(node.Args)) {
+			fc.errorf(node, "print directive %q called with %v args, expected one of: %v",
+				node.Name, len(node.Args), lengths)
+		} else if node.Name == "formatNum" {
+			fc.checkFormatNum(node)
+		}
+	}
+	if parent, ok := node.(ast.ParentNode); ok {
+		for _, child := range parent.Children() {
+			fc.check(child)
+		}
+	}
+}
+
+// checkLoopFunc checks that the given call of a loop function is given the
+// variable of an enclosing loop.
+func (fc *funcChecker) checkLoopFunc(node *ast.FunctionNode) {
+	if len(node.Args) != 1 {
+		fc.errorf(node, "function %q called with %v args, expected one of: [1]", node.Name, len(node.Args))
+		return
+	}
+	var ref, ok = node.Args[0].(*ast.DataRefNode)
+	if !ok || len(ref.Access) > 0 || !contains(fc.forVars, ref.Key) {
+		fc.errorf(node, "function %q requires a loop variable, got %v", node.Name, node.Args[0])
+	}
+}
+
+// checkFormatNum checks the literal args of the given formatNum directive: the
+// type of format, and the minimum and maximum numbers of fraction digits.
+func (fc *funcChecker) checkFormatNum(node *ast.PrintDirectiveNode) {
+	for i, arg := range node.Args {
+		switch arg := arg.(type) {
+		case *ast.StringNode:
+			if i == 0 && !contains(numberFormats, arg.Value) {
+				fc.errorf(node, "unknown number format %q, expected one of: %v", arg.Value, numberFormats)
+			} else if i >= 2 {
+				fc.errorf(node, "fraction digits must be an integer, got %v", arg)
+			}
+		case *ast.FloatNode:
+			if i >= 2 && arg.Value != math.Trunc(arg.Value) {
+				fc.errorf(node, "fraction digits must be an integer, got %v", arg)
+			}
+		}
+	}
+}
+
+// validArgLength returns true if n is one of the given lengths, or if there are
+// none.
+func validArgLength(lengths []int, n int) bool {
+	if lengths == nil {
+		return true
+	}
+	for _, length := range lengths {
+		if n == length {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package parsepasses
+
+import (
+	"testing"
+
+	"github.com/robfig/soy/errortypes"
+	"github.com/robfig/soy/parse"
+	"github.com/robfig/soy/template"
+)
+
+var (
+	testFuncs      = map[string][]int{"round": {1, 2}, "hasData": {0}, "range": {1, 2, 3}, "acme.any": nil}
+	testDirectives = map[string][]int{"truncate": {1, 2}, "escapeHtml": {0}, "formatNum": {0, 1, 2, 3, 4}, "acme.any": nil}
+)
+
+func TestCheckFuncs(t *testing.T) {
+	runFuncCheckerTests(t, []simpleCheckerTest{
+		{`
+{template .known}
+{@param x: float}
+{round($x)} {round($x, 2)} {hasData()} {acme.any(1, 2, 3)}
+{$x|truncate:5|escapeHtml} {$x|acme.any:1,2}
+{/template}`, true},
+
+		{`
+{template .unknownFunc}
+{if false}{rnd(1)}{/if}
+{/template}`, false},
+
+		{`
+{template .unknownDirective}
+{'a'|trunc:5}
+{/template}`, false},
+
+		{`
+{template .funcArgs}
+{round(1, 2, 3)}
+{/template}`, false},
+
+		{`
+{template .directiveArgs}
+{'a'|truncate}
+{/template}`, false},
+
+		{`
+{template .paramDefault}
+{@param? x:= rnd(1)}
+{$x}
+{/template}`, false},
+
+		{`
+{template .loopFuncs}
+{@param list: list<int>}
+{foreach $x in $list}
+  {index($x)} {isFirst($x)} {isLast($x)}
+  {for $i in range(3)}{index($x)}{index($i)}{/for}
+{/foreach}
+{/template}`, true},
+
+		{`
+{template .loopFuncOutsideLoop}
+{@param x: int}
+{index($x)}
+{/template}`, false},
+
+		{`
+{template .loopFuncAfterLoop}
+{@param list: list<int>}
+{foreach $x in $list}{$x}{ifempty}{isFirst($x)}{/foreach}
+{/template}`, false},
+
+		{`
+{template .loopFuncAccess}
+{@param list: list<list<int>>}
+{foreach $x in $list}{isLast($x[0])}{/foreach}
+{/template}`, false},
+
+		{`
+{template .loopFuncArgs}
+{@param list: list<int>}
+{foreach $x in $list}{isLast($x, $x)}{/foreach}
+{/template}`, false},
+
+		{`
+{template .formatNum}
+{@param x: float}
+{@param f: string}
+{$x|formatNum} {$x|formatNum:'percent'} {$x|formatNum:$f,'latn',$x}
+{$x|formatNum:'decimal','latn',1,2.0}
+{/template}`, true},
+
+		{`
+{template .formatNumFormat}
+{1|formatNum:'bogus'}
+{/template}`, false},
+
+		{`
+{template .formatNumFloatDigits}
+{1|formatNum:'decimal','latn',1.5}
+{/template}`, false},
+
+		{`
+{template .formatNumStringDigits}
+{1|formatNum:'decimal','latn',0,'2'}
+{/template}`, false},
+	})
+}
+
+func TestCheckFuncsReportsAll(t *testing.T) {
+	var tree, err = parse.SoyFile("funcs.soy", `{namespace test}
+{template .a}
+  {rnd(1)} {round()}
+  {'a'|trunc}
+{/template}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg template.Registry
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+	var errs = errortypes.ToErrList(CheckFuncs(reg, testFuncs, testDirectives))
+	var expected = [][2]int{{3, 8}, {3, 19}, {4, 9}}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i, err := range errs {
+		if err.Line() != expected[i][0] || err.Col() != expected[i][1] {
+			t.Errorf("expected error %d at %v, got %d:%d: %v", i, expected[i], err.Line(), err.Col(), err)
+		}
+	}
+}
+
+func runFuncCheckerTests(t *testing.T, tests []simpleCheckerTest) {
+	var result []checkerTest
+	for _, simpleTest := range tests {
+		result = append(result, checkerTest{
+			[]string{"{namespace test}\n" + simpleTest.body},
+			simpleTest.success,
+		})
+	}
+	runPassTests(t, func(reg template.Registry) error {
+		return CheckFuncs(reg, testFuncs, testDirectives)
+	}, result)
+}
@@ -5,39 +5,65 @@ import (
 
 	"github.com/robfig/soy/ast"
 	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/errortypes"
 	"github.com/robfig/soy/template"
 )
 
 // SetGlobals sets the value of all global nodes in the given registry.
 // An error is returned if any globals were left undefined.
 func SetGlobals(reg template.Registry, globals data.Map) error {
-	for _, t := range reg.Templates {
-		if err := SetNodeGlobals(t.Node, globals); err != nil {
-			return fmt.Errorf("template %v: %v", t.Node.Name, err)
+	return SetTemplateGlobals(reg, reg.Templates, globals)
+}
+
+// SetTemplateGlobals is like SetGlobals, but only sets the global nodes in the
+// given templates of the registry.  An error is returned for each global that
+// is left undefined.
+func SetTemplateGlobals(reg template.Registry, templates []template.Template, globals data.Map) error {
+	var errs errortypes.ErrList
+	for _, t := range templates {
+		for _, param := range t.Node.Params {
+			if param.Default == nil {
+				continue
+			}
+			for _, node := range setNodeGlobals(param.Default, globals, nil) {
+				errs = append(errs, templateError(reg, t.Node.Name, node,
+					fmt.Errorf("param %v: global %q is undefined", param.Name, node.Name)))
+			}
+		}
+		for _, node := range setNodeGlobals(t.Node, globals, nil) {
+			errs = append(errs, templateError(reg, t.Node.Name, node,
+				fmt.Errorf("global %q is undefined", node.Name)))
 		}
 	}
-	return nil
+	return errs.Err()
 }
 
 // SetNodeGlobals sets global values on the given node and all children nodes,
 // using the given data map.  An error is returned if any global nodes were left
 // undefined.
 func SetNodeGlobals(node ast.Node, globals data.Map) error {
+	if undefined := setNodeGlobals(node, globals, nil); len(undefined) > 0 {
+		return fmt.Errorf("global %q is undefined", undefined[0].Name)
+	}
+	return nil
+}
+
+// setNodeGlobals sets global values on the given node and all children nodes,
+// appending the global nodes that were left undefined to undefined.
+func setNodeGlobals(node ast.Node, globals data.Map, undefined []*ast.GlobalNode) []*ast.GlobalNode {
 	switch node := node.(type) {
 	case *ast.GlobalNode:
 		if val, ok := globals[node.Name]; ok {
 			node.Value = val
 		} else {
-			return fmt.Errorf("global %q is undefined", node.Name)
+			undefined = append(undefined, node)
 		}
 	default:
 		if parent, ok := node.(ast.ParentNode); ok {
 			for _, child := range parent.Children() {
-				if err := SetNodeGlobals(child, globals); err != nil {
-					return err
-				}
+				undefined = setNodeGlobals(child, globals, undefined)
 			}
 		}
 	}
-	return nil
+	return undefined
 }
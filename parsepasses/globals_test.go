@@ -0,0 +1,35 @@
+package parsepasses
+
+import (
+	"testing"
+
+	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/errortypes"
+	"github.com/robfig/soy/parse"
+	"github.com/robfig/soy/template"
+)
+
+func TestSetGlobalsReportsAll(t *testing.T) {
+	var tree, err = parse.SoyFile("globals.soy", `{namespace test}
+{template .a}
+  {@param? p: int = A}
+  {B} {C + 1}
+{/template}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg template.Registry
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+	var errs = errortypes.ToErrList(SetGlobals(reg, data.Map{"C": data.Int(1)}))
+	var expected = [][2]int{{3, 23}, {4, 6}}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i, err := range errs {
+		if err.Line() != expected[i][0] || err.Col() != expected[i][1] {
+			t.Errorf("expected error %d at %v, got %d:%d: %v", i, expected[i], err.Line(), err.Col(), err)
+		}
+	}
+}
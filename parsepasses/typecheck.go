@@ -0,0 +1,550 @@
+package parsepasses
+
+import (
+	"fmt"
+
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/errortypes"
+	"github.com/robfig/soy/plugin"
+	"github.com/robfig/soy/template"
+	"github.com/robfig/soy/types"
+)
+
+// CheckTypes validates the use of values whose types are known at compile
+// time, from typed {@param} declarations and literals:
+//  1. {@param} default values are assignable to the declared type.
+//  2. {call} and {delcall} params are assignable to the types declared by the
+//     callee (or every implementation of the delegate).
+//  3. field and index access is only applied to maps, records, and lists.
+//  4. arithmetic and comparison operators are only applied to numbers.
+//  5. {foreach} only iterates over lists.
+//  6. the arguments of functions and print directives in the given plugins,
+//     and the values the directives are applied to, are assignable to the
+//     types they declare, and their results have the declared type.
+//
+// Params declared in soydoc have the unknown type, so values derived from them
+// are only checked at runtime, if at all.
+func CheckTypes(reg template.Registry, plugins *plugin.Set) (err error) {
+	return CheckTemplateTypes(reg, reg.Templates, plugins)
+}
+
+// CheckTemplateTypes is like CheckTypes, but only validates the given templates
+// of the registry.
+func CheckTemplateTypes(reg template.Registry, templates []template.Template, plugins *plugin.Set) error {
+	if plugins == nil {
+		plugins = plugin.NewSet()
+	}
+	var errs errortypes.ErrList
+	for _, t := range templates {
+		if err := checkTemplateTypes(reg, t, plugins); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.Err()
+}
+
+// checkTemplateTypes validates the given template, returning the first error
+// found within it.
+func checkTemplateTypes(reg template.Registry, t template.Template, plugins *plugin.Set) (err errortypes.ErrFilePos) {
+	var tc = &typeChecker{registry: reg, plugins: plugins, params: make(map[string]types.Type), node: t.Node}
+	defer func() {
+		if err2 := recover(); err2 != nil {
+			err = templateError(reg, t.Node.Name, tc.node, err2)
+		}
+	}()
+
+	for _, param := range t.Doc.Params {
+		tc.params[param.Name] = types.Unknown
+	}
+	for _, param := range t.Node.Params {
+		if param.Default != nil && param.Type.Type != nil {
+			tc.node = param
+			var defaultType = tc.typeOf(param.Default)
+			if !types.Assignable(param.Type.Type, defaultType) {
+				panic(fmt.Errorf("default value of param %q has type %v, expected %v",
+					param.Name, defaultType, param.Type.Type))
+			}
+		}
+		tc.params[param.Name] = tc.paramType(param)
+	}
+	tc.check(t.Node)
+	return nil
+}
+
+type typeChecker struct {
+	registry template.Registry
+	plugins  *plugin.Set
+	params   map[string]types.Type
+	vars     []typedVar // {let} and {foreach} variables in scope
+	node     ast.Node   // the command being checked
+}
+
+type typedVar struct {
+	name string
+	typ  types.Type
+}
+
+// paramType returns the type of the given header param within its template.
+// Params without a declared type take the type of their default value.
+func (tc *typeChecker) paramType(param *ast.HeaderParamNode) types.Type {
+	var typ = param.Type.Type
+	switch {
+	case typ == nil && param.Default != nil:
+		typ = tc.typeOf(param.Default)
+	case typ == nil:
+		typ = types.Unknown
+	}
+	if param.Optional && param.Default == nil {
+		typ = types.NewUnion(typ, types.Null)
+	}
+	return typ
+}
+
+// lookup returns the type of the given variable.
+func (tc *typeChecker) lookup(name string) types.Type {
+	for i := len(tc.vars) - 1; i >= 0; i-- {
+		if tc.vars[i].name == name {
+			return tc.vars[i].typ
+		}
+	}
+	if typ, ok := tc.params[name]; ok {
+		return typ
+	}
+	return types.Unknown
+}
+
+func (tc *typeChecker) check(node ast.Node) {
+	tc.node = node
+	switch node := node.(type) {
+	case *ast.LetValueNode:
+		tc.vars = append(tc.vars, typedVar{node.Name, tc.typeOf(node.Expr)})
+		return
+	case *ast.LetContentNode:
+		tc.check(node.Body)
+		tc.vars = append(tc.vars, typedVar{node.Name, types.ContentKind(node.Kind)})
+		return
+	case *ast.ForNode:
+		var listType = tc.typeOf(node.List)
+		var elemType = elemType(listType)
+		if elemType == nil {
+			panic(fmt.Errorf("%v: can not iterate over %v", node.List, listType))
+		}
+		var numVars = len(tc.vars)
+		tc.vars = append(tc.vars, typedVar{node.Var, elemType})
+		tc.check(node.Body)
+		tc.vars = tc.vars[:numVars]
+		if node.IfEmpty != nil {
+			tc.check(node.IfEmpty)
+		}
+		return
+	case *ast.CallNode:
+		if callee, ok := tc.registry.Template(node.Name); ok {
+			tc.checkCall(node, []template.Template{callee})
+		} else {
+			tc.checkCall(node, nil)
+		}
+	case *ast.DelCallNode:
+		if node.Variant != nil {
+			var variantType = tc.typeOf(node.Variant)
+			if !types.Assignable(types.NewUnion(types.String, types.Int), variantType) {
+				panic(fmt.Errorf("%v: variant has type %v, expected string or int", node, variantType))
+			}
+		}
+		tc.checkCall(&node.CallNode, tc.registry.Delegates(node.Name))
+	case *ast.PrintNode:
+		var typ = tc.typeOf(node.Arg)
+		for _, directive := range node.Directives {
+			tc.node = directive
+			typ = tc.directiveType(directive, typ)
+		}
+		return
+	default:
+		// Expressions are checked as their type is computed.
+		if tc.typeOf(node) != nil {
+			return
+		}
+	}
+	if parent, ok := node.(ast.ParentNode); ok {
+		var numVars = len(tc.vars)
+		for _, child := range parent.Children() {
+			tc.check(child)
+		}
+		tc.vars = tc.vars[:numVars]
+	}
+}
+
+// checkCall checks the params passed by the given call against those declared
+// by each of the given callees.
+func (tc *typeChecker) checkCall(node *ast.CallNode, callees []template.Template) {
+	if node.Data != nil {
+		var dataType = types.NonNull(tc.typeOf(node.Data))
+		if !types.Assignable(types.Map{types.String, types.Unknown}, dataType) {
+			panic(fmt.Errorf("%v: data has type %v, expected a map or record", node, dataType))
+		}
+	}
+
+	var checkParam = func(key string, typ types.Type) {
+		for _, callee := range callees {
+			var param = callee.Node.Param(key)
+			if param == nil {
+				continue
+			}
+			var paramType = tc.paramType(param)
+			if param.Optional {
+				paramType = types.NewUnion(paramType, types.Null)
+			}
+			if !types.Assignable(paramType, typ) {
+				panic(fmt.Errorf("%v: param %q has type %v, expected %v", node, key, typ, paramType))
+			}
+		}
+	}
+
+	var passed = make(map[string]bool)
+	for _, param := range node.Params {
+		switch param := param.(type) {
+		case *ast.CallParamValueNode:
+			checkParam(param.Key, tc.typeOf(param.Value))
+			passed[param.Key] = true
+		case *ast.CallParamContentNode:
+			checkParam(param.Key, types.ContentKind(param.Kind))
+			passed[param.Key] = true
+		}
+	}
+	if node.AllData {
+		for name, typ := range tc.params {
+			if !passed[name] {
+				checkParam(name, typ)
+			}
+		}
+	}
+}
+
+// typeOf returns the type of the given expression, or nil if the node is not
+// an expression.  It panics if the expression misuses a value of known type.
+func (tc *typeChecker) typeOf(node ast.Node) types.Type {
+	switch node := node.(type) {
+	case *ast.NullNode:
+		return types.Null
+	case *ast.BoolNode:
+		return types.Bool
+	case *ast.IntNode:
+		return types.Int
+	case *ast.FloatNode:
+		return types.Float
+	case *ast.StringNode:
+		return types.String
+	case *ast.GlobalNode:
+		return valueType(node.Value)
+	case *ast.ListLiteralNode:
+		var itemTypes []types.Type
+		for _, item := range node.Items {
+			itemTypes = append(itemTypes, tc.typeOf(item))
+		}
+		return types.List{types.NewUnion(itemTypes...)}
+	case *ast.MapLiteralNode:
+		var fields []types.Field
+		for key, item := range node.Items {
+			fields = append(fields, types.Field{Name: key, Type: tc.typeOf(item)})
+		}
+		return types.NewRecord(fields...)
+	case *ast.DataRefNode:
+		return tc.dataRefType(node)
+	case *ast.FunctionNode:
+		return tc.functionType(node)
+
+	case *ast.NotNode:
+		tc.typeOf(node.Arg)
+		return types.Bool
+	case *ast.NegateNode:
+		return tc.numeric(node, tc.typeOf(node.Arg))
+	case *ast.AddNode:
+		var arg1, arg2 = tc.typeOf(node.Arg1), tc.typeOf(node.Arg2)
+		switch {
+		case isStringType(arg1) || isStringType(arg2):
+			return types.String
+		case isNumberType(arg1) && isNumberType(arg2):
+			return types.NewUnion(arg1, arg2)
+		}
+		return types.Unknown
+	case *ast.SubNode:
+		return tc.arithmetic(&node.BinaryOpNode)
+	case *ast.MulNode:
+		return tc.arithmetic(&node.BinaryOpNode)
+	case *ast.DivNode:
+		tc.arithmetic(&node.BinaryOpNode)
+		return types.Float
+	case *ast.ModNode:
+		for _, arg := range []ast.Node{node.Arg1, node.Arg2} {
+			if typ := tc.typeOf(arg); !types.Assignable(types.Int, types.NonNull(typ)) {
+				panic(fmt.Errorf("%v: operator %% expects ints, got %v", node, typ))
+			}
+		}
+		return types.Int
+	case *ast.LtNode:
+		tc.arithmetic(&node.BinaryOpNode)
+		return types.Bool
+	case *ast.LteNode:
+		tc.arithmetic(&node.BinaryOpNode)
+		return types.Bool
+	case *ast.GtNode:
+		tc.arithmetic(&node.BinaryOpNode)
+		return types.Bool
+	case *ast.GteNode:
+		tc.arithmetic(&node.BinaryOpNode)
+		return types.Bool
+	case *ast.EqNode:
+		tc.typeOf(node.Arg1)
+		tc.typeOf(node.Arg2)
+		return types.Bool
+	case *ast.NotEqNode:
+		tc.typeOf(node.Arg1)
+		tc.typeOf(node.Arg2)
+		return types.Bool
+	case *ast.AndNode:
+		tc.typeOf(node.Arg1)
+		tc.typeOf(node.Arg2)
+		return types.Bool
+	case *ast.OrNode:
+		tc.typeOf(node.Arg1)
+		tc.typeOf(node.Arg2)
+		return types.Bool
+	case *ast.ElvisNode:
+		return types.NewUnion(types.NonNull(tc.typeOf(node.Arg1)), tc.typeOf(node.Arg2))
+	case *ast.TernNode:
+		tc.typeOf(node.Arg1)
+		return types.NewUnion(tc.typeOf(node.Arg2), tc.typeOf(node.Arg3))
+	}
+	return nil
+}
+
+// arithmetic checks that both operands of the given operator are numbers, and
+// returns the type of the result.
+func (tc *typeChecker) arithmetic(node *ast.BinaryOpNode) types.Type {
+	return types.NewUnion(
+		tc.numeric(node, tc.typeOf(node.Arg1)),
+		tc.numeric(node, tc.typeOf(node.Arg2)))
+}
+
+// numeric checks that the given operand of the given operator is a number.
+func (tc *typeChecker) numeric(node ast.Node, typ types.Type) types.Type {
+	if !isNumberType(typ) {
+		panic(fmt.Errorf("%v: expected a number, got %v", node, typ))
+	}
+	return types.NonNull(typ)
+}
+
+func (tc *typeChecker) dataRefType(node *ast.DataRefNode) types.Type {
+	if node.Key == "ij" {
+		return types.Unknown
+	}
+	var typ = tc.lookup(node.Key)
+	for _, access := range node.Access {
+		var nullSafe bool
+		var accessType = func(t types.Type) types.Type { return nil }
+		switch access := access.(type) {
+		case *ast.DataRefKeyNode:
+			nullSafe = access.NullSafe
+			accessType = func(t types.Type) types.Type {
+				switch t := t.(type) {
+				case types.Record:
+					var fieldType, _ = t.Field(access.Key)
+					return fieldType
+				case types.Map:
+					return t.Value
+				}
+				return nil
+			}
+		case *ast.DataRefIndexNode:
+			nullSafe = access.NullSafe
+			accessType = func(t types.Type) types.Type {
+				switch t := t.(type) {
+				case types.List:
+					return t.Elem
+				case types.Map:
+					return t.Value
+				}
+				return nil
+			}
+		case *ast.DataRefExprNode:
+			nullSafe = access.NullSafe
+			var keyType = tc.typeOf(access.Arg)
+			accessType = func(t types.Type) types.Type {
+				switch t := t.(type) {
+				case types.List:
+					if types.Assignable(types.Int, keyType) {
+						return t.Elem
+					}
+				case types.Map:
+					if types.Assignable(t.Key, keyType) {
+						return t.Value
+					}
+				case types.Record:
+					if types.Assignable(types.String, keyType) {
+						return types.Unknown
+					}
+				}
+				return nil
+			}
+		}
+
+		var result = memberTypes(types.NonNull(typ), accessType)
+		if result == nil {
+			panic(fmt.Errorf("%v: invalid access %v on %v", node, access, typ))
+		}
+		if nullSafe {
+			result = types.NewUnion(result, types.Null)
+		}
+		typ = result
+	}
+	return typ
+}
+
+func (tc *typeChecker) functionType(node *ast.FunctionNode) types.Type {
+	var argTypes []types.Type
+	for _, arg := range node.Args {
+		argTypes = append(argTypes, tc.typeOf(arg))
+	}
+	if fn, ok := tc.plugins.Funcs[node.Name]; ok {
+		tc.checkArgs(node, fn.Args, argTypes)
+		if fn.Result == nil {
+			return types.Unknown
+		}
+		return fn.Result
+	}
+	switch node.Name {
+	case "isNonnull", "strContains", "hasData", "isFirst", "isLast", "listContains":
+		return types.Bool
+	case "length", "floor", "ceiling", "randomInt", "index", "strIndexOf", "strLen",
+		"listIndexOf", "bidiGlobalDir":
+		return types.Int
+	case "strSub", "strToUpperCase", "strToLowerCase", "strReplaceAll", "join",
+		"bidiStartEdge", "bidiEndEdge":
+		return types.String
+	case "sqrt", "pow":
+		return types.Float
+	case "parseInt":
+		return types.NewUnion(types.Int, types.Null)
+	case "parseFloat":
+		return types.NewUnion(types.Float, types.Null)
+	case "bidiDirAttr":
+		return types.Attributes
+	case "abs", "concatLists", "mapToLegacyObjectMap":
+		return types.NewUnion(argTypes...)
+	case "checkNotNull":
+		if len(argTypes) == 1 {
+			return types.NonNull(argTypes[0])
+		}
+	case "round":
+		if len(node.Args) == 1 {
+			return types.Int
+		}
+		return types.NewUnion(types.Int, types.Float)
+	case "min", "max":
+		return types.NewUnion(argTypes...)
+	case "range":
+		return types.List{types.Int}
+	case "keys", "mapKeys":
+		if len(argTypes) == 1 {
+			if m, ok := types.NonNull(argTypes[0]).(types.Map); ok {
+				return types.List{m.Key}
+			}
+		}
+		return types.List{types.Unknown}
+	}
+	return types.Unknown
+}
+
+// checkArgs checks that the types of the arguments given to a plugin function
+// or print directive are assignable to the types it declares.  Nil types are
+// unknown.
+// directiveType returns the type of the result of applying the given print
+// directive to a value of the given type.
+func (tc *typeChecker) directiveType(node *ast.PrintDirectiveNode, valueType types.Type) types.Type {
+	var argTypes []types.Type
+	for _, arg := range node.Args {
+		argTypes = append(argTypes, tc.typeOf(arg))
+	}
+	var d, ok = tc.plugins.PrintDirectives[node.Name]
+	if !ok {
+		return types.Unknown
+	}
+	if d.Value != nil && !types.Assignable(d.Value, valueType) {
+		panic(fmt.Errorf("%v: value has type %v, expected %v", node, valueType, d.Value))
+	}
+	tc.checkArgs(node, d.Args, argTypes)
+	if d.Result == nil {
+		return types.Unknown
+	}
+	return d.Result
+}
+
+func (tc *typeChecker) checkArgs(node ast.Node, declared, argTypes []types.Type) {
+	for i, argType := range argTypes {
+		if i >= len(declared) || declared[i] == nil {
+			continue
+		}
+		if !types.Assignable(declared[i], argType) {
+			panic(fmt.Errorf("%v: argument %d has type %v, expected %v", node, i+1, argType, declared[i]))
+		}
+	}
+}
+
+// memberTypes applies fn to the given type, or each member of the given union,
+// and returns the union of the results.  Unknown types result in an unknown
+// type.  It returns nil if fn returns nil for every type.
+func memberTypes(typ types.Type, fn func(types.Type) types.Type) types.Type {
+	if typ == types.Unknown || typ == types.Any {
+		return types.Unknown
+	}
+	var members = []types.Type{typ}
+	if union, ok := typ.(types.Union); ok {
+		members = union.Members
+	}
+	var results []types.Type
+	for _, member := range members {
+		if result := fn(member); result != nil {
+			results = append(results, result)
+		}
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	return types.NewUnion(results...)
+}
+
+// elemType returns the type of the elements of the given list type, or nil if
+// it is not a list.
+func elemType(typ types.Type) types.Type {
+	return memberTypes(types.NonNull(typ), func(t types.Type) types.Type {
+		if list, ok := t.(types.List); ok {
+			return list.Elem
+		}
+		return nil
+	})
+}
+
+func isNumberType(typ types.Type) bool {
+	return types.Assignable(types.Float, types.NonNull(typ))
+}
+
+func isStringType(typ types.Type) bool {
+	return typ != types.Unknown && types.Assignable(types.String, typ)
+}
+
+// valueType returns the type of the given (global) value.
+func valueType(val data.Value) types.Type {
+	switch val.(type) {
+	case data.Null:
+		return types.Null
+	case data.Bool:
+		return types.Bool
+	case data.Int:
+		return types.Int
+	case data.Float:
+		return types.Float
+	case data.String:
+		return types.String
+	}
+	return types.Unknown
+}
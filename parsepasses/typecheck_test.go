@@ -0,0 +1,313 @@
+package parsepasses
+
+import (
+	"testing"
+
+	"github.com/robfig/soy/template"
+)
+
+func TestCheckTypes(t *testing.T) {
+	runTypeCheckerTests(t, []simpleCheckerTest{
+		{`
+{template .untyped}
+{@param x: ?}
+{@param y: any}
+{$x.a.b[0] * 2} {$y}
+{/template}`, true},
+
+		{`
+/** @param x */
+{template .soydoc}
+{-$x.a}
+{/template}`, true},
+
+		{`
+{template .defaults}
+{@param? x: int = 5}
+{@param? y: string = 5}
+{$x}{$y}
+{/template}`, false},
+
+		{`
+{template .inferredDefault}
+{@param? x:= 5}
+{$x * 2}
+{/template}`, true},
+
+		{`
+{template .arithmetic}
+{@param n: int}
+{@param f: float}
+{@param? o: number}
+{$n * $f - $o} {$n % 2} {$n > $f}
+{/template}`, true},
+
+		{`
+{template .stringArithmetic}
+{@param s: string}
+{$s * 2}
+{/template}`, false},
+
+		{`
+{template .stringConcat}
+{@param s: string}
+{$s + 2}
+{/template}`, true},
+
+		{`
+{template .stringComparison}
+{@param s: string}
+{if $s < 2}{/if}
+{/template}`, false},
+
+		{`
+{template .mod}
+{@param f: float}
+{$f % 2}
+{/template}`, false},
+
+		{`
+{template .records}
+{@param r: [name: string, tags: list<string>, meta: map<string, int>]}
+{$r.name} {$r.tags[0]} {$r.tags.1} {$r.meta.x + 1} {$r.meta['y'] * 2}
+{/template}`, true},
+
+		{`
+{template .missingField}
+{@param r: [name: string]}
+{$r.age}
+{/template}`, false},
+
+		{`
+{template .fieldOfString}
+{@param s: string}
+{$s.length}
+{/template}`, false},
+
+		{`
+{template .listIndexByString}
+{@param l: list<int>}
+{$l['a']}
+{/template}`, false},
+
+		{`
+{template .nullable}
+{@param? r: [a: int]}
+{$r?.a + 1}
+{/template}`, true},
+
+		{`
+{template .foreach}
+{@param l: list<[n: int]>}
+{foreach $x in $l}{$x.n * 2}{/foreach}
+{for $i in range(3)}{$i * 2}{/for}
+{/template}`, true},
+
+		{`
+{template .foreachElemType}
+{@param l: list<string>}
+{foreach $x in $l}{$x * 2}{/foreach}
+{/template}`, false},
+
+		{`
+{template .foreachNonList}
+{@param m: map<string, int>}
+{foreach $x in $m}{$x}{/foreach}
+{/template}`, false},
+
+		{`
+{template .let}
+{@param n: int}
+{let $s: 'a' + $n /}
+{let $html kind="html"}<b>{$n}</b>{/let}
+{$s * 2}{$html}
+{/template}`, false},
+
+		{`
+{template .literals}
+{let $l: [1, 2, 3] /}
+{let $m: ['a': 1, 'b': 'c'] /}
+{$l[0] * 2} {$m.a * 2}
+{/template}`, true},
+
+		{`
+{template .literalMissingField}
+{let $m: ['a': 1] /}
+{$m.b}
+{/template}`, false},
+
+		{`
+{template .ternary}
+{@param b: bool}
+{2 * ($b ? 1 : 2.5)}
+{/template}`, true},
+
+		{`
+{template .elvis}
+{@param? b: bool}
+{1 - ($b ?: 1)}
+{/template}`, false},
+	})
+}
+
+func TestCheckCallTypes(t *testing.T) {
+	runTypeCheckerTests(t, []simpleCheckerTest{
+		{`
+{template .caller}
+{@param n: int}
+{call .callee}
+  {param n: $n /}
+  {param f: $n /}
+  {param s kind="html"}<b>{$n}</b>{/param}
+  {param l: [1, 2] /}
+  {param r: ['a': 1] /}
+{/call}
+{/template}
+
+{template .callee}
+{@param n: int}
+{@param f: float}
+{@param s: string}
+{@param l: list<float>}
+{@param r: [a: int, b: string|null]}
+{$n}{$f}{$s}{$l}{$r}
+{/template}`, true},
+
+		{`
+{template .caller}
+{call .callee}
+  {param n: 'a' /}
+{/call}
+{/template}
+
+{template .callee}
+{@param n: int}
+{$n}
+{/template}`, false},
+
+		{`
+{template .caller}
+{call .callee}
+  {param h kind="uri"}/a{/param}
+{/call}
+{/template}
+
+{template .callee}
+{@param h: html}
+{$h}
+{/template}`, false},
+
+		{`
+{template .caller}
+{@param n: string}
+{call .callee data="all" /}
+{/template}
+
+{template .callee}
+{@param n: int}
+{$n}
+{/template}`, false},
+
+		{`
+{template .caller}
+{@param? n: int}
+{call .callee}
+  {param n: $n /}
+{/call}
+{/template}
+
+{template .callee}
+{@param? n: int}
+{$n}
+{/template}`, true},
+
+		{`
+{template .caller}
+{@param? n: int}
+{call .callee}
+  {param n: $n /}
+{/call}
+{/template}
+
+{template .callee}
+{@param n: int}
+{$n}
+{/template}`, false},
+
+		{`
+{template .caller}
+{@param n: int}
+{call .callee data="$n" /}
+{/template}
+
+{template .callee}
+{@param? n: int}
+{$n}
+{/template}`, false},
+
+		{`
+/** @param n */
+{template .caller}
+{call .callee}
+  {param n: $n /}
+{/call}
+{/template}
+
+{template .callee}
+{@param n: int}
+{$n}
+{/template}`, true},
+
+		{`
+{template .caller}
+{delcall ns.callee variant="1"}
+  {param n: 1 /}
+{/delcall}
+{/template}
+
+{deltemplate ns.callee}
+{@param n: int}
+{$n}
+{/deltemplate}
+
+{deltemplate ns.callee variant="1"}
+{@param n: float}
+{$n}
+{/deltemplate}`, true},
+
+		{`
+{template .caller}
+{delcall ns.callee}
+  {param n: 1.5 /}
+{/delcall}
+{/template}
+
+{deltemplate ns.callee}
+{@param n: float}
+{$n}
+{/deltemplate}
+
+{deltemplate ns.callee variant="1"}
+{@param n: int}
+{$n}
+{/deltemplate}`, false},
+
+		{`
+{template .caller}
+{delcall ns.callee variant="[1]" /}
+{/template}`, false},
+	})
+}
+
+func runTypeCheckerTests(t *testing.T, tests []simpleCheckerTest) {
+	var result []checkerTest
+	for _, simpleTest := range tests {
+		result = append(result, checkerTest{
+			[]string{"{namespace test}\n" + simpleTest.body},
+			simpleTest.success,
+		})
+	}
+	runPassTests(t, func(reg template.Registry) error {
+		return CheckTypes(reg, nil)
+	}, result)
+}
@@ -0,0 +1,129 @@
+// Package plugin defines Soy functions and print directives once, for every
+// backend.
+//
+// Each plugin supplies its Go implementation, used by soyhtml, and its
+// javascript emitter, used by soyjs, along with the types of its arguments and
+// result.  A Set of plugins may be given to a soyhtml.Tofu (WithPlugins), to
+// the soyjs.Options (Plugins) and to a soy.Bundle (AddPlugins), which makes
+// them available to the templates it compiles into a Tofu.
+//
+// Plugin names may be namespaced to avoid conflicts with the builtins and with
+// each other, e.g. a function named "acme.formatPrice" is called as
+// {acme.formatPrice($price)}.
+package plugin
+
+import (
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/types"
+)
+
+// JSWriter is provided to javascript emitters to write to the generated
+// javascript.
+type JSWriter interface {
+	// Write writes the given arguments into the generated javascript.  It is
+	// recommended to only pass strings and ast.Nodes to Write. Other types
+	// are printed using their default string representation (fmt.Sprintf("%v")).
+	Write(...interface{})
+}
+
+// Func is a Soy function that may be invoked within a Soy template.
+type Func struct {
+	Name     string       // the name it is called by, e.g. "acme.formatPrice"
+	Args     []types.Type // the types of the arguments; nil types are unknown
+	Optional int          // the number of trailing Args that may be omitted
+	Result   types.Type   // the type of the result; nil is unknown
+
+	// Pure is set if the result depends only on the arguments, and calling
+	// it has no side effects.  soyhtml calls a pure function once for each
+	// call in the templates whose arguments are all literals or calls to pure
+	// functions, and reuses the result.
+	Pure bool
+
+	// Go returns the result of the function for the given arguments.
+	Go func(args []data.Value) data.Value
+
+	// JS writes the javascript expression that calls the function with the
+	// given arguments.
+	JS func(js JSWriter, args []ast.Node)
+}
+
+// ValidArgLengths returns the numbers of arguments the function may be called
+// with.
+func (fn Func) ValidArgLengths() []int {
+	return validArgLengths(len(fn.Args), fn.Optional)
+}
+
+// PrintDirective is a transformation applied when printing a value, e.g.
+// {$price|acme.currency:'USD'}.
+type PrintDirective struct {
+	Name     string       // the name it is applied by, e.g. "acme.currency"
+	Args     []types.Type // the types of the arguments; nil types are unknown
+	Optional int          // the number of trailing Args that may be omitted
+	Value    types.Type   // the type of the value it is applied to; nil is unknown
+	Result   types.Type   // the type of the result; nil is unknown
+
+	// CancelAutoescape is set if the result is not to be HTML escaped.
+	CancelAutoescape bool
+
+	// Pure is set if the result depends only on the value and arguments, and
+	// applying it has no side effects.  soyhtml applies a pure directive once
+	// for each print in the templates whose value and arguments are all
+	// literals or calls to pure functions, and reuses the result.
+	Pure bool
+
+	// Go returns the result of applying the directive to the given value.
+	Go func(value data.Value, args []data.Value) data.Value
+
+	// JS is the javascript function that is called with the value followed by
+	// the arguments.
+	JS string
+}
+
+// ValidArgLengths returns the numbers of arguments the directive may be
+// applied with.
+func (d PrintDirective) ValidArgLengths() []int {
+	return validArgLengths(len(d.Args), d.Optional)
+}
+
+func validArgLengths(args, optional int) []int {
+	var lengths []int
+	for n := args - optional; n <= args; n++ {
+		if n >= 0 {
+			lengths = append(lengths, n)
+		}
+	}
+	return lengths
+}
+
+// Set is a collection of plugins, by name.
+type Set struct {
+	Funcs           map[string]Func
+	PrintDirectives map[string]PrintDirective
+}
+
+// NewSet returns a new, empty set of plugins.
+func NewSet() *Set {
+	return &Set{
+		Funcs:           make(map[string]Func),
+		PrintDirectives: make(map[string]PrintDirective),
+	}
+}
+
+// AddFuncs adds the given functions to the set, replacing any of the same
+// name.
+func (s *Set) AddFuncs(fns ...Func) *Set {
+	for _, fn := range fns {
+		s.Funcs[fn.Name] = fn
+	}
+	return s
+}
+
+// AddPrintDirectives adds the given print directives to the set, replacing any
+// of the same name.
+func (s *Set) AddPrintDirectives(directives ...PrintDirective) *Set {
+	for _, d := range directives {
+		s.PrintDirectives[d.Name] = d
+	}
+	return s
+}
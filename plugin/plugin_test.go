@@ -0,0 +1,210 @@
+package plugin_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/robertkrimen/otto"
+	"github.com/robfig/soy"
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/plugin"
+	"github.com/robfig/soy/soyjs"
+	"github.com/robfig/soy/types"
+)
+
+var acme = plugin.NewSet().
+	AddFuncs(plugin.Func{
+		Name:     "acme.formatPrice",
+		Args:     []types.Type{types.Int, types.String},
+		Optional: 1,
+		Result:   types.String,
+		Pure:     true,
+		Go: func(args []data.Value) data.Value {
+			var currency = "$"
+			if len(args) > 1 {
+				currency = args[1].String()
+			}
+			return data.String(fmt.Sprintf("%s%d.%02d", currency, args[0].(data.Int)/100, args[0].(data.Int)%100))
+		},
+		JS: func(js plugin.JSWriter, args []ast.Node) {
+			var currency interface{} = "'$'"
+			if len(args) > 1 {
+				currency = args[1]
+			}
+			js.Write("(", currency, " + Math.floor(", args[0], " / 100) + '.' + ('0' + ",
+				args[0], " % 100).slice(-2))")
+		},
+	}).
+	AddPrintDirectives(plugin.PrintDirective{
+		Name: "acme.shout",
+		Pure: true,
+		Go: func(value data.Value, _ []data.Value) data.Value {
+			return data.String(value.String() + "!")
+		},
+		JS: "acme.shout",
+	})
+
+const acmeSoy = `{namespace test}
+{template .price}
+{acme.formatPrice(1999)} {acme.formatPrice(5, '€')|acme.shout}
+{/template}`
+
+func TestPlugins(t *testing.T) {
+	const expected = "$19.99 €0.05!"
+	var bundle = soy.NewBundle().
+		AddTemplateString("acme.soy", acmeSoy).
+		AddPlugins(acme)
+	var tofu, err = bundle.CompileToTofu()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = tofu.Render(&buf, "test.price", nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Errorf("soyhtml: expected %q, got %q", expected, buf.String())
+	}
+
+	registry, err := bundle.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err = soyjs.Write(&buf, registry.SoyFiles[0], soyjs.Options{Plugins: acme}); err != nil {
+		t.Fatal(err)
+	}
+	var js = otto.New()
+	if _, err = js.Run(`
+var soy = {$$escapeHtml: function(s) { return String(s); }};
+var acme = {shout: function(s) { return s + '!'; }};
+` + buf.String()); err != nil {
+		t.Fatal(err)
+	}
+	output, err := js.Run(`test.price();`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != expected {
+		t.Errorf("soyjs: expected %q, got %q", expected, output.String())
+	}
+}
+
+func TestPluginTypes(t *testing.T) {
+	var set = plugin.NewSet().
+		AddFuncs(acme.Funcs["acme.formatPrice"]).
+		AddPrintDirectives(
+			plugin.PrintDirective{Name: "acme.pad", Args: []types.Type{types.Int, nil}, Optional: 1},
+			plugin.PrintDirective{Name: "acme.double", Value: types.Int, Result: types.Int},
+			plugin.PrintDirective{Name: "acme.cents", Value: types.Int, Result: types.String})
+	var tests = []struct {
+		body string
+		ok   bool
+	}{
+		{`{acme.formatPrice(1999, 'EUR')|acme.pad:3,'x'}`, true},
+		{`{@param p: ?}{acme.formatPrice($p)|acme.pad:$p}`, true},
+		{`{acme.formatPrice('1999')}`, false},
+		{`{acme.formatPrice(1999, 5)}`, false},
+		{`{acme.formatPrice(1999) * 2}`, false},
+		{`{'a'|acme.pad:'3'}`, false},
+		{`{2|acme.double|acme.double}`, true},
+		{`{2|acme.double|acme.cents}`, true},
+		{`{@param p: ?}{$p|acme.double}`, true},
+		{`{2|acme.pad:3|acme.double}`, true},
+		{`{2|escapeHtml|acme.double}`, true},
+		{`{'2'|acme.double}`, false},
+		{`{acme.formatPrice(2)|acme.double}`, false},
+		{`{2|acme.cents|acme.double}`, false},
+	}
+	for _, test := range tests {
+		var _, err = soy.NewBundle().
+			AddTemplateString("acme.soy", "{namespace test}\n{template .t}\n"+test.body+"\n{/template}").
+			AddPlugins(set).
+			Compile()
+		if (err == nil) != test.ok {
+			t.Errorf("%s: expected ok=%v, got %v", test.body, test.ok, err)
+		}
+	}
+}
+
+func TestPurePlugins(t *testing.T) {
+	var calls = make(map[string]int)
+	var set = plugin.NewSet().
+		AddFuncs(
+			plugin.Func{Name: "acme.pure", Args: []types.Type{nil}, Pure: true,
+				Go: func(args []data.Value) data.Value { calls["acme.pure"]++; return args[0] }},
+			plugin.Func{Name: "acme.impure", Args: []types.Type{nil},
+				Go: func(args []data.Value) data.Value { calls["acme.impure"]++; return args[0] }}).
+		AddPrintDirectives(
+			plugin.PrintDirective{Name: "acme.pureShout", Pure: true,
+				Go: func(v data.Value, _ []data.Value) data.Value {
+					calls["acme.pureShout"]++
+					return data.String(v.String() + "!")
+				}},
+			plugin.PrintDirective{Name: "acme.impureShout",
+				Go: func(v data.Value, _ []data.Value) data.Value {
+					calls["acme.impureShout"]++
+					return data.String(v.String() + "!")
+				}})
+	var tests = []struct {
+		body     string
+		output   string
+		expected map[string]int // calls in two renders
+	}{
+		{`{acme.pure(1)}`, "1", map[string]int{"acme.pure": 1}},
+		{`{acme.pure(1)}{acme.pure(1)}`, "11", map[string]int{"acme.pure": 2}},
+		{`{acme.pure(acme.pure('a'))}`, "a", map[string]int{"acme.pure": 2}},
+		{`{@param p: ?}{acme.pure($p)}`, "p", map[string]int{"acme.pure": 2}},
+		{`{acme.pure(acme.impure(1))}`, "1", map[string]int{"acme.pure": 2, "acme.impure": 2}},
+		{`{acme.impure(1)}`, "1", map[string]int{"acme.impure": 2}},
+		{`{'a'|acme.pureShout}`, "a!", map[string]int{"acme.pureShout": 1}},
+		{`{acme.pure('a')|acme.pureShout|acme.pureShout}`, "a!!", map[string]int{"acme.pure": 1, "acme.pureShout": 2}},
+		{`{@param p: ?}{$p|acme.pureShout}`, "p!", map[string]int{"acme.pureShout": 2}},
+		{`{'a'|acme.impureShout|acme.pureShout}`, "a!!", map[string]int{"acme.impureShout": 2, "acme.pureShout": 2}},
+	}
+	for _, test := range tests {
+		var tofu, err = soy.NewBundle().
+			AddTemplateString("acme.soy", "{namespace test}\n{template .t}\n"+test.body+"\n{/template}").
+			AddPlugins(set).
+			CompileToTofu()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		for k := range calls {
+			delete(calls, k)
+		}
+		for i := 0; i < 2; i++ {
+			var buf bytes.Buffer
+			if err = tofu.Render(&buf, "test.t", map[string]interface{}{"p": "p"}); err != nil {
+				t.Error(err)
+			} else if buf.String() != test.output {
+				t.Errorf("%s: expected %q, got %q", test.body, test.output, buf.String())
+			}
+		}
+		if !reflect.DeepEqual(calls, test.expected) {
+			t.Errorf("%s: expected calls %v, got %v", test.body, test.expected, calls)
+		}
+	}
+}
+
+func TestValidArgLengths(t *testing.T) {
+	var tests = []struct {
+		args, optional int
+		expected       []int
+	}{
+		{0, 0, []int{0}},
+		{2, 0, []int{2}},
+		{2, 1, []int{1, 2}},
+		{3, 3, []int{0, 1, 2, 3}},
+	}
+	for _, test := range tests {
+		var fn = plugin.Func{Args: make([]types.Type, test.args), Optional: test.optional}
+		if actual := fn.ValidArgLengths(); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%d args, %d optional: expected %v, got %v", test.args, test.optional, test.expected, actual)
+		}
+	}
+}
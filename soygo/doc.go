@@ -0,0 +1,36 @@
+/*
+Package soygo compiles Soy to Go.
+
+For each template in a registry, it generates a render function that takes a
+struct of the template's params, along with the functions that implement it.
+For example, this template:
+
+	{namespace acme.account}
+
+	{template .overview}
+	  {@param user: [name: string, age: int]}
+	  Hello {$user.name}!
+	{/template}
+
+is rendered by calling:
+
+	acme.AcmeAccountOverview(wr, acme.AcmeAccountOverviewParams{
+	    User: acme.AcmeAccountOverviewUser{Name: "Rob", Age: 35},
+	}, nil)
+
+Params declared in SoyDoc or without a type have type interface{}, and their
+values are converted with data.New.  Optional params are nilable; a nil value
+is treated as if the param were not passed.  Private templates do not get an
+exported render function.
+
+Every data reference must resolve to a declared param or variable, and
+functions and print directives must exist in the Config given to the generator.
+The render functions execute them using the Config in their Options, and both
+default to a copy of soyhtml's.  The generated output matches soyhtml,
+including contextual and strict autoescaping, content kinds, and delegate
+templates, which are selected using the DelPackages in the Options.
+
+The soygo command in the soygo sub-directory writes the generated code for a
+set of Soy files.
+*/
+package soygo
@@ -0,0 +1,851 @@
+package soygo
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/template"
+)
+
+// goVar is a Soy {let} or {foreach} variable declared as a Go local.
+type goVar struct {
+	name      string // the Soy variable name
+	ident     string // the Go identifier
+	used      bool   // true if the variable has been referenced
+	indexUsed bool   // true if a loop function has referenced the loop index
+	loop      bool   // true if this is a loop variable
+}
+
+// writeTemplate writes the render function for the given template, preceded
+// by its exported wrapper and params struct if it is not private.
+func (g *generator) writeTemplate(tmpl template.Template) {
+	g.tmpl, g.node = tmpl, tmpl.Node
+	g.scopes, g.varnum = nil, 0
+	g.params = make(map[string]string)
+
+	g.autoescape = tmpl.Namespace.Autoescape
+	if tmpl.Node.Autoescape != ast.AutoescapeUnspecified {
+		g.autoescape = tmpl.Node.Autoescape
+	}
+	switch tmpl.Node.Kind {
+	case data.KindUnspecified:
+	case data.KindText:
+		g.autoescape = ast.AutoescapeOff
+	default:
+		g.autoescape = ast.AutoescapeStrict
+	}
+
+	var idents []string
+	for _, param := range tmpl.Doc.Params {
+		var ident = "p" + exportedName(param.Name)
+		for name, other := range g.params {
+			if other == ident {
+				g.errorf("params %q and %q both map to Go identifier %s", name, param.Name, ident)
+			}
+		}
+		g.params[param.Name] = ident
+		idents = append(idents, ident)
+	}
+
+	if !tmpl.Node.Private && tmpl.Node.Delegate == nil {
+		g.writePublic(tmpl)
+	}
+
+	var fn = g.funcName(tmpl)
+	if len(idents) == 0 {
+		g.line("func ", fn, "(s *soygo.State) {")
+	} else {
+		g.line("func ", fn, "(s *soygo.State, ", strings.Join(idents, ", "), " data.Value) {")
+	}
+	g.line("defer soygo.Annotate(", strconv.Quote(tmpl.Node.Name), ")")
+	if g.contextual() {
+		g.line("defer s.EnterContext(", kindExpr(tmpl.Node.Kind), ")()")
+	}
+	for _, param := range tmpl.Node.Params {
+		if param.Default == nil {
+			continue
+		}
+		g.node = param
+		var ident = g.params[param.Name]
+		g.line("if _, ok := ", ident, ".(data.Undefined); ok {")
+		g.line(ident, " = ", g.expr(param.Default))
+		g.line("}")
+	}
+	g.block(tmpl.Node.Body)
+	g.line("}")
+	g.line()
+}
+
+// writePublic writes the params struct and exported render function for the
+// given template.
+func (g *generator) writePublic(tmpl template.Template) {
+	var (
+		name       = publicName(tmpl.Node.Name)
+		paramsType = name + "Params"
+		fields     bytes.Buffer
+		args       = []string{"s"}
+	)
+	g.reserve(name, "template "+tmpl.Node.Name)
+	g.reserve(paramsType, "the params of template "+tmpl.Node.Name)
+	for _, param := range tmpl.Doc.Params {
+		var (
+			field = exportedName(param.Name)
+			typ   = g.goTypeOf(paramType(tmpl.Node, param.Name, param.Optional), name+field)
+		)
+		fmt.Fprintf(&fields, "%s %s\n", field, typ.name)
+		args = append(args, fmt.Sprintf(typ.conv, "p."+field))
+	}
+
+	g.line("// ", paramsType, " are the params of the ", tmpl.Node.Name, " template.")
+	g.line("type ", paramsType, " struct {")
+	g.wr.Write(fields.Bytes())
+	g.line("}")
+	g.line()
+	g.line("// ", name, " renders the ", tmpl.Node.Name, " template.")
+	g.line("func ", name, "(wr io.Writer, p ", paramsType, ", opts *soygo.Options) error {")
+	g.line("return soygo.Render(wr, opts, func(s *soygo.State) {")
+	g.line(internalName(tmpl.Node.Name), "(", strings.Join(args, ", "), ")")
+	g.line("})")
+	g.line("}")
+	g.line()
+	g.usesIO = true
+}
+
+// walk writes the Go statements that render the given node.
+func (g *generator) walk(node ast.Node) {
+	g.node = node
+	switch node := node.(type) {
+	case *ast.ListNode:
+		for _, child := range node.Nodes {
+			g.walk(child)
+		}
+	case *ast.SoyDocNode, *ast.HeaderParamNode, *ast.DebuggerNode:
+
+		// Output nodes ----------
+	case *ast.RawTextNode:
+		g.line("s.Write(", strconv.Quote(string(node.Text)), ")")
+	case *ast.MsgHtmlTagNode:
+		g.line("s.Write(", strconv.Quote(string(node.Text)), ")")
+	case *ast.PrintNode:
+		g.writePrint(node)
+	case *ast.MsgNode:
+		g.writeMsg(node)
+	case *ast.CssNode:
+		if node.Expr != nil {
+			g.line("s.Write(", g.expr(node.Expr), ".String() + ", strconv.Quote("-"+node.Suffix), ")")
+		} else {
+			g.line("s.Write(", strconv.Quote(node.Suffix), ")")
+		}
+	case *ast.LogNode:
+		g.line("s.Log(", g.captureBlock(node.Body), ")")
+
+		// Control flow ----------
+	case *ast.IfNode:
+		for i, cond := range node.Conds {
+			switch {
+			case cond.Cond == nil:
+				g.line("} else {")
+			case i == 0:
+				g.line("if ", g.cond(cond.Cond), " {")
+			default:
+				g.line("} else if ", g.cond(cond.Cond), " {")
+			}
+			g.block(cond.Body)
+		}
+		g.line("}")
+	case *ast.ForNode:
+		g.writeFor(node)
+	case *ast.SwitchNode:
+		g.writeSwitch(node)
+	case *ast.CallNode:
+		g.writeCall(node)
+	case *ast.DelCallNode:
+		g.writeDelCall(node)
+	case *ast.LetValueNode:
+		var v = g.declareVar(node.Name)
+		g.line("var ", v.ident, " = ", g.expr(node.Expr))
+	case *ast.LetContentNode:
+		var value = g.content(node.Body, node.Kind)
+		var v = g.declareVar(node.Name)
+		g.line("var ", v.ident, " = ", value)
+
+	default:
+		g.errorf("unknown node: %T", node)
+	}
+}
+
+// block writes the given node within a new variable scope.
+func (g *generator) block(node ast.Node) {
+	g.scopes = append(g.scopes, nil)
+	g.walk(node)
+	var vars = g.scopes[len(g.scopes)-1]
+	g.scopes = g.scopes[:len(g.scopes)-1]
+	for _, v := range vars {
+		if !v.used && !v.loop {
+			g.line("_ = ", v.ident)
+		}
+	}
+}
+
+// capture returns the statements written by the given function, rather than
+// writing them to the output.
+func (g *generator) capture(fn func()) string {
+	var orig = g.wr
+	g.wr = &bytes.Buffer{}
+	fn()
+	var result = g.wr.String()
+	g.wr = orig
+	return result
+}
+
+// newIdent returns a Go identifier for a local variable, unique within the
+// current template.  Identifiers end in a number, so they do not conflict with
+// Go keywords or the parameters of the render function.
+func (g *generator) newIdent(prefix string) string {
+	g.varnum++
+	return prefix + strconv.Itoa(g.varnum)
+}
+
+// declareVar declares the Soy variable of the given name in the current scope.
+func (g *generator) declareVar(name string) *goVar {
+	var v = &goVar{name: name, ident: g.newIdent(name)}
+	g.scopes[len(g.scopes)-1] = append(g.scopes[len(g.scopes)-1], v)
+	return v
+}
+
+// lookupVar returns the {let} or {foreach} variable of the given name, or nil
+// if it is a param.
+func (g *generator) lookupVar(name string) *goVar {
+	for i := len(g.scopes) - 1; i >= 0; i-- {
+		var vars = g.scopes[i]
+		for j := len(vars) - 1; j >= 0; j-- {
+			if vars[j].name == name {
+				return vars[j]
+			}
+		}
+	}
+	return nil
+}
+
+// lookup returns the Go identifier holding the value of the given variable.
+func (g *generator) lookup(name string) string {
+	if v := g.lookupVar(name); v != nil {
+		v.used = true
+		return v.ident
+	}
+	if ident, ok := g.params[name]; ok {
+		return ident
+	}
+	g.errorf("$%s is not a declared param or variable", name)
+	panic("unreachable")
+}
+
+func (g *generator) writePrint(node *ast.PrintNode) {
+	var (
+		val    = g.expr(node.Arg)
+		escape = g.autoescape != ast.AutoescapeOff
+	)
+	for _, directiveNode := range node.Directives {
+		var directive, ok = g.config.PrintDirectives[directiveNode.Name]
+		if !ok {
+			g.errorf("Print directive %q does not exist", directiveNode.Name)
+		}
+		if !checkNumArgs(directive.ValidArgLengths, len(directiveNode.Args)) {
+			g.errorf("Print directive %q called with %v args, expected one of: %v",
+				directiveNode.Name, len(directiveNode.Args), directive.ValidArgLengths)
+		}
+		var args = []string{strconv.Quote(directiveNode.Name), val}
+		for _, arg := range directiveNode.Args {
+			args = append(args, g.expr(arg))
+		}
+		val = "s.Directive(" + strings.Join(args, ", ") + ")"
+		if directive.CancelAutoescape {
+			escape = false
+		}
+	}
+	if escape && g.contextual() {
+		g.line("s.PrintInContext(", val, ")")
+		return
+	}
+	g.line("s.Print(", val, ", ", strconv.FormatBool(escape), ")")
+}
+
+// contextual returns true if the current template is contextually autoescaped.
+func (g *generator) contextual() bool {
+	return g.autoescape == ast.AutoescapeContextual || g.autoescape == ast.AutoescapeStrict
+}
+
+func (g *generator) writeFor(node *ast.ForNode) {
+	var list = g.expr(node.List)
+	g.scopes = append(g.scopes, nil)
+	var (
+		v     = g.declareVar(node.Var)
+		index = v.ident + "Index"
+		items = v.ident + "List"
+	)
+	v.loop = true
+	var body = g.capture(func() { g.block(node.Body) })
+	g.scopes = g.scopes[:len(g.scopes)-1]
+
+	g.line("var ", items, " = soygo.List(", list, ")")
+	switch {
+	case v.used && v.indexUsed:
+		g.line("for ", index, ", ", v.ident, " := range ", items, " {")
+	case v.used:
+		g.line("for _, ", v.ident, " := range ", items, " {")
+	case v.indexUsed:
+		g.line("for ", index, " := range ", items, " {")
+	default:
+		g.line("for range ", items, " {")
+	}
+	g.wr.WriteString(body)
+	g.line("}")
+	if node.IfEmpty != nil {
+		g.line("if len(", items, ") == 0 {")
+		g.block(node.IfEmpty)
+		g.line("}")
+	}
+}
+
+func (g *generator) writeSwitch(node *ast.SwitchNode) {
+	var (
+		value = g.expr(node.Value)
+		v     = g.newIdent("switch")
+		conds = make([]string, len(node.Cases))
+	)
+	for i, caseNode := range node.Cases {
+		g.node = caseNode
+		var exprs []string
+		for _, caseValue := range caseNode.Values {
+			exprs = append(exprs, v+".Equals("+g.expr(caseValue)+")")
+		}
+		conds[i] = strings.Join(exprs, ", ")
+	}
+	if len(node.Cases) == 0 || len(node.Cases[0].Values) == 0 {
+		g.line("_ = ", value)
+	} else {
+		g.line("var ", v, " = ", value)
+	}
+	g.line("switch {")
+	for i, caseNode := range node.Cases {
+		if len(caseNode.Values) == 0 {
+			g.line("default:")
+		} else {
+			g.line("case ", conds[i], ":")
+		}
+		g.block(caseNode.Body)
+	}
+	g.line("}")
+}
+
+func (g *generator) writeCall(node *ast.CallNode) {
+	var callee, ok = g.registry.Template(node.Name)
+	if !ok {
+		g.errorf("failed to find template: %s", node.Name)
+	}
+	var callData, params = g.callParams(node)
+	g.writeCallTo(node, callee, callData, params)
+}
+
+func (g *generator) writeDelCall(node *ast.DelCallNode) {
+	var variant = "nil"
+	if node.Variant != nil {
+		variant = g.expr(node.Variant)
+	}
+	var callData, params = g.callParams(&node.CallNode)
+
+	var (
+		impls  = g.registry.Delegates(node.Name)
+		ident  = "delegates" + publicName(node.Name)
+		fields []string
+	)
+	for _, impl := range impls {
+		fields = append(fields, fmt.Sprintf("{Variant: %q, Package: %q},\n",
+			impl.Node.Delegate.Variant, impl.Node.Delegate.Package))
+	}
+	if _, ok := g.decls[ident]; !ok {
+		g.declare(ident, "var "+ident+" = []soygo.DelegateImpl{\n"+strings.Join(fields, "")+"}")
+	}
+	var selected = fmt.Sprintf("s.Delegate(%q, %s, %s, %t)", node.Name, variant, ident, node.AllowEmptyDefault)
+	if len(impls) == 0 {
+		g.line(selected)
+		return
+	}
+	g.line("switch ", selected, " {")
+	for i, impl := range impls {
+		g.line("case ", strconv.Itoa(i), ":")
+		g.writeCallTo(&node.CallNode, impl, callData, params)
+	}
+	g.line("}")
+}
+
+// callParams returns the identifier of the variable holding the data passed by
+// the given call, if any, and expressions for the params it passes, by name.
+func (g *generator) callParams(node *ast.CallNode) (callData string, params map[string]string) {
+	if node.Data != nil {
+		callData = g.newIdent("data")
+		g.line("var ", callData, " = soygo.Map(", g.expr(node.Data), ")")
+	}
+	params = make(map[string]string)
+	for _, param := range node.Params {
+		switch param := param.(type) {
+		case *ast.CallParamValueNode:
+			params[param.Key] = g.expr(param.Value)
+		case *ast.CallParamContentNode:
+			params[param.Key] = g.content(param.Content, param.Kind)
+		default:
+			g.errorf("unexpected call param type: %T", param)
+		}
+	}
+	return callData, params
+}
+
+// writeCallTo writes the call of the given template, with the given data and
+// params.  Strict templates called by contextually autoescaped ones are
+// rendered on their own, and their output is escaped as content of their kind
+// in the caller's context.
+func (g *generator) writeCallTo(node *ast.CallNode, callee template.Template, callData string, params map[string]string) {
+	var args = []string{"s"}
+	for _, param := range callee.Doc.Params {
+		var arg, ok = params[param.Name]
+		switch {
+		case ok:
+		case node.AllData && g.params[param.Name] != "":
+			arg = g.params[param.Name]
+		case callData != "":
+			arg = callData + ".Key(" + strconv.Quote(param.Name) + ")"
+		default:
+			arg = "data.Undefined{}"
+		}
+		args = append(args, arg)
+	}
+	if callData != "" && len(callee.Doc.Params) == 0 {
+		g.line("_ = ", callData)
+	}
+	g.node = node
+	var call = g.funcName(callee) + "(" + strings.Join(args, ", ") + ")"
+	if !g.contextual() || !isStrict(callee) {
+		g.line(call)
+		return
+	}
+	var kind = callee.Node.Kind
+	if kind == data.KindUnspecified {
+		kind = data.KindHTML
+	}
+	g.line("s.WriteInContext(data.NewSanitizedContent(s.CaptureContent(", kindExpr(kind), ", func() {")
+	g.line(call)
+	g.line("}), ", kindExpr(kind), "))")
+}
+
+// isStrict returns true if the given template is strictly autoescaped.
+func isStrict(tmpl template.Template) bool {
+	switch {
+	case tmpl.Node.Kind != data.KindUnspecified:
+		return true
+	case tmpl.Node.Autoescape != ast.AutoescapeUnspecified:
+		return tmpl.Node.Autoescape == ast.AutoescapeStrict
+	}
+	return tmpl.Namespace.Autoescape == ast.AutoescapeStrict
+}
+
+// content returns an expression that renders the given block of content to a
+// value of the given kind.  Blocks with a kind are strictly autoescaped,
+// starting in the context appropriate to their kind.  Blocks in strict
+// templates default to HTML.
+func (g *generator) content(node ast.Node, kind data.ContentKind) string {
+	if kind == data.KindUnspecified && g.autoescape == ast.AutoescapeStrict {
+		kind = data.KindHTML
+	}
+	if kind == data.KindUnspecified {
+		return "data.String(" + g.captureBlock(node) + ")"
+	}
+
+	var autoescape = g.autoescape
+	var body string
+	if kind == data.KindText {
+		g.autoescape = ast.AutoescapeOff
+		body = "s.Capture(func() {\n" + g.capture(func() { g.block(node) }) + "})"
+	} else {
+		g.autoescape = ast.AutoescapeStrict
+		body = "s.CaptureContent(" + kindExpr(kind) + ", func() {\n" + g.capture(func() { g.block(node) }) + "})"
+	}
+	g.autoescape = autoescape
+	return "data.NewSanitizedContent(" + body + ", " + kindExpr(kind) + ")"
+}
+
+// captureBlock returns an expression that renders the given block to a string.
+// In contextually autoescaped templates, the block begins in the HTML text
+// context.
+func (g *generator) captureBlock(node ast.Node) string {
+	var body = g.capture(func() { g.block(node) })
+	if g.contextual() {
+		return "s.CaptureContent(data.KindHTML, func() {\n" + body + "})"
+	}
+	return "s.Capture(func() {\n" + body + "})"
+}
+
+// kindExpr returns a Go expression for the given content kind.
+func kindExpr(kind data.ContentKind) string {
+	return map[data.ContentKind]string{
+		data.KindUnspecified: "data.KindUnspecified",
+		data.KindText:        "data.KindText",
+		data.KindHTML:        "data.KindHTML",
+		data.KindAttributes:  "data.KindAttributes",
+		data.KindJS:          "data.KindJS",
+		data.KindCSS:         "data.KindCSS",
+		data.KindURI:         "data.KindURI",
+	}[kind]
+}
+
+func (g *generator) writeMsg(node *ast.MsgNode) {
+	var placeholders = make(map[string]*ast.MsgPlaceholderNode)
+	var plurals []*ast.MsgPluralNode
+	var q = node.Body.Children()
+	for len(q) > 0 {
+		var n ast.Node
+		n, q = q[0], q[1:]
+		switch n := n.(type) {
+		case *ast.MsgPlaceholderNode:
+			if _, ok := placeholders[n.Name]; !ok {
+				placeholders[n.Name] = n
+			}
+		case *ast.MsgPluralNode:
+			plurals = append(plurals, n)
+			for _, pluralCase := range n.Cases {
+				q = append(q, pluralCase.Body.Children()...)
+			}
+			q = append(q, n.Default.Children()...)
+		}
+	}
+
+	var placeholderFn, pluralFn = "nil", "nil"
+	if len(placeholders) > 0 {
+		var names []string
+		for name := range placeholders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		placeholderFn = g.capture(func() {
+			g.line("func(name string) bool {")
+			g.line("switch name {")
+			for _, name := range names {
+				g.line("case ", strconv.Quote(name), ":")
+				g.block(placeholders[name].Body)
+			}
+			g.line("default:")
+			g.line("return false")
+			g.line("}")
+			g.line("return true")
+			g.wr.WriteString("}")
+		})
+	}
+	if len(plurals) > 0 {
+		pluralFn = g.capture(func() {
+			g.line("func(name string) data.Value {")
+			g.line("switch name {")
+			for _, plural := range plurals {
+				g.node = plural
+				g.line("case ", strconv.Quote(plural.VarName), ":")
+				g.line("return ", g.expr(plural.Value))
+			}
+			g.line("}")
+			g.line("return nil")
+			g.wr.WriteString("}")
+		})
+	}
+
+	var msg = g.newIdent("msg")
+	g.node = node
+	g.line("if ", msg, " := s.Message(", strconv.FormatUint(node.ID, 10), "); ", msg, " != nil {")
+	g.line("s.RenderMsg(", msg, ".Parts, ", placeholderFn, ", ", pluralFn, ")")
+	g.line("} else {")
+	g.writeMsgBody(node.Body)
+	g.line("}")
+}
+
+// writeMsgBody writes the message in the source language, used if there is no
+// translation.
+func (g *generator) writeMsgBody(node ast.ParentNode) {
+	for _, n := range node.Children() {
+		switch n := n.(type) {
+		case *ast.RawTextNode:
+			g.walk(n)
+		case *ast.MsgPlaceholderNode:
+			g.block(n.Body)
+		case *ast.MsgPluralNode:
+			g.node = n
+			g.line("switch soygo.PluralValue(", g.expr(n.Value), ") {")
+			var seen = make(map[int]bool)
+			for _, pluralCase := range n.Cases {
+				if seen[pluralCase.Value] {
+					continue
+				}
+				seen[pluralCase.Value] = true
+				g.line("case ", strconv.Itoa(pluralCase.Value), ":")
+				g.writeMsgBody(pluralCase.Body)
+			}
+			g.line("default:")
+			g.writeMsgBody(n.Default)
+			g.line("}")
+		}
+	}
+}
+
+// expr returns a Go expression that evaluates the given node to a data.Value.
+func (g *generator) expr(node ast.Node) string {
+	var prev = g.node
+	g.node = node
+	defer func() { g.node = prev }()
+	switch node := node.(type) {
+	case *ast.NullNode:
+		return "data.Null{}"
+	case *ast.StringNode:
+		return "data.String(" + strconv.Quote(node.Value) + ")"
+	case *ast.IntNode:
+		return "data.Int(" + strconv.FormatInt(node.Value, 10) + ")"
+	case *ast.FloatNode:
+		return "data.Float(" + strconv.FormatFloat(node.Value, 'g', -1, 64) + ")"
+	case *ast.BoolNode:
+		return "data.Bool(" + strconv.FormatBool(node.True) + ")"
+	case *ast.GlobalNode:
+		return g.valueLiteral(node.Value)
+	case *ast.ListLiteralNode:
+		var items []string
+		for _, item := range node.Items {
+			items = append(items, g.expr(item))
+		}
+		return "data.List{" + strings.Join(items, ", ") + "}"
+	case *ast.MapLiteralNode:
+		var keys []string
+		for k := range node.Items {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var items []string
+		for _, k := range keys {
+			items = append(items, strconv.Quote(k)+": "+g.expr(node.Items[k]))
+		}
+		return "data.Map{" + strings.Join(items, ", ") + "}"
+	case *ast.FunctionNode:
+		return g.function(node)
+	case *ast.DataRefNode:
+		return g.dataRef(node)
+
+		// Arithmetic operators ----------
+	case *ast.NegateNode:
+		return "soygo.Negate(" + g.expr(node.Arg) + ")"
+	case *ast.AddNode:
+		return g.call("soygo.Add", node.Arg1, node.Arg2)
+	case *ast.SubNode:
+		return g.call("soygo.Sub", node.Arg1, node.Arg2)
+	case *ast.MulNode:
+		return g.call("soygo.Mul", node.Arg1, node.Arg2)
+	case *ast.DivNode:
+		return g.call("soygo.Div", node.Arg1, node.Arg2)
+	case *ast.ModNode:
+		return g.call("soygo.Mod", node.Arg1, node.Arg2)
+
+		// Arithmetic comparisons ----------
+	case *ast.EqNode, *ast.NotEqNode, *ast.NotNode, *ast.AndNode, *ast.OrNode:
+		return "data.Bool(" + g.cond(node) + ")"
+	case *ast.LtNode:
+		return g.call("soygo.Less", node.Arg1, node.Arg2)
+	case *ast.LteNode:
+		return g.call("soygo.LessEq", node.Arg1, node.Arg2)
+	case *ast.GtNode:
+		return g.call("soygo.Greater", node.Arg1, node.Arg2)
+	case *ast.GteNode:
+		return g.call("soygo.GreaterEq", node.Arg1, node.Arg2)
+
+		// Boolean operators ----------
+	case *ast.ElvisNode:
+		return "func() data.Value {\nif v := " + g.expr(node.Arg1) + "; !soygo.IsNull(v) {\nreturn v\n}\n" +
+			"return " + g.expr(node.Arg2) + "\n}()"
+	case *ast.TernNode:
+		return "func() data.Value {\nif " + g.cond(node.Arg1) + " {\nreturn " + g.expr(node.Arg2) + "\n}\n" +
+			"return " + g.expr(node.Arg3) + "\n}()"
+	}
+	g.errorf("unknown node: %T", node)
+	panic("unreachable")
+}
+
+// cond returns a Go boolean expression for the truthiness of the given node.
+func (g *generator) cond(node ast.Node) string {
+	var prev = g.node
+	g.node = node
+	defer func() { g.node = prev }()
+	switch node := node.(type) {
+	case *ast.EqNode:
+		return g.expr(node.Arg1) + ".Equals(" + g.expr(node.Arg2) + ")"
+	case *ast.NotEqNode:
+		return "!" + g.expr(node.Arg1) + ".Equals(" + g.expr(node.Arg2) + ")"
+	case *ast.NotNode:
+		switch arg := node.Arg.(type) {
+		case *ast.EqNode:
+			return "!" + g.expr(arg.Arg1) + ".Equals(" + g.expr(arg.Arg2) + ")"
+		case *ast.NotEqNode:
+			return g.expr(arg.Arg1) + ".Equals(" + g.expr(arg.Arg2) + ")"
+		case *ast.AndNode, *ast.OrNode:
+			return "!(" + g.cond(arg) + ")"
+		case *ast.FunctionNode:
+			if arg.Name == "isFirst" || arg.Name == "isLast" {
+				return "!(" + g.cond(arg) + ")"
+			}
+		}
+		return "!" + g.cond(node.Arg)
+	case *ast.AndNode:
+		return g.condOperand(node.Arg1) + " && " + g.condOperand(node.Arg2)
+	case *ast.OrNode:
+		return g.cond(node.Arg1) + " || " + g.cond(node.Arg2)
+	case *ast.FunctionNode:
+		if node.Name == "isFirst" || node.Name == "isLast" {
+			return g.loopFunc(node)
+		}
+	}
+	return g.expr(node) + ".Truthy()"
+}
+
+// condOperand returns cond for an operand of &&, parenthesized if required.
+func (g *generator) condOperand(node ast.Node) string {
+	if _, ok := node.(*ast.OrNode); ok {
+		return "(" + g.cond(node) + ")"
+	}
+	return g.cond(node)
+}
+
+// call returns an expression calling the given function with the given args.
+func (g *generator) call(fn string, args ...ast.Node) string {
+	var exprs []string
+	for _, arg := range args {
+		exprs = append(exprs, g.expr(arg))
+	}
+	return fn + "(" + strings.Join(exprs, ", ") + ")"
+}
+
+func (g *generator) function(node *ast.FunctionNode) string {
+	switch node.Name {
+	case "index":
+		return "data.Int(" + g.loopFunc(node) + ")"
+	case "isFirst", "isLast":
+		return "data.Bool(" + g.loopFunc(node) + ")"
+	}
+
+	var fn, ok = g.config.Funcs[node.Name]
+	if !ok {
+		g.errorf("unrecognized function name: %s", node.Name)
+	}
+	if !checkNumArgs(fn.ValidArgLengths, len(node.Args)) {
+		g.errorf("Function %q called with %v args, expected: %v",
+			node.Name, len(node.Args), fn.ValidArgLengths)
+	}
+	var args = []string{strconv.Quote(node.Name)}
+	for _, arg := range node.Args {
+		args = append(args, g.expr(arg))
+	}
+	return "s.Func(" + strings.Join(args, ", ") + ")"
+}
+
+func (g *generator) dataRef(node *ast.DataRefNode) string {
+	var ref string
+	if node.Key == "ij" {
+		ref = "s.Injected()"
+	} else {
+		ref = g.lookup(node.Key)
+	}
+	if len(node.Access) == 0 {
+		return ref
+	}
+
+	var args = []string{strconv.Quote("$" + node.Key), ref}
+	for _, accessNode := range node.Access {
+		var step, nullsafe string
+		switch accessNode := accessNode.(type) {
+		case *ast.DataRefIndexNode:
+			step, nullsafe = "Index: "+strconv.Itoa(accessNode.Index), boolField(accessNode.NullSafe)
+		case *ast.DataRefKeyNode:
+			step, nullsafe = "Key: "+strconv.Quote(accessNode.Key), boolField(accessNode.NullSafe)
+		case *ast.DataRefExprNode:
+			step, nullsafe = "Expr: "+g.expr(accessNode.Arg), boolField(accessNode.NullSafe)
+		default:
+			g.errorf("unexpected access node: %T", accessNode)
+		}
+		args = append(args, "soygo.Step{"+step+nullsafe+"}")
+	}
+	return "soygo.Access(" + strings.Join(args, ", ") + ")"
+}
+
+// loopFunc returns a Go expression for the given call to index(), isFirst() or
+// isLast(), which evaluates to an int or bool.
+func (g *generator) loopFunc(node *ast.FunctionNode) string {
+	var ref, ok = node.Args[0].(*ast.DataRefNode)
+	var v *goVar
+	if ok {
+		v = g.lookupVar(ref.Key)
+	}
+	if v == nil || !v.loop {
+		g.errorf("%s() requires a foreach loop variable, got %v", node.Name, node.Args[0])
+	}
+	v.indexUsed = true
+	switch node.Name {
+	case "index":
+		return v.ident + "Index"
+	case "isFirst":
+		return v.ident + "Index == 0"
+	}
+	return v.ident + "Index == len(" + v.ident + "List)-1"
+}
+
+func boolField(nullsafe bool) string {
+	if nullsafe {
+		return ", NullSafe: true"
+	}
+	return ""
+}
+
+// valueLiteral returns a Go expression for the given (global) value.
+func (g *generator) valueLiteral(val data.Value) string {
+	switch val := val.(type) {
+	case data.Null:
+		return "data.Null{}"
+	case data.Bool:
+		return "data.Bool(" + strconv.FormatBool(bool(val)) + ")"
+	case data.Int:
+		return "data.Int(" + strconv.FormatInt(int64(val), 10) + ")"
+	case data.Float:
+		return "data.Float(" + strconv.FormatFloat(float64(val), 'g', -1, 64) + ")"
+	case data.String:
+		return "data.String(" + strconv.Quote(string(val)) + ")"
+	case data.List:
+		var items []string
+		for _, item := range val {
+			items = append(items, g.valueLiteral(item))
+		}
+		return "data.List{" + strings.Join(items, ", ") + "}"
+	case data.Map:
+		var keys []string
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var items []string
+		for _, k := range keys {
+			items = append(items, strconv.Quote(k)+": "+g.valueLiteral(val[k]))
+		}
+		return "data.Map{" + strings.Join(items, ", ") + "}"
+	}
+	g.errorf("global value %v of type %T is not supported", val, val)
+	panic("unreachable")
+}
+
+// line writes the given strings followed by a newline.
+func (g *generator) line(args ...string) {
+	for _, arg := range args {
+		g.wr.WriteString(arg)
+	}
+	g.wr.WriteByte('\n')
+}
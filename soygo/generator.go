@@ -0,0 +1,197 @@
+package soygo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/errortypes"
+	"github.com/robfig/soy/template"
+)
+
+// GenOptions for Go source generation.
+type GenOptions struct {
+	Package string  // the package name of the generated file
+	Config  *Config // the functions and print directives, or NewConfig() if nil
+}
+
+// Generator provides an interface to a template registry capable of generating
+// Go source to execute the embodied templates.
+type Generator struct {
+	registry *template.Registry
+}
+
+// NewGenerator returns a new Go generator capable of producing Go source for
+// the templates contained in the given registry.
+func NewGenerator(registry *template.Registry) *Generator {
+	return &Generator{registry}
+}
+
+// WritePackage generates a Go file of the given package containing a render
+// function for each template in the registry.
+func (gen *Generator) WritePackage(out io.Writer, pkg string) error {
+	return Write(out, gen.registry, GenOptions{Package: pkg})
+}
+
+// generator holds the state of the Go source generation.
+type generator struct {
+	registry *template.Registry
+	config   *Config
+	wr       *bytes.Buffer
+	decls    map[string]string // declarations of types and helpers, by name
+	idents   map[string]string // top-level identifiers, to what they declare
+	usesIO   bool              // true if any public render functions were written
+
+	// state for the template being generated
+	tmpl       template.Template
+	node       ast.Node           // current node, for errors
+	autoescape ast.AutoescapeType // escaping mode
+	params     map[string]string
+	scopes     [][]*goVar
+	varnum     int
+}
+
+// Write writes Go source for all templates in the given registry to the given
+// writer.  The first error encountered is returned.
+func Write(out io.Writer, registry *template.Registry, options GenOptions) (err error) {
+	if options.Package == "" {
+		return errors.New("soygo: a package name is required")
+	}
+	if options.Config == nil {
+		options.Config = NewConfig()
+	}
+	var g = &generator{
+		registry: registry,
+		config:   options.Config,
+		wr:       &bytes.Buffer{},
+		decls:    make(map[string]string),
+		idents:   make(map[string]string),
+	}
+	defer g.errRecover(&err)
+	for _, tmpl := range registry.Templates {
+		g.reserve(g.funcName(tmpl), "template "+tmpl.Node.Name)
+	}
+	for _, tmpl := range registry.Templates {
+		g.writeTemplate(tmpl)
+	}
+
+	var src bytes.Buffer
+	var filenames []string
+	for _, soyfile := range registry.SoyFiles {
+		filenames = append(filenames, soyfile.Name)
+	}
+	fmt.Fprintf(&src, "// Code generated by soygo from %s. DO NOT EDIT.\n\n", strings.Join(filenames, ", "))
+	fmt.Fprintf(&src, "package %s\n\n", options.Package)
+	if g.wr.Len() > 0 {
+		src.WriteString("import (\n")
+		if g.usesIO {
+			src.WriteString("\"io\"\n\n")
+		}
+		src.WriteString("\"github.com/robfig/soy/data\"\n\"github.com/robfig/soy/soygo\"\n)\n\n")
+		src.WriteString("// Reference imports to suppress errors if they are not otherwise used.\n")
+		src.WriteString("var _ data.Value\n\n")
+	}
+	src.Write(g.wr.Bytes())
+	var names []string
+	for name := range g.decls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		src.WriteString(g.decls[name])
+		src.WriteString("\n\n")
+	}
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return fmt.Errorf("soygo: failed to format generated source: %v", err)
+	}
+	_, err = out.Write(formatted)
+	return err
+}
+
+// reserve records that the given top-level identifier is declared.
+func (g *generator) reserve(ident, what string) {
+	if other, ok := g.idents[ident]; ok {
+		g.errorf("%s and %s both generate Go identifier %s", other, what, ident)
+	}
+	g.idents[ident] = what
+}
+
+// declare adds the given top-level declaration to the output.
+func (g *generator) declare(ident, decl string) {
+	g.reserve(ident, "a declaration for template "+g.tmpl.Node.Name)
+	g.decls[ident] = decl
+}
+
+// errorf formats the error and terminates generation.
+func (g *generator) errorf(format string, args ...interface{}) {
+	if g.node == nil {
+		panic(fmt.Errorf(format, args...))
+	}
+	var name = g.tmpl.Node.Name
+	panic(errortypes.NewErrFilePosf(
+		g.registry.Filename(name),
+		g.registry.LineNumber(name, g.node),
+		g.registry.ColNumber(name, g.node),
+		"template %s: %s", name, fmt.Sprintf(format, args...)))
+}
+
+// errRecover is the handler that turns panics into returns from the top
+// level of Write.
+func (g *generator) errRecover(errp *error) {
+	if e := recover(); e != nil {
+		if err, ok := e.(error); ok {
+			*errp = err
+			return
+		}
+		*errp = fmt.Errorf("%v", e)
+	}
+}
+
+// exportedName returns the given name with its first letter capitalized.
+func exportedName(name string) string {
+	var r, n = utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[n:]
+}
+
+// publicName returns the name of the render function for the given template,
+// formed by capitalizing each part of its name, e.g. "ns.hello" => "NsHello".
+func publicName(templateName string) string {
+	var name string
+	for _, part := range strings.Split(templateName, ".") {
+		if part != "" {
+			name += exportedName(part)
+		}
+	}
+	return name
+}
+
+// internalName returns the name of the function implementing the given
+// template.
+func internalName(templateName string) string {
+	return "render" + publicName(templateName)
+}
+
+// funcName returns the name of the function implementing the given template.
+// Delegate templates are numbered by their position among the implementations
+// of the delegate, e.g. "renderDelNsHello0".
+func (g *generator) funcName(tmpl template.Template) string {
+	if tmpl.Node.Delegate == nil {
+		return internalName(tmpl.Node.Name)
+	}
+	for i, impl := range g.registry.Delegates(tmpl.Node.Delegate.Name) {
+		if impl.Node == tmpl.Node {
+			return "renderDel" + publicName(tmpl.Node.Delegate.Name) + strconv.Itoa(i)
+		}
+	}
+	panic("unreachable")
+}
@@ -0,0 +1,74 @@
+package soygo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/robfig/soy"
+	"github.com/robfig/soy/parse"
+	"github.com/robfig/soy/template"
+)
+
+func TestExampleUpToDate(t *testing.T) {
+	var bundle = soy.NewBundle()
+	for _, name := range []string{"example.soy", "strict.soy", "fancy.soy"} {
+		var content, err = ioutil.ReadFile("internal/example/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle.AddTemplateString(name, string(content))
+	}
+	var registry, err = bundle.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = NewGenerator(registry).WritePackage(&buf, "example"); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile("internal/example/example.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(expected) {
+		t.Errorf("internal/example/example.go is out of date; run go generate")
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	var tests = []struct {
+		body, err string
+	}{
+		{`{template .a}{$x}{/template}`,
+			"$x is not a declared param or variable"},
+		{`{template .a}{@param x: int}{$x |unknown}{/template}`,
+			`Print directive "unknown" does not exist`},
+		{`{template .a}{@param x: int}{$x |truncate}{/template}`,
+			`Print directive "truncate" called with 0 args`},
+		{`{template .a}{unknown()}{/template}`,
+			"unrecognized function name: unknown"},
+		{`{template .a}{@param x: int}{$x}{/template}
+{template .aParams}{/template}`,
+			"Go identifier TestAParams"},
+		{`{template .a}{@param x: int}{@param X: int}{$x}{$X}{/template}`,
+			"Go identifier pX"},
+	}
+	for _, test := range tests {
+		var soyfile, err = parse.SoyFile("test.soy", "{namespace test}\n"+test.body)
+		if err != nil {
+			t.Errorf("%s: %v", test.body, err)
+			continue
+		}
+		var registry = template.Registry{}
+		if err = registry.Add(soyfile); err != nil {
+			t.Errorf("%s: %v", test.body, err)
+			continue
+		}
+		err = Write(ioutil.Discard, &registry, GenOptions{Package: "test"})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.body, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+// Package example is generated from example.soy by soygo, and is tested to
+// render the same output as soyhtml.
+package example
+
+//go:generate go run ../../soygo -pkg example -o example.go example.soy strict.soy fancy.soy
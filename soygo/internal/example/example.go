@@ -0,0 +1,446 @@
+// Code generated by soygo from example.soy, strict.soy, fancy.soy. DO NOT EDIT.
+
+package example
+
+import (
+	"io"
+
+	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/soygo"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ data.Value
+
+// ExampleHelloNameParams are the params of the example.helloName template.
+type ExampleHelloNameParams struct {
+	Name         interface{}
+	GreetingWord interface{}
+}
+
+// ExampleHelloName renders the example.helloName template.
+func ExampleHelloName(wr io.Writer, p ExampleHelloNameParams, opts *soygo.Options) error {
+	return soygo.Render(wr, opts, func(s *soygo.State) {
+		renderExampleHelloName(s, soygo.New(p.Name), soygo.New(p.GreetingWord))
+	})
+}
+
+func renderExampleHelloName(s *soygo.State, pName, pGreetingWord data.Value) {
+	defer soygo.Annotate("example.helloName")
+	if !pGreetingWord.Truthy() {
+		s.Write("Hello ")
+		s.Print(pName, true)
+		s.Write("!")
+	} else {
+		s.Print(pGreetingWord, true)
+		s.Write(" ")
+		s.Print(pName, true)
+		s.Write("!")
+	}
+}
+
+// ExampleHelloNamesParams are the params of the example.helloNames template.
+type ExampleHelloNamesParams struct {
+	Name            interface{}
+	AdditionalNames interface{}
+}
+
+// ExampleHelloNames renders the example.helloNames template.
+func ExampleHelloNames(wr io.Writer, p ExampleHelloNamesParams, opts *soygo.Options) error {
+	return soygo.Render(wr, opts, func(s *soygo.State) {
+		renderExampleHelloNames(s, soygo.New(p.Name), soygo.New(p.AdditionalNames))
+	})
+}
+
+func renderExampleHelloNames(s *soygo.State, pName, pAdditionalNames data.Value) {
+	defer soygo.Annotate("example.helloNames")
+	renderExampleHelloName(s, pName, data.Undefined{})
+	s.Write("<br>")
+	var additionalName1List = soygo.List(pAdditionalNames)
+	for additionalName1Index, additionalName1 := range additionalName1List {
+		renderExampleHelloName(s, additionalName1, data.Undefined{})
+		if !(additionalName1Index == len(additionalName1List)-1) {
+			s.Write("<br>")
+		}
+	}
+	if len(additionalName1List) == 0 {
+		s.Write("No additional people to greet.")
+	}
+}
+
+// ExampleProfileParams are the params of the example.profile template.
+type ExampleProfileParams struct {
+	User   ExampleProfileUser
+	Visits *int64
+	Scores map[string]float64
+	Note   interface{}
+}
+
+// ExampleProfile renders the example.profile template.
+func ExampleProfile(wr io.Writer, p ExampleProfileParams, opts *soygo.Options) error {
+	return soygo.Render(wr, opts, func(s *soygo.State) {
+		renderExampleProfile(s, valueOfExampleProfileUser(p.User), valueOfPtrToInt64(p.Visits), valueOfNullableMapOfFloat64(p.Scores), soygo.New(p.Note))
+	})
+}
+
+func renderExampleProfile(s *soygo.State, pUser, pVisits, pScores, pNote data.Value) {
+	defer soygo.Annotate("example.profile")
+	if _, ok := pVisits.(data.Undefined); ok {
+		pVisits = data.Int(1)
+	}
+	var title1 = data.NewSanitizedContent(s.Capture(func() {
+		s.Print(soygo.Access("$user", pUser, soygo.Step{Key: "name"}), false)
+		s.Write("'s profile")
+	}), data.KindText)
+	s.Write("<h1>")
+	s.Print(title1, true)
+	s.Write("</h1><p class=\"")
+	s.Write("user-age")
+	s.Write("\">")
+	s.Print(soygo.Sub(soygo.Mul(soygo.Access("$user", pUser, soygo.Step{Key: "age"}), data.Int(2)), data.Int(1)), true)
+	s.Write(" / ")
+	s.Print(soygo.Add(pVisits, data.Float(0.5)), true)
+	s.Write(" / ")
+	s.Print(soygo.Mod(soygo.Access("$user", pUser, soygo.Step{Key: "age"}), data.Int(7)), true)
+	s.Write("</p><ul>")
+	var tag2List = soygo.List(soygo.Access("$user", pUser, soygo.Step{Key: "tags"}))
+	for tag2Index, tag2 := range tag2List {
+		s.Write("<li")
+		if tag2Index == 0 {
+			s.Write(" class=\"first\"")
+		}
+		s.Write(">")
+		s.Print(data.Int(tag2Index), true)
+		s.Write(": ")
+		s.Print(s.Directive("truncate", tag2, data.Int(5)), true)
+		s.Write("</li>")
+	}
+	if len(tag2List) == 0 {
+		s.Write("<li>none</li>")
+	}
+	s.Write("</ul>")
+	if soygo.Access("$user", pUser, soygo.Step{Key: "address"}, soygo.Step{Key: "city", NullSafe: true}).Truthy() {
+		s.Print(s.Directive("noAutoescape", soygo.Access("$user", pUser, soygo.Step{Key: "address"}, soygo.Step{Key: "city"})), false)
+	} else if pScores.Truthy() {
+		s.Print(func() data.Value {
+			if v := soygo.Access("$scores", pScores, soygo.Step{Expr: data.String("math")}); !soygo.IsNull(v) {
+				return v
+			}
+			return data.String("n/a")
+		}(), true)
+	} else {
+		s.Print(func() data.Value {
+			if v := pNote; !soygo.IsNull(v) {
+				return v
+			}
+			return data.String("<none>")
+		}(), true)
+	}
+	var switch3 = pVisits
+	switch {
+	case switch3.Equals(data.Int(1)):
+		s.Write("first visit")
+	case switch3.Equals(data.Int(2)), switch3.Equals(data.Int(3)):
+		s.Write("a few visits")
+	default:
+		renderExampleVisits(s, pVisits)
+	}
+	var i4List = soygo.List(s.Func("range", data.Int(1), func() data.Value {
+		if soygo.Greater(pVisits, data.Int(3)).Truthy() {
+			return data.Int(3)
+		}
+		return pVisits
+	}()))
+	for _, i4 := range i4List {
+		s.Write("[")
+		s.Print(i4, true)
+		s.Write("]")
+	}
+	if msg5 := s.Message(564925288985248624); msg5 != nil {
+		s.RenderMsg(msg5.Parts, func(name string) bool {
+			switch name {
+			case "NAME":
+				s.Print(soygo.Access("$user", pUser, soygo.Step{Key: "name"}), true)
+			case "VISITS_2":
+				s.Print(pVisits, true)
+			default:
+				return false
+			}
+			return true
+		}, func(name string) data.Value {
+			switch name {
+			case "VISITS_1":
+				return pVisits
+			}
+			return nil
+		})
+	} else {
+		switch soygo.PluralValue(pVisits) {
+		case 1:
+			s.Write("One visit by ")
+			s.Print(soygo.Access("$user", pUser, soygo.Step{Key: "name"}), true)
+			s.Write(".")
+		default:
+			s.Print(pVisits, true)
+			s.Write(" visits by ")
+			s.Print(soygo.Access("$user", pUser, soygo.Step{Key: "name"}), true)
+			s.Write(".")
+		}
+	}
+	s.Print(func() data.Value {
+		if v := soygo.Access("$ij", s.Injected(), soygo.Step{Key: "locale"}); !soygo.IsNull(v) {
+			return v
+		}
+		return data.String("en")
+	}(), true)
+}
+
+func renderExampleVisits(s *soygo.State, pN data.Value) {
+	defer soygo.Annotate("example.visits")
+	s.Write("<b>")
+	s.Print(pN, false)
+	s.Write(" visits</b>")
+	s.Print(s.Func("length", data.List{data.Int(1), data.Int(2), data.Int(3)}), false)
+	s.Write(" ")
+	s.Print(s.Func("round", data.Float(2.5)), false)
+	s.Write(" ")
+	s.Print(soygo.Add(soygo.Add(data.String("a"), data.Int(1)), data.Int(2)), false)
+	s.Write(" ")
+	s.Print(soygo.Add(soygo.Add(data.Int(1), data.Int(2)), data.String("a")), false)
+}
+
+// ExampleLegacyParams are the params of the example.legacy template.
+type ExampleLegacyParams struct {
+	Title interface{}
+	Url   interface{}
+}
+
+// ExampleLegacy renders the example.legacy template.
+func ExampleLegacy(wr io.Writer, p ExampleLegacyParams, opts *soygo.Options) error {
+	return soygo.Render(wr, opts, func(s *soygo.State) {
+		renderExampleLegacy(s, soygo.New(p.Title), soygo.New(p.Url))
+	})
+}
+
+func renderExampleLegacy(s *soygo.State, pTitle, pUrl data.Value) {
+	defer soygo.Annotate("example.legacy")
+	defer s.EnterContext(data.KindUnspecified)()
+	s.Write("<a href=\"")
+	s.PrintInContext(pUrl)
+	s.Write("\" onclick=\"alert(")
+	s.PrintInContext(pTitle)
+	s.Write(")\">")
+	s.PrintInContext(pTitle)
+	s.Write("</a><span ")
+	s.WriteInContext(data.NewSanitizedContent(s.CaptureContent(data.KindAttributes, func() {
+		renderExampleStrictAttrs(s, pTitle)
+	}), data.KindAttributes))
+	s.Write("></span>")
+}
+
+// ExampleStrictPageParams are the params of the example.strict.page template.
+type ExampleStrictPageParams struct {
+	Title   string
+	Url     string
+	Color   *string
+	Variant *string
+}
+
+// ExampleStrictPage renders the example.strict.page template.
+func ExampleStrictPage(wr io.Writer, p ExampleStrictPageParams, opts *soygo.Options) error {
+	return soygo.Render(wr, opts, func(s *soygo.State) {
+		renderExampleStrictPage(s, data.String(p.Title), data.String(p.Url), valueOfPtrToString(p.Color), valueOfPtrToString(p.Variant))
+	})
+}
+
+func renderExampleStrictPage(s *soygo.State, pTitle, pUrl, pColor, pVariant data.Value) {
+	defer soygo.Annotate("example.strict.page")
+	defer s.EnterContext(data.KindUnspecified)()
+	var attrs1 = data.NewSanitizedContent(s.CaptureContent(data.KindAttributes, func() {
+		s.Write("title=\"")
+		s.PrintInContext(pTitle)
+		s.Write("\"")
+		if pColor.Truthy() {
+			s.Write(" style=\"color: ")
+			s.PrintInContext(pColor)
+			s.Write("\"")
+		}
+	}), data.KindAttributes)
+	s.Write("<a href=\"")
+	s.PrintInContext(pUrl)
+	s.Write("\" ")
+	s.PrintInContext(attrs1)
+	s.Write(">")
+	s.PrintInContext(pTitle)
+	s.Write("</a><script>var title = ")
+	s.PrintInContext(pTitle)
+	s.Write(";</script>")
+	s.WriteInContext(data.NewSanitizedContent(s.CaptureContent(data.KindHTML, func() {
+		renderExampleStrictLink(s, pUrl, data.NewSanitizedContent(s.Capture(func() {
+			s.Print(pTitle, false)
+			s.Write("!")
+		}), data.KindText))
+	}), data.KindHTML))
+	s.Write("<div ")
+	s.WriteInContext(data.NewSanitizedContent(s.CaptureContent(data.KindAttributes, func() {
+		renderExampleStrictAttrs(s, pTitle)
+	}), data.KindAttributes))
+	s.Write("></div>")
+	switch s.Delegate("example.strict.badge", func() data.Value {
+		if v := pVariant; !soygo.IsNull(v) {
+			return v
+		}
+		return data.String("")
+	}(), delegatesExampleStrictBadge, false) {
+	case 0:
+		s.WriteInContext(data.NewSanitizedContent(s.CaptureContent(data.KindHTML, func() {
+			renderDelExampleStrictBadge0(s, pTitle)
+		}), data.KindHTML))
+	case 1:
+		s.WriteInContext(data.NewSanitizedContent(s.CaptureContent(data.KindHTML, func() {
+			renderDelExampleStrictBadge1(s, pTitle)
+		}), data.KindHTML))
+	case 2:
+		s.WriteInContext(data.NewSanitizedContent(s.CaptureContent(data.KindHTML, func() {
+			renderDelExampleStrictBadge2(s, pTitle)
+		}), data.KindHTML))
+	}
+	s.Delegate("example.strict.missing", nil, delegatesExampleStrictMissing, true)
+	renderExampleLegacy(s, pTitle, pUrl)
+}
+
+func renderExampleStrictLink(s *soygo.State, pUrl, pText data.Value) {
+	defer soygo.Annotate("example.strict.link")
+	defer s.EnterContext(data.KindUnspecified)()
+	s.Write("<a href=\"")
+	s.PrintInContext(pUrl)
+	s.Write("\">")
+	s.PrintInContext(pText)
+	s.Write("</a>")
+}
+
+// ExampleStrictAttrsParams are the params of the example.strict.attrs template.
+type ExampleStrictAttrsParams struct {
+	Title string
+}
+
+// ExampleStrictAttrs renders the example.strict.attrs template.
+func ExampleStrictAttrs(wr io.Writer, p ExampleStrictAttrsParams, opts *soygo.Options) error {
+	return soygo.Render(wr, opts, func(s *soygo.State) {
+		renderExampleStrictAttrs(s, data.String(p.Title))
+	})
+}
+
+func renderExampleStrictAttrs(s *soygo.State, pTitle data.Value) {
+	defer soygo.Annotate("example.strict.attrs")
+	defer s.EnterContext(data.KindAttributes)()
+	s.Write("data-title=\"")
+	s.PrintInContext(pTitle)
+	s.Write("\"")
+}
+
+func renderDelExampleStrictBadge0(s *soygo.State, pTitle data.Value) {
+	defer soygo.Annotate("example.strict.__deltemplate____example_2e_strict_2e_badge__")
+	defer s.EnterContext(data.KindUnspecified)()
+	s.Write("<b>")
+	s.PrintInContext(pTitle)
+	s.Write("</b>")
+}
+
+func renderDelExampleStrictBadge1(s *soygo.State, pTitle data.Value) {
+	defer soygo.Annotate("example.strict.__deltemplate____example_2e_strict_2e_badge__big")
+	defer s.EnterContext(data.KindUnspecified)()
+	s.Write("<h1>")
+	s.PrintInContext(pTitle)
+	s.Write("</h1>")
+}
+
+func renderDelExampleStrictBadge2(s *soygo.State, pTitle data.Value) {
+	defer soygo.Annotate("example.fancy.__deltemplate__fancy__example_2e_strict_2e_badge__")
+	defer s.EnterContext(data.KindUnspecified)()
+	s.Write("<i title=\"")
+	s.PrintInContext(pTitle)
+	s.Write("\">")
+	s.PrintInContext(pTitle)
+	s.Write("</i>")
+}
+
+// ExampleProfileUser is a record type used by the example.profile template.
+type ExampleProfileUser struct {
+	Address *ExampleProfileUserAddress
+	Age     int64
+	Name    string
+	Tags    []string
+}
+
+// ExampleProfileUserAddress is a record type used by the example.profile template.
+type ExampleProfileUserAddress struct {
+	City string
+}
+
+var delegatesExampleStrictBadge = []soygo.DelegateImpl{
+	{Variant: "", Package: ""},
+	{Variant: "big", Package: ""},
+	{Variant: "", Package: "fancy"},
+}
+
+var delegatesExampleStrictMissing = []soygo.DelegateImpl{}
+
+func valueOfExampleProfileUser(v ExampleProfileUser) data.Value {
+	return data.Map{
+		"address": valueOfPtrToExampleProfileUserAddress(v.Address),
+		"age":     data.Int(v.Age),
+		"name":    data.String(v.Name),
+		"tags":    valueOfListOfString(v.Tags),
+	}
+}
+
+func valueOfExampleProfileUserAddress(v ExampleProfileUserAddress) data.Value {
+	return data.Map{
+		"city": data.String(v.City),
+	}
+}
+
+func valueOfListOfString(v []string) data.Value {
+	var list = make(data.List, len(v))
+	for i, elem := range v {
+		list[i] = data.String(elem)
+	}
+	return list
+}
+
+func valueOfMapOfFloat64(v map[string]float64) data.Value {
+	var m = make(data.Map, len(v))
+	for k, elem := range v {
+		m[k] = data.Float(elem)
+	}
+	return m
+}
+
+func valueOfNullableMapOfFloat64(v map[string]float64) data.Value {
+	if v == nil {
+		return data.Undefined{}
+	}
+	return valueOfMapOfFloat64(v)
+}
+
+func valueOfPtrToExampleProfileUserAddress(v *ExampleProfileUserAddress) data.Value {
+	if v == nil {
+		return data.Undefined{}
+	}
+	return valueOfExampleProfileUserAddress(*v)
+}
+
+func valueOfPtrToInt64(v *int64) data.Value {
+	if v == nil {
+		return data.Undefined{}
+	}
+	return data.Int(*v)
+}
+
+func valueOfPtrToString(v *string) data.Value {
+	if v == nil {
+		return data.Undefined{}
+	}
+	return data.String(*v)
+}
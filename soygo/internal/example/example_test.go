@@ -0,0 +1,237 @@
+package example
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/robfig/soy"
+	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/soygo"
+	"github.com/robfig/soy/soyhtml"
+	"github.com/robfig/soy/soymsg"
+)
+
+type exampleTest struct {
+	name   string   // template name
+	data   data.Map // data for soyhtml
+	render func(io.Writer, *soygo.Options) error
+}
+
+func TestExample(t *testing.T) {
+	var visits = int64(3)
+	var tests = []exampleTest{
+		{"example.helloName", data.Map{"name": data.String("Rob")},
+			func(wr io.Writer, opts *soygo.Options) error {
+				return ExampleHelloName(wr, ExampleHelloNameParams{Name: "Rob"}, opts)
+			}},
+		{"example.helloName", data.Map{"name": data.String("<b>Rob</b>"), "greetingWord": data.String("Hi")},
+			func(wr io.Writer, opts *soygo.Options) error {
+				return ExampleHelloName(wr, ExampleHelloNameParams{Name: "<b>Rob</b>", GreetingWord: "Hi"}, opts)
+			}},
+		{"example.helloNames", data.Map{"name": data.String("Rob"), "additionalNames": data.List{}},
+			func(wr io.Writer, opts *soygo.Options) error {
+				return ExampleHelloNames(wr, ExampleHelloNamesParams{Name: "Rob", AdditionalNames: []string{}}, opts)
+			}},
+		{"example.helloNames", data.New(map[string]interface{}{
+			"name":            "Rob",
+			"additionalNames": []string{"Alice", "Bob", "Carol"},
+		}).(data.Map),
+			func(wr io.Writer, opts *soygo.Options) error {
+				return ExampleHelloNames(wr, ExampleHelloNamesParams{
+					Name:            "Rob",
+					AdditionalNames: []string{"Alice", "Bob", "Carol"},
+				}, opts)
+			}},
+		{"example.profile", data.New(map[string]interface{}{
+			"user": map[string]interface{}{
+				"name": "Rob & co",
+				"age":  35,
+				"tags": []string{"go", "soy templates"},
+			},
+			"note": data.SanitizedHTML("<i>quiet</i>"),
+		}).(data.Map),
+			func(wr io.Writer, opts *soygo.Options) error {
+				return ExampleProfile(wr, ExampleProfileParams{
+					User: ExampleProfileUser{Name: "Rob & co", Age: 35, Tags: []string{"go", "soy templates"}},
+					Note: data.SanitizedHTML("<i>quiet</i>"),
+				}, opts)
+			}},
+		{"example.profile", data.New(map[string]interface{}{
+			"user": map[string]interface{}{
+				"name":    "Rob",
+				"age":     7,
+				"tags":    []string{},
+				"address": map[string]interface{}{"city": "<NYC>"},
+			},
+			"visits": 3,
+		}).(data.Map),
+			func(wr io.Writer, opts *soygo.Options) error {
+				return ExampleProfile(wr, ExampleProfileParams{
+					User: ExampleProfileUser{
+						Name:    "Rob",
+						Age:     7,
+						Address: &ExampleProfileUserAddress{City: "<NYC>"},
+					},
+					Visits: &visits,
+				}, opts)
+			}},
+		{"example.profile", data.New(map[string]interface{}{
+			"user":   map[string]interface{}{"name": "Rob", "age": 1, "tags": []string{"a"}},
+			"visits": 3,
+			"scores": map[string]float64{"math": 1.5},
+		}).(data.Map),
+			func(wr io.Writer, opts *soygo.Options) error {
+				return ExampleProfile(wr, ExampleProfileParams{
+					User:   ExampleProfileUser{Name: "Rob", Age: 1, Tags: []string{"a"}},
+					Visits: &visits,
+					Scores: map[string]float64{"math": 1.5},
+				}, opts)
+			}},
+	}
+
+	var tofu, err = exampleBundle().CompileToTofu()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ij = data.Map{"locale": data.String("fr")}
+	for _, msgs := range []soymsg.Bundle{nil, fakeBundle{}} {
+		for _, test := range tests {
+			var expected, actual bytes.Buffer
+			err = tofu.NewRenderer(test.name).Inject(ij).WithMessages(msgs).Execute(&expected, test.data)
+			if err != nil {
+				t.Errorf("%s: soyhtml: %v", test.name, err)
+				continue
+			}
+			err = test.render(&actual, &soygo.Options{Inject: ij, Messages: msgs})
+			if err != nil {
+				t.Errorf("%s: soygo: %v", test.name, err)
+				continue
+			}
+			if actual.String() != expected.String() {
+				t.Errorf("%s: expected\n%s\ngot\n%s", test.name, expected.String(), actual.String())
+			}
+		}
+	}
+}
+
+// TestStrictExample checks that contextually and strictly autoescaped
+// templates, content kinds and delegates render the same as soyhtml.
+func TestStrictExample(t *testing.T) {
+	var tofu, err = exampleBundle().CompileToTofu()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		params  ExampleStrictPageParams
+		delPkgs []string
+	}{
+		{ExampleStrictPageParams{Title: "Rob's <page>", Url: "/a b?c=d&e"}, nil},
+		{ExampleStrictPageParams{Title: "x", Url: "javascript:alert(1)", Color: strPtr("red;}")}, nil},
+		{ExampleStrictPageParams{Title: "\"q\"", Url: "http://x.com/", Variant: strPtr("big")}, nil},
+		{ExampleStrictPageParams{Title: "y", Url: "/", Variant: strPtr("other")}, []string{"fancy"}},
+	}
+	for _, test := range tests {
+		var m = data.Map{"title": data.String(test.params.Title), "url": data.String(test.params.Url)}
+		if test.params.Color != nil {
+			m["color"] = data.String(*test.params.Color)
+		}
+		if test.params.Variant != nil {
+			m["variant"] = data.String(*test.params.Variant)
+		}
+		var expected, actual bytes.Buffer
+		err = tofu.NewRenderer("example.strict.page").WithDelegatePackages(test.delPkgs...).Execute(&expected, m)
+		if err != nil {
+			t.Errorf("%v: soyhtml: %v", m, err)
+			continue
+		}
+		err = ExampleStrictPage(&actual, test.params, &soygo.Options{DelPackages: test.delPkgs})
+		if err != nil {
+			t.Errorf("%v: soygo: %v", m, err)
+			continue
+		}
+		if actual.String() != expected.String() {
+			t.Errorf("%v: expected\n%s\ngot\n%s", m, expected.String(), actual.String())
+		}
+	}
+}
+
+// TestExampleConfig checks that the functions, print directives and logger are
+// those of the given config.
+func TestExampleConfig(t *testing.T) {
+	var config = soygo.NewConfig()
+	var logged bytes.Buffer
+	config.Logger = log.New(&logged, "", 0)
+	config.ObligatoryPrintDirectives = []string{"shout"}
+	config.PrintDirectives["shout"] = soyhtml.PrintDirective{
+		Apply: func(value data.Value, _ []data.Value) data.Value {
+			return data.String(strings.ToUpper(value.String()))
+		},
+		ValidArgLengths: []int{0},
+	}
+	var buf bytes.Buffer
+	var err = ExampleHelloName(&buf, ExampleHelloNameParams{Name: "Rob"}, &soygo.Options{Config: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Hello ROB!") {
+		t.Errorf("expected the obligatory directive to apply, got %q", buf.String())
+	}
+
+	delete(config.Funcs, "length")
+	err = ExampleProfile(&buf, ExampleProfileParams{Visits: &[]int64{5}[0]},
+		&soygo.Options{Config: config, Inject: data.Map{}})
+	if err == nil || !strings.Contains(err.Error(), "unrecognized function name: length") {
+		t.Errorf("expected an error for the missing function, got %v", err)
+	}
+}
+
+func exampleBundle() *soy.Bundle {
+	return soy.NewBundle().
+		AddTemplateFile("example.soy").
+		AddTemplateFile("strict.soy").
+		AddTemplateFile("fancy.soy")
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestExampleErrors(t *testing.T) {
+	var err = ExampleHelloName(&bytes.Buffer{}, ExampleHelloNameParams{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "example.helloName") {
+		t.Errorf("expected an error for the undefined name, got %v", err)
+	}
+
+	err = ExampleProfile(&bytes.Buffer{}, ExampleProfileParams{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "Injected data") {
+		t.Errorf("expected an error for the missing injected data, got %v", err)
+	}
+}
+
+// fakeBundle translates every message into a plural message that refers to
+// the $user.name placeholder.
+type fakeBundle struct{}
+
+func (fakeBundle) Locale() string { return "xx" }
+
+func (fakeBundle) Message(id uint64) *soymsg.Message {
+	return &soymsg.Message{ID: id, Parts: []soymsg.Part{
+		soymsg.RawTextPart{Text: "["},
+		soymsg.PluralPart{VarName: "VISITS_1", Cases: []soymsg.PluralCase{
+			{Parts: []soymsg.Part{soymsg.PlaceholderPart{Name: "NAME"}, soymsg.RawTextPart{Text: " once"}}},
+			{Parts: []soymsg.Part{soymsg.PlaceholderPart{Name: "NAME"}, soymsg.RawTextPart{Text: " x "},
+				soymsg.PlaceholderPart{Name: "VISITS_2"}}},
+		}},
+		soymsg.RawTextPart{Text: "]"},
+	}}
+}
+
+func (fakeBundle) PluralCase(n int) int {
+	if n == 1 {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,618 @@
+package soygo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/soyhtml"
+	"github.com/robfig/soy/soymsg"
+)
+
+// Options are the optional inputs to a generated render function.
+type Options struct {
+	Inject      data.Map      // data available to all templates as $ij
+	Messages    soymsg.Bundle // replacement text for {msg} tags
+	DelPackages []string      // the active delegate packages
+	Config      *Config       // the functions, print directives and logger; see Config
+}
+
+// Config holds the functions, print directives and logger used by generated
+// code, as a soyhtml.Tofu does for rendering.  It may be shared by renders,
+// but must not be changed while they are in progress.
+//
+// The print directives given to the generator, which decide whether a print
+// is autoescaped, must be the same as those given to the render functions.
+type Config struct {
+	Funcs                     map[string]soyhtml.Func
+	PrintDirectives           map[string]soyhtml.PrintDirective
+	ObligatoryPrintDirectives []string    // names of the directives applied to every print
+	Logger                    *log.Logger // receives the output of {log} commands, if not nil
+}
+
+// NewConfig returns a config with a copy of the default functions, print
+// directives and logger of soyhtml.
+func NewConfig() *Config {
+	var c = &Config{
+		Funcs:                     make(map[string]soyhtml.Func, len(soyhtml.Funcs)),
+		PrintDirectives:           make(map[string]soyhtml.PrintDirective, len(soyhtml.PrintDirectives)),
+		ObligatoryPrintDirectives: append([]string(nil), soyhtml.ObligatoryPrintDirectiveNames...),
+		Logger:                    soyhtml.Logger,
+	}
+	for name, fn := range soyhtml.Funcs {
+		c.Funcs[name] = fn
+	}
+	for name, directive := range soyhtml.PrintDirectives {
+		c.PrintDirectives[name] = directive
+	}
+	return c
+}
+
+var (
+	defaultConfig     *Config
+	defaultConfigOnce sync.Once
+)
+
+// getDefaultConfig returns the config used if none is given in the Options,
+// which is made by NewConfig the first time it is needed.
+func getDefaultConfig() *Config {
+	defaultConfigOnce.Do(func() { defaultConfig = NewConfig() })
+	return defaultConfig
+}
+
+// State represents the state of an execution.  It is used by generated code,
+// and is not intended to be used directly.
+type State struct {
+	wr      io.Writer
+	ij      data.Map
+	msgs    soymsg.Bundle
+	delPkgs []string
+	config  *Config
+}
+
+// Render runs the given generated template function against the given writer,
+// returning the first error encountered.
+func Render(wr io.Writer, opts *Options, fn func(s *State)) (err error) {
+	var s = &State{wr: wr}
+	if opts != nil {
+		s.ij, s.msgs, s.delPkgs, s.config = opts.Inject, opts.Messages, opts.DelPackages, opts.Config
+	}
+	if s.config == nil {
+		s.config = getDefaultConfig()
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			switch e := e.(type) {
+			case runtime.Error:
+				err = fmt.Errorf("%v\n%v", e, string(debug.Stack()))
+			case error:
+				err = e
+			default:
+				err = fmt.Errorf("%v", e)
+			}
+		}
+	}()
+	fn(s)
+	return nil
+}
+
+// Annotate is deferred by each generated template function to prefix any
+// error raised while rendering it with the template name.
+func Annotate(name string) {
+	if e := recover(); e != nil {
+		if _, ok := e.(runtime.Error); ok {
+			e = fmt.Sprintf("%v\n%v", e, string(debug.Stack()))
+		}
+		panic(fmt.Errorf("template %s: %v", name, e))
+	}
+}
+
+// Write writes the given raw text.
+func (s *State) Write(text string) {
+	if _, err := io.WriteString(s.wr, text); err != nil {
+		panic(err)
+	}
+}
+
+// Print writes the given value after applying the obligatory print directives.
+// If escape is true, the value is HTML-escaped unless it is sanitized HTML.
+func (s *State) Print(val data.Value, escape bool) {
+	val, escape = s.obligatory(val, escape)
+	if content, ok := val.(data.SanitizedContent); escape && !(ok && content.Kind() == data.KindHTML) {
+		s.Write(htmlEscaper.Replace(val.String()))
+		return
+	}
+	s.Write(val.String())
+}
+
+// PrintInContext writes the given value after applying the obligatory print
+// directives, escaped for the context of the output, unless a directive
+// cancels autoescaping.  It is used by contextually autoescaped templates.
+func (s *State) PrintInContext(val data.Value) {
+	var escape bool
+	if val, escape = s.obligatory(val, true); !escape {
+		s.Write(val.String())
+		return
+	}
+	s.WriteInContext(val)
+}
+
+// WriteInContext writes the given value escaped for the context of the
+// output, e.g. the output of a strict template called by a contextually
+// autoescaped one.
+func (s *State) WriteInContext(val data.Value) {
+	var cw, ok = s.wr.(*soyhtml.ContextWriter)
+	if !ok {
+		panic("contextual autoescaping requires the output to be tracked by EnterContext")
+	}
+	var str, err = cw.Escape(val)
+	if err != nil {
+		panic(fmt.Sprintf("%q may not be printed: %v", val, err))
+	}
+	s.Write(str)
+}
+
+// obligatory applies the obligatory print directives to the given value,
+// returning the result and whether it should still be escaped.
+func (s *State) obligatory(val data.Value, escape bool) (data.Value, bool) {
+	if _, ok := val.(data.Undefined); ok {
+		panic("In 'print' tag, value is undefined.")
+	}
+	for _, name := range s.config.ObligatoryPrintDirectives {
+		val = s.Directive(name, val)
+		if s.config.PrintDirectives[name].CancelAutoescape {
+			escape = false
+		}
+	}
+	return val, escape
+}
+
+var htmlEscaper = strings.NewReplacer(
+	`"`, "&#34;",
+	"'", "&#39;",
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+)
+
+// Capture returns the output written by the given function, rather than
+// writing it to the output.
+func (s *State) Capture(fn func()) string {
+	var buf bytes.Buffer
+	var orig = s.wr
+	s.wr = &buf
+	fn()
+	s.wr = orig
+	return buf.String()
+}
+
+// CaptureContent is like Capture, but the context of the output is tracked
+// for contextual autoescaping, starting at the beginning of content of the
+// given kind.
+func (s *State) CaptureContent(kind data.ContentKind, fn func()) string {
+	var buf bytes.Buffer
+	var orig = s.wr
+	s.wr = soyhtml.NewContextWriter(&buf, kind)
+	fn()
+	s.wr = orig
+	return buf.String()
+}
+
+// EnterContext begins tracking the context of the output for a contextually
+// autoescaped template of the given kind, unless it is already tracked.  It
+// returns a function that restores the previous output.
+func (s *State) EnterContext(kind data.ContentKind) func() {
+	var orig = s.wr
+	if _, ok := orig.(*soyhtml.ContextWriter); !ok {
+		s.wr = soyhtml.NewContextWriter(orig, kind)
+	}
+	return func() { s.wr = orig }
+}
+
+// Log writes the output of the given function to the configured logger.
+func (s *State) Log(output string) {
+	if s.config.Logger != nil {
+		s.config.Logger.Print(output)
+	}
+}
+
+// Injected returns the data provided as $ij.
+func (s *State) Injected() data.Value {
+	if s.ij == nil {
+		panic("Injected data not provided, yet referenced")
+	}
+	return s.ij
+}
+
+// Message returns the message with the given ID from the bundle, or nil if no
+// bundle was provided or it does not contain the message.
+func (s *State) Message(id uint64) *soymsg.Message {
+	if s.msgs == nil {
+		return nil
+	}
+	return s.msgs.Message(id)
+}
+
+// RenderMsg renders the given parts of a translated message.  Placeholders are
+// rendered by calling placeholder with their name, and plural variables are
+// evaluated by calling plural with their name.
+func (s *State) RenderMsg(parts []soymsg.Part, placeholder func(name string) bool, plural func(name string) data.Value) {
+	for _, part := range parts {
+		switch part := part.(type) {
+		case soymsg.RawTextPart:
+			s.Write(part.Text)
+		case soymsg.PlaceholderPart:
+			if placeholder == nil || !placeholder(part.Name) {
+				panic(fmt.Sprintf("failed to find placeholder %q", part.Name))
+			}
+		case soymsg.PluralPart:
+			var val data.Value
+			if plural != nil {
+				val = plural(part.VarName)
+			}
+			if val == nil {
+				panic(fmt.Sprintf("failed to find plural variable %q", part.VarName))
+			}
+			var idx = s.msgs.PluralCase(PluralValue(val))
+			if idx >= len(part.Cases) {
+				panic(fmt.Sprintf("plural case index out of bounds (n=%v, len(cases)=%v)",
+					idx, len(part.Cases)))
+			}
+			s.RenderMsg(part.Cases[idx].Parts, placeholder, plural)
+		}
+	}
+}
+
+// PluralValue returns the given plural argument as an int.
+func PluralValue(val data.Value) int {
+	var n, ok = val.(data.Int)
+	if !ok {
+		panic(fmt.Sprintf("plural argument must be integer, got %T", val))
+	}
+	return int(n)
+}
+
+// Func calls the named function from the configured functions with the given
+// args.
+func (s *State) Func(name string, args ...data.Value) data.Value {
+	var fn, ok = s.config.Funcs[name]
+	if !ok {
+		panic(fmt.Sprintf("unrecognized function name: %s", name))
+	}
+	if !checkNumArgs(fn.ValidArgLengths, len(args)) {
+		panic(fmt.Sprintf("Function %q called with %v args, expected: %v",
+			name, len(args), fn.ValidArgLengths))
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			panic(fmt.Sprintf("panic in %s(%v): %v\n%v", name, args, err, string(debug.Stack())))
+		}
+	}()
+	var r = fn.Apply(args)
+	if r == nil {
+		return data.Null{}
+	}
+	return r
+}
+
+// Directive applies the named print directive from the configured print
+// directives to the given value.
+func (s *State) Directive(name string, val data.Value, args ...data.Value) data.Value {
+	var directive, ok = s.config.PrintDirectives[name]
+	if !ok {
+		panic(fmt.Sprintf("Print directive %q does not exist", name))
+	}
+	if _, ok := val.(data.Undefined); ok {
+		panic("In 'print' tag, value is undefined.")
+	}
+	if !checkNumArgs(directive.ValidArgLengths, len(args)) {
+		panic(fmt.Sprintf("Print directive %q called with %v args, expected one of: %v",
+			name, len(args), directive.ValidArgLengths))
+	}
+	defer func() {
+		switch err := recover().(type) {
+		case nil:
+		case runtime.Error:
+			panic(fmt.Sprintf("panic in |%s: %v\nexecuted: %v(%q, %v)\n%v",
+				name, err, name, val, args, string(debug.Stack())))
+		default:
+			panic(fmt.Sprintf("|%s: %v", name, err))
+		}
+	}()
+	return directive.Apply(val, args)
+}
+
+// DelegateImpl describes an implementation of a delegate template.
+type DelegateImpl struct {
+	Variant string // the variant implemented, or "" for the default
+	Package string // the {delpackage} containing it, or "" if none
+}
+
+// Delegate returns the index of the implementation of the named delegate to
+// render for the given variant, among the given implementations, as
+// template.Registry.Delegate chooses it for the active delegate packages.  If
+// there is none, it returns -1 if allowEmptyDefault is set, or else panics.
+func (s *State) Delegate(name string, variant data.Value, impls []DelegateImpl, allowEmptyDefault bool) int {
+	var variantStr string
+	switch variant.(type) {
+	case nil, data.Undefined, data.Null:
+	default:
+		variantStr = variant.String()
+	}
+	var i = s.delegate(name, variantStr, impls)
+	if i == -1 && variantStr != "" {
+		i = s.delegate(name, "", impls)
+	}
+	if i == -1 && !allowEmptyDefault {
+		panic(fmt.Sprintf("found no active implementation for delegate %s (variant %q)", name, variantStr))
+	}
+	return i
+}
+
+func (s *State) delegate(name, variant string, impls []DelegateImpl) int {
+	var result, priority = -1, -1
+	for i, impl := range impls {
+		if impl.Variant != variant {
+			continue
+		}
+		var p = 0
+		if impl.Package != "" {
+			if !contains(s.delPkgs, impl.Package) {
+				continue
+			}
+			p = 1
+		}
+		switch {
+		case p == priority:
+			panic(fmt.Sprintf("delegate %s (variant %q) is implemented in more than one active delpackage: %q, %q",
+				name, variant, impls[result].Package, impl.Package))
+		case p > priority:
+			result, priority = i, p
+		}
+	}
+	return result
+}
+
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+func checkNumArgs(allowedNumArgs []int, numArgs int) bool {
+	for _, length := range allowedNumArgs {
+		if numArgs == length {
+			return true
+		}
+	}
+	return false
+}
+
+// Step is a single access within a data reference, e.g. ".key" or "[0]".
+type Step struct {
+	Key      string     // the map key to access, if not empty
+	Index    int        // the list index to access, if Key is empty and Expr is nil
+	Expr     data.Value // the evaluated [expr] to access, if not nil
+	NullSafe bool       // if the access is null-safe, e.g. "?.key"
+}
+
+func (step Step) String() string {
+	var prefix = ""
+	if step.NullSafe {
+		prefix = "?"
+	}
+	switch {
+	case step.Expr != nil:
+		return prefix + "[" + step.Expr.String() + "]"
+	case step.Key != "":
+		return prefix + "." + step.Key
+	}
+	return prefix + "." + strconv.Itoa(step.Index)
+}
+
+// Access performs the given steps against the value of the variable of the
+// given name.
+func Access(name string, ref data.Value, steps ...Step) data.Value {
+	for i, step := range steps {
+		var (
+			index = -1
+			key   string
+		)
+		switch {
+		case step.Expr != nil:
+			if n, ok := step.Expr.(data.Int); ok {
+				index = int(n)
+			} else {
+				key = step.Expr.String()
+			}
+		case step.Key != "":
+			key = step.Key
+		default:
+			index = step.Index
+		}
+
+		switch obj := ref.(type) {
+		case data.Undefined, data.Null:
+			if step.NullSafe {
+				return data.Null{}
+			}
+			panic(fmt.Sprintf("%q is null or undefined", refString(name, steps[:i])))
+		case data.List:
+			if index == -1 {
+				panic(fmt.Sprintf("%q is a list, but was accessed with a non-integer index",
+					refString(name, steps[:i])))
+			}
+			ref = obj.Index(index)
+		case data.Map:
+			if key == "" {
+				panic(fmt.Sprintf("%q is a map, and requires a string key to access",
+					refString(name, steps[:i])))
+			}
+			ref = obj.Key(key)
+		default:
+			panic(fmt.Sprintf("While evaluating \"%v\", encountered non-collection"+
+				" just before accessing \"%v\".", refString(name, steps), step))
+		}
+	}
+	return ref
+}
+
+func refString(name string, steps []Step) string {
+	var str = name
+	for _, step := range steps {
+		str += step.String()
+	}
+	return str
+}
+
+// List returns the given value as a list, for use in a {foreach} loop.
+func List(val data.Value) data.List {
+	var list, ok = val.(data.List)
+	if !ok {
+		panic(fmt.Sprintf("%v does not resolve to a list", val))
+	}
+	return list
+}
+
+// Map returns the given value as a map, for use as the data of a {call}.
+func Map(val data.Value) data.Map {
+	var m, ok = val.(data.Map)
+	if !ok {
+		panic(fmt.Sprintf("call data %v does not resolve to a map", val))
+	}
+	return m
+}
+
+// New converts the given Go value into a Soy data value.  Unlike data.New, a
+// nil value is converted to Undefined, so that a param's default applies.
+func New(value interface{}) data.Value {
+	if value == nil {
+		return data.Undefined{}
+	}
+	return data.New(value)
+}
+
+// IsNull returns true if the given value is null or undefined.
+func IsNull(val data.Value) bool {
+	switch val.(type) {
+	case data.Null, data.Undefined:
+		return true
+	}
+	return false
+}
+
+// Arithmetic ----------
+
+func def(val data.Value) data.Value {
+	if _, ok := val.(data.Undefined); ok {
+		panic("value is undefined")
+	}
+	return val
+}
+
+func isInt(v data.Value) bool {
+	_, ok := v.(data.Int)
+	return ok
+}
+
+func isString(v data.Value) bool {
+	switch v.(type) {
+	case data.String, data.SanitizedContent:
+		return true
+	}
+	return false
+}
+
+func toFloat(v data.Value) float64 {
+	switch v := v.(type) {
+	case data.Int:
+		return float64(v)
+	case data.Float:
+		return float64(v)
+	case data.Undefined:
+		panic("not a number: undefined")
+	default:
+		panic(fmt.Sprintf("not a number: %v (%T)", v, v))
+	}
+}
+
+// Negate returns -arg.
+func Negate(arg data.Value) data.Value {
+	switch arg := def(arg).(type) {
+	case data.Int:
+		return data.Int(-arg)
+	case data.Float:
+		return data.Float(-arg)
+	}
+	panic(fmt.Sprintf("can not negate non-number: %q", arg.String()))
+}
+
+// Add returns arg1 + arg2, concatenating them if either is a string.
+func Add(arg1, arg2 data.Value) data.Value {
+	arg1, arg2 = def(arg1), def(arg2)
+	switch {
+	case isInt(arg1) && isInt(arg2):
+		return data.Int(arg1.(data.Int) + arg2.(data.Int))
+	case isString(arg1) || isString(arg2):
+		return data.String(arg1.String() + arg2.String())
+	}
+	return data.Float(toFloat(arg1) + toFloat(arg2))
+}
+
+// Sub returns arg1 - arg2.
+func Sub(arg1, arg2 data.Value) data.Value {
+	arg1, arg2 = def(arg1), def(arg2)
+	if isInt(arg1) && isInt(arg2) {
+		return data.Int(arg1.(data.Int) - arg2.(data.Int))
+	}
+	return data.Float(toFloat(arg1) - toFloat(arg2))
+}
+
+// Mul returns arg1 * arg2.
+func Mul(arg1, arg2 data.Value) data.Value {
+	arg1, arg2 = def(arg1), def(arg2)
+	if isInt(arg1) && isInt(arg2) {
+		return data.Int(arg1.(data.Int) * arg2.(data.Int))
+	}
+	return data.Float(toFloat(arg1) * toFloat(arg2))
+}
+
+// Div returns arg1 / arg2, which is always a float.
+func Div(arg1, arg2 data.Value) data.Value {
+	return data.Float(toFloat(def(arg1)) / toFloat(def(arg2)))
+}
+
+// Mod returns arg1 % arg2, which must both be ints.
+func Mod(arg1, arg2 data.Value) data.Value {
+	return data.Int(def(arg1).(data.Int) % def(arg2).(data.Int))
+}
+
+// Less returns arg1 < arg2.
+func Less(arg1, arg2 data.Value) data.Value {
+	return data.Bool(toFloat(def(arg1)) < toFloat(def(arg2)))
+}
+
+// LessEq returns arg1 <= arg2.
+func LessEq(arg1, arg2 data.Value) data.Value {
+	return data.Bool(toFloat(def(arg1)) <= toFloat(def(arg2)))
+}
+
+// Greater returns arg1 > arg2.
+func Greater(arg1, arg2 data.Value) data.Value {
+	return data.Bool(toFloat(def(arg1)) > toFloat(def(arg2)))
+}
+
+// GreaterEq returns arg1 >= arg2.
+func GreaterEq(arg1, arg2 data.Value) data.Value {
+	return data.Bool(toFloat(def(arg1)) >= toFloat(def(arg2)))
+}
@@ -0,0 +1,82 @@
+// soygo is a tool to generate Go render functions from Soy templates.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/robfig/soy"
+	"github.com/robfig/soy/soygo"
+)
+
+var (
+	pkg     = flag.String("pkg", "", "package name of the generated file")
+	output  = flag.String("o", "", "file to write the generated Go source to (default STDOUT)")
+	globals = flag.String("globals", "", "file of compile-time globals")
+)
+
+func usage() {
+	fmt.Fprint(os.Stderr, `soygo is a tool to generate Go render functions from Soy templates.
+
+Usage:
+
+	soygo -pkg PACKAGE [-o OUTPUT] [-globals FILE] [INPUTPATH]...
+
+INPUTPATH elements may be files or directories. Input directories will be
+recursively searched for *.soy files.
+
+Flags:
+
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *pkg == "" || flag.NArg() == 0 {
+		usage()
+		os.Exit(1)
+	}
+
+	var bundle = soy.NewBundle()
+	if *globals != "" {
+		bundle.AddGlobalsFile(*globals)
+	}
+	for _, src := range flag.Args() {
+		var info, err = os.Stat(src)
+		if err != nil {
+			exit(err)
+		}
+		if info.IsDir() {
+			bundle.AddTemplateDir(src)
+		} else {
+			bundle.AddTemplateFile(src)
+		}
+	}
+	var registry, err = bundle.Compile()
+	if err != nil {
+		exit(err)
+	}
+
+	var buf bytes.Buffer
+	if err = soygo.Write(&buf, registry, soygo.GenOptions{Package: *pkg}); err != nil {
+		exit(err)
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+	} else {
+		err = ioutil.WriteFile(*output, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		exit(err)
+	}
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,178 @@
+package soygo
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/types"
+)
+
+// goType describes the Go type used to pass values of a Soy type into a
+// generated template.
+type goType struct {
+	name string // the Go type, e.g. "[]string"
+	key  string // identifies the type in the names of helpers, e.g. "ListOfString"
+	conv string // format converting an expression of the type to a data.Value
+}
+
+var (
+	goBool      = goType{"bool", "Bool", "data.Bool(%s)"}
+	goInt       = goType{"int64", "Int64", "data.Int(%s)"}
+	goFloat     = goType{"float64", "Float64", "data.Float(%s)"}
+	goString    = goType{"string", "String", "data.String(%s)"}
+	goInterface = goType{"interface{}", "Interface", "soygo.New(%s)"}
+)
+
+// paramType returns the Soy type of the named param of the given template.
+// Params declared in SoyDoc, or with a type that can not be inferred from their
+// default, have an unknown type.  Optional params are nullable, since they
+// may be omitted even if they have a default.
+func paramType(tmpl *ast.TemplateNode, name string, optional bool) types.Type {
+	var param = tmpl.Param(name)
+	if param == nil {
+		return types.Unknown
+	}
+	var typ = param.Type.Type
+	if typ == nil {
+		switch param.Default.(type) {
+		case *ast.BoolNode:
+			typ = types.Bool
+		case *ast.IntNode:
+			typ = types.Int
+		case *ast.FloatNode:
+			typ = types.Float
+		case *ast.StringNode:
+			typ = types.String
+		default:
+			typ = types.Unknown
+		}
+	}
+	if optional {
+		typ = types.NewUnion(typ, types.Null)
+	}
+	return typ
+}
+
+// goTypeOf returns the Go type for the given Soy type, declaring any struct
+// types and conversion helpers that it requires.  Record types are named
+// after the given name.
+//
+// A nil pointer, slice, map or interface is converted to undefined if the Soy
+// type is nullable, which allows any default value to apply.  Otherwise nil
+// slices and maps are converted to empty lists and maps.
+func (g *generator) goTypeOf(typ types.Type, name string) goType {
+	if types.Nullable(typ) {
+		return g.nullableType(types.NonNull(typ), name)
+	}
+	switch typ := typ.(type) {
+	case types.Primitive:
+		switch typ {
+		case types.Bool:
+			return goBool
+		case types.Int:
+			return goInt
+		case types.Float:
+			return goFloat
+		case types.String:
+			return goString
+		}
+	case types.List:
+		var elem = g.goTypeOf(typ.Elem, name+"Item")
+		var t = goType{"[]" + elem.name, "ListOf" + elem.key, ""}
+		return g.helper(t, "v []%[1]s", `var list = make(data.List, len(v))
+for i, elem := range v {
+	list[i] = `+fmt.Sprintf(elem.conv, "elem")+`
+}
+return list`, elem.name)
+	case types.Map:
+		var value = g.goTypeOf(typ.Value, name+"Value")
+		var t = goType{"map[string]" + value.name, "MapOf" + value.key, ""}
+		return g.helper(t, "v map[string]%[1]s", `var m = make(data.Map, len(v))
+for k, elem := range v {
+	m[k] = `+fmt.Sprintf(value.conv, "elem")+`
+}
+return m`, value.name)
+	case types.Record:
+		return g.recordType(typ, name)
+	}
+	return goInterface
+}
+
+// nullableType returns the Go type for the given Soy type or null.
+func (g *generator) nullableType(typ types.Type, name string) goType {
+	switch typ := typ.(type) {
+	case types.Primitive:
+		var elem goType
+		switch typ {
+		case types.Bool:
+			elem = goBool
+		case types.Int:
+			elem = goInt
+		case types.Float:
+			elem = goFloat
+		case types.String:
+			elem = goString
+		default:
+			return goInterface
+		}
+		var t = goType{"*" + elem.name, "PtrTo" + elem.key, ""}
+		return g.helper(t, "v *%[1]s", `if v == nil {
+	return data.Undefined{}
+}
+return `+fmt.Sprintf(elem.conv, "*v"), elem.name)
+	case types.List, types.Map:
+		var nonNull = g.goTypeOf(typ, name)
+		var t = goType{nonNull.name, "Nullable" + nonNull.key, ""}
+		return g.helper(t, "v %[1]s", `if v == nil {
+	return data.Undefined{}
+}
+return `+fmt.Sprintf(nonNull.conv, "v"), nonNull.name)
+	case types.Record:
+		var rec = g.recordType(typ, name)
+		var t = goType{"*" + rec.name, "PtrTo" + rec.key, ""}
+		return g.helper(t, "v *%[1]s", `if v == nil {
+	return data.Undefined{}
+}
+return `+fmt.Sprintf(rec.conv, "*v"), rec.name)
+	}
+	return goInterface
+}
+
+// recordType declares a struct type of the given name for the given record
+// type, along with a helper to convert it to a map.
+func (g *generator) recordType(typ types.Record, name string) goType {
+	var (
+		decl  bytes.Buffer
+		conv  bytes.Buffer
+		names = map[string]string{}
+	)
+	fmt.Fprintf(&decl, "type %s struct {\n", name)
+	for _, field := range typ.Fields {
+		var fieldName = exportedName(field.Name)
+		if other, ok := names[fieldName]; ok {
+			g.errorf("record fields %q and %q both map to Go field %s", other, field.Name, fieldName)
+		}
+		names[fieldName] = field.Name
+		var fieldType = g.goTypeOf(field.Type, name+fieldName)
+		fmt.Fprintf(&decl, "%s %s\n", fieldName, fieldType.name)
+		fmt.Fprintf(&conv, "%q: %s,\n", field.Name, fmt.Sprintf(fieldType.conv, "v."+fieldName))
+	}
+	decl.WriteString("}")
+	g.declare(name, fmt.Sprintf("// %s is a record type used by the %s template.\n%s",
+		name, g.tmpl.Node.Name, decl.String()))
+	return g.helper(goType{name, name, ""}, "v %[1]s", "return data.Map{\n"+conv.String()+"}", name)
+}
+
+// helper declares a function converting values of the given Go type to Soy
+// data values, unless it has already been declared.  It returns the type with
+// its conversion set to call the helper.
+func (g *generator) helper(t goType, param, body string, args ...interface{}) goType {
+	var fn = "valueOf" + t.key
+	t.conv = fn + "(%s)"
+	if _, ok := g.decls[fn]; ok {
+		return t
+	}
+	g.declare(fn, fmt.Sprintf("func %s(%s) data.Value {\n%s\n}", fn, fmt.Sprintf(param, args...), body))
+	return t
+}
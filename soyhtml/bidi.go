@@ -0,0 +1,60 @@
+package soyhtml
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The directionality of text is estimated in the same way as soyutils.js, so
+// that both backends agree.  Characters outside the Basic Multilingual Plane
+// are encoded as surrogate pairs in javascript, which are classed as LTR.
+const (
+	bidiLtrChars = `A-Za-z\x{00C0}-\x{00D6}\x{00D8}-\x{00F6}\x{00F8}-\x{02B8}\x{0300}-\x{0590}` +
+		`\x{0800}-\x{1FFF}\x{2C00}-\x{FB1C}\x{FDFE}-\x{FE6F}\x{FEFD}-\x{10FFFF}`
+	bidiNeutralChars = `\x{0000}-\x{0020}!-@\[-\x60{-\x{00BF}\x{00D7}\x{00F7}\x{02B9}-\x{02FF}\x{2000}-\x{2BFF}`
+	bidiRtlChars     = `\x{0591}-\x{07FF}\x{FB1D}-\x{FDFD}\x{FE70}-\x{FEFC}`
+
+	// bidiRtlDetectionThreshold is the ratio of RTL words above which text is
+	// considered to be RTL.
+	bidiRtlDetectionThreshold = 0.40
+)
+
+var (
+	bidiHTMLSkip     = regexp.MustCompile(`<[^>]*>|&[^;]+;`)
+	bidiRtlDirCheck  = regexp.MustCompile(`^[^` + bidiLtrChars + `]*[` + bidiRtlChars + `]`)
+	bidiNeutralCheck = regexp.MustCompile(`^[` + bidiNeutralChars + `]*$|^http://`)
+)
+
+// bidiTextDir returns the estimated directionality of the given text: 1 if it
+// is LTR, -1 if it is RTL, and 0 if it is empty.  If isHTML is true, the tags
+// and escapes within the text are ignored.
+func bidiTextDir(text string, isHTML bool) int {
+	if text == "" {
+		return 0
+	}
+	if isHTML {
+		text = bidiHTMLSkip.ReplaceAllString(text, " ")
+	}
+	if bidiRtlWordRatio(text) > bidiRtlDetectionThreshold {
+		return -1
+	}
+	return 1
+}
+
+// bidiRtlWordRatio returns the ratio of RTL words among all words with
+// directionality in the given text.
+func bidiRtlWordRatio(text string) float64 {
+	var rtlCount, totalCount int
+	for _, token := range strings.Split(text, " ") {
+		if bidiRtlDirCheck.MatchString(token) {
+			rtlCount++
+			totalCount++
+		} else if !bidiNeutralCheck.MatchString(token) {
+			totalCount++
+		}
+	}
+	if totalCount == 0 {
+		return 0
+	}
+	return float64(rtlCount) / float64(totalCount)
+}
@@ -0,0 +1,1003 @@
+package soyhtml
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+
+	"github.com/robfig/soy/data"
+)
+
+// context describes the state an HTML parser would be in after consuming the
+// output written so far.  It is used by the contextual autoescaper to choose
+// the appropriate escaping for each print command.
+//
+// The design follows the one used by Go's html/template and Closure
+// Templates: a small state machine that is advanced over each chunk of raw
+// output.
+type context struct {
+	state   ctxState
+	delim   ctxDelim
+	urlPart ctxURLPart
+	jsCtx   ctxJS
+	attr    ctxAttr
+	element ctxElement
+}
+
+// ctxState describes a high-level HTML parser state.
+type ctxState uint8
+
+const (
+	// stateText is parsed character data.
+	stateText ctxState = iota
+	// stateRCDATA is the body of an element that may not contain tags, such
+	// as <textarea> or <title>.
+	stateRCDATA
+	// stateTagName occurs in the name of an element, after the '<'.
+	stateTagName
+	// stateTag occurs inside a tag but not inside an attribute.
+	stateTag
+	// stateAttrName occurs inside an attribute name.
+	stateAttrName
+	// stateAfterName occurs after an attribute name has ended but before
+	// any equals sign.
+	stateAfterName
+	// stateBeforeValue occurs after the equals sign but before the value.
+	stateBeforeValue
+	// stateAttr occurs inside an HTML attribute whose content is text.
+	stateAttr
+	// stateURL occurs inside a URL-valued attribute.
+	stateURL
+	// stateJS occurs inside a <script> element or an event handler.
+	stateJS
+	// stateJSDqStr occurs inside a JavaScript double quoted string.
+	stateJSDqStr
+	// stateJSSqStr occurs inside a JavaScript single quoted string.
+	stateJSSqStr
+	// stateJSRegexp occurs inside a JavaScript regular expression literal.
+	stateJSRegexp
+	// stateJSBlockCmt occurs inside a JavaScript /* block comment */.
+	stateJSBlockCmt
+	// stateJSLineCmt occurs inside a JavaScript // line comment.
+	stateJSLineCmt
+	// stateCSS occurs inside a <style> element or style attribute.
+	stateCSS
+	// stateCSSDqStr occurs inside a CSS double quoted string.
+	stateCSSDqStr
+	// stateCSSSqStr occurs inside a CSS single quoted string.
+	stateCSSSqStr
+	// stateCSSDqURL occurs inside a CSS double quoted url("...").
+	stateCSSDqURL
+	// stateCSSSqURL occurs inside a CSS single quoted url('...').
+	stateCSSSqURL
+	// stateCSSURL occurs inside a CSS unquoted url(...).
+	stateCSSURL
+	// stateCSSBlockCmt occurs inside a CSS /* block comment */.
+	stateCSSBlockCmt
+	// stateCSSLineCmt occurs inside a CSS // line comment.
+	stateCSSLineCmt
+	// stateHTMLCmt occurs inside an <!-- HTML comment -->.
+	stateHTMLCmt
+	// stateError is an infectious error state.
+	stateError
+)
+
+var stateNames = [...]string{
+	stateText:        "text",
+	stateRCDATA:      "rcdata",
+	stateTagName:     "tag name",
+	stateTag:         "tag",
+	stateAttrName:    "attribute name",
+	stateAfterName:   "after attribute name",
+	stateBeforeValue: "before attribute value",
+	stateAttr:        "attribute value",
+	stateURL:         "url",
+	stateJS:          "javascript",
+	stateJSDqStr:     "javascript string",
+	stateJSSqStr:     "javascript string",
+	stateJSRegexp:    "javascript regexp",
+	stateJSBlockCmt:  "javascript comment",
+	stateJSLineCmt:   "javascript comment",
+	stateCSS:         "css",
+	stateCSSDqStr:    "css string",
+	stateCSSSqStr:    "css string",
+	stateCSSDqURL:    "css url",
+	stateCSSSqURL:    "css url",
+	stateCSSURL:      "css url",
+	stateCSSBlockCmt: "css comment",
+	stateCSSLineCmt:  "css comment",
+	stateHTMLCmt:     "html comment",
+	stateError:       "error",
+}
+
+func (s ctxState) String() string {
+	if int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return "unknown"
+}
+
+// ctxDelim is the delimiter that will end the current HTML attribute.
+type ctxDelim uint8
+
+const (
+	delimNone ctxDelim = iota
+	delimDoubleQuote
+	delimSingleQuote
+	delimSpaceOrTagEnd
+)
+
+// ctxURLPart identifies which part of a URL the output is in.
+type ctxURLPart uint8
+
+const (
+	// urlPartNone occurs at the start of a URL, before any output.
+	urlPartNone ctxURLPart = iota
+	// urlPartPreQuery occurs in the scheme, authority or path.
+	urlPartPreQuery
+	// urlPartQueryOrFrag occurs in the query or fragment.
+	urlPartQueryOrFrag
+)
+
+// ctxJS determines whether a '/' starts a regular expression literal or is a
+// division operator.
+type ctxJS uint8
+
+const (
+	jsCtxRegexp ctxJS = iota
+	jsCtxDivOp
+)
+
+// ctxAttr identifies the type of the attribute being written.
+type ctxAttr uint8
+
+const (
+	attrNone ctxAttr = iota
+	attrScript
+	attrStyle
+	attrURL
+)
+
+// ctxElement identifies elements whose content needs special handling.
+type ctxElement uint8
+
+const (
+	elementNone ctxElement = iota
+	elementScript
+	elementStyle
+	elementTextarea
+	elementTitle
+)
+
+// kindContext returns the context at the start of content of the given kind.
+func kindContext(kind data.ContentKind) context {
+	switch kind {
+	case data.KindAttributes:
+		return context{state: stateTag}
+	case data.KindJS:
+		return context{state: stateJS}
+	case data.KindCSS:
+		return context{state: stateCSS}
+	case data.KindURI:
+		return context{state: stateURL, attr: attrURL}
+	}
+	return context{state: stateText}
+}
+
+// escapers returns the names of the escapers to apply, in order, to values
+// printed in this context.  It returns nil if values may not be printed here.
+func (c context) escapers() []string {
+	switch c.state {
+	case stateText:
+		return []string{"escapeHtml"}
+	case stateRCDATA:
+		return []string{"escapeHtmlRcdata"}
+	case stateTagName:
+		return []string{"filterHtmlElementName"}
+	case stateTag, stateAttrName, stateAfterName:
+		return []string{"filterHtmlAttributes"}
+	case stateBeforeValue:
+		c = c.attrValue(delimSpaceOrTagEnd)
+	}
+
+	var names []string
+	switch c.state {
+	case stateAttr:
+		// The attribute escaper is added below.
+	case stateURL, stateCSSDqURL, stateCSSSqURL, stateCSSURL:
+		switch c.urlPart {
+		case urlPartNone:
+			names = []string{"filterNormalizeUri"}
+		case urlPartPreQuery:
+			names = []string{"normalizeUri"}
+		default:
+			names = []string{"escapeUri"}
+		}
+	case stateJS:
+		names = []string{"escapeJsValue"}
+	case stateJSDqStr, stateJSSqStr:
+		names = []string{"escapeJsString"}
+	case stateJSRegexp:
+		names = []string{"escapeJsRegex"}
+	case stateCSS:
+		names = []string{"filterCssValue"}
+	case stateCSSDqStr, stateCSSSqStr:
+		names = []string{"escapeCssString"}
+	default:
+		return nil
+	}
+
+	switch c.delim {
+	case delimSpaceOrTagEnd:
+		names = append(names, "escapeHtmlAttributeNospace")
+	case delimDoubleQuote, delimSingleQuote:
+		names = append(names, "escapeHtmlAttribute")
+	}
+	return names
+}
+
+// contextWriter is an io.Writer that tracks the context of everything that
+// passes through it.
+type contextWriter struct {
+	w   io.Writer
+	ctx context
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	cw.ctx = cw.ctx.after(p)
+	return cw.w.Write(p)
+}
+
+// ContextWriter is an io.Writer that tracks the context of its output, so that
+// values printed to it may be escaped as contextual autoescaping requires.  It
+// is used by the code generated by soygo.
+type ContextWriter struct {
+	contextWriter
+}
+
+// NewContextWriter returns a ContextWriter to w of content of the given kind.
+func NewContextWriter(w io.Writer, kind data.ContentKind) *ContextWriter {
+	return &ContextWriter{contextWriter{w: w, ctx: kindContext(kind)}}
+}
+
+// Escape returns the given value escaped for printing in the current context
+// of the output.  An error is returned if values may not be printed there.
+func (cw *ContextWriter) Escape(value data.Value) (string, error) {
+	var result, ok = cw.ctx.escape(value)
+	if !ok {
+		return "", fmt.Errorf("values may not be printed in %v context", cw.ctx.state)
+	}
+	return result, nil
+}
+
+// escape returns the given value escaped for printing in this context, and
+// false if values may not be printed here.
+func (c context) escape(value data.Value) (string, bool) {
+	var names = c.escapers()
+	if names == nil {
+		return "", false
+	}
+	for _, name := range names {
+		value = data.String(escapers[name](value))
+	}
+	return value.String(), true
+}
+
+// after returns the context resulting from writing s in context c.
+func (c context) after(s []byte) context {
+	for len(s) > 0 && c.state != stateError {
+		var n int
+		c, n = c.next(s)
+		s = s[n:]
+	}
+	return c
+}
+
+// next consumes a prefix of s, returning the resulting context and the number
+// of bytes consumed.
+func (c context) next(s []byte) (context, int) {
+	if c.delim == delimNone {
+		if c.element != elementNone && isElementContent(c.state) {
+			return tSpecialTagEnd(c, s)
+		}
+		return transitionFunc[c.state](c, s)
+	}
+
+	// Inside an attribute value, find the end of the value and run the
+	// transitions over its decoded content.
+	var i = indexAttrEnd(s, c.delim)
+	var value = s
+	if i != -1 {
+		value = s[:i]
+	}
+	var decoded = []byte(html.UnescapeString(string(value)))
+	for len(decoded) > 0 && c.state != stateError {
+		var n int
+		c, n = transitionFunc[c.state](c, decoded)
+		decoded = decoded[n:]
+	}
+	if i == -1 {
+		return c, len(s)
+	}
+	if c.delim != delimSpaceOrTagEnd {
+		i++ // consume the closing quote
+	}
+	return context{state: stateTag, element: c.element}, i
+}
+
+// indexAttrEnd returns the index of the byte that ends an attribute value with
+// the given delimiter, or -1 if the value does not end within s.
+func indexAttrEnd(s []byte, delim ctxDelim) int {
+	switch delim {
+	case delimDoubleQuote:
+		return bytes.IndexByte(s, '"')
+	case delimSingleQuote:
+		return bytes.IndexByte(s, '\'')
+	}
+	return bytes.IndexAny(s, " \t\n\f\r>")
+}
+
+func isElementContent(s ctxState) bool {
+	switch s {
+	case stateRCDATA, stateJS, stateJSDqStr, stateJSSqStr, stateJSRegexp,
+		stateJSBlockCmt, stateJSLineCmt, stateCSS, stateCSSDqStr, stateCSSSqStr,
+		stateCSSDqURL, stateCSSSqURL, stateCSSURL, stateCSSBlockCmt, stateCSSLineCmt:
+		return true
+	}
+	return false
+}
+
+var transitionFunc = [...]func(context, []byte) (context, int){
+	stateText:        tText,
+	stateRCDATA:      tRCDATA,
+	stateTagName:     tTagName,
+	stateTag:         tTag,
+	stateAttrName:    tAttrName,
+	stateAfterName:   tAfterName,
+	stateBeforeValue: tBeforeValue,
+	stateAttr:        tAttr,
+	stateURL:         tURL,
+	stateJS:          tJS,
+	stateJSDqStr:     tJSDelimited,
+	stateJSSqStr:     tJSDelimited,
+	stateJSRegexp:    tJSDelimited,
+	stateJSBlockCmt:  tBlockCmt,
+	stateJSLineCmt:   tLineCmt,
+	stateCSS:         tCSS,
+	stateCSSDqStr:    tCSSStr,
+	stateCSSSqStr:    tCSSStr,
+	stateCSSDqURL:    tCSSStr,
+	stateCSSSqURL:    tCSSStr,
+	stateCSSURL:      tCSSStr,
+	stateCSSBlockCmt: tBlockCmt,
+	stateCSSLineCmt:  tLineCmt,
+	stateHTMLCmt:     tHTMLCmt,
+	stateError:       tError,
+}
+
+// tText is the transition function for the text state.
+func tText(c context, s []byte) (context, int) {
+	var i = bytes.IndexByte(s, '<')
+	if i == -1 {
+		return c, len(s)
+	}
+	if bytes.HasPrefix(s[i:], []byte("<!--")) {
+		return context{state: stateHTMLCmt}, i + 4
+	}
+	var j, end = i + 1, false
+	if j < len(s) && s[j] == '/' {
+		j, end = j+1, true
+	}
+	if j == len(s) {
+		// The tag name may be provided by a following print.
+		return context{state: stateTagName}, j
+	}
+	if !isASCIIAlpha(s[j]) {
+		return c, j
+	}
+	var k = j
+	for k < len(s) && isTagNameChar(s[k]) {
+		k++
+	}
+	if k == len(s) {
+		// The rest of the tag name may be provided by a following print.
+		return context{state: stateTagName}, k
+	}
+	return context{state: stateTag}.withElement(s[j:k], end), k
+}
+
+// tRCDATA is the transition function for the body of <textarea> and <title>.
+// The element end tag is handled by tSpecialTagEnd.
+func tRCDATA(c context, s []byte) (context, int) {
+	return c, len(s)
+}
+
+// tTagName is the transition function for the name of an element.
+func tTagName(c context, s []byte) (context, int) {
+	var i = 0
+	for i < len(s) && isTagNameChar(s[i]) {
+		i++
+	}
+	if i == len(s) {
+		return c, i
+	}
+	c.state = stateTag
+	return c, i
+}
+
+// tTag is the transition function for the tag state.
+func tTag(c context, s []byte) (context, int) {
+	var i = eatWhiteSpace(s, 0)
+	if i == len(s) {
+		return c, i
+	}
+	switch s[i] {
+	case '>':
+		return context{state: elementContentState(c.element), element: c.element}, i + 1
+	case '/':
+		return c, i + 1
+	}
+	var j = i
+	for j < len(s) && isAttrNameChar(s[j]) {
+		j++
+	}
+	if j == i {
+		// Not a valid attribute name character; skip it.
+		return c, i + 1
+	}
+	c.attr = attrType(string(bytes.ToLower(s[i:j])))
+	if j == len(s) {
+		c.state = stateAttrName
+	} else {
+		c.state = stateAfterName
+	}
+	return c, j
+}
+
+// tAttrName is the transition function for the attribute name state.
+func tAttrName(c context, s []byte) (context, int) {
+	var i = 0
+	for i < len(s) && isAttrNameChar(s[i]) {
+		i++
+	}
+	if i < len(s) {
+		c.state = stateAfterName
+	}
+	return c, i
+}
+
+// tAfterName is the transition function for the after attribute name state.
+func tAfterName(c context, s []byte) (context, int) {
+	var i = eatWhiteSpace(s, 0)
+	if i == len(s) {
+		return c, i
+	}
+	if s[i] != '=' {
+		// The attribute had no value; reprocess the rest as part of the tag.
+		c.state, c.attr = stateTag, attrNone
+		return c, i
+	}
+	c.state = stateBeforeValue
+	return c, i + 1
+}
+
+// tBeforeValue is the transition function for the before value state.
+func tBeforeValue(c context, s []byte) (context, int) {
+	var i = eatWhiteSpace(s, 0)
+	if i == len(s) {
+		return c, i
+	}
+	var delim = delimSpaceOrTagEnd
+	switch s[i] {
+	case '"':
+		delim, i = delimDoubleQuote, i+1
+	case '\'':
+		delim, i = delimSingleQuote, i+1
+	}
+	return c.attrValue(delim), i
+}
+
+// attrValue returns the context at the start of the value of the current
+// attribute, delimited by the given delimiter.
+func (c context) attrValue(delim ctxDelim) context {
+	var state = stateAttr
+	switch c.attr {
+	case attrScript:
+		state = stateJS
+	case attrStyle:
+		state = stateCSS
+	case attrURL:
+		state = stateURL
+	}
+	return context{state: state, delim: delim, attr: c.attr, element: c.element}
+}
+
+// tAttr is the transition function for a plain attribute value.
+func tAttr(c context, s []byte) (context, int) {
+	return c, len(s)
+}
+
+// tURL is the transition function for the URL state.
+func tURL(c context, s []byte) (context, int) {
+	if bytes.ContainsAny(s, "#?") {
+		c.urlPart = urlPartQueryOrFrag
+	} else if len(s) != eatWhiteSpace(s, 0) && c.urlPart == urlPartNone {
+		c.urlPart = urlPartPreQuery
+	}
+	return c, len(s)
+}
+
+// tJS is the transition function for the JS state.
+func tJS(c context, s []byte) (context, int) {
+	var i = bytes.IndexAny(s, `"'/`)
+	if i == -1 {
+		c.jsCtx = nextJSCtx(s, c.jsCtx)
+		return c, len(s)
+	}
+	c.jsCtx = nextJSCtx(s[:i], c.jsCtx)
+	switch s[i] {
+	case '"':
+		c.state, c.jsCtx = stateJSDqStr, jsCtxRegexp
+	case '\'':
+		c.state, c.jsCtx = stateJSSqStr, jsCtxRegexp
+	case '/':
+		switch {
+		case i+1 < len(s) && s[i+1] == '/':
+			c.state, i = stateJSLineCmt, i+1
+		case i+1 < len(s) && s[i+1] == '*':
+			c.state, i = stateJSBlockCmt, i+1
+		case c.jsCtx == jsCtxRegexp:
+			c.state = stateJSRegexp
+		default:
+			c.jsCtx = jsCtxRegexp
+		}
+	}
+	return c, i + 1
+}
+
+// tJSDelimited is the transition function for the JS string and regexp
+// literal states.
+func tJSDelimited(c context, s []byte) (context, int) {
+	var specials = `\"`
+	switch c.state {
+	case stateJSSqStr:
+		specials = `\'`
+	case stateJSRegexp:
+		specials = `\/[]`
+	}
+
+	var k, inCharset = 0, false
+	for {
+		var i = k + bytes.IndexAny(s[k:], specials)
+		if i < k {
+			break
+		}
+		switch s[i] {
+		case '\\':
+			i++
+			if i == len(s) {
+				return c, len(s)
+			}
+		case '[':
+			inCharset = true
+		case ']':
+			inCharset = false
+		default:
+			// end delimiter
+			if !inCharset {
+				c.state, c.jsCtx = stateJS, jsCtxDivOp
+				return c, i + 1
+			}
+		}
+		k = i + 1
+	}
+	return c, len(s)
+}
+
+// tBlockCmt is the transition function for /*comment*/ states.
+func tBlockCmt(c context, s []byte) (context, int) {
+	var i = bytes.Index(s, []byte("*/"))
+	if i == -1 {
+		return c, len(s)
+	}
+	if c.state == stateJSBlockCmt {
+		c.state = stateJS
+	} else {
+		c.state = stateCSS
+	}
+	return c, i + 2
+}
+
+// tLineCmt is the transition function for //comment states.
+func tLineCmt(c context, s []byte) (context, int) {
+	var i = bytes.IndexAny(s, "\n\r\u2028\u2029")
+	if i == -1 {
+		return c, len(s)
+	}
+	if c.state == stateJSLineCmt {
+		c.state, c.jsCtx = stateJS, jsCtxRegexp
+	} else {
+		c.state = stateCSS
+	}
+	// Per section 7.4 of EcmaScript 5, the line terminator is not part of
+	// the comment, and it is significant to both JS and CSS.
+	return c, i
+}
+
+// tCSS is the transition function for the CSS state.
+func tCSS(c context, s []byte) (context, int) {
+	var k = 0
+	for {
+		var i = k + bytes.IndexAny(s[k:], `("'/`)
+		if i < k {
+			return c, len(s)
+		}
+		switch s[i] {
+		case '(':
+			// Look for url to the left.
+			var p = bytes.TrimRight(s[:i], "\t\n\f\r ")
+			if endsWithCSSKeyword(p, "url") {
+				var j = len(s) - len(bytes.TrimLeft(s[i+1:], "\t\n\f\r "))
+				switch {
+				case j != len(s) && s[j] == '"':
+					c.state, j = stateCSSDqURL, j+1
+				case j != len(s) && s[j] == '\'':
+					c.state, j = stateCSSSqURL, j+1
+				default:
+					c.state = stateCSSURL
+				}
+				c.urlPart = urlPartNone
+				return c, j
+			}
+		case '"':
+			c.state = stateCSSDqStr
+			return c, i + 1
+		case '\'':
+			c.state = stateCSSSqStr
+			return c, i + 1
+		case '/':
+			if i+1 < len(s) {
+				switch s[i+1] {
+				case '/':
+					c.state = stateCSSLineCmt
+					return c, i + 2
+				case '*':
+					c.state = stateCSSBlockCmt
+					return c, i + 2
+				}
+			}
+		}
+		k = i + 1
+	}
+}
+
+// tCSSStr is the transition function for the CSS string and URL states.
+func tCSSStr(c context, s []byte) (context, int) {
+	var endAndEsc string
+	switch c.state {
+	case stateCSSDqStr, stateCSSDqURL:
+		endAndEsc = `\"`
+	case stateCSSSqStr, stateCSSSqURL:
+		endAndEsc = `\'`
+	case stateCSSURL:
+		// Unquoted URLs end with a newline or close parenthesis.
+		// The below includes the wc (whitespace character) and nl.
+		endAndEsc = "\\\t\n\f\r )"
+	}
+
+	var k = 0
+	for {
+		var i = k + bytes.IndexAny(s[k:], endAndEsc)
+		if i < k {
+			return cssURLPart(c, s[k:]), len(s)
+		}
+		if s[i] == '\\' {
+			i++
+			if i == len(s) {
+				return c, len(s)
+			}
+		} else {
+			c = cssURLPart(c, s[k:i])
+			c.state, c.urlPart = stateCSS, urlPartNone
+			return c, i + 1
+		}
+		c = cssURLPart(c, s[:i+1])
+		k = i + 1
+	}
+}
+
+// cssURLPart updates the URL part of c if it is in a CSS URL state.
+func cssURLPart(c context, s []byte) context {
+	switch c.state {
+	case stateCSSDqURL, stateCSSSqURL, stateCSSURL:
+		c, _ = tURL(c, s)
+	}
+	return c
+}
+
+// tHTMLCmt is the transition function for stateHTMLCmt.
+func tHTMLCmt(c context, s []byte) (context, int) {
+	if i := bytes.Index(s, []byte("-->")); i != -1 {
+		return context{}, i + 3
+	}
+	return c, len(s)
+}
+
+// tSpecialTagEnd is the transition function for the content of raw text and
+// RCDATA elements.  It ends the element at its end tag.
+func tSpecialTagEnd(c context, s []byte) (context, int) {
+	if i := indexTagEnd(s, elementNames[c.element]); i != -1 {
+		var content = s[:i]
+		for len(content) > 0 && c.state != stateError {
+			var n int
+			c, n = transitionFunc[c.state](c, content)
+			content = content[n:]
+		}
+		return context{}, i
+	}
+	return transitionFunc[c.state](c, s)
+}
+
+// indexTagEnd finds the index of a special tag end in a case insensitive way,
+// or returns -1.
+func indexTagEnd(s []byte, tag string) int {
+	var lower = bytes.ToLower(s)
+	var end = []byte("</" + tag)
+	var i = 0
+	for {
+		var j = bytes.Index(lower[i:], end)
+		if j == -1 {
+			return -1
+		}
+		i += j + len(end)
+		if i == len(s) || !isTagNameChar(s[i]) {
+			return i - len(end)
+		}
+	}
+}
+
+// tError is the transition function for the error state.
+func tError(c context, s []byte) (context, int) {
+	return c, len(s)
+}
+
+var elementNames = [...]string{
+	elementNone:     "",
+	elementScript:   "script",
+	elementStyle:    "style",
+	elementTextarea: "textarea",
+	elementTitle:    "title",
+}
+
+// elementContentState returns the state for the content of the element.
+func elementContentState(e ctxElement) ctxState {
+	switch e {
+	case elementScript:
+		return stateJS
+	case elementStyle:
+		return stateCSS
+	case elementTextarea, elementTitle:
+		return stateRCDATA
+	}
+	return stateText
+}
+
+// urlAttrs lists the attributes whose values are URLs.
+var urlAttrs = map[string]bool{
+	"action":     true,
+	"archive":    true,
+	"background": true,
+	"cite":       true,
+	"classid":    true,
+	"codebase":   true,
+	"data":       true,
+	"formaction": true,
+	"href":       true,
+	"icon":       true,
+	"longdesc":   true,
+	"manifest":   true,
+	"poster":     true,
+	"profile":    true,
+	"src":        true,
+	"usemap":     true,
+	"xmlns":      true,
+}
+
+// attrType returns the type of the attribute with the given lower-case name.
+func attrType(name string) ctxAttr {
+	if i := bytes.IndexByte([]byte(name), ':'); i != -1 {
+		if name[:i] == "xmlns" {
+			return attrURL
+		}
+		name = name[i+1:]
+	}
+	switch {
+	case len(name) > 2 && name[:2] == "on":
+		return attrScript
+	case name == "style":
+		return attrStyle
+	case urlAttrs[name]:
+		return attrURL
+	}
+	return attrNone
+}
+
+// withElement returns the context after the name of an element has been
+// read, given the tag name that was read.
+func (c context) withElement(name []byte, end bool) context {
+	c.element = elementNone
+	if !end {
+		switch string(bytes.ToLower(name)) {
+		case "script":
+			c.element = elementScript
+		case "style":
+			c.element = elementStyle
+		case "textarea":
+			c.element = elementTextarea
+		case "title":
+			c.element = elementTitle
+		}
+	}
+	return c
+}
+
+// nextJSCtx returns the context that determines whether a slash after the
+// given run of tokens starts a regular expression instead of a division
+// operator.
+func nextJSCtx(s []byte, preceding ctxJS) ctxJS {
+	s = bytes.TrimRight(s, "\t\n\f\r \u2028\u2029")
+	if len(s) == 0 {
+		return preceding
+	}
+
+	// All cases below are in the single-byte UTF-8 group.
+	switch c, n := s[len(s)-1], len(s); c {
+	case '+', '-':
+		// ++ and -- are not regexp preceders, but + and - are whether
+		// they are used as infix or prefix operators.
+		var start = n - 1
+		// Count the number of adjacent dashes or pluses.
+		for start > 0 && s[start-1] == c {
+			start--
+		}
+		if (n-start)&1 == 1 {
+			// Reached for trailing minus signs since "---" is the
+			// same as "-- -".
+			return jsCtxRegexp
+		}
+		return jsCtxDivOp
+	case '.':
+		// Handle "42."
+		if n != 1 && '0' <= s[n-2] && s[n-2] <= '9' {
+			return jsCtxDivOp
+		}
+		return jsCtxRegexp
+	// Suffixes for all punctuators from section 7.7 of the language spec
+	// that only end binary operators not handled above.
+	case ',', '<', '>', '=', '*', '%', '&', '|', '^', '?':
+		return jsCtxRegexp
+	// Suffixes for all punctuators from section 7.7 of the language spec
+	// that are prefix operators not handled above.
+	case '!', '~':
+		return jsCtxRegexp
+	// Matches all the punctuators from section 7.7 of the language spec
+	// that are open brackets not handled above.
+	case '(', '[':
+		return jsCtxRegexp
+	// Matches all the punctuators from section 7.7 of the language spec
+	// that precede expression starts.
+	case ':', ';', '{':
+		return jsCtxRegexp
+	// CAVEAT: the close punctuators ('}', ']', ')') precede div ops and
+	// are handled in the default except for '}' which can precede a
+	// division op as in
+	//    ({ valueOf: function () { return 42 } } / 2
+	// which is valid, but, in practice, developers don't divide object
+	// literals, so our heuristic works well for code like
+	//    function () { ... }  /foo/.test(x) && sideEffect();
+	// The ')' punctuator can precede a regular expression as in
+	//     if (b) /foo/.test(x) && ...
+	// but this is much less likely than
+	//     (a + b) / c
+	case '}':
+		return jsCtxRegexp
+	default:
+		// Look for an IdentifierName and see if it is a keyword that
+		// can precede a regular expression.
+		var j = n
+		for j > 0 && isJSIdentPart(rune(s[j-1])) {
+			j--
+		}
+		if regexpPrecederKeywords[string(s[j:])] {
+			return jsCtxRegexp
+		}
+	}
+	// Otherwise is a punctuator not listed above, or
+	// a string which precedes a div op, or an identifier
+	// which precedes a div op.
+	return jsCtxDivOp
+}
+
+// regexpPrecederKeywords is a set of reserved JS keywords that can precede a
+// regular expression in JS source.
+var regexpPrecederKeywords = map[string]bool{
+	"break":      true,
+	"case":       true,
+	"continue":   true,
+	"delete":     true,
+	"do":         true,
+	"else":       true,
+	"finally":    true,
+	"in":         true,
+	"instanceof": true,
+	"return":     true,
+	"throw":      true,
+	"try":        true,
+	"typeof":     true,
+	"void":       true,
+}
+
+// endsWithCSSKeyword reports whether b ends with an ident that
+// case-insensitively matches the lower-case kw.
+func endsWithCSSKeyword(b []byte, kw string) bool {
+	var i = len(b) - len(kw)
+	if i < 0 {
+		// Too short.
+		return false
+	}
+	if i != 0 && isCSSNmchar(rune(b[i-1])) {
+		// Too long.
+		return false
+	}
+	return string(bytes.ToLower(b[i:])) == kw
+}
+
+func isJSIdentPart(r rune) bool {
+	switch {
+	case r == '$', r == '_':
+		return true
+	case '0' <= r && r <= '9':
+		return true
+	case 'A' <= r && r <= 'Z', 'a' <= r && r <= 'z':
+		return true
+	}
+	return false
+}
+
+func isCSSNmchar(r rune) bool {
+	return 'a' <= r && r <= 'z' ||
+		'A' <= r && r <= 'Z' ||
+		'0' <= r && r <= '9' ||
+		r == '-' || r == '_' ||
+		0x80 <= r
+}
+
+func isASCIIAlpha(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z'
+}
+
+func isTagNameChar(c byte) bool {
+	return isASCIIAlpha(c) || '0' <= c && c <= '9' || c == '-' || c == ':' || c == '_'
+}
+
+func isAttrNameChar(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\f', '\r', '=', '>', '/', '"', '\'', '<':
+		return false
+	}
+	return true
+}
+
+// eatWhiteSpace returns the largest j such that s[i:j] is white space.
+func eatWhiteSpace(s []byte, i int) int {
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case ' ', '\t', '\n', '\f', '\r':
+			// No-op.
+		default:
+			return j
+		}
+	}
+	return len(s)
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
 	"text/template"
 	"unicode/utf8"
 
@@ -19,8 +22,9 @@ type PrintDirective struct {
 	CancelAutoescape bool
 }
 
-// PrintDirectives are the builtin print directives.
-// Callers may add their own print directives to this map.
+// PrintDirectives are the builtin print directives, which each new Tofu starts
+// with.  Callers may add their own print directives to this map before
+// creating a Tofu, or to a Tofu with WithPrintDirectives.
 var PrintDirectives = map[string]PrintDirective{
 	"insertWordBreaks":  {directiveInsertWordBreaks, []int{1}, true},
 	"changeNewlineToBr": {directiveChangeNewlineToBr, []int{0}, true},
@@ -33,11 +37,24 @@ var PrintDirectives = map[string]PrintDirective{
 	"bidiSpanWrap":      {nil, []int{0}, false}, // unimplemented
 	"bidiUnicodeWrap":   {nil, []int{0}, false}, // unimplemented
 	"json":              {directiveJson, []int{0}, true},
+
+	"escapeHtmlAttribute":        {escaperDirective("escapeHtmlAttribute"), []int{0}, true},
+	"escapeHtmlAttributeNospace": {escaperDirective("escapeHtmlAttributeNospace"), []int{0}, true},
+	"escapeJsValue":              {escaperDirective("escapeJsValue"), []int{0}, true},
+	"escapeJsRegex":              {escaperDirective("escapeJsRegex"), []int{0}, true},
+	"escapeCssString":            {escaperDirective("escapeCssString"), []int{0}, true},
+	"filterCssValue":             {escaperDirective("filterCssValue"), []int{0}, true},
+	"filterNormalizeUri":         {escaperDirective("filterNormalizeUri"), []int{0}, true},
+	"normalizeUri":               {escaperDirective("normalizeUri"), []int{0}, true},
+	"filterImageDataUri":         {directiveFilterImageDataUri, []int{0}, true},
+	"text":                       {directiveText, []int{0}, false},
+	"formatNum":                  {directiveFormatNum, []int{0, 1, 2, 3, 4}, false},
 }
 
 // ObligatoryPrintDirectives are always called
 // These directives can't take arguments
-// Callers may add their own print directives to this list.
+// Callers may add their own print directives to this list before creating a
+// Tofu, or set them on a Tofu with WithObligatoryPrintDirectives.
 var ObligatoryPrintDirectiveNames = []string{}
 
 func directiveInsertWordBreaks(value data.Value, args []data.Value) data.Value {
@@ -132,6 +149,135 @@ func directiveEscapeJsString(value data.Value, _ []data.Value) data.Value {
 	return data.String(template.JSEscapeString(value.String()))
 }
 
+// escaperDirective returns a print directive applying the named escaper, the
+// same one used by the contextual autoescaper.
+func escaperDirective(name string) func(data.Value, []data.Value) data.Value {
+	return func(value data.Value, _ []data.Value) data.Value {
+		return data.String(escapers[name](value))
+	}
+}
+
+func directiveFilterImageDataUri(value data.Value, _ []data.Value) data.Value {
+	return data.SanitizedURI(filterImageDataURI(value.String()))
+}
+
+// directiveText treats the value as plain text.  It does not cancel
+// autoescaping, so the text is still escaped for the context it is printed in.
+func directiveText(value data.Value, _ []data.Value) data.Value {
+	return data.String(value.String())
+}
+
+// directiveFormatNum formats a number in the en locale.  The optional args are
+// the type of format, which is one of "decimal" (the default), "currency",
+// "percent" or "scientific"; a numbering system keyword, which is ignored; and
+// the minimum and maximum number of fraction digits, which must be integers.  The maximum defaults to
+// the minimum if it is not given.
+func directiveFormatNum(value data.Value, args []data.Value) data.Value {
+	var format = "decimal"
+	if len(args) > 0 {
+		format = args[0].String()
+	}
+	var prefix, suffix string
+	var minDigits, maxDigits = 0, 3
+	switch format {
+	case "decimal", "scientific":
+	case "currency":
+		prefix, minDigits, maxDigits = "$", 2, 2
+	case "percent":
+		suffix, maxDigits = "%", 0
+	default:
+		panic(fmt.Errorf("unknown number format %q", format))
+	}
+	if len(args) > 2 {
+		minDigits = fractionDigits(args[2])
+		maxDigits = minDigits
+	}
+	if len(args) > 3 {
+		maxDigits = fractionDigits(args[3])
+	}
+
+	var num = toFloat(value)
+	var sign string
+	if num < 0 {
+		sign, num = "-", -num
+	}
+	if format == "percent" {
+		num *= 100
+	}
+	if format == "scientific" {
+		var mantissa, exponent = num, 0
+		for mantissa >= 10 {
+			mantissa /= 10
+			exponent++
+		}
+		for mantissa > 0 && mantissa < 1 {
+			mantissa *= 10
+			exponent--
+		}
+		if mantissa = roundDigits(mantissa, maxDigits); mantissa >= 10 {
+			mantissa /= 10
+			exponent++
+		}
+		suffix = "E" + strconv.Itoa(exponent)
+		return data.String(sign + formatDigits(mantissa, minDigits, maxDigits) + suffix)
+	}
+	var digits = formatDigits(roundDigits(num, maxDigits), minDigits, maxDigits)
+	return data.String(sign + prefix + groupThousands(digits) + suffix)
+}
+
+// fractionDigits returns the given number of fraction digits of formatNum,
+// which may be a float with an integer value, like in javascript.
+func fractionDigits(v data.Value) int {
+	switch v := v.(type) {
+	case data.Int:
+		return int(v)
+	case data.Float:
+		if float64(v) == math.Trunc(float64(v)) {
+			return int(v)
+		}
+	}
+	panic(fmt.Errorf("fraction digits must be an integer, got %v", v))
+}
+
+// roundDigits rounds the non-negative number to the given number of fraction
+// digits, rounding halves up.
+func roundDigits(num float64, digits int) float64 {
+	var pow = math.Pow(10, float64(digits))
+	return math.Floor(num*pow+0.5) / pow
+}
+
+// formatDigits formats the non-negative number with at most maxDigits fraction
+// digits, removing trailing zeros beyond minDigits.
+func formatDigits(num float64, minDigits, maxDigits int) string {
+	var str = strconv.FormatFloat(num, 'f', maxDigits, 64)
+	if maxDigits > minDigits {
+		str = strings.TrimRight(str, "0")
+		if i := strings.IndexByte(str, '.'); len(str)-i-1 < minDigits {
+			str += strings.Repeat("0", minDigits-(len(str)-i-1))
+		}
+		str = strings.TrimSuffix(str, ".")
+	}
+	return str
+}
+
+// groupThousands separates the thousands in the integer part of the given
+// formatted number with commas.
+func groupThousands(num string) string {
+	var end = strings.IndexByte(num, '.')
+	if end == -1 {
+		end = len(num)
+	}
+	var b strings.Builder
+	for i := 0; i < end; i++ {
+		if i > 0 && (end-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte(num[i])
+	}
+	b.WriteString(num[end:])
+	return b.String()
+}
+
 func directiveJson(value data.Value, _ []data.Value) data.Value {
 	j, err := json.Marshal(value)
 	if err != nil {
@@ -0,0 +1,433 @@
+package soyhtml
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/robfig/soy/data"
+)
+
+// The escapers in this file are ports of the ones in soyutils.js, so that
+// contextually autoescaped output is identical between the backends.
+
+// escapers are the escaping functions chosen by the contextual autoescaper,
+// keyed by the name of the equivalent function in soyutils.js.  Like those
+// functions, they pass through sanitized content of the kind that is safe in
+// their context.
+var escapers = map[string]func(data.Value) string{
+	"escapeHtml": func(v data.Value) string {
+		if isKind(v, data.KindHTML) {
+			return v.String()
+		}
+		return escapeHTML(v.String())
+	},
+	"escapeHtmlRcdata": func(v data.Value) string {
+		if isKind(v, data.KindHTML) {
+			return normalizeHTML(v.String())
+		}
+		return escapeHTML(v.String())
+	},
+	"escapeHtmlAttribute": func(v data.Value) string {
+		if isKind(v, data.KindHTML) {
+			return normalizeHTML(stripHTMLTags(v.String()))
+		}
+		return escapeHTML(v.String())
+	},
+	"escapeHtmlAttributeNospace": func(v data.Value) string {
+		if isKind(v, data.KindHTML) {
+			return normalizeHTMLNospace(stripHTMLTags(v.String()))
+		}
+		return escapeHTMLNospace(v.String())
+	},
+	"filterHtmlAttributes": func(v data.Value) string {
+		if isKind(v, data.KindAttributes) {
+			// Add a space at the end to ensure this won't get merged into
+			// following attributes, unless the interpretation is unambiguous.
+			return trailingAttrPattern.ReplaceAllString(v.String(), "$1 ")
+		}
+		return filterHTMLAttributes(v.String())
+	},
+	"filterHtmlElementName": func(v data.Value) string {
+		return filterHTMLElementName(v.String())
+	},
+	"escapeJsString": func(v data.Value) string {
+		return escapeJSString(v.String())
+	},
+	"escapeJsValue": func(v data.Value) string {
+		if isKind(v, data.KindJS) {
+			return v.String()
+		}
+		return escapeJSValue(v)
+	},
+	"escapeJsRegex": func(v data.Value) string {
+		return escapeJSRegex(v.String())
+	},
+	"escapeUri": func(v data.Value) string {
+		if isKind(v, data.KindURI) {
+			return normalizeURI(v.String())
+		}
+		return escapeURI(v.String())
+	},
+	"normalizeUri": func(v data.Value) string {
+		return normalizeURI(v.String())
+	},
+	"filterNormalizeUri": func(v data.Value) string {
+		if isKind(v, data.KindURI) {
+			return normalizeURI(v.String())
+		}
+		return filterNormalizeURI(v.String())
+	},
+	"escapeCssString": func(v data.Value) string {
+		return escapeCSSString(v.String())
+	},
+	"filterCssValue": func(v data.Value) string {
+		switch {
+		case isKind(v, data.KindCSS):
+			return v.String()
+		case v == data.Null{}:
+			return ""
+		}
+		return filterCSSValue(v.String())
+	},
+}
+
+// isKind returns true if v is sanitized content of the given kind.
+func isKind(v data.Value, kind data.ContentKind) bool {
+	var content, ok = v.(data.SanitizedContent)
+	return ok && content.Kind() == kind
+}
+
+// htmlEscapes maps characters to their escaped versions for the HTML escapers.
+var htmlEscapes = map[rune]string{
+	'\x00':   "&#0;",
+	'"':      "&quot;",
+	'&':      "&amp;",
+	'\'':     "&#39;",
+	'<':      "&lt;",
+	'>':      "&gt;",
+	'\t':     "&#9;",
+	'\n':     "&#10;",
+	'\v':     "&#11;",
+	'\f':     "&#12;",
+	'\r':     "&#13;",
+	' ':      "&#32;",
+	'-':      "&#45;",
+	'/':      "&#47;",
+	'=':      "&#61;",
+	'`':      "&#96;",
+	'\u0085': "&#133;",
+	'\u00a0': "&#160;",
+	'\u2028': "&#8232;",
+	'\u2029': "&#8233;",
+}
+
+// jsEscapes maps characters to their escaped versions for the JS string and
+// regular expression escapers.
+var jsEscapes = map[rune]string{
+	'\x00':   `\x00`,
+	'\x08':   `\x08`,
+	'\t':     `\t`,
+	'\n':     `\n`,
+	'\v':     `\x0b`,
+	'\f':     `\f`,
+	'\r':     `\r`,
+	'"':      `\x22`,
+	'&':      `\x26`,
+	'\'':     `\x27`,
+	'/':      `\/`,
+	'<':      `\x3c`,
+	'=':      `\x3d`,
+	'>':      `\x3e`,
+	'\\':     `\\`,
+	'\u0085': `\x85`,
+	'\u2028': `\u2028`,
+	'\u2029': `\u2029`,
+	'$':      `\x24`,
+	'(':      `\x28`,
+	')':      `\x29`,
+	'*':      `\x2a`,
+	'+':      `\x2b`,
+	',':      `\x2c`,
+	'-':      `\x2d`,
+	'.':      `\x2e`,
+	':':      `\x3a`,
+	'?':      `\x3f`,
+	'[':      `\x5b`,
+	']':      `\x5d`,
+	'^':      `\x5e`,
+	'{':      `\x7b`,
+	'|':      `\x7c`,
+	'}':      `\x7d`,
+}
+
+// cssEscapes maps characters to their escaped versions for the CSS string
+// escaper.
+var cssEscapes = map[rune]string{
+	'\x00':   `\0 `,
+	'\x08':   `\8 `,
+	'\t':     `\9 `,
+	'\n':     `\a `,
+	'\v':     `\b `,
+	'\f':     `\c `,
+	'\r':     `\d `,
+	'"':      `\22 `,
+	'&':      `\26 `,
+	'\'':     `\27 `,
+	'(':      `\28 `,
+	')':      `\29 `,
+	'*':      `\2a `,
+	'/':      `\2f `,
+	':':      `\3a `,
+	';':      `\3b `,
+	'<':      `\3c `,
+	'=':      `\3d `,
+	'>':      `\3e `,
+	'@':      `\40 `,
+	'\\':     `\5c `,
+	'{':      `\7b `,
+	'}':      `\7d `,
+	'\u0085': `\85 `,
+	'\u00a0': `\a0 `,
+	'\u2028': `\2028 `,
+	'\u2029': `\2029 `,
+}
+
+// uriEscapes maps characters to their escaped versions for the URI
+// normalizers.
+var uriEscapes = map[rune]string{
+	' ':      "%20",
+	'"':      "%22",
+	'\'':     "%27",
+	'(':      "%28",
+	')':      "%29",
+	'<':      "%3C",
+	'>':      "%3E",
+	'\\':     "%5C",
+	'{':      "%7B",
+	'}':      "%7D",
+	'\x7f':   "%7F",
+	'\u0085': "%C2%85",
+	'\u00a0': "%C2%A0",
+	'\u2028': "%E2%80%A8",
+	'\u2029': "%E2%80%A9",
+	'\uff01': "%EF%BC%81",
+	'\uff03': "%EF%BC%83",
+	'\uff04': "%EF%BC%84",
+	'\uff06': "%EF%BC%86",
+	'\uff07': "%EF%BC%87",
+	'\uff08': "%EF%BC%88",
+	'\uff09': "%EF%BC%89",
+	'\uff0a': "%EF%BC%8A",
+	'\uff0b': "%EF%BC%8B",
+	'\uff0c': "%EF%BC%8C",
+	'\uff0f': "%EF%BC%8F",
+	'\uff1a': "%EF%BC%9A",
+	'\uff1b': "%EF%BC%9B",
+	'\uff1d': "%EF%BC%9D",
+	'\uff1f': "%EF%BC%9F",
+	'\uff20': "%EF%BC%A0",
+	'\uff3b': "%EF%BC%BB",
+	'\uff3d': "%EF%BC%BD",
+}
+
+func init() {
+	for r := rune(0); r < ' '; r++ {
+		uriEscapes[r] = fmt.Sprintf("%%%02X", r)
+	}
+}
+
+// The sets of characters matched by each escaper.
+const (
+	escapeHTMLChars           = "\x00\"&'<>"
+	normalizeHTMLChars        = "\x00\"'<>"
+	escapeHTMLNospaceChars    = "\x00\t\n\v\f\r \"&'-/<=>`\u0085\u00a0\u2028\u2029"
+	normalizeHTMLNospaceChars = "\x00\t\n\v\f\r \"'-/<=>`\u0085\u00a0\u2028\u2029"
+	escapeJSStringChars       = "\x00\x08\t\n\v\f\r\"&'/<=>\\\u0085\u2028\u2029"
+)
+
+// escapeRunes replaces each rune in s for which match returns true with its
+// entry in escapes.
+func escapeRunes(s string, escapes map[rune]string, match func(rune) bool) string {
+	var b strings.Builder
+	var last = 0
+	for i := 0; i < len(s); {
+		var r, width = utf8.DecodeRuneInString(s[i:])
+		if match(r) {
+			if repl, ok := escapes[r]; ok {
+				b.WriteString(s[last:i])
+				b.WriteString(repl)
+				last = i + width
+			}
+		}
+		i += width
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// in returns a matcher for the runes in the given set.
+func in(chars string) func(rune) bool {
+	return func(r rune) bool {
+		return strings.ContainsRune(chars, r)
+	}
+}
+
+func matchAll(rune) bool {
+	return true
+}
+
+func escapeHTML(s string) string {
+	return escapeRunes(s, htmlEscapes, in(escapeHTMLChars))
+}
+
+func normalizeHTML(s string) string {
+	return escapeRunes(s, htmlEscapes, in(normalizeHTMLChars))
+}
+
+func escapeHTMLNospace(s string) string {
+	return escapeRunes(s, htmlEscapes, in(escapeHTMLNospaceChars))
+}
+
+func normalizeHTMLNospace(s string) string {
+	return escapeRunes(s, htmlEscapes, in(normalizeHTMLNospaceChars))
+}
+
+func escapeJSString(s string) string {
+	return escapeRunes(s, jsEscapes, in(escapeJSStringChars))
+}
+
+func escapeJSRegex(s string) string {
+	return escapeRunes(s, jsEscapes, matchAll)
+}
+
+func escapeCSSString(s string) string {
+	return escapeRunes(s, cssEscapes, matchAll)
+}
+
+func normalizeURI(s string) string {
+	return escapeRunes(s, uriEscapes, matchAll)
+}
+
+var (
+	filterNormalizeURIPattern = regexp.MustCompile(
+		`^(?:(?:[hH][tT][tT][pP][sS]?|[mM][aA][iI][lL][tT][oO]):|[^&:/?#]*(?:[/?#]|$))`)
+	filterCSSValuePattern = regexp.MustCompile(
+		`^(?:[.#]?-?(?:[_a-zA-Z0-9-]+)(?:-[_a-zA-Z0-9-]+)*-?|` +
+			`-?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:[a-zA-Z]{1,2}|%)?|!(?i:important)|)$`)
+	filterImageDataURIPattern = regexp.MustCompile(
+		`^(?i:data:image/(?:bmp|gif|jpe?g|png|tiff|webp);base64,[a-z0-9+/]+=*)$`)
+	filterHTMLAttributesPattern  = regexp.MustCompile(`^[a-zA-Z0-9_$:-]*$`)
+	filterHTMLElementNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_$:-]*$`)
+
+	// Prefixes that may not begin the filtered values.  These are separate
+	// from the patterns since RE2 does not support negative lookahead.
+	filterCSSValueBanned        = []string{"expression", "binding", "moz-binding"}
+	filterHTMLAttributesBanned  = []string{"style", "on", "action", "archive", "background", "cite", "classid", "codebase", "data", "dsync", "href", "longdesc", "src", "usemap"}
+	filterHTMLElementNameBanned = []string{"script", "style", "title", "textarea", "xmp", "no"}
+)
+
+var (
+	htmlTagPattern      = regexp.MustCompile(`<(?:!|/?([a-zA-Z][a-zA-Z0-9:\-]*))(?:[^>'"]|"[^"]*"|'[^']*')*>`)
+	trailingAttrPattern = regexp.MustCompile(`([^"'\s])$`)
+)
+
+// stripHTMLTags removes all tags, comments and doctypes from s, so that the
+// result may be embedded in an attribute value.
+func stripHTMLTags(s string) string {
+	return strings.Replace(htmlTagPattern.ReplaceAllString(s, ""), "<", "&lt;", -1)
+}
+
+// hasBannedPrefix reports whether s begins with any of the given lower-case
+// prefixes, ignoring ASCII case.
+func hasBannedPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterNormalizeURI vets a URI's protocol and normalizes it.  Values with a
+// disallowed protocol are replaced with "#zSoyz".
+func filterNormalizeURI(s string) string {
+	if !filterNormalizeURIPattern.MatchString(s) {
+		return "#zSoyz"
+	}
+	return normalizeURI(s)
+}
+
+// filterImageDataURI passes through base64 data URIs of images, and replaces
+// anything else with an innocuous image URI.  Sanitized URIs are filtered too.
+func filterImageDataURI(s string) string {
+	if !filterImageDataURIPattern.MatchString(s) {
+		return "data:image/gif;base64,zSoyz"
+	}
+	return s
+}
+
+// filterCSSValue passes through CSS identifiers, keywords and quantities, and
+// replaces anything else with "zSoyz".
+func filterCSSValue(s string) string {
+	if hasBannedPrefix(strings.TrimLeft(s, "-"), filterCSSValueBanned) ||
+		!filterCSSValuePattern.MatchString(s) {
+		return "zSoyz"
+	}
+	return s
+}
+
+// filterHTMLAttributes passes through safe attribute names, and replaces
+// anything else with "zSoyz".
+func filterHTMLAttributes(s string) string {
+	if hasBannedPrefix(s, filterHTMLAttributesBanned) ||
+		!filterHTMLAttributesPattern.MatchString(s) {
+		return "zSoyz"
+	}
+	return s
+}
+
+// filterHTMLElementName passes through safe element names, and replaces
+// anything else with "zSoyz".
+func filterHTMLElementName(s string) string {
+	if hasBannedPrefix(s, filterHTMLElementNameBanned) ||
+		!filterHTMLElementNamePattern.MatchString(s) {
+		return "zSoyz"
+	}
+	return s
+}
+
+// escapeURI percent-encodes s for inclusion in a URI, in the same way as
+// JavaScript's encodeURIComponent, additionally encoding apostrophes and
+// parentheses.
+func escapeURI(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		var c = s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			b.WriteByte(c)
+		case strings.IndexByte("-_.!~*", c) != -1:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// escapeJSValue encodes a value as a JavaScript literal, surrounded by spaces
+// so that it may not be interpolated into an identifier by accident.
+func escapeJSValue(v data.Value) string {
+	switch v := v.(type) {
+	case data.Null, data.Undefined:
+		return " null "
+	case data.Bool, data.Int, data.Float:
+		return " " + v.String() + " "
+	}
+	return "'" + escapeJSString(v.String()) + "'"
+}
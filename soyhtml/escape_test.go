@@ -0,0 +1,47 @@
+package soyhtml
+
+import "testing"
+
+// The expected results of these tests were produced by the corresponding
+// functions in soyutils.js.
+var escaperTests = []struct {
+	name    string
+	escaper func(string) string
+	input   string
+	result  string
+}{
+	{"escapeHTML", escapeHTML, `<a href="x">'&'</a>`, `&lt;a href=&quot;x&quot;&gt;&#39;&amp;&#39;&lt;/a&gt;`},
+	{"normalizeHTML", normalizeHTML, `<&amp;>`, `&lt;&amp;&gt;`},
+	{"escapeHTMLNospace", escapeHTMLNospace, "a b=c`d\u00a0", `a&#32;b&#61;c&#96;d&#160;`},
+	{"escapeJSString", escapeJSString, "'a\"</script>\n\u2028", `\x27a\x22\x3c\/script\x3e\n\u2028`},
+	{"escapeJSRegex", escapeJSRegex, "a.b*(c)[d]{e}|f$", `a\x2eb\x2a\x28c\x29\x5bd\x5d\x7be\x7d\x7cf\x24`},
+	{"escapeCSSString", escapeCSSString, `"</style>`, `\22 \3c \2f style\3e `},
+	{"normalizeURI", normalizeURI, "/a b/\"c\"?d=(e)\uff01", "/a%20b/%22c%22?d=%28e%29%EF%BC%81"},
+	{"filterNormalizeURI", filterNormalizeURI, "http://a.com/b c", "http://a.com/b%20c"},
+	{"filterNormalizeURI relative", filterNormalizeURI, "a/b:c", "a/b:c"},
+	{"filterNormalizeURI mailto", filterNormalizeURI, "MAILTO:a@b.com", "MAILTO:a@b.com"},
+	{"filterNormalizeURI javascript", filterNormalizeURI, "javascript:alert(1)", "#zSoyz"},
+	{"filterNormalizeURI data", filterNormalizeURI, "data:text/html,x", "#zSoyz"},
+	{"escapeURI", escapeURI, "a b/c?d=e&f='(g)'*~\u00e9", "a%20b%2Fc%3Fd%3De%26f%3D%27%28g%29%27*~%C3%A9"},
+	{"filterCSSValue", filterCSSValue, "-moz-box", "-moz-box"},
+	{"filterCSSValue number", filterCSSValue, "-1.5em", "-1.5em"},
+	{"filterCSSValue important", filterCSSValue, "!IMPORTANT", "!IMPORTANT"},
+	{"filterCSSValue expression", filterCSSValue, "--Expression", "zSoyz"},
+	{"filterCSSValue binding", filterCSSValue, "-moz-binding", "zSoyz"},
+	{"filterCSSValue punctuation", filterCSSValue, "red;", "zSoyz"},
+	{"filterHTMLAttributes", filterHTMLAttributes, "title", "title"},
+	{"filterHTMLAttributes event", filterHTMLAttributes, "OnClick", "zSoyz"},
+	{"filterHTMLAttributes value", filterHTMLAttributes, "a=b", "zSoyz"},
+	{"filterHTMLElementName", filterHTMLElementName, "h1", "h1"},
+	{"filterHTMLElementName script", filterHTMLElementName, "SCRIPT", "zSoyz"},
+	{"filterHTMLElementName noscript", filterHTMLElementName, "noscript", "zSoyz"},
+}
+
+func TestEscapers(t *testing.T) {
+	for _, test := range escaperTests {
+		var result = test.escaper(test.input)
+		if result != test.result {
+			t.Errorf("%s(%q) => %q, expected %q", test.name, test.input, result, test.result)
+		}
+	}
+}
@@ -12,8 +12,9 @@ import (
 // print tag.
 //
 // This is useful for evaluating Globals, or anything returned from parse.Expr.
+// The default functions are available to the expression.
 func EvalExpr(node ast.Node) (val data.Value, err error) {
-	state := &state{wr: ioutil.Discard}
+	state := &state{config: defaultConfig(), wr: ioutil.Discard}
 	defer state.errRecover(&err)
 	state.walk(node)
 	return state.val, nil
@@ -13,13 +13,16 @@ import (
 	"github.com/robfig/soy/errortypes"
 	"github.com/robfig/soy/soymsg"
 	soyt "github.com/robfig/soy/template"
+	"github.com/robfig/soy/types"
 )
 
-// Logger collects output from {log} commands.
+// Logger collects output from {log} commands, unless another is configured on
+// the Tofu or Renderer.
 var Logger *log.Logger
 
 // state represents the state of an execution.
 type state struct {
+	config
 	namespace  string
 	tmpl       soyt.Template
 	wr         io.Writer
@@ -30,6 +33,8 @@ type state struct {
 	autoescape ast.AutoescapeType // escaping mode
 	ij         data.Map           // injected data available to all templates.
 	msgs       soymsg.Bundle      // replacement text for {msg} tags
+	checkTypes bool               // validate data against declared param types
+	delPkgs    []string           // active delegate packages
 }
 
 // at marks the state to be on node n, for error reporting.
@@ -82,12 +87,27 @@ func (s *state) walk(node ast.Node) {
 			s.walk(node)
 		}
 	case *ast.TemplateNode:
+		s.applyDefaults(node)
+		if s.checkTypes {
+			s.checkParamTypes()
+		}
 		if node.Autoescape != ast.AutoescapeUnspecified {
 			s.autoescape = node.Autoescape
 		}
+		switch node.Kind {
+		case data.KindUnspecified:
+		case data.KindText:
+			s.autoescape = ast.AutoescapeOff
+		default:
+			s.autoescape = ast.AutoescapeStrict
+		}
+		if _, ok := s.wr.(*contextWriter); s.contextual() && !ok {
+			s.wr = &contextWriter{w: s.wr, ctx: kindContext(node.Kind)}
+		}
 		s.walk(node.Body)
 	case *ast.HeaderParamNode:
-		// TODO: Validate param types.
+		// Header params are removed from the template body by the registry, and
+		// their types are checked by checkParamTypes.
 	case *ast.ListNode:
 		for _, node := range node.Nodes {
 			s.walk(node)
@@ -119,8 +139,8 @@ func (s *state) walk(node ast.Node) {
 	case *ast.LogNode:
 		// Render the node to capture any additional errors
 		rendered := s.renderBlock(node.Body)
-		if Logger != nil {
-			Logger.Print(string(rendered))
+		if s.logger != nil {
+			s.logger.Print(string(rendered))
 		}
 
 		// Control flow ----------
@@ -172,10 +192,12 @@ func (s *state) walk(node ast.Node) {
 		}
 	case *ast.CallNode:
 		s.evalCall(node)
+	case *ast.DelCallNode:
+		s.evalDelCall(node)
 	case *ast.LetValueNode:
 		s.context.set(node.Name, s.eval(node.Expr))
 	case *ast.LetContentNode:
-		s.context.set(node.Name, data.String(s.renderBlock(node.Body)))
+		s.context.set(node.Name, s.renderContent(node.Body, node.Kind))
 
 		// Values ----------
 	case *ast.NullNode:
@@ -297,8 +319,11 @@ func isInt(v data.Value) bool {
 }
 
 func isString(v data.Value) bool {
-	_, ok := v.(data.String)
-	return ok
+	switch v.(type) {
+	case data.String, data.SanitizedContent:
+		return true
+	}
+	return false
 }
 
 func toFloat(v data.Value) float64 {
@@ -321,16 +346,23 @@ func (s *state) evalPrint(node *ast.PrintNode) {
 	}
 	var escapeHtml = s.autoescape != ast.AutoescapeOff
 	var result = s.val
-
-	for _, directiveName := range ObligatoryPrintDirectiveNames {
-		node.Directives = append(node.Directives, &ast.PrintDirectiveNode{
-			Pos:  node.Position(),
-			Name: directiveName,
-		})
+	var constant = s.constant(node.Arg)
+
+	// The obligatory directives are appended to a copy, leaving the AST as it
+	// is, since it is shared by concurrent renders.
+	var directives = node.Directives
+	if len(s.obligatory) > 0 {
+		directives = directives[:len(directives):len(directives)]
+		for _, directiveName := range s.obligatory {
+			directives = append(directives, &ast.PrintDirectiveNode{
+				Pos:  node.Position(),
+				Name: directiveName,
+			})
+		}
 	}
 
-	for _, directiveNode := range node.Directives {
-		var directive, ok = PrintDirectives[directiveNode.Name]
+	for i, directiveNode := range directives {
+		var directive, ok = s.directives[directiveNode.Name]
 		if !ok {
 			s.errorf("Print directive %q does not exist", directiveNode.Name)
 		}
@@ -339,6 +371,20 @@ func (s *state) evalPrint(node *ast.PrintNode) {
 			s.errorf("Print directive %q called with %v args, expected one of: %v",
 				directiveNode.Name, len(directiveNode.Args), directive.ValidArgLengths)
 		}
+		if directive.CancelAutoescape {
+			escapeHtml = false
+		}
+
+		// The obligatory directives are not cached, since their nodes are
+		// created for each print.
+		constant = constant && i < len(node.Directives) &&
+			s.pure.directives[directiveNode.Name] && s.allConstant(directiveNode.Args)
+		if constant {
+			if cached, ok := s.pure.results.Load(directiveNode); ok {
+				result = cached.(data.Value)
+				continue
+			}
+		}
 
 		var args = make([]data.Value, len(directiveNode.Args))
 		for i, arg := range directiveNode.Args {
@@ -346,22 +392,30 @@ func (s *state) evalPrint(node *ast.PrintNode) {
 		}
 		func() {
 			defer func() {
-				if err := recover(); err != nil {
+				switch err := recover().(type) {
+				case nil:
+				case runtime.Error:
 					s.errorf("panic in %v: %v\nexecuted: %v(%q, %v)\n%v",
 						directiveNode, err,
 						directiveNode.Name, result, args,
 						string(debug.Stack()))
+				default:
+					s.errorf("%v: %v", directiveNode, err)
 				}
 			}()
 			result = directive.Apply(result, args)
 		}()
-		if directive.CancelAutoescape {
-			escapeHtml = false
+		if constant {
+			s.pure.results.Store(directiveNode, result)
 		}
 	}
 
 	var resultStr = result.String()
-	if escapeHtml {
+	if cw, ok := s.wr.(*contextWriter); escapeHtml && ok && s.contextual() {
+		resultStr = s.escapeInContext(cw.ctx, result)
+		escapeHtml = false
+	}
+	if escapeHtml && !isKind(result, data.KindHTML) {
 		htmlEscapeString(s.wr, resultStr)
 	} else {
 		if _, err := io.WriteString(s.wr, resultStr); err != nil {
@@ -370,6 +424,20 @@ func (s *state) evalPrint(node *ast.PrintNode) {
 	}
 }
 
+// contextual returns true if the current template is contextually autoescaped.
+func (s *state) contextual() bool {
+	return s.autoescape == ast.AutoescapeContextual || s.autoescape == ast.AutoescapeStrict
+}
+
+// escapeInContext escapes the given value for printing in the given context.
+func (s *state) escapeInContext(c context, value data.Value) string {
+	var result, ok = c.escape(value)
+	if !ok {
+		s.errorf("%q may not be printed in %v context.", s.node.String(), c.state)
+	}
+	return result
+}
+
 func (s *state) evalMsg(node *ast.MsgNode) {
 	// If no bundle was provided, walk the message sub-nodes.
 	if s.msgs == nil {
@@ -475,7 +543,35 @@ func (s *state) evalCall(node *ast.CallNode) {
 	if !ok {
 		s.errorf("failed to find template: %s", node.Name)
 	}
+	s.renderCall(node, calledTmpl)
+}
 
+func (s *state) evalDelCall(node *ast.DelCallNode) {
+	var variant string
+	if node.Variant != nil {
+		switch val := s.eval(node.Variant).(type) {
+		case data.Undefined, data.Null:
+		default:
+			variant = val.String()
+		}
+	}
+
+	// get the active implementation of the delegate
+	var calledTmpl, ok, err = s.registry.Delegate(node.Name, variant, s.delPkgs)
+	if err != nil {
+		s.errorf("%s", err)
+	}
+	if !ok {
+		if node.AllowEmptyDefault {
+			return
+		}
+		s.errorf("found no active implementation for delegate %s (variant %q)", node.Name, variant)
+	}
+	s.renderCall(&node.CallNode, calledTmpl)
+}
+
+// renderCall renders the given template with the data passed by the call.
+func (s *state) renderCall(node *ast.CallNode, calledTmpl soyt.Template) {
 	// sort out the data to pass
 	var callData scope
 	if node.AllData {
@@ -499,7 +595,7 @@ func (s *state) evalCall(node *ast.CallNode) {
 		case *ast.CallParamValueNode:
 			callData.set(param.Key, s.eval(param.Value))
 		case *ast.CallParamContentNode:
-			callData.set(param.Key, data.String(s.renderBlock(param.Content)))
+			callData.set(param.Key, s.renderContent(param.Content, param.Kind))
 		default:
 			s.errorf("unexpected call param type: %T", param)
 		}
@@ -507,6 +603,7 @@ func (s *state) evalCall(node *ast.CallNode) {
 
 	callData.enter()
 	state := &state{
+		config:     s.config,
 		tmpl:       calledTmpl,
 		registry:   s.registry,
 		namespace:  calledTmpl.Namespace.Name,
@@ -515,6 +612,8 @@ func (s *state) evalCall(node *ast.CallNode) {
 		context:    callData,
 		ij:         s.ij,
 		msgs:       s.msgs,
+		checkTypes: s.checkTypes,
+		delPkgs:    s.delPkgs,
 	}
 
 	defer func() {
@@ -523,7 +622,89 @@ func (s *state) evalCall(node *ast.CallNode) {
 		}
 	}()
 
+	// Strict templates are rendered on their own, and their output is printed
+	// as content of their kind in the caller's context.
+	var cw, contextual = s.wr.(*contextWriter)
+	if !contextual || !s.contextual() || !isStrict(calledTmpl) {
+		state.walk(calledTmpl.Node)
+		return
+	}
+	var buf bytes.Buffer
+	state.wr = &buf
 	state.walk(calledTmpl.Node)
+	var kind = calledTmpl.Node.Kind
+	if kind == data.KindUnspecified {
+		kind = data.KindHTML
+	}
+	var output = s.escapeInContext(cw.ctx, data.NewSanitizedContent(buf.String(), kind))
+	if _, err := io.WriteString(s.wr, output); err != nil {
+		s.errorf("%s", err)
+	}
+}
+
+// applyDefaults sets any params of the given template that were not passed to
+// their default values.
+func (s *state) applyDefaults(node *ast.TemplateNode) {
+	for _, param := range node.Params {
+		if param.Default == nil {
+			continue
+		}
+		if _, ok := s.context.lookup(param.Name).(data.Undefined); ok {
+			s.context.set(param.Name, s.eval(param.Default))
+		}
+	}
+}
+
+// checkParamTypes validates the current template's data against the types
+// declared by its header params.
+func (s *state) checkParamTypes() {
+	for _, param := range s.tmpl.Node.Params {
+		var typ = param.Type.Type
+		if typ == nil {
+			continue
+		}
+		if param.Optional {
+			typ = types.NewUnion(typ, types.Null)
+		}
+		if err := types.Validate(typ, s.context.lookup(param.Name)); err != nil {
+			s.errorf("param %q: %v", param.Name, err)
+		}
+	}
+}
+
+// isStrict returns true if the given template is strictly autoescaped.
+func isStrict(tmpl soyt.Template) bool {
+	switch {
+	case tmpl.Node.Kind != data.KindUnspecified:
+		return true
+	case tmpl.Node.Autoescape != ast.AutoescapeUnspecified:
+		return tmpl.Node.Autoescape == ast.AutoescapeStrict
+	}
+	return tmpl.Namespace.Autoescape == ast.AutoescapeStrict
+}
+
+// renderContent renders the given block of content, returning a value that
+// carries its kind.  Blocks with a kind are strictly autoescaped, starting in
+// the context appropriate to their kind.  Blocks in strict templates default
+// to HTML.
+func (s *state) renderContent(node ast.Node, kind data.ContentKind) data.Value {
+	if kind == data.KindUnspecified && s.autoescape == ast.AutoescapeStrict {
+		kind = data.KindHTML
+	}
+	if kind == data.KindUnspecified {
+		return data.String(s.renderBlock(node))
+	}
+
+	var buf bytes.Buffer
+	var origWriter, origAutoescape = s.wr, s.autoescape
+	if kind == data.KindText {
+		s.wr, s.autoescape = &buf, ast.AutoescapeOff
+	} else {
+		s.wr, s.autoescape = &contextWriter{w: &buf, ctx: kindContext(kind)}, ast.AutoescapeStrict
+	}
+	s.walk(node)
+	s.wr, s.autoescape = origWriter, origAutoescape
+	return data.NewSanitizedContent(buf.String(), kind)
 }
 
 // renderBlock is a helper that renders the given node to a temporary output
@@ -532,6 +713,9 @@ func (s *state) renderBlock(node ast.Node) []byte {
 	var buf bytes.Buffer
 	origWriter := s.wr
 	s.wr = &buf
+	if s.contextual() {
+		s.wr = &contextWriter{w: &buf}
+	}
 	s.walk(node)
 	s.wr = origWriter
 	return buf.Bytes()
@@ -546,16 +730,46 @@ func checkNumArgs(allowedNumArgs []int, numArgs int) bool {
 	return false
 }
 
+// constant returns true if the given expression always has the same value: a
+// literal null, boolean, number or string, or a call to a pure function whose
+// arguments are constant.
+func (s *state) constant(node ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.NullNode, *ast.BoolNode, *ast.IntNode, *ast.FloatNode, *ast.StringNode:
+		return true
+	case *ast.FunctionNode:
+		return s.pure.funcs[node.Name] && s.allConstant(node.Args)
+	}
+	return false
+}
+
+// allConstant returns true if each of the given expressions is constant.
+func (s *state) allConstant(nodes []ast.Node) bool {
+	for _, node := range nodes {
+		if !s.constant(node) {
+			return false
+		}
+	}
+	return true
+}
+
 func (s *state) evalFunc(node *ast.FunctionNode) data.Value {
 	if fn, ok := loopFuncs[node.Name]; ok {
 		return fn(s, node.Args[0].(*ast.DataRefNode).Key)
 	}
-	if fn, ok := Funcs[node.Name]; ok {
+	if fn, ok := s.funcs[node.Name]; ok {
 		if !checkNumArgs(fn.ValidArgLengths, len(node.Args)) {
 			s.errorf("Function %q called with %v args, expected: %v",
 				node.Name, len(node.Args), fn.ValidArgLengths)
 		}
 
+		var constant = s.constant(node)
+		if constant {
+			if cached, ok := s.pure.results.Load(node); ok {
+				return cached.(data.Value)
+			}
+		}
+
 		var args = make([]data.Value, len(node.Args))
 		for i, arg := range node.Args {
 			args[i] = s.eval(arg)
@@ -567,7 +781,10 @@ func (s *state) evalFunc(node *ast.FunctionNode) data.Value {
 		}()
 		r := fn.Apply(args)
 		if r == nil {
-			return data.Null{}
+			r = data.Null{}
+		}
+		if constant {
+			s.pure.results.Store(node, r)
 		}
 		return r
 	}
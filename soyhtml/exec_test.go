@@ -62,6 +62,34 @@ func TestBasicExec(t *testing.T) {
 	{/template}`,
 			"Hello Rob!",
 			d{"name": "Rob"}, true},
+		{"header param defaults", "test.caller",
+			`{namespace test}
+
+	{template .caller}
+	{call .greet /}{sp}
+	{call .greet}{param name: 'Rob' /}{param n: 5 /}{/call}{sp}
+	{call .greet}{param greeting: null /}{/call}
+	{/template}
+
+	{template .greet}
+	{@param? greeting: string|null = 'Hello'}
+	{@param name:= 'world'}
+	{@param? n: int = 1 + 1}
+	{$greeting} {$name} {$n}
+	{/template}`,
+			"Hello world 2 Hello Rob 5 null world 2",
+			nil, true},
+		{"header param defaults w/o data", "test.greet",
+			`{namespace test}
+
+	{template .greet}
+	{@param? greeting: string|null = 'Hello'}
+	{@param name:= 'world'}
+	{@param? n: int = 1 + 1}
+	{$greeting} {$name} {$n}
+	{/template}`,
+			"Hello world 2",
+			nil, true},
 
 		{"call w/ line join", "test.callLine",
 			`{namespace test}
@@ -113,6 +141,19 @@ func TestExpressions(t *testing.T) {
 	})
 }
 
+// TestStrFuncsUTF16 checks that the string functions index strings in UTF-16
+// code units like javascript.  The shared tests of the standard functions can
+// not check this, since otto indexes strings inconsistently.
+func TestStrFuncsUTF16(t *testing.T) {
+	runExecTests(t, []execTest{
+		exprtest("strIndexOf accent", "{strIndexOf('héllo', 'l')}", "2"),
+		exprtest("strIndexOf astral", "{strIndexOf('😀abc', 'b')}", "3"),
+		exprtest("strSub accent", "{strSub('héllo', 1, 2)}", "é"),
+		exprtest("strLen accent", "{strLen('héllo')}", "5"),
+		exprtest("strLen astral", "{strLen('😀')}", "2"),
+	})
+}
+
 func TestIf(t *testing.T) {
 	runExecTests(t, multidatatest("if", `
 {if $zoo}{$zoo}{/if}
@@ -485,6 +526,26 @@ func TestPrintDirectives(t *testing.T) {
 	})
 }
 
+func TestPrintDirectiveError(t *testing.T) {
+	var tree, err = parse.SoyFile("directive.soy", `{namespace test}
+{template .formatNum}
+{$x|formatNum:'decimal','latn',$x}
+{/template}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry = template.Registry{}
+	if err = registry.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+	err = NewTofu(&registry).NewRenderer("test.formatNum").Execute(new(bytes.Buffer), data.Map{"x": data.Float(1.5)})
+	if err == nil || !strings.Contains(err.Error(), "fraction digits must be an integer, got 1.5") {
+		t.Errorf("expected an error for the fraction digits, got %v", err)
+	} else if strings.Contains(err.Error(), "goroutine") {
+		t.Errorf("expected no stack trace, got %v", err)
+	}
+}
+
 func TestObligatoryDirectives(t *testing.T) {
 	ObligatoryPrintDirectiveNames = []string{"noAutoescape"}
 	runExecTests(t, []execTest{
@@ -493,6 +554,59 @@ func TestObligatoryDirectives(t *testing.T) {
 	ObligatoryPrintDirectiveNames = []string{}
 }
 
+func TestTofuConfig(t *testing.T) {
+	var tree, err = parse.SoyFile("config.soy", `{namespace test}
+{template .hello}
+{log}Greeted Rob{/log}
+{greet('Rob')}
+{/template}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry template.Registry
+	if err = registry.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	var greeting = func(word string) Func {
+		return Func{func(args []data.Value) data.Value {
+			return data.String(word + ", " + args[0].String() + "!")
+		}, []int{1}}
+	}
+	var obligatory = []string{"escapeUri"}
+	var hello = NewTofu(&registry).
+		WithFuncs(map[string]Func{"greet": greeting("Hello")}).
+		WithObligatoryPrintDirectives(obligatory...)
+	obligatory[0] = "noAutoescape" // the tofu keeps its own copy
+	var hi = NewTofu(&registry).
+		WithFuncs(map[string]Func{"greet": greeting("Hi")})
+	if _, ok := Funcs["greet"]; ok {
+		t.Error("expected the default functions to be unchanged")
+	}
+
+	for i := 0; i < 2; i++ {
+		var logged, buf bytes.Buffer
+		if err = hello.NewRenderer("test.hello").WithLogger(log.New(&logged, "", 0)).Execute(&buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "Hello%2C+Rob%21" || logged.String() != "Greeted Rob\n" {
+			t.Errorf("got %q, logged %q", buf.String(), logged.String())
+		}
+	}
+	var print = tree.Body[1].(*ast.TemplateNode).Body.Nodes[1].(*ast.PrintNode)
+	if len(print.Directives) != 0 {
+		t.Errorf("expected the obligatory directives not to be added to the template, got %v", print)
+	}
+
+	var buf bytes.Buffer
+	if err = hi.WithLogger(nil).NewRenderer("test.hello").Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "Hi, Rob!" {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
 func TestGlobals(t *testing.T) {
 	globals["app.global_str"] = data.New("abc")
 	globals["GLOBAL_INT"] = data.New(5)
@@ -575,6 +689,265 @@ func TestAutoescapeModes(t *testing.T) {
 	})
 }
 
+func TestContextualAutoescape(t *testing.T) {
+	runExecTests(t, []execTest{
+		contextualtest("text", `<b>{$x}</b>`, `<b>&lt;i&gt;&quot;&#39;&amp;</b>`, d{"x": `<i>"'&`}),
+		contextualtest("text directive", `<b title="{$x|text}">{$x|text}</b>`,
+			`<b title="&lt;script&gt;">&lt;script&gt;</b>`, d{"x": "<script>"}),
+		contextualtest("rcdata", `<textarea>{$x}</textarea>`, `<textarea>&lt;/textarea&gt;</textarea>`,
+			d{"x": "</textarea>"}),
+		contextualtest("element name", `<{$x}>`, `<zSoyz>`, d{"x": "script"}),
+		contextualtest("element name ok", `<h{$x}>`, `<h1>`, d{"x": 1}),
+		contextualtest("attribute name", `<div {$x}="1">`, `<div zSoyz="1">`, d{"x": "onclick"}),
+		contextualtest("attribute name ok", `<div {$x}="1">`, `<div title="1">`, d{"x": "title"}),
+		contextualtest("quoted attr", `<div title="{$x}">`, `<div title="a &quot;b&quot; &lt;c&gt;">`,
+			d{"x": `a "b" <c>`}),
+		contextualtest("single quoted attr", `<div title='{$x}'>`, `<div title='&#39;'>`, d{"x": `'`}),
+		contextualtest("unquoted attr", `<div title={$x}>`, `<div title=a&#32;b&#61;c>`, d{"x": `a b=c`}),
+		contextualtest("url", `<a href="{$x}">`, `<a href="#zSoyz">`, d{"x": "javascript:alert(1)"}),
+		contextualtest("url ok", `<a href="{$x}">`, `<a href="http://a.com/?a=1&amp;b=%3C2%3E">`,
+			d{"x": "http://a.com/?a=1&b=<2>"}),
+		contextualtest("url path", `<a href="/foo/{$x}">`, `<a href="/foo/a%20b">`, d{"x": "a b"}),
+		contextualtest("url query", `<a href="/foo?q={$x}&amp;r={$y}">`,
+			`<a href="/foo?q=a%20b%26c&amp;r=%2F%27">`, d{"x": "a b&c", "y": "/'"}),
+		contextualtest("script value", `<script>var x = {$x}, y = {$y};</script>`,
+			`<script>var x = 'a\x27b\x3c\/script\x3e', y =  5 ;</script>`,
+			d{"x": "a'b</script>", "y": 5}),
+		contextualtest("script string", `<script>var x = "{$x}";</script>`,
+			`<script>var x = "\x22\n";</script>`, d{"x": "\"\n"}),
+		contextualtest("script regexp", `<script>var x = /{$x}/;</script>`,
+			`<script>var x = /a\x2eb\x2a/;</script>`, d{"x": "a.b*"}),
+		contextualtest("script after string", `<script>var x = 'a' + {$x};</script>`,
+			`<script>var x = 'a' + 'b';</script>`, d{"x": "b"}),
+		contextualtest("after script", `<script>var x;</script>{$x}`,
+			`<script>var x;</script>&lt;b&gt;`, d{"x": "<b>"}),
+		contextualtest("event handler", `<a onclick="f({$x})">`,
+			`<a onclick="f(&#39;\x27);alert(1);\x27&#39;)">`, d{"x": "');alert(1);'"}),
+		contextualtest("event handler string", `<a onclick="f('{$x}')">`,
+			`<a onclick="f('\x27\x22')">`, d{"x": `'"`}),
+		contextualtest("style attr", `<div style="color: {$x}">`, `<div style="color: zSoyz">`,
+			d{"x": "expression(alert(1))"}),
+		contextualtest("style attr ok", `<div style="color: {$x}">`, `<div style="color: red">`,
+			d{"x": "red"}),
+		contextualtest("style element", `<style>p {lb} color: {$x} {rb} p:after {lb} content: "{$y}" {rb}</style>`,
+			`<style>p { color: #fff } p:after { content: "\3c \2f style\3e " }</style>`,
+			d{"x": "#fff", "y": "</style>"}),
+		contextualtest("css url", `<style>p {lb} background: url({$x}) {rb}</style>`,
+			`<style>p { background: url(#zSoyz) }</style>`, d{"x": "javascript:alert(1)"}),
+		contextualtest("css quoted url", `<div style="background: url('/img/{$x}')">`,
+			`<div style="background: url('/img/a%27%28')">`, d{"x": "a'("}),
+		contextualtest("msg", `{msg desc=""}<a title="{$x}">{/msg}`, `<a title="&lt;">`, d{"x": "<"}),
+		contextualtest("noAutoescape", `<script>{$x|noAutoescape}</script>`, `<script>alert(1)</script>`,
+			d{"x": "alert(1)"}),
+		contextualtest("html comment", `<!-- {$x} -->`, `<!-- `, d{"x": "a"}).fails(),
+		contextualtest("js comment", `<script>// {$x}</script>`, `<script>// `, d{"x": "a"}).fails(),
+
+		{"call in attribute", "test.caller", `{namespace test autoescape="contextual"}
+
+{template .caller}
+<a href="{call .callee data="all"/}">
+{/template}
+
+{template .callee}
+{$x}
+{/template}`,
+			`<a href="#zSoyz">`,
+			d{"x": "javascript:alert(1)"},
+			true,
+		},
+
+		{"strict", "test.strict", `{namespace test}
+
+{template .strict autoescape="strict"}
+<a href="{$x}">{$y}</a>
+{/template}`,
+			`<a href="#zSoyz">&lt;b&gt;</a>`,
+			d{"x": "javascript:alert(1)", "y": "<b>"},
+			true,
+		},
+	})
+}
+
+func TestContentKinds(t *testing.T) {
+	runExecTests(t, []execTest{
+		{"html param not double-escaped", "test.caller", `{namespace test autoescape="strict"}
+
+{template .caller}
+{call .callee}
+  {param content kind="html"}<b>{$x}</b>{/param}
+{/call}
+{/template}
+
+{template .callee}
+<div>{$content}</div>
+{/template}`,
+			`<div><b>&lt;i&gt;</b></div>`,
+			d{"x": "<i>"},
+			true,
+		},
+
+		{"html let in attribute", "test.let", `{namespace test autoescape="strict"}
+
+{template .let}
+{let $title kind="html"}<b>a</b>{/let}
+<div title="{$title}">{$title}</div>
+{/template}`,
+			`<div title="a"><b>a</b></div>`,
+			d{},
+			true,
+		},
+
+		{"uri let", "test.let", `{namespace test autoescape="strict"}
+
+{template .let}
+{let $href kind="uri"}/search?q={$q}{/let}
+<a href="{$href}">{$href}</a>
+{/template}`,
+			`<a href="/search?q=a%20b">/search?q=a%20b</a>`,
+			d{"q": "a b"},
+			true,
+		},
+
+		{"attributes let", "test.let", `{namespace test autoescape="strict"}
+
+{template .let}
+{let $attrs kind="attributes"}title="{$x}"{/let}
+<div {$attrs}>
+{/template}`,
+			`<div title="&lt;">`,
+			d{"x": "<"},
+			true,
+		},
+
+		{"text let", "test.let", `{namespace test autoescape="strict"}
+
+{template .let}
+{let $text kind="text"}<b>{$x}</b>{/let}
+{$text}
+{/template}`,
+			`&lt;b&gt;&lt;&lt;/b&gt;`,
+			d{"x": "<"},
+			true,
+		},
+
+		{"js let", "test.let", `{namespace test autoescape="strict"}
+
+{template .let}
+{let $js kind="js"}alert({$x}){/let}
+<script>{$js}</script><div title="{$js}">
+{/template}`,
+			`<script>alert('\x3c')</script><div title="alert(&#39;\x3c&#39;)">`,
+			d{"x": "<"},
+			true,
+		},
+
+		{"strict callee in attribute", "test.caller", `{namespace test autoescape="contextual"}
+
+{template .caller}
+<a href="{call .callee data="all"/}">
+{/template}
+
+{template .callee kind="uri"}
+/foo?q={$x}
+{/template}`,
+			`<a href="/foo?q=a%26b">`,
+			d{"x": "a&b"},
+			true,
+		},
+
+		{"strict html callee", "test.caller", `{namespace test}
+
+{template .caller autoescape="strict"}
+<div>{call .callee data="all"/}</div>
+{/template}
+
+{template .callee kind="html"}
+<b>{$x}</b>
+{/template}`,
+			`<div><b>&lt;</b></div>`,
+			d{"x": "<"},
+			true,
+		},
+
+		{"legacy template prints html", "test.let", `{namespace test}
+
+{template .let}
+{let $html kind="html"}<b>{$x}</b>{/let}
+{$html}
+{/template}`,
+			`<b>&lt;</b>`,
+			d{"x": "<"},
+			true,
+		},
+	})
+}
+
+func TestParamTypeChecks(t *testing.T) {
+	var tests = []struct {
+		name string
+		data d
+		err  string // expected error, or "" for success
+	}{
+		{"valid", d{"n": 1, "s": "a", "l": []interface{}{1.5, 2}, "r": d{"a": true, "b": nil}}, ""},
+		{"int", d{"n": "1", "s": "a", "l": []interface{}{}, "r": d{"a": true}},
+			`param "n": expected int, got string`},
+		{"missing", d{"s": "a", "l": []interface{}{}, "r": d{"a": true}},
+			`param "n": expected int, got null`},
+		{"list", d{"n": 1, "s": "a", "l": []interface{}{1, "2"}, "r": d{"a": true}},
+			`param "l": [1]: expected float, got string`},
+		{"record", d{"n": 1, "s": "a", "l": []interface{}{}, "r": d{"a": 1}},
+			`param "r": .a: expected bool, got int`},
+		{"callee", d{"n": 1, "s": "a", "l": []interface{}{}, "r": d{"a": true, "b": "x"}},
+			`param "x": expected int|null, got string`},
+	}
+
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+{template .typed}
+{@param n: int}
+{@param s: string}
+{@param l: list<float>}
+{@param r: [a: bool, b: string|null]}
+{$n}{$s}{$l}{$r.a}
+{call .callee}{param x: $r.b /}{/call}
+{/template}
+
+{template .callee}
+{@param? x: int}
+{$x}
+{/template}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry = template.Registry{}
+	if err = registry.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+	var tofu = NewTofu(&registry)
+	for _, test := range tests {
+		var buf bytes.Buffer
+		var err = tofu.NewRenderer("test.typed").
+			WithTypeChecks(true).
+			Execute(&buf, data.New(test.data).(data.Map))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%s: expected error %q, got none", test.name, test.err)
+		case test.err != "" && !strings.Contains(err.Error(), test.err):
+			t.Errorf("%s: expected error %q, got %q", test.name, test.err, err)
+		}
+	}
+
+	// Without type checks, the invalid values are printed.
+	var buf bytes.Buffer
+	err = tofu.NewRenderer("test.typed").Execute(&buf,
+		data.New(d{"n": "1", "s": "a", "l": []interface{}{}, "r": d{"a": 1, "b": nil}}).(data.Map))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 var helloWorldTemplate = `{namespace examples.simple}
 /**
  * Says hello to the world.
@@ -618,6 +991,46 @@ var helloWorldTemplate = `{namespace examples.simple}
 {/template}`
 
 // TestHelloWorld executes the Hello World tutorial on the Soy Templates site.
+func TestPrivateCheck(t *testing.T) {
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+{template .public}
+{call .private /}
+{/template}
+
+{template .private private="true"}
+Hello
+{/template}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry = template.Registry{}
+	if err = registry.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+	var tofu = NewTofu(&registry)
+	var tests = []struct {
+		name  string
+		check bool
+		err   error
+	}{
+		{"test.public", true, nil},
+		{"test.private", true, ErrPrivateTemplate},
+		{"test.private", false, nil},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		var err = tofu.NewRenderer(test.name).
+			WithPrivateCheck(test.check).
+			Execute(&buf, nil)
+		if err != test.err {
+			t.Errorf("%s (check %v): expected error %v, got %v", test.name, test.check, test.err, err)
+		} else if err == nil && buf.String() != "Hello" {
+			t.Errorf("%s (check %v): expected Hello, got %q", test.name, test.check, buf.String())
+		}
+	}
+}
+
 func TestHelloWorld(t *testing.T) {
 	runExecTests(t, []execTest{
 		{"no data", "examples.simple.helloWorld", helloWorldTemplate,
@@ -1268,6 +1681,13 @@ func exprtest(name, expr, result string) execTest {
 	return exprtestwdata(name, expr, result, nil)
 }
 
+func contextualtest(name, body, result string, data map[string]interface{}) execTest {
+	var tmplName = strings.Replace(name, " ", "_", -1)
+	return execTest{name, "test." + tmplName,
+		"{namespace test}{template ." + tmplName + " autoescape=\"contextual\"}" + body + "{/template}",
+		result, data, true}
+}
+
 type datatest struct {
 	data   map[string]interface{}
 	result string
@@ -1378,3 +1798,104 @@ func runNsExecTests(t *testing.T, tests []nsExecTest) {
 		}
 	}
 }
+
+func TestDelegates(t *testing.T) {
+	var tests = []struct {
+		name string
+		pkgs []string
+		data d
+		out  string // expected output, or "" for an error
+	}{
+		{"default", nil, d{}, "Hello, Rob. [] <>"},
+		{"variant", nil, d{"variant": "formal"}, "Good day, Rob. [] <>"},
+		{"int variant", nil, d{"variant": 2}, "Hi 2, Rob. [] <>"},
+		{"unknown variant falls back", nil, d{"variant": "other"}, "Hello, Rob. [] <>"},
+		{"active package", []string{"pirate"}, d{}, "Ahoy, Rob. [pirate] <>"},
+		{"active package variant", []string{"pirate"}, d{"variant": "formal"}, "Good day, Rob. [pirate] <>"},
+		{"inactive package", []string{"other"}, d{}, "Hello, Rob. [] <>"},
+		{"conflicting packages", []string{"pirate", "parrot"}, d{}, ""},
+	}
+
+	var files = []string{`{namespace test}
+
+{template .main}
+{@param? variant: string|int}
+{delcall greeting variant="$variant"}{param name: 'Rob' /}{/delcall}
+{sp}[{delcall marker allowemptydefault="true" /}]
+{sp}<{delcall missing allowemptydefault="true" variant="'x'" /}>
+{/template}
+
+{deltemplate greeting}
+{@param name: string}
+Hello, {$name}.
+{/deltemplate}
+
+{deltemplate greeting variant="'formal'"}
+{@param name: string}
+Good day, {$name}.
+{/deltemplate}
+
+{deltemplate greeting variant="2"}
+{@param name: string}
+Hi 2, {$name}.
+{/deltemplate}`, `{delpackage pirate}
+{namespace test.pirate}
+
+{deltemplate greeting}
+{@param name: string}
+Ahoy, {$name}.
+{/deltemplate}
+
+{deltemplate marker}
+pirate
+{/deltemplate}`, `{delpackage parrot}
+{namespace test.parrot}
+
+{deltemplate greeting}
+{@param name: string}
+Squawk, {$name}.
+{/deltemplate}`}
+
+	var registry = template.Registry{}
+	for _, file := range files {
+		var tree, err = parse.SoyFile("", file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = registry.Add(tree); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var tofu = NewTofu(&registry)
+	for _, test := range tests {
+		var buf bytes.Buffer
+		var err = tofu.NewRenderer("test.main").
+			WithDelegatePackages(test.pkgs...).
+			Execute(&buf, data.New(test.data).(data.Map))
+		switch {
+		case test.out == "" && err == nil:
+			t.Errorf("%s: expected an error, got %q", test.name, buf.String())
+		case test.out != "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		case buf.String() != test.out && err == nil:
+			t.Errorf("%s: expected %q, got %q", test.name, test.out, buf.String())
+		}
+	}
+
+	// A delegate with no implementation is an error, unless allowemptydefault.
+	var tree, err = parse.SoyFile("", `{namespace test.missing}
+{template .main}
+{delcall missing /}
+{/template}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = registry.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = tofu.NewRenderer("test.missing.main").Execute(&buf, data.Map{})
+	if err == nil {
+		t.Errorf("expected an error for a missing delegate, got %q", buf.String())
+	}
+}
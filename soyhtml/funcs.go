@@ -1,11 +1,17 @@
 package soyhtml
 
 import (
+	"errors"
 	"math"
 	"math/rand"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf16"
 
 	"github.com/robfig/soy/data"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
 )
 
 type loopFunc func(s *state, key string) data.Value
@@ -35,8 +41,9 @@ type Func struct {
 	ValidArgLengths []int
 }
 
-// Funcs contains the builtin Soy functions.
-// Callers may add their own functions to this map as well.
+// Funcs contains the builtin Soy functions, which each new Tofu starts with.
+// Callers may add their own functions to this map before creating a Tofu, or
+// to a Tofu with WithFuncs.
 var Funcs = map[string]Func{
 	"isNonnull":   {funcIsNonnull, []int{1}},
 	"length":      {funcLength, []int{1}},
@@ -51,6 +58,30 @@ var Funcs = map[string]Func{
 	"strContains": {funcStrContains, []int{2}},
 	"range":       {funcRange, []int{1, 2, 3}},
 	"hasData":     {funcHasData, []int{0}},
+
+	"strIndexOf":           {funcStrIndexOf, []int{2}},
+	"strSub":               {funcStrSub, []int{2, 3}},
+	"strLen":               {funcStrLen, []int{1}},
+	"strToUpperCase":       {funcStrToUpperCase, []int{1}},
+	"strToLowerCase":       {funcStrToLowerCase, []int{1}},
+	"strReplaceAll":        {funcStrReplaceAll, []int{3}},
+	"join":                 {funcJoin, []int{2}},
+	"concatLists":          {funcConcatLists, []int{2}},
+	"listContains":         {funcListContains, []int{2}},
+	"listIndexOf":          {funcListIndexOf, []int{2}},
+	"parseInt":             {funcParseInt, []int{1}},
+	"parseFloat":           {funcParseFloat, []int{1}},
+	"sqrt":                 {funcSqrt, []int{1}},
+	"pow":                  {funcPow, []int{2}},
+	"abs":                  {funcAbs, []int{1}},
+	"mapKeys":              {funcKeys, []int{1}},
+	"mapToLegacyObjectMap": {funcMapToLegacyObjectMap, []int{1}},
+	"checkNotNull":         {funcCheckNotNull, []int{1}},
+
+	"bidiGlobalDir": {funcBidiGlobalDir, []int{0}},
+	"bidiDirAttr":   {funcBidiDirAttr, []int{1, 2}},
+	"bidiStartEdge": {funcBidiStartEdge, []int{0}},
+	"bidiEndEdge":   {funcBidiEndEdge, []int{0}},
 }
 
 func funcIsNonnull(v []data.Value) data.Value {
@@ -176,3 +207,195 @@ func funcRange(v []data.Value) data.Value {
 func funcHasData(v []data.Value) data.Value {
 	return data.Bool(true)
 }
+
+// The string functions measure and index strings in UTF-16 code units, as
+// javascript does.
+
+func funcStrIndexOf(v []data.Value) data.Value {
+	var str = v[0].String()
+	var i = strings.Index(str, v[1].String())
+	if i == -1 {
+		return data.Int(-1)
+	}
+	return data.Int(utf16Len(str[:i]))
+}
+
+// funcStrSub returns the substring between the given indexes, which are
+// clamped to the string and swapped if out of order, like String.substring.
+func funcStrSub(v []data.Value) data.Value {
+	var str = utf16.Encode([]rune(v[0].String()))
+	var start, end = clamp(int(v[1].(data.Int)), len(str)), len(str)
+	if len(v) == 3 {
+		end = clamp(int(v[2].(data.Int)), len(str))
+	}
+	if start > end {
+		start, end = end, start
+	}
+	return data.String(utf16.Decode(str[start:end]))
+}
+
+func clamp(i, length int) int {
+	if i < 0 {
+		return 0
+	}
+	if i > length {
+		return length
+	}
+	return i
+}
+
+func funcStrLen(v []data.Value) data.Value {
+	return data.Int(utf16Len(v[0].String()))
+}
+
+func utf16Len(str string) int {
+	return len(utf16.Encode([]rune(str)))
+}
+
+// funcStrToUpperCase uppercases a string with the full case mapping, as
+// javascript does, e.g. "ß" to "SS".
+func funcStrToUpperCase(v []data.Value) data.Value {
+	return data.String(cases.Upper(language.Und).String(v[0].String()))
+}
+
+// funcStrToLowerCase lowercases a string with the full case mapping, as
+// javascript does, e.g. "İ" to "i̇" and a final "Σ" to "ς".
+func funcStrToLowerCase(v []data.Value) data.Value {
+	return data.String(cases.Lower(language.Und).String(v[0].String()))
+}
+
+// funcStrReplaceAll replaces each occurrence of a substring.  An empty
+// substring is not replaced, as in the javascript runtime.
+func funcStrReplaceAll(v []data.Value) data.Value {
+	var str, substring = v[0].String(), v[1].String()
+	if substring == "" {
+		return data.String(str)
+	}
+	return data.String(strings.Join(strings.Split(str, substring), v[2].String()))
+}
+
+// funcJoin joins the items of a list with a separator.  Null items are joined
+// as empty strings, as javascript does.
+func funcJoin(v []data.Value) data.Value {
+	var list = v[0].(data.List)
+	var items = make([]string, len(list))
+	for i, item := range list {
+		switch item.(type) {
+		case data.Null, data.Undefined:
+		default:
+			items[i] = item.String()
+		}
+	}
+	return data.String(strings.Join(items, v[1].String()))
+}
+
+func funcConcatLists(v []data.Value) data.Value {
+	var l1, l2 = v[0].(data.List), v[1].(data.List)
+	var result = make(data.List, 0, len(l1)+len(l2))
+	return append(append(result, l1...), l2...)
+}
+
+func funcListContains(v []data.Value) data.Value {
+	return data.Bool(listIndexOf(v[0].(data.List), v[1]) != -1)
+}
+
+func funcListIndexOf(v []data.Value) data.Value {
+	return data.Int(listIndexOf(v[0].(data.List), v[1]))
+}
+
+func listIndexOf(list data.List, val data.Value) int {
+	for i, item := range list {
+		if item.Equals(val) {
+			return i
+		}
+	}
+	return -1
+}
+
+var (
+	intPattern   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	floatPattern = regexp.MustCompile(`^[-+]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][-+]?[0-9]+)?$`)
+)
+
+// funcParseInt returns the integer in the given string, or null if it is not
+// one.
+func funcParseInt(v []data.Value) data.Value {
+	var str = v[0].String()
+	if !intPattern.MatchString(str) {
+		return data.Null{}
+	}
+	var i, err = strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return data.Null{}
+	}
+	return data.Int(i)
+}
+
+// funcParseFloat returns the number in the given string, or null if it is not
+// one.
+func funcParseFloat(v []data.Value) data.Value {
+	var str = v[0].String()
+	if !floatPattern.MatchString(str) {
+		return data.Null{}
+	}
+	var f, err = strconv.ParseFloat(str, 64)
+	if err != nil {
+		return data.Null{}
+	}
+	return data.Float(f)
+}
+
+func funcSqrt(v []data.Value) data.Value {
+	return data.Float(math.Sqrt(toFloat(v[0])))
+}
+
+func funcPow(v []data.Value) data.Value {
+	return data.Float(math.Pow(toFloat(v[0]), toFloat(v[1])))
+}
+
+func funcAbs(v []data.Value) data.Value {
+	if i, ok := v[0].(data.Int); ok {
+		if i < 0 {
+			return -i
+		}
+		return i
+	}
+	return data.Float(math.Abs(toFloat(v[0])))
+}
+
+func funcMapToLegacyObjectMap(v []data.Value) data.Value {
+	return v[0].(data.Map)
+}
+
+func funcCheckNotNull(v []data.Value) data.Value {
+	switch v[0].(type) {
+	case data.Null, data.Undefined:
+		panic(errors.New("unexpected null value"))
+	}
+	return v[0]
+}
+
+// The bidi functions assume that the global directionality is left-to-right.
+
+func funcBidiGlobalDir(v []data.Value) data.Value {
+	return data.Int(1)
+}
+
+// funcBidiDirAttr returns dir="rtl" if the given text is estimated to be
+// right-to-left, or else the empty string.  If the optional second argument is
+// true, the text is HTML, the tags and escapes of which are ignored.
+func funcBidiDirAttr(v []data.Value) data.Value {
+	var isHTML = len(v) == 2 && v[1].Truthy()
+	if bidiTextDir(v[0].String(), isHTML) < 0 {
+		return data.SanitizedHTMLAttributes(`dir="rtl"`)
+	}
+	return data.SanitizedHTMLAttributes("")
+}
+
+func funcBidiStartEdge(v []data.Value) data.Value {
+	return data.String("left")
+}
+
+func funcBidiEndEdge(v []data.Value) data.Value {
+	return data.String("right")
+}
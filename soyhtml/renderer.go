@@ -4,6 +4,7 @@ package soyhtml
 import (
 	"errors"
 	"io"
+	"log"
 
 	"github.com/robfig/soy/ast"
 	"github.com/robfig/soy/data"
@@ -12,6 +13,10 @@ import (
 
 var ErrTemplateNotFound = errors.New("template not found")
 
+// ErrPrivateTemplate is returned when rendering a template declared
+// private="true" directly, if the renderer refuses to (see WithPrivateCheck).
+var ErrPrivateTemplate = errors.New("template is private")
+
 // Renderer provides parameters to template execution.
 // At minimum, Registry and Template are required to render a template..
 type Renderer struct {
@@ -19,6 +24,11 @@ type Renderer struct {
 	name string   // fully-qualified name of the template to render
 	ij   data.Map // data for the $ij map
 	msgs soymsg.Bundle
+
+	checkTypes   bool        // validate template data against declared param types
+	checkPrivate bool        // refuse to render private templates directly
+	delPkgs      []string    // active delegate packages
+	logger       *log.Logger // receives the output of {log} commands
 }
 
 // Inject sets the given data map as the $ij injected data.
@@ -33,20 +43,59 @@ func (r *Renderer) WithMessages(bundle soymsg.Bundle) *Renderer {
 	return r
 }
 
+// WithTypeChecks sets whether the data passed to each template is validated
+// against the types declared by its {@param} headers.  Mismatches are reported
+// as errors, instead of failing (or not) wherever the value is used.
+func (r *Renderer) WithTypeChecks(check bool) *Renderer {
+	r.checkTypes = check
+	return r
+}
+
+// WithPrivateCheck sets whether rendering a template declared private="true"
+// is refused with ErrPrivateTemplate.  Private templates are meant to be called
+// only by the other templates in their file, which they still may be.
+func (r *Renderer) WithPrivateCheck(check bool) *Renderer {
+	r.checkPrivate = check
+	return r
+}
+
+// WithLogger sets the logger that collects output from {log} commands while
+// rendering, in place of the one configured on the Tofu.  If it is nil, the
+// output is discarded.
+func (r *Renderer) WithLogger(logger *log.Logger) *Renderer {
+	r.logger = logger
+	return r
+}
+
+// WithDelegatePackages sets the delegate packages that are active while
+// rendering.  A {delcall} renders the implementation of the delegate in an
+// active package, if there is one, or else the one not in any package.
+func (r *Renderer) WithDelegatePackages(pkgs ...string) *Renderer {
+	r.delPkgs = pkgs
+	return r
+}
+
 // Execute applies a parsed template to the specified data object,
 // and writes the output to wr.
 func (t Renderer) Execute(wr io.Writer, obj data.Map) (err error) {
-	if t.tofu == nil || t.tofu.registry == nil {
+	if t.tofu == nil {
+		return errors.New("Template Registry required")
+	}
+	var registry = t.tofu.Registry()
+	if registry == nil {
 		return errors.New("Template Registry required")
 	}
 	if t.name == "" {
 		return errors.New("Template name required")
 	}
 
-	var tmpl, ok = t.tofu.registry.Template(t.name)
+	var tmpl, ok = registry.Template(t.name)
 	if !ok {
 		return ErrTemplateNotFound
 	}
+	if t.checkPrivate && tmpl.Node.Private {
+		return ErrPrivateTemplate
+	}
 
 	var autoescapeMode = tmpl.Namespace.Autoescape
 	if autoescapeMode == ast.AutoescapeUnspecified {
@@ -56,15 +105,20 @@ func (t Renderer) Execute(wr io.Writer, obj data.Map) (err error) {
 	var initialScope = newScope(obj)
 	initialScope.enter()
 
+	var config = t.tofu.config
+	config.logger = t.logger
 	state := &state{
+		config:     config,
 		tmpl:       tmpl,
-		registry:   *t.tofu.registry,
+		registry:   *registry,
 		namespace:  tmpl.Namespace.Name,
 		autoescape: autoescapeMode,
 		wr:         wr,
 		context:    initialScope,
 		ij:         t.ij,
 		msgs:       t.msgs,
+		checkTypes: t.checkTypes,
+		delPkgs:    t.delPkgs,
 	}
 	defer state.errRecover(&err)
 	state.walk(tmpl.Node)
@@ -3,21 +3,183 @@ package soyhtml
 import (
 	"fmt"
 	"io"
+	"log"
+	"sync"
+	"sync/atomic"
 
 	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/plugin"
 	"github.com/robfig/soy/template"
 )
 
 // Tofu is a bundle of compiled soy, ready to render to HTML.
+//
+// The registry of templates may be replaced while the Tofu is in use, e.g. by
+// a bundle watching its files for changes.  Each render uses the registry that
+// was current when it began.
+//
+// Each Tofu has its own functions, print directives and logger, which begin as
+// a copy of the package defaults (Funcs, PrintDirectives,
+// ObligatoryPrintDirectiveNames and Logger).  They may be changed with the
+// With methods before rendering, but not while renders are in progress.
 type Tofu struct {
-	registry *template.Registry
+	registry *atomic.Value // holds the current *template.Registry
+	config
+}
+
+// config holds the functions, print directives and logger used in rendering.
+type config struct {
+	funcs      map[string]Func
+	directives map[string]PrintDirective
+	obligatory []string    // names of the directives applied to every print
+	logger     *log.Logger // receives the output of {log} commands
+	pure       *pureCalls  // the pure plugins and the results of their calls
+}
+
+// pureCalls holds the names of the functions and print directives that were
+// added as pure plugins, and the results of their calls whose arguments are
+// constant, by the node of the call.
+type pureCalls struct {
+	funcs      map[string]bool
+	directives map[string]bool
+	results    sync.Map // ast.Node => data.Value
+}
+
+// reset forgets the results, since the functions or templates that they came
+// from have been replaced.  It is safe to call while renders are in progress.
+func (p *pureCalls) reset() {
+	p.results.Range(func(key, _ interface{}) bool {
+		p.results.Delete(key)
+		return true
+	})
+}
+
+// defaultConfig returns a copy of the package defaults, so that later changes
+// to them do not affect it.
+func defaultConfig() config {
+	var c = config{
+		funcs:      make(map[string]Func, len(Funcs)),
+		directives: make(map[string]PrintDirective, len(PrintDirectives)),
+		obligatory: append([]string(nil), ObligatoryPrintDirectiveNames...),
+		logger:     Logger,
+		pure:       &pureCalls{funcs: make(map[string]bool), directives: make(map[string]bool)},
+	}
+	for name, fn := range Funcs {
+		c.funcs[name] = fn
+	}
+	for name, directive := range PrintDirectives {
+		c.directives[name] = directive
+	}
+	return c
 }
 
 // NewTofu returns a new instance that is ready to provide HTML rendering
-// services for the given templates, with the default functions and print
-// directives.
+// services for the given templates, with a copy of the default functions and
+// print directives.
 func NewTofu(registry *template.Registry) *Tofu {
-	return &Tofu{registry}
+	var tofu = &Tofu{registry: &atomic.Value{}, config: defaultConfig()}
+	tofu.SetRegistry(registry)
+	return tofu
+}
+
+// WithFuncs adds the given functions to those available to the templates,
+// replacing any of the same name.
+func (tofu *Tofu) WithFuncs(funcs map[string]Func) *Tofu {
+	for name, fn := range funcs {
+		tofu.funcs[name] = fn
+		delete(tofu.pure.funcs, name)
+	}
+	tofu.pure.reset()
+	return tofu
+}
+
+// WithPrintDirectives adds the given print directives to those available to
+// the templates, replacing any of the same name.
+func (tofu *Tofu) WithPrintDirectives(directives map[string]PrintDirective) *Tofu {
+	for name, directive := range directives {
+		tofu.directives[name] = directive
+		delete(tofu.pure.directives, name)
+	}
+	tofu.pure.reset()
+	return tofu
+}
+
+// WithPlugins adds the Go implementations of the given plugins to the functions
+// and print directives available to the templates, replacing any of the same
+// name.  Plugins without a Go implementation are skipped.  The results of
+// pure plugins called with constant arguments are cached.
+func (tofu *Tofu) WithPlugins(set *plugin.Set) *Tofu {
+	for name, fn := range set.Funcs {
+		if fn.Go != nil {
+			tofu.funcs[name] = Func{fn.Go, fn.ValidArgLengths()}
+			tofu.pure.funcs[name] = fn.Pure
+		}
+	}
+	for name, d := range set.PrintDirectives {
+		if d.Go != nil {
+			tofu.directives[name] = PrintDirective{d.Go, d.ValidArgLengths(), d.CancelAutoescape}
+			tofu.pure.directives[name] = d.Pure
+		}
+	}
+	tofu.pure.reset()
+	return tofu
+}
+
+// WithObligatoryPrintDirectives sets the print directives applied to every
+// print, after those given in the template.  They can't take arguments.
+func (tofu *Tofu) WithObligatoryPrintDirectives(names ...string) *Tofu {
+	tofu.obligatory = append([]string(nil), names...)
+	return tofu
+}
+
+// WithLogger sets the logger that collects output from {log} commands.  If it
+// is nil, the output is discarded.
+func (tofu *Tofu) WithLogger(logger *log.Logger) *Tofu {
+	tofu.logger = logger
+	return tofu
+}
+
+// Arities returns the valid numbers of arguments of the functions and print
+// directives available to the templates, by name, e.g. for
+// soy.Bundle.AllowFuncs.
+func (tofu *Tofu) Arities() (funcs, directives map[string][]int) {
+	funcs = make(map[string][]int, len(tofu.funcs))
+	for name, fn := range tofu.funcs {
+		funcs[name] = fn.ValidArgLengths
+	}
+	directives = make(map[string][]int, len(tofu.directives))
+	for name, d := range tofu.directives {
+		directives[name] = d.ValidArgLengths
+	}
+	return funcs, directives
+}
+
+// NewTofuFromArtifact returns a new instance for the templates in the given
+// artifact, which was written by template.Registry.WriteArtifact.  The
+// templates are not parsed or checked again.
+func NewTofuFromArtifact(r io.Reader) (*Tofu, error) {
+	var registry, err = template.ReadArtifact(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewTofu(registry), nil
+}
+
+// Registry returns the registry of templates currently used for rendering.
+func (tofu *Tofu) Registry() *template.Registry {
+	if tofu.registry == nil {
+		return nil
+	}
+	var registry, _ = tofu.registry.Load().(*template.Registry)
+	return registry
+}
+
+// SetRegistry atomically replaces the registry of templates used for
+// rendering.  Renders that are in progress complete using the registry that
+// they started with.
+func (tofu *Tofu) SetRegistry(registry *template.Registry) {
+	tofu.registry.Store(registry)
+	tofu.pure.reset()
 }
 
 // Render is a convenience function that executes the Soy template of the given
@@ -44,7 +206,8 @@ func (tofu Tofu) Render(wr io.Writer, name string, obj interface{}) error {
 // fully-qualified name of the template to render.
 func (tofu *Tofu) NewRenderer(name string) *Renderer {
 	return &Renderer{
-		tofu: tofu,
-		name: name,
+		tofu:   tofu,
+		name:   name,
+		logger: tofu.logger,
 	}
 }
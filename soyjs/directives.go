@@ -3,21 +3,38 @@ package soyjs
 // PrintDirective represents a transformation applied when printing a value.
 type PrintDirective struct {
 	Name             string
+	ValidArgLengths  []int
 	CancelAutoescape bool
 }
 
-// PrintDirectives are the builtin print directives.
-// Callers may add their own print directives to this map.
+// escapeHtmlDirective is applied to prints by autoescaping.
+var escapeHtmlDirective = PrintDirective{"soy.$$escapeHtml", []int{0}, true}
+
+// PrintDirectives are the builtin print directives, used unless others are
+// given in the Options.  Callers may add their own print directives to this
+// map, or to a copy of it given in the Options.
 var PrintDirectives = map[string]PrintDirective{
-	"insertWordBreaks":  {"soy.$$insertWordBreaks", true},
-	"changeNewlineToBr": {"soy.$$changeNewlineToBr", true},
-	"truncate":          {"soy.$$truncate", false},
-	"id":                {"", true}, // visitPrint() will turn into a noop
-	"noAutoescape":      {"", true}, // visitPrint() will turn into a noop
-	"escapeHtml":        {"soy.$$escapeHtml", true},
-	"escapeUri":         {"soy.$$escapeUri", true},
-	"escapeJsString":    {"soy.$$escapeJsString", true},
-	"bidiSpanWrap":      {"soy.$$bidiSpanWrap", false},
-	"bidiUnicodeWrap":   {"soy.$$bidiUnicodeWrap", false},
-	"json":              {"JSON.stringify", true},
+	"insertWordBreaks":  {"soy.$$insertWordBreaks", []int{1}, true},
+	"changeNewlineToBr": {"soy.$$changeNewlineToBr", []int{0}, true},
+	"truncate":          {"soy.$$truncate", []int{1, 2}, false},
+	"id":                {"", []int{0}, true}, // visitPrint() will turn into a noop
+	"noAutoescape":      {"", []int{0}, true}, // visitPrint() will turn into a noop
+	"escapeHtml":        escapeHtmlDirective,
+	"escapeUri":         {"soy.$$escapeUri", []int{0}, true},
+	"escapeJsString":    {"soy.$$escapeJsString", []int{0}, true},
+	"bidiSpanWrap":      {"soy.$$bidiSpanWrap", []int{0}, false},
+	"bidiUnicodeWrap":   {"soy.$$bidiUnicodeWrap", []int{0}, false},
+	"json":              {"JSON.stringify", []int{0}, true},
+
+	"escapeHtmlAttribute":        {"soy.$$escapeHtmlAttribute", []int{0}, true},
+	"escapeHtmlAttributeNospace": {"soy.$$escapeHtmlAttributeNospace", []int{0}, true},
+	"escapeJsValue":              {"soy.$$escapeJsValue", []int{0}, true},
+	"escapeJsRegex":              {"soy.$$escapeJsRegex", []int{0}, true},
+	"escapeCssString":            {"soy.$$escapeCssString", []int{0}, true},
+	"filterCssValue":             {"soy.$$filterCssValue", []int{0}, true},
+	"filterNormalizeUri":         {"soy.$$filterNormalizeUri", []int{0}, true},
+	"normalizeUri":               {"soy.$$normalizeUri", []int{0}, true},
+	"filterImageDataUri":         {"soy.$$filterImageDataUri", []int{0}, true},
+	"text":                       {"String", []int{0}, false},
+	"formatNum":                  {"soy.$$formatNum", []int{0, 1, 2, 3, 4}, false},
 }
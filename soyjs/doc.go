@@ -5,6 +5,26 @@ It fulfills the same interface as the javascript produced by the official Soy
 compiler and should work as a drop-in replacement.
 https://developers.google.com/closure/templates/docs/javascript_usage
 
+The generated javascript requires the runtime in the lib sub-directory: either
+soyutils.js, or soyutils_usegoog.js for use with the Closure Library, followed
+by soyutils_ext.js, which adds the functions and print directives that those
+lack.
+
+The soyc command in the soyc sub-directory compiles Soy files and directories
+to Javascript, with flags to choose the formatter, output layout and messages:
+
+	soyc -formatter closure -layout namespace -outdir js/ views/
+
+For use with the Closure Compiler, the ClosureFormatter declares each namespace
+with goog.provide, and the GoogModuleFormatter declares it as a goog.module.
+Both goog.require the namespaces of called templates and soyutils_usegoog.js.
+Since a goog.module may only be declared by one file, the GoogModuleFormatter
+should be used with one file per namespace.
+
+For use from TypeScript, WriteDeclarations (or soyc -dts) generates a .d.ts
+file declaring the templates of a Soy file, with their params typed by their
+{@param} headers.
+
 It is presently alpha quality.  See ../TODO for unimplemented features.
 */
 package soyjs
@@ -0,0 +1,199 @@
+package soyjs
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/template"
+	"github.com/robfig/soy/types"
+)
+
+// DeclarationGenerator provides an interface to a template registry capable of
+// generating TypeScript declarations for the javascript generated from it.
+type DeclarationGenerator struct {
+	registry *template.Registry
+	options  Options
+}
+
+// NewDeclarationGenerator returns a new generator of TypeScript declarations
+// for the javascript generated with the given options from the templates in
+// the given registry.
+func NewDeclarationGenerator(registry *template.Registry, options Options) *DeclarationGenerator {
+	return &DeclarationGenerator{registry, options}
+}
+
+// WriteFile generates the TypeScript declarations (.d.ts) corresponding to the
+// Soy file of the given name.
+func (gen *DeclarationGenerator) WriteFile(out io.Writer, filename string) error {
+	for _, soyfile := range gen.registry.SoyFiles {
+		if soyfile.Name == filename {
+			return WriteDeclarations(out, soyfile, gen.options)
+		}
+	}
+	return ErrNotFound
+}
+
+// WriteDeclarations writes TypeScript declarations for the javascript generated
+// from the given Soy file with the given options.  Each template is declared
+// with an interface describing its params, typed by their {@param} headers.
+// Params declared in SoyDoc have type any.
+//
+// Templates are declared as exports of a module for the ES6Formatter and
+// GoogModuleFormatter, and as members of their namespace otherwise.  Private
+// and delegate templates are not declared, since they are not to be called
+// directly.
+func WriteDeclarations(out io.Writer, soyfile *ast.SoyFileNode, options Options) error {
+	var (
+		buf     bytes.Buffer
+		soydoc  *ast.SoyDocNode
+		current string // the namespace or module being declared
+	)
+	buf.WriteString("// This file was automatically generated from " + soyfile.Name + ".\n")
+	buf.WriteString("// Please don't edit this file by hand.\n")
+	for _, node := range soyfile.Body {
+		if doc, ok := node.(*ast.SoyDocNode); ok {
+			soydoc = doc
+			continue
+		}
+		var tmpl, ok = node.(*ast.TemplateNode)
+		if !ok {
+			continue
+		}
+		var doc = soydoc
+		soydoc = nil
+		if tmpl.Private || tmpl.Delegate != nil {
+			continue
+		}
+
+		var namespace, name = splitTemplateName(tmpl.Name)
+		var indent = "  "
+		switch options.Formatter.(type) {
+		case ES6Formatter, *ES6Formatter:
+			name, indent = ES6Identifier(tmpl.Name), ""
+			buf.WriteString("\n")
+		case GoogModuleFormatter, *GoogModuleFormatter:
+			if namespace != current {
+				if current != "" {
+					buf.WriteString("}\n")
+				}
+				buf.WriteString("\ndeclare module 'goog:" + namespace + "' {\n")
+				current = namespace
+			} else {
+				buf.WriteString("\n")
+			}
+		default:
+			if namespace != current {
+				if current != "" {
+					buf.WriteString("}\n")
+				}
+				buf.WriteString("\ndeclare namespace " + namespace + " {\n")
+				current = namespace
+			} else {
+				buf.WriteString("\n")
+			}
+		}
+		writeDeclaration(&buf, indent, name, tmpl, doc)
+	}
+	if current != "" {
+		buf.WriteString("}\n")
+	}
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+// writeDeclaration writes the declaration of the given template function, and
+// the interface of its params, with the given name.  Declarations that are not
+// indented are at the top level of a module, and so are exported.
+func writeDeclaration(buf *bytes.Buffer, indent, name string, tmpl *ast.TemplateNode, soydoc *ast.SoyDocNode) {
+	var (
+		fields      []string
+		allOptional = true
+	)
+	if soydoc != nil {
+		for _, param := range soydoc.Params {
+			var field = param.Name + ": any;"
+			if param.Optional {
+				field = param.Name + "?: any;"
+			} else {
+				allOptional = false
+			}
+			fields = append(fields, field)
+		}
+	}
+	for _, param := range tmpl.Params {
+		var field = param.Name + ": " + tsType(headerParamType(param)) + ";"
+		if param.Optional || param.Default != nil {
+			field = param.Name + "?: " + tsType(headerParamType(param)) + ";"
+		} else {
+			allOptional = false
+		}
+		fields = append(fields, field)
+	}
+
+	// Members of ambient namespaces and modules are exported implicitly.
+	var export string
+	if indent == "" {
+		export = "export "
+	}
+	var dataType = "{}"
+	if len(fields) > 0 {
+		dataType = name + "Params"
+		buf.WriteString(indent + export + "interface " + dataType + " {\n")
+		for _, field := range fields {
+			buf.WriteString(indent + "  " + field + "\n")
+		}
+		buf.WriteString(indent + "}\n\n")
+	}
+	var dataParam = "opt_data: "
+	if allOptional {
+		dataParam = "opt_data?: "
+	}
+	buf.WriteString(indent + export + "function " + name + "(" + dataParam + dataType +
+		", opt_sb?: unknown, opt_ijData?: {[key: string]: unknown}): string;\n")
+}
+
+// tsType returns the TypeScript type for the given Soy type.  Values of the
+// content kind types may be given as strings or sanitized content objects.
+func tsType(t types.Type) string {
+	switch t := t.(type) {
+	case types.Primitive:
+		switch t {
+		case types.Any:
+			return "unknown"
+		case types.Null:
+			return "null"
+		case types.Bool:
+			return "boolean"
+		case types.Int, types.Float:
+			return "number"
+		case types.String:
+			return "string"
+		}
+		if _, ok := sanitizedTypes[t]; ok {
+			return "string | object"
+		}
+	case types.List:
+		return "ReadonlyArray<" + tsType(t.Elem) + ">"
+	case types.Map:
+		var key = "string"
+		if t.Key == types.Int || t.Key == types.Float {
+			key = "number"
+		}
+		return "{[key: " + key + "]: " + tsType(t.Value) + "}"
+	case types.Record:
+		var fields = make([]string, len(t.Fields))
+		for i, field := range t.Fields {
+			fields[i] = field.Name + ": " + tsType(field.Type)
+		}
+		return "{" + strings.Join(fields, "; ") + "}"
+	case types.Union:
+		var members = make([]string, len(t.Members))
+		for i, member := range t.Members {
+			members[i] = tsType(member)
+		}
+		return strings.Join(members, " | ")
+	}
+	return "any"
+}
@@ -0,0 +1,128 @@
+package soyjs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/diff"
+	"github.com/robfig/soy"
+)
+
+func TestWriteDeclarations(t *testing.T) {
+	bundle := soy.NewBundle()
+	bundle.AddTemplateString("say_hello.soy", `{namespace say}
+
+/**
+ * @param name
+ * @param? greeting
+ */
+{template .hello}
+  {$greeting ?: 'Hello'} {$name}
+{/template}
+
+{template .typed}
+  {@param name: string}
+  {@param count:= 1}
+  {@param? nickname: string}
+  {@param? items: list<[id: int, tags: map<string, bool>]>}
+  {@param? body: html|null = null}
+  {$name}{$count}{$nickname}{$items}{$body}
+{/template}
+
+{template .private private="true"}
+  Hidden
+{/template}
+
+{template .empty}
+{/template}`)
+	registry, err := bundle.Compile()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var tests = []struct {
+		formatter JSFormatter
+		expected  string
+	}{
+		{nil, `// This file was automatically generated from say_hello.soy.
+// Please don't edit this file by hand.
+
+declare namespace say {
+  interface helloParams {
+    name: any;
+    greeting?: any;
+  }
+
+  function hello(opt_data: helloParams, opt_sb?: unknown, opt_ijData?: {[key: string]: unknown}): string;
+
+  interface typedParams {
+    name: string;
+    count?: number;
+    nickname?: string | null;
+    items?: ReadonlyArray<{id: number; tags: {[key: string]: boolean}}> | null;
+    body?: string | object | null;
+  }
+
+  function typed(opt_data: typedParams, opt_sb?: unknown, opt_ijData?: {[key: string]: unknown}): string;
+
+  function empty(opt_data?: {}, opt_sb?: unknown, opt_ijData?: {[key: string]: unknown}): string;
+}`},
+		{GoogModuleFormatter{}, `// This file was automatically generated from say_hello.soy.
+// Please don't edit this file by hand.
+
+declare module 'goog:say' {
+  interface helloParams {
+    name: any;
+    greeting?: any;
+  }
+
+  function hello(opt_data: helloParams, opt_sb?: unknown, opt_ijData?: {[key: string]: unknown}): string;
+
+  interface typedParams {
+    name: string;
+    count?: number;
+    nickname?: string | null;
+    items?: ReadonlyArray<{id: number; tags: {[key: string]: boolean}}> | null;
+    body?: string | object | null;
+  }
+
+  function typed(opt_data: typedParams, opt_sb?: unknown, opt_ijData?: {[key: string]: unknown}): string;
+
+  function empty(opt_data?: {}, opt_sb?: unknown, opt_ijData?: {[key: string]: unknown}): string;
+}`},
+		{ES6Formatter{}, `// This file was automatically generated from say_hello.soy.
+// Please don't edit this file by hand.
+
+export interface say__helloParams {
+  name: any;
+  greeting?: any;
+}
+
+export function say__hello(opt_data: say__helloParams, opt_sb?: unknown, opt_ijData?: {[key: string]: unknown}): string;
+
+export interface say__typedParams {
+  name: string;
+  count?: number;
+  nickname?: string | null;
+  items?: ReadonlyArray<{id: number; tags: {[key: string]: boolean}}> | null;
+  body?: string | object | null;
+}
+
+export function say__typed(opt_data: say__typedParams, opt_sb?: unknown, opt_ijData?: {[key: string]: unknown}): string;
+
+export function say__empty(opt_data?: {}, opt_sb?: unknown, opt_ijData?: {[key: string]: unknown}): string;`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		var gen = NewDeclarationGenerator(registry, Options{Formatter: test.formatter})
+		if err = gen.WriteFile(&buf, "say_hello.soy"); err != nil {
+			t.Error(err)
+			continue
+		}
+		if a, e := strings.TrimSpace(buf.String()), strings.TrimSpace(test.expected); a != e {
+			t.Errorf("%T: did not get expected results:\n%v", test.formatter, diff.LineDiff(e, a))
+		}
+	}
+}
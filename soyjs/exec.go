@@ -11,6 +11,7 @@ import (
 
 	"github.com/robfig/soy/ast"
 	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/errortypes"
 	"github.com/robfig/soy/soymsg"
 )
 
@@ -27,15 +28,22 @@ type state struct {
 	options      Options
 	funcsCalled  map[string]string
 	funcsInFile  map[string]bool
+	file         *ast.SoyFileNode // file being generated, for errors
+	importsAt    int              // offset in wr at which imports are written
 }
 
-func difference(a map[string]string, b map[string]bool) []string {
+// imports returns the sorted, distinct imports of the functions in a that are
+// not defined in b.
+func imports(a map[string]string, b map[string]bool) []string {
+	var seen = map[string]bool{}
 	new := []string{}
-	for key1 := range a {
-		if _, ok := b[key1]; !ok {
-			new = append(new, key1)
+	for key1, impt := range a {
+		if _, ok := b[key1]; !ok && !seen[impt] {
+			seen[impt] = true
+			new = append(new, impt)
 		}
 	}
+	sort.Strings(new)
 	return new
 }
 
@@ -47,6 +55,15 @@ func Write(out io.Writer, node ast.Node, options Options) (err error) {
 	if options.Formatter == nil {
 		options.Formatter = &ES5Formatter{}
 	}
+	if options.Funcs == nil {
+		options.Funcs = Funcs
+	}
+	if options.PrintDirectives == nil {
+		options.PrintDirectives = PrintDirectives
+	}
+	if options.Plugins != nil {
+		options.Funcs, options.PrintDirectives = withPlugins(options.Funcs, options.PrintDirectives, options.Plugins)
+	}
 
 	var (
 		tmpOut     = &bytes.Buffer{}
@@ -62,16 +79,20 @@ func Write(out io.Writer, node ast.Node, options Options) (err error) {
 	s.scope.push()
 	s.walk(node)
 
-	if len(s.funcsCalled) > 0 {
-		for _, f := range difference(s.funcsCalled, s.funcsInFile) {
-			importsBuf.WriteString(s.funcsCalled[f])
-			importsBuf.WriteRune('\n')
-		}
+	// Imports are written at the top of the file, unless the formatter
+	// declared the namespace, in which case they follow that declaration.
+	var impts = imports(s.funcsCalled, s.funcsInFile)
+	for _, impt := range impts {
+		importsBuf.WriteString(impt)
+		importsBuf.WriteRune('\n')
+	}
+	if len(impts) > 0 && s.importsAt == 0 {
 		importsBuf.WriteRune('\n')
 	}
 
+	out.Write(tmpOut.Bytes()[:s.importsAt])
 	out.Write(importsBuf.Bytes())
-	out.Write(tmpOut.Bytes())
+	out.Write(tmpOut.Bytes()[s.importsAt:])
 
 	return nil
 }
@@ -82,9 +103,20 @@ func (s *state) at(node ast.Node) {
 	s.node = node
 }
 
-// errorf formats the error and terminates processing.
+// errorf formats the error and terminates processing.  The error records the
+// position of the current node, if it is known.
 func (s *state) errorf(format string, args ...interface{}) {
-	panic(fmt.Sprintf(format, args...))
+	if s.file == nil || s.node == nil || int(s.node.Position()) > len(s.file.Text) {
+		panic(fmt.Sprintf(format, args...))
+	}
+	var src = s.file.Text[:s.node.Position()]
+	panic(errortypes.NewErrFilePosf(
+		s.file.Name,
+		1+strings.Count(src, "\n"),
+		len(src)-strings.LastIndex(src, "\n"),
+		format,
+		args...,
+	))
 }
 
 // errRecover is the handler that turns panics into returns from the top
@@ -92,6 +124,10 @@ func (s *state) errorf(format string, args ...interface{}) {
 func errRecover(errp *error) {
 	e := recover()
 	if e != nil {
+		if err, ok := e.(error); ok {
+			*errp = err
+			return
+		}
 		*errp = fmt.Errorf("%v", e)
 	}
 }
@@ -105,7 +141,7 @@ func (s *state) walk(node ast.Node) {
 		s.visitSoyFile(node)
 	case *ast.NamespaceNode:
 		s.visitNamespace(node)
-	case *ast.SoyDocNode:
+	case *ast.SoyDocNode, *ast.DelPackageNode:
 		return
 	case *ast.TemplateNode:
 		s.visitTemplate(node)
@@ -144,13 +180,15 @@ func (s *state) walk(node ast.Node) {
 		s.visitSwitch(node)
 	case *ast.CallNode:
 		s.visitCall(node)
+	case *ast.DelCallNode:
+		s.visitDelCall(node)
 	case *ast.LetValueNode:
 		s.jsln("var ", s.scope.makevar(node.Name), " = ", node.Expr, ";")
 	case *ast.LetContentNode:
 		var oldBufferName = s.bufferName
 		s.bufferName = s.scope.makevar(node.Name)
 		s.jsln("var ", s.bufferName, " = '';")
-		s.walk(node.Body)
+		s.walkContent(node.Body, node.Kind)
 		s.bufferName = oldBufferName
 
 	// Values ----------
@@ -250,6 +288,7 @@ func (s *state) walk(node ast.Node) {
 }
 
 func (s *state) visitSoyFile(node *ast.SoyFileNode) {
+	s.file = node
 	s.jsln("// This file was automatically generated from ", node.Name, ".")
 	s.jsln("// Please don't edit this file by hand.")
 	s.jsln("")
@@ -265,6 +304,13 @@ func (s *state) visitChildren(parent ast.ParentNode) {
 func (s *state) visitNamespace(node *ast.NamespaceNode) {
 	s.namespace = node.Name
 	s.autoescape = node.Autoescape
+	if f, ok := s.options.Formatter.(NamespaceFormatter); ok {
+		s.jsln(f.Namespace(node.Name))
+		if buf, ok := s.wr.(*bytes.Buffer); ok && s.importsAt == 0 {
+			s.importsAt = buf.Len()
+		}
+		return
+	}
 
 	// iterate through the dot segments.
 	var i = 0
@@ -289,10 +335,14 @@ func (s *state) visitTemplate(node *ast.TemplateNode) {
 	if node.Autoescape != ast.AutoescapeUnspecified {
 		s.autoescape = node.Autoescape
 	}
+	if node.Kind == data.KindText {
+		s.autoescape = ast.AutoescapeOff
+	}
 
 	// Determine if we need nullsafe initialization for opt_data
 	var allOptionalParams = false
-	if soydoc, ok := s.lastNode.(*ast.SoyDocNode); ok {
+	var soydoc, _ = s.lastNode.(*ast.SoyDocNode)
+	if soydoc != nil {
 		allOptionalParams = len(soydoc.Params) > 0
 		for _, param := range soydoc.Params {
 			if !param.Optional {
@@ -300,9 +350,21 @@ func (s *state) visitTemplate(node *ast.TemplateNode) {
 			}
 		}
 	}
+	if len(node.Params) > 0 {
+		allOptionalParams = true
+		for _, param := range node.Params {
+			if !param.Optional && param.Default == nil {
+				allOptionalParams = false
+			}
+		}
+	}
 
 	s.jsln("")
+	s.writeJSDoc(node, soydoc, allOptionalParams)
 	callName, callStyle := s.options.Formatter.Template(node.Name)
+	if f, ok := s.options.Formatter.(PrivateFormatter); ok && node.Private {
+		callName, callStyle = f.PrivateTemplate(node.Name)
+	}
 	s.jsln(callStyle, "(opt_data, opt_sb, opt_ijData) {")
 	s.funcsInFile[callName] = true
 	s.indentLevels++
@@ -313,20 +375,40 @@ func (s *state) visitTemplate(node *ast.TemplateNode) {
 	s.bufferName = "output"
 	s.scope.push()
 	defer s.scope.pop()
+	for _, param := range node.Params {
+		if param.Default != nil {
+			s.jsln("var ", s.scope.makevar(param.Name), " = opt_data.", param.Name,
+				" === undefined ? ", param.Default, " : opt_data.", param.Name, ";")
+		}
+	}
 	s.walk(node.Body)
 	s.jsln("return output;")
 	s.indentLevels--
 	s.jsln("};")
+	if f, ok := s.options.Formatter.(ModuleFormatter); ok && !node.Private {
+		s.jsln(f.Export(node.Name))
+	}
 	s.autoescape = oldAutoescape
+
+	// Delegates in a delpackage take priority over the default implementation
+	// when their package is loaded.
+	if node.Delegate != nil {
+		var priority = 0
+		if node.Delegate.Package != "" {
+			priority = 1
+		}
+		s.jsln("soy.$$registerDelegateFn(soy.$$getDelTemplateId('", node.Delegate.Name, "'), '",
+			template.JSEscapeString(node.Delegate.Variant), "', ", priority, ", ", callName, ");")
+	}
 }
 
-// TODO: unify print directives
 func (s *state) visitPrint(node *ast.PrintNode) {
 	var escape = s.autoescape
 	var directives []*ast.PrintDirectiveNode
 	for _, dir := range node.Directives {
-		var directive, ok = PrintDirectives[dir.Name]
+		var directive, ok = s.options.PrintDirectives[dir.Name]
 		if !ok {
+			s.at(dir)
 			s.errorf("Print directive %q not found", dir.Name)
 		}
 		if directive.CancelAutoescape {
@@ -349,7 +431,7 @@ func (s *state) visitPrint(node *ast.PrintNode) {
 	s.indent()
 	s.js(s.bufferName, " += ")
 	for _, dir := range directives {
-		s.js(PrintDirectives[dir.Name].Name, "(")
+		s.js(s.directive(dir.Name).Name, "(")
 	}
 	s.walk(node.Arg)
 	for i := range directives {
@@ -368,8 +450,17 @@ func (s *state) visitPrint(node *ast.PrintNode) {
 	s.js(";\n")
 }
 
+// directive returns the print directive of the given name.  The escapeHtml
+// directive applied by autoescaping is the builtin one, unless overridden.
+func (s *state) directive(name string) PrintDirective {
+	if directive, ok := s.options.PrintDirectives[name]; ok || name != "escapeHtml" {
+		return directive
+	}
+	return escapeHtmlDirective
+}
+
 func (s *state) visitFunction(node *ast.FunctionNode) {
-	if fn, ok := Funcs[node.Name]; ok {
+	if fn, ok := s.options.Funcs[node.Name]; ok {
 		fn.Apply(s, node.Args)
 		if impt := s.options.Formatter.Function(fn); impt != "" {
 			s.funcsCalled[node.Name] = impt
@@ -379,7 +470,6 @@ func (s *state) visitFunction(node *ast.FunctionNode) {
 
 	switch node.Name {
 	case "isFirst":
-		// TODO: Add compile-time check that this is only called on loop variable.
 		s.js("(", s.scope.loopindex(), " == 0)")
 	case "isLast":
 		s.js("(", s.scope.loopindex(), " == ", s.scope.looplimit(), " - 1)")
@@ -425,6 +515,37 @@ func (s *state) visitDataRef(node *ast.DataRefNode) {
 }
 
 func (s *state) visitCall(node *ast.CallNode) {
+	var dataExpr = s.callData(node)
+	callName, importString := s.options.Formatter.Call(node.Name)
+	if _, ok := s.options.Formatter.(ModuleFormatter); ok {
+		// Templates in the module being generated are called by their local
+		// definition.
+		if namespace, _ := splitTemplateName(node.Name); namespace == s.namespace {
+			callName, _ = s.options.Formatter.Template(node.Name)
+			importString = ""
+		}
+	}
+	s.jsln(s.bufferName, " += ", callName, "(", dataExpr, ", opt_sb, opt_ijData);")
+	if importString != "" {
+		s.funcsCalled[callName] = importString
+	}
+}
+
+func (s *state) visitDelCall(node *ast.DelCallNode) {
+	var variantExpr = "''"
+	if node.Variant != nil {
+		var variantVar = s.scope.tempvar("variant")
+		s.jsln("var ", variantVar, " = ", node.Variant, ";")
+		variantExpr = variantVar + " == null ? '' : " + variantVar
+	}
+	var dataExpr = s.callData(&node.CallNode)
+	s.jsln(s.bufferName, " += soy.$$getDelegateFn(soy.$$getDelTemplateId('", node.Name, "'), ",
+		variantExpr, ", ", node.AllowEmptyDefault, ")(", dataExpr, ", opt_sb, opt_ijData);")
+}
+
+// callData writes any content params of the given call, and returns the
+// expression for the data object to pass to the callee.
+func (s *state) callData(node *ast.CallNode) string {
 	var dataExpr = "{}"
 	if node.Data != nil {
 		dataExpr = s.block(node.Data)
@@ -445,17 +566,40 @@ func (s *state) visitCall(node *ast.CallNode) {
 				var oldBufferName = s.bufferName
 				s.bufferName = s.scope.makevar("param")
 				s.jsln("var ", s.bufferName, " = '';")
-				s.walk(param.Content)
+				s.walkContent(param.Content, param.Kind)
 				dataExpr += param.Key + ": " + s.bufferName
 				s.bufferName = oldBufferName
 			}
 		}
 		dataExpr += "})"
 	}
-	callName, importString := s.options.Formatter.Call(node.Name)
-	s.jsln(s.bufferName, " += ", callName, "(", dataExpr, ", opt_sb, opt_ijData);")
-	if importString != "" {
-		s.funcsCalled[callName] = importString
+	return dataExpr
+}
+
+// sanitizedContentFactories maps content kinds to the soyutils functions that
+// wrap a string as sanitized content of that kind.
+var sanitizedContentFactories = map[data.ContentKind]string{
+	data.KindHTML:       "soydata.VERY_UNSAFE.ordainSanitizedHtml",
+	data.KindAttributes: "soydata.VERY_UNSAFE.ordainSanitizedHtmlAttribute",
+	data.KindJS:         "soydata.VERY_UNSAFE.ordainSanitizedJs",
+	data.KindCSS:        "soydata.VERY_UNSAFE.ordainSanitizedCss",
+	data.KindURI:        "soydata.VERY_UNSAFE.ordainSanitizedUri",
+}
+
+// walkContent writes the given block of the given kind into the current
+// buffer.  Text blocks are not escaped, and the buffer of other kinds is
+// wrapped as sanitized content so that it is not escaped again when printed.
+func (s *state) walkContent(node ast.Node, kind data.ContentKind) {
+	if kind == data.KindText {
+		var oldAutoescape = s.autoescape
+		s.autoescape = ast.AutoescapeOff
+		s.walk(node)
+		s.autoescape = oldAutoescape
+		return
+	}
+	s.walk(node)
+	if factory, ok := sanitizedContentFactories[kind]; ok {
+		s.jsln(s.bufferName, " = ", factory, "(", s.bufferName, ");")
 	}
 }
 
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"os"
 	"strings"
 	"testing"
 
@@ -15,6 +14,8 @@ import (
 	"github.com/robfig/soy"
 	"github.com/robfig/soy/ast"
 	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/errortypes"
+	"github.com/robfig/soy/internal/ottotest"
 	"github.com/robfig/soy/parse"
 	"github.com/robfig/soy/parsepasses"
 	"github.com/robfig/soy/soymsg"
@@ -63,6 +64,34 @@ func TestBasicExec(t *testing.T) {
 	{/template}`,
 			"Hello Rob!",
 			d{"name": "Rob"}, true},
+		{"header param defaults", "test.caller",
+			`{namespace test}
+
+	{template .caller}
+	{call .greet /}{sp}
+	{call .greet}{param name: 'Rob' /}{param n: 5 /}{/call}{sp}
+	{call .greet}{param greeting: null /}{/call}
+	{/template}
+
+	{template .greet}
+	{@param? greeting: string|null = 'Hello'}
+	{@param name:= 'world'}
+	{@param? n: int = 1 + 1}
+	{$greeting} {$name} {$n}
+	{/template}`,
+			"Hello world 2 Hello Rob 5 null world 2",
+			nil, true},
+		{"header param defaults w/o data", "test.greet",
+			`{namespace test}
+
+	{template .greet}
+	{@param? greeting: string|null = 'Hello'}
+	{@param name:= 'world'}
+	{@param? n: int = 1 + 1}
+	{$greeting} {$name} {$n}
+	{/template}`,
+			"Hello world 2",
+			nil, true},
 
 		{"call w/ line join", "test.callLine",
 			`{namespace test}
@@ -698,6 +727,12 @@ func TestLet(t *testing.T) {
 {$gamma}`,
 			"0Boo!1Boo!2Boo!",
 			d{"boo": d{"foo": 3}}),
+		exprtestwdata("let kind", `
+{let $html kind="html"}<b>{$x}</b>{/let}
+{let $text kind="text"}<b>{$x}</b>{/let}
+{$html}{$text}`,
+			"<b>&lt;</b>&lt;b&gt;&lt;&lt;/b&gt;",
+			d{"x": "<"}),
 	})
 }
 
@@ -740,6 +775,74 @@ Hello world
 	})
 }
 
+func TestDelegates(t *testing.T) {
+	var caller = `
+{namespace test}
+
+{template .main}
+{@param? variant: string|int}
+{delcall greeting variant="$variant"}{param name: 'Rob' /}{/delcall}
+{sp}[{delcall marker allowemptydefault="true" /}]
+{/template}
+`
+	var defaults = `
+{namespace test.defaults}
+
+{deltemplate greeting}
+{@param name: string}
+Hello, {$name}.
+{/deltemplate}
+
+{deltemplate greeting variant="'formal'"}
+{@param name: string}
+Good day, {$name}.
+{/deltemplate}
+
+{deltemplate greeting variant="2"}
+{@param name: string}
+Hi 2, {$name}.
+{/deltemplate}`
+	var pirate = `
+{delpackage pirate}
+{namespace test.pirate}
+
+{deltemplate greeting}
+{@param name: string}
+Ahoy, {$name}.
+{/deltemplate}
+
+{deltemplate marker}
+pirate
+{/deltemplate}`
+	var parrot = `
+{delpackage parrot}
+{namespace test.parrot}
+
+{deltemplate greeting}
+{@param name: string}
+Squawk, {$name}.
+{/deltemplate}`
+
+	runNsExecTests(t, []nsExecTest{
+		{"default", "test.main", []string{caller, defaults},
+			"Hello, Rob. []", nil, true, nil},
+		{"variant", "test.main", []string{caller, defaults},
+			"Good day, Rob. []", d{"variant": "formal"}, true, nil},
+		{"int variant", "test.main", []string{caller, defaults},
+			"Hi 2, Rob. []", d{"variant": 2}, true, nil},
+		{"unknown variant falls back", "test.main", []string{caller, defaults},
+			"Hello, Rob. []", d{"variant": "other"}, true, nil},
+		{"package loaded", "test.main", []string{caller, defaults, pirate},
+			"Ahoy, Rob. [pirate]", nil, true, nil},
+		{"package loaded first", "test.main", []string{caller, pirate, defaults},
+			"Ahoy, Rob. [pirate]", nil, true, nil},
+		{"conflicting packages", "test.main", []string{caller, pirate, parrot},
+			"", nil, false, nil},
+		{"no implementation", "test.main", []string{caller},
+			"", nil, false, nil},
+	})
+}
+
 // Helpers
 
 var globals = make(data.Map)
@@ -879,6 +982,56 @@ func newMessage(node *ast.MsgNode, msgstrs []string) soymsg.Message {
 	}}}
 }
 
+func TestDelegateNames(t *testing.T) {
+	// The variants differ only in punctuation, which must not give their
+	// implementations the same name.
+	var input = `
+{namespace test}
+
+{template .main}
+{@param variant: string}
+{delcall x.y variant="$variant" /}
+{/template}
+
+{deltemplate x.y variant="'a-b'"}
+dash
+{/deltemplate}
+
+{deltemplate x.y variant="'a_b'"}
+underscore
+{/deltemplate}`
+	runNsExecTests(t, []nsExecTest{
+		{"dash", "test.main", []string{input}, "dash", d{"variant": "a-b"}, true, nil},
+		{"underscore", "test.main", []string{input}, "underscore", d{"variant": "a_b"}, true, nil},
+	})
+
+	var soyfile, err = parse.SoyFile("names.soy", input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry = template.Registry{}
+	if err = registry.Add(soyfile); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = Write(&buf, soyfile, Options{Formatter: ES6Formatter{}}); err != nil {
+		t.Fatal(err)
+	}
+	var exported = make(map[string]bool)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if !strings.HasPrefix(line, "export function ") {
+			continue
+		}
+		if exported[line] {
+			t.Errorf("exported twice: %s", line)
+		}
+		exported[line] = true
+	}
+	if len(exported) != 3 {
+		t.Errorf("expected 3 exported functions, got %v", exported)
+	}
+}
+
 func TestMessages(t *testing.T) {
 	runNsExecTests(t, []nsExecTest{
 		{"no bundle", "test.main", []string{`{namespace test}
@@ -1063,7 +1216,11 @@ func TestES6(t *testing.T) {
 {/template}`)
 	bundle.AddTemplateString("say_hello.soy", `{namespace say}
 {template .hello}
-	Hello World!
+	Hello {call .world /}!
+{/template}
+
+{template .world private="true"}
+	World
 {/template}`)
 	registry, err := bundle.Compile()
 	if err != nil {
@@ -1078,6 +1235,12 @@ func TestES6(t *testing.T) {
 
 if (typeof test == 'undefined') { var test = {}; }
 
+/**
+ * @param {Object<string, *>=} opt_data
+ * @param {?=} opt_sb
+ * @param {Object=} opt_ijData
+ * @return {string}
+ */
 export function test__formatter(opt_data, opt_sb, opt_ijData) {
   var output = '';
   output += say__hello({}, opt_sb, opt_ijData);
@@ -1088,9 +1251,30 @@ export function test__formatter(opt_data, opt_sb, opt_ijData) {
 
 if (typeof say == 'undefined') { var say = {}; }
 
+/**
+ * @param {Object<string, *>=} opt_data
+ * @param {?=} opt_sb
+ * @param {Object=} opt_ijData
+ * @return {string}
+ */
 export function say__hello(opt_data, opt_sb, opt_ijData) {
   var output = '';
-  output += 'Hello World!';
+  output += 'Hello ';
+  output += say__world({}, opt_sb, opt_ijData);
+  output += '!';
+  return output;
+};
+
+/**
+ * @param {Object<string, *>=} opt_data
+ * @param {?=} opt_sb
+ * @param {Object=} opt_ijData
+ * @return {string}
+ * @private
+ */
+function say__world(opt_data, opt_sb, opt_ijData) {
+  var output = '';
+  output += 'World';
   return output;
 };`,
 	}
@@ -1107,6 +1291,140 @@ export function say__hello(opt_data, opt_sb, opt_ijData) {
 	}
 }
 
+func TestClosure(t *testing.T) {
+	bundle := soy.NewBundle()
+	bundle.AddTemplateString("say_hello.soy", `{namespace say.greetings}
+{template .hello}
+	Hello {call .name /}! {call say.names.first /} {call say.names.last /}
+{/template}
+{template .name private="true"}
+	World
+{/template}`)
+	bundle.AddTemplateString("say_names.soy", `{namespace say.names}
+{template .first}
+	Rob
+{/template}
+{template .last}
+	Figueiredo
+{/template}`)
+	registry, err := bundle.Compile()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var tests = []struct {
+		formatter JSFormatter
+		expected  string
+	}{
+		{ClosureFormatter{}, `// This file was automatically generated from say_hello.soy.
+// Please don't edit this file by hand.
+
+goog.provide('say.greetings');
+
+goog.require('soy');
+goog.require('soydata');
+goog.require('say.names');
+
+/**
+ * @param {Object<string, *>=} opt_data
+ * @param {?=} opt_sb
+ * @param {Object=} opt_ijData
+ * @return {string}
+ */
+say.greetings.hello = function(opt_data, opt_sb, opt_ijData) {
+  var output = '';
+  output += 'Hello ';
+  output += say.greetings.name({}, opt_sb, opt_ijData);
+  output += '! ';
+  output += say.names.first({}, opt_sb, opt_ijData);
+  output += ' ';
+  output += say.names.last({}, opt_sb, opt_ijData);
+  return output;
+};
+
+/**
+ * @param {Object<string, *>=} opt_data
+ * @param {?=} opt_sb
+ * @param {Object=} opt_ijData
+ * @return {string}
+ * @private
+ */
+say.greetings.name = function(opt_data, opt_sb, opt_ijData) {
+  var output = '';
+  output += 'World';
+  return output;
+};`},
+		{GoogModuleFormatter{}, `// This file was automatically generated from say_hello.soy.
+// Please don't edit this file by hand.
+
+goog.module('say.greetings');
+
+const soy = goog.require('soy');
+const soydata = goog.require('soydata');
+const $say__names = goog.require('say.names');
+
+/**
+ * @param {Object<string, *>=} opt_data
+ * @param {?=} opt_sb
+ * @param {Object=} opt_ijData
+ * @return {string}
+ */
+const say__greetings__hello = function(opt_data, opt_sb, opt_ijData) {
+  var output = '';
+  output += 'Hello ';
+  output += say__greetings__name({}, opt_sb, opt_ijData);
+  output += '! ';
+  output += $say__names.first({}, opt_sb, opt_ijData);
+  output += ' ';
+  output += $say__names.last({}, opt_sb, opt_ijData);
+  return output;
+};
+exports.hello = say__greetings__hello;
+
+/**
+ * @param {Object<string, *>=} opt_data
+ * @param {?=} opt_sb
+ * @param {Object=} opt_ijData
+ * @return {string}
+ * @private
+ */
+const say__greetings__name = function(opt_data, opt_sb, opt_ijData) {
+  var output = '';
+  output += 'World';
+  return output;
+};`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err = Write(&buf, registry.SoyFiles[0], Options{Formatter: test.formatter}); err != nil {
+			t.Error(err)
+			continue
+		}
+		if a, e := strings.TrimSpace(buf.String()), strings.TrimSpace(test.expected); a != e {
+			t.Errorf("%T Error, did not get expected results:\n%v", test.formatter, diff.LineDiff(e, a))
+		}
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	soyfile, err := parse.SoyFile("test.soy", `{namespace test}
+{template .hello}
+  Hello {$name |unknown}!
+{/template}`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = Write(&bytes.Buffer{}, soyfile, Options{})
+	var pos = errortypes.ToErrFilePos(err)
+	if pos == nil {
+		t.Fatalf("expected an error with a position, got %v", err)
+	}
+	if pos.File() != "test.soy" || pos.Line() != 3 || pos.Col() != 17 {
+		t.Errorf("expected test.soy:3:17, got %s:%d:%d", pos.File(), pos.Line(), pos.Col())
+	}
+}
+
 var pluralFuncBodies = map[string]string{
 	"en": `
 	if (n > 1) {
@@ -1213,35 +1531,12 @@ TESTS_LOOP:
 }
 
 func initJs(t *testing.T) *otto.Otto {
-	var otto = otto.New()
-	soyutilsFile, err := os.Open("lib/soyutils.js")
-	if err != nil {
-		panic(err)
-	}
-	// remove any non-otto compatible regular expressions
-	var soyutilsBuf bytes.Buffer
-	var scanner = bufio.NewScanner(soyutilsFile)
-	var i = 1
-	for scanner.Scan() {
-		switch i {
-		case 2565, 2579, 2586:
-			// skip these regexes
-			// soy.esc.$$FILTER_FOR_FILTER_CSS_VALUE_
-			// soy.esc.$$FILTER_FOR_FILTER_HTML_ATTRIBUTES_
-			// soy.esc.$$FILTER_FOR_FILTER_HTML_ELEMENT_NAME_
-		default:
-			soyutilsBuf.Write(scanner.Bytes())
-			soyutilsBuf.Write([]byte("\n"))
-		}
-		i++
-	}
-	// load the soyutils library
-	_, err = otto.Run(soyutilsBuf.String())
+	var js, err = ottotest.New("lib")
 	if err != nil {
 		t.Errorf("soyutils error: %v", err)
 		panic(err)
 	}
-	return otto
+	return js
 }
 
 func numberLines(soyfile io.Reader) string {
@@ -1256,3 +1551,74 @@ func numberLines(soyfile io.Reader) string {
 	}
 	return buf.String()
 }
+
+func TestJSDoc(t *testing.T) {
+	bundle := soy.NewBundle()
+	bundle.AddTemplateString("test.soy", `{namespace test}
+
+/**
+ * @param name
+ * @param? greeting
+ */
+{template .soydoc}
+  {$name}{$greeting}
+{/template}
+
+{template .header private="true"}
+  {@param name: string}
+  {@param count:= 1}
+  {@param? nickname: string}
+  {@param? items: list<[id: int, tags: map<string, bool>]>}
+  {@param? body: html|null = null}
+  {$name}{$count}{$nickname}{$items}{$body}
+{/template}`)
+	registry, err := bundle.Compile()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := `// This file was automatically generated from test.soy.
+// Please don't edit this file by hand.
+
+if (typeof test == 'undefined') { var test = {}; }
+
+/**
+ * @param {{name: ?, greeting: ?}} opt_data
+ * @param {?=} opt_sb
+ * @param {Object=} opt_ijData
+ * @return {string}
+ */
+test.soydoc = function(opt_data, opt_sb, opt_ijData) {
+  var output = '';
+  output += soy.$$escapeHtml(opt_data.name);
+  output += soy.$$escapeHtml(opt_data.greeting);
+  return output;
+};
+
+/**
+ * @param {{name: string, count: (number|undefined), nickname: (string|null|undefined), items: (!Array<{id: number, tags: !Object<string, boolean>}>|null|undefined), body: (!soydata.SanitizedHtml|string|null|undefined)}} opt_data
+ * @param {?=} opt_sb
+ * @param {Object=} opt_ijData
+ * @return {string}
+ * @private
+ */
+test.header = function(opt_data, opt_sb, opt_ijData) {
+  var output = '';
+  var count1 = opt_data.count === undefined ? 1 : opt_data.count;
+  var body2 = opt_data.body === undefined ? null : opt_data.body;
+  output += soy.$$escapeHtml(opt_data.name);
+  output += soy.$$escapeHtml(count1);
+  output += soy.$$escapeHtml(opt_data.nickname);
+  output += soy.$$escapeHtml(opt_data.items);
+  output += soy.$$escapeHtml(body2);
+  return output;
+};`
+	var buf bytes.Buffer
+	if err = Write(&buf, registry.SoyFiles[0], Options{}); err != nil {
+		t.Error(err)
+		return
+	}
+	if a, e := strings.TrimSpace(buf.String()), strings.TrimSpace(expected); a != e {
+		t.Errorf("JSDoc Error, did not get expected results:\n%v", diff.LineDiff(e, a))
+	}
+}
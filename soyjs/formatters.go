@@ -25,6 +25,34 @@ type JSFormatter interface {
 	Function(Func) string
 }
 
+// NamespaceFormatter may be implemented by a JSFormatter to control how the
+// namespace of each Soy file is declared.  Formatters that do not implement it
+// get the ES5 declaration, which creates each object along the namespace path
+// that is not already defined.
+type NamespaceFormatter interface {
+	// Namespace returns the statement declaring the given namespace.
+	Namespace(name string) string
+}
+
+// ModuleFormatter may be implemented by a JSFormatter whose output declares
+// each namespace as a module with its own scope, so that templates are
+// defined as locals and exported by a separate statement.  Calls to templates
+// in the namespace being generated refer to the local definition directly.
+type ModuleFormatter interface {
+	NamespaceFormatter
+	// Export returns the statement exporting the given template from its
+	// namespace, written after the template is defined unless it is private.
+	Export(name string) string
+}
+
+// PrivateFormatter may be implemented by a JSFormatter whose templates are
+// exported by their definition, so that templates declared private="true" may
+// be defined without being exported.
+type PrivateFormatter interface {
+	// PrivateTemplate is like Template, for private templates.
+	PrivateTemplate(name string) (string, string)
+}
+
 // ES5Formatter implements the JSFormatter interface
 // and creates Javascript files following the ES5
 // Javascript format (without imports)
@@ -35,8 +63,27 @@ type ES5Formatter struct{}
 // Javascript format (with imports)
 type ES6Formatter struct{}
 
+// ClosureFormatter implements the JSFormatter interface
+// and creates Javascript files following the ES5
+// Javascript format, with each namespace declared by
+// the Closure Library's goog.provide and each called
+// namespace loaded by goog.require
+type ClosureFormatter struct {
+	ES5Formatter
+}
+
+// GoogModuleFormatter implements the JSFormatter interface
+// and creates Javascript files that are Closure Library
+// modules, declared by goog.module
+type GoogModuleFormatter struct{}
+
 var _ JSFormatter = (*ES6Formatter)(nil)
 var _ JSFormatter = (*ES5Formatter)(nil)
+var _ JSFormatter = (*ClosureFormatter)(nil)
+var _ JSFormatter = (*GoogModuleFormatter)(nil)
+var _ NamespaceFormatter = (*ClosureFormatter)(nil)
+var _ ModuleFormatter = (*GoogModuleFormatter)(nil)
+var _ PrivateFormatter = (*ES6Formatter)(nil)
 
 // Template returns two values, the name of the template to save
 // in the defined functions map, and how the function should be defined.
@@ -66,6 +113,84 @@ func (f ES5Formatter) Function(fn Func) string {
 	return ""
 }
 
+// splitTemplateName returns the namespace of the given template
+// name and the name of the template within it.
+func splitTemplateName(name string) (string, string) {
+	var i = strings.LastIndex(name, ".")
+	if i == -1 {
+		return "", name
+	}
+	return name[:i], name[i+1:]
+}
+
+// Namespace returns the statement declaring the given
+// namespace, which for Closure is a goog.provide, followed
+// by the goog.require of soyutils_usegoog.js
+func (f ClosureFormatter) Namespace(name string) string {
+	return "goog.provide('" + name + "');\n\n" +
+		"goog.require('soy');\n" +
+		"goog.require('soydata');"
+}
+
+// Call returns two values, the name of the template to save
+// in the called functions map, and a string that is written
+// into the imports - for Closure, the goog.require of the
+// template's namespace
+func (f ClosureFormatter) Call(name string) (string, string) {
+	var namespace, _ = splitTemplateName(name)
+	return name, "goog.require('" + namespace + "');"
+}
+
+// Template returns two values, the name of the template to save
+// in the defined functions map, and how the function should be defined.
+// For goog.module, the function is defined as a local and exported
+// separately
+func (f GoogModuleFormatter) Template(name string) (string, string) {
+	return ES6Identifier(name), "const " + ES6Identifier(name) + " = function"
+}
+
+// Call returns two values, the name of the template to save
+// in the called functions map, and a string that is written
+// into the imports - for goog.module, the goog.require of the
+// template's namespace, aliased by its ES6Identifier
+func (f GoogModuleFormatter) Call(name string) (string, string) {
+	var namespace, local = splitTemplateName(name)
+	var alias = "$" + ES6Identifier(namespace)
+	return alias + "." + local, "const " + alias + " = goog.require('" + namespace + "');"
+}
+
+// Directive takes in a PrintDirective and returns a string
+// that is written into the imports - for goog.module, the
+// directives are provided by soyutils_usegoog.js, which is
+// always required
+func (f GoogModuleFormatter) Directive(dir PrintDirective) string {
+	return ""
+}
+
+// Function takes in a Func and returns a string
+// that is written into the imports - for goog.module, the
+// functions are provided by soyutils_usegoog.js, which is
+// always required
+func (f GoogModuleFormatter) Function(fn Func) string {
+	return ""
+}
+
+// Namespace returns the statement declaring the given
+// namespace, which for goog.module is the module declaration
+// followed by the goog.require of soyutils_usegoog.js
+func (f GoogModuleFormatter) Namespace(name string) string {
+	return "goog.module('" + name + "');\n\n" +
+		"const soy = goog.require('soy');\n" +
+		"const soydata = goog.require('soydata');"
+}
+
+// Export returns the statement exporting the given template
+// from its module
+func (f GoogModuleFormatter) Export(name string) string {
+	var _, local = splitTemplateName(name)
+	return "exports." + local + " = " + ES6Identifier(name) + ";"
+}
+
 // ES6Identifier creates an ES6 compatible function name
 // without periods. It replaces all periods, which usually
 // denominate namespaces in soy, with a double underscore.
@@ -89,6 +214,12 @@ func (f ES6Formatter) Template(name string) (string, string) {
 	return ES6Identifier(name), "export function " + ES6Identifier(name)
 }
 
+// PrivateTemplate returns the same name as Template, but
+// defines the function without exporting it
+func (f ES6Formatter) PrivateTemplate(name string) (string, string) {
+	return ES6Identifier(name), "function " + ES6Identifier(name)
+}
+
 // Call returns two values, the name of the template to save
 // in the called functions map, and a string that is written
 // into the imports
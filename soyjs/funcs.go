@@ -2,15 +2,11 @@ package soyjs
 
 import (
 	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/plugin"
 )
 
 // JSWriter is provided to functions to write to the generated javascript.
-type JSWriter interface {
-	// Write writes the given arguments into the generated javascript.  It is
-	// recommended to only pass strings and ast.Nodes to Write. Other types
-	// are printed using their default string representation (fmt.Sprintf("%v")).
-	Write(...interface{})
-}
+type JSWriter = plugin.JSWriter
 
 func (s *state) Write(args ...interface{}) {
 	s.js(args...)
@@ -35,15 +31,35 @@ var funcs = []Func{
 	{"max", funcMax, []int{2}},
 	{"randomInt", funcRandomInt, []int{1}},
 	{"strContains", funcStrContains, []int{2}},
+	{"range", funcRange, []int{1, 2, 3}},
 	{"hasData", funcHasData, []int{0}},
+	{"strIndexOf", funcStrIndexOf, []int{2}},
+	{"strSub", funcStrSub, []int{2, 3}},
+	{"strLen", funcStrLen, []int{1}},
+	{"strToUpperCase", funcStrToUpperCase, []int{1}},
+	{"strToLowerCase", funcStrToLowerCase, []int{1}},
+	{"strReplaceAll", builtinFunc("strReplaceAll"), []int{3}},
+	{"join", funcJoin, []int{2}},
+	{"concatLists", funcConcatLists, []int{2}},
+	{"listContains", funcListContains, []int{2}},
+	{"listIndexOf", funcListIndexOf, []int{2}},
+	{"parseInt", funcParseInt, []int{1}},
+	{"parseFloat", funcParseFloat, []int{1}},
+	{"sqrt", funcSqrt, []int{1}},
+	{"pow", funcPow, []int{2}},
+	{"abs", funcAbs, []int{1}},
+	{"mapKeys", builtinFunc("getMapKeys"), []int{1}},
+	{"mapToLegacyObjectMap", funcMapToLegacyObjectMap, []int{1}},
+	{"checkNotNull", funcCheckNotNull, []int{1}},
 	{"bidiGlobalDir", funcBidiGlobalDir, []int{0}},
-	{"bidiDirAttr", funcBidiDirAttr, []int{0}},
+	{"bidiDirAttr", funcBidiDirAttr, []int{1, 2}},
 	{"bidiStartEdge", funcBidiStartEdge, []int{0}},
 	{"bidiEndEdge", funcBidiEndEdge, []int{0}},
 }
 
-// Funcs contains the available Soy functions.
-// Callers may add custom functions to this map.
+// Funcs contains the available Soy functions, used unless others are given in
+// the Options.  Callers may add custom functions to this map, or to a copy of
+// it given in the Options.
 var Funcs = make(map[string]Func, len(funcs))
 
 func init() {
@@ -52,6 +68,56 @@ func init() {
 	}
 }
 
+// Arities returns the valid numbers of arguments of the functions and print
+// directives generated with the given options, by name, e.g. for
+// soy.Bundle.AllowFuncs.
+func Arities(options Options) (funcs, directives map[string][]int) {
+	if options.Funcs == nil {
+		options.Funcs = Funcs
+	}
+	if options.PrintDirectives == nil {
+		options.PrintDirectives = PrintDirectives
+	}
+	if options.Plugins != nil {
+		options.Funcs, options.PrintDirectives = withPlugins(options.Funcs, options.PrintDirectives, options.Plugins)
+	}
+	funcs = make(map[string][]int, len(options.Funcs))
+	for name, fn := range options.Funcs {
+		funcs[name] = fn.ValidArgLengths
+	}
+	directives = make(map[string][]int, len(options.PrintDirectives))
+	for name, d := range options.PrintDirectives {
+		directives[name] = d.ValidArgLengths
+	}
+	return funcs, directives
+}
+
+// withPlugins returns copies of the given functions and print directives, with
+// the javascript emitters of the given plugins added to them.  Plugins without
+// one are skipped.
+func withPlugins(funcs map[string]Func, directives map[string]PrintDirective, set *plugin.Set) (
+	map[string]Func, map[string]PrintDirective) {
+	var newFuncs = make(map[string]Func, len(funcs)+len(set.Funcs))
+	for name, fn := range funcs {
+		newFuncs[name] = fn
+	}
+	for name, fn := range set.Funcs {
+		if fn.JS != nil {
+			newFuncs[name] = Func{name, fn.JS, fn.ValidArgLengths()}
+		}
+	}
+	var newDirectives = make(map[string]PrintDirective, len(directives)+len(set.PrintDirectives))
+	for name, d := range directives {
+		newDirectives[name] = d
+	}
+	for name, d := range set.PrintDirectives {
+		if d.JS != "" {
+			newDirectives[name] = PrintDirective{d.JS, d.ValidArgLengths(), d.CancelAutoescape}
+		}
+	}
+	return newFuncs, newDirectives
+}
+
 // builtinFunc returns a function that writes a call to a soy.$$ builtin func.
 func builtinFunc(name string) func(js JSWriter, args []ast.Node) {
 	var funcStart = "soy.$$" + name + "("
@@ -109,16 +175,117 @@ func funcStrContains(js JSWriter, args []ast.Node) {
 	js.Write(args[0], ".indexOf(", args[1], ") != -1")
 }
 
+// funcRange writes a list of the indexes that a for loop over the same range
+// would iterate.
+func funcRange(js JSWriter, args []ast.Node) {
+	var init, limit, increment interface{} = "0", nil, "1"
+	switch len(args) {
+	case 3:
+		increment = args[2]
+		fallthrough
+	case 2:
+		init, limit = args[0], args[1]
+	case 1:
+		limit = args[0]
+	}
+	js.Write("(function(init, limit, increment) {",
+		"var list = []; for (var i = init; i < limit; i += increment) { list.push(i); } return list;",
+		"})(", init, ", ", limit, ", ", increment, ")")
+}
+
 func funcHasData(js JSWriter, args []ast.Node) {
 	js.Write("true")
 }
 
+func funcStrIndexOf(js JSWriter, args []ast.Node) {
+	js.Write("String(", args[0], ").indexOf(String(", args[1], "))")
+}
+
+func funcStrSub(js JSWriter, args []ast.Node) {
+	if len(args) == 2 {
+		js.Write("String(", args[0], ").substring(", args[1], ")")
+		return
+	}
+	js.Write("String(", args[0], ").substring(", args[1], ", ", args[2], ")")
+}
+
+func funcStrLen(js JSWriter, args []ast.Node) {
+	js.Write("String(", args[0], ").length")
+}
+
+func funcStrToUpperCase(js JSWriter, args []ast.Node) {
+	js.Write("String(", args[0], ").toUpperCase()")
+}
+
+func funcStrToLowerCase(js JSWriter, args []ast.Node) {
+	js.Write("String(", args[0], ").toLowerCase()")
+}
+
+func funcJoin(js JSWriter, args []ast.Node) {
+	js.Write(args[0], ".join(", args[1], ")")
+}
+
+func funcConcatLists(js JSWriter, args []ast.Node) {
+	js.Write(args[0], ".concat(", args[1], ")")
+}
+
+func funcListContains(js JSWriter, args []ast.Node) {
+	js.Write("(", args[0], ".indexOf(", args[1], ") != -1)")
+}
+
+func funcListIndexOf(js JSWriter, args []ast.Node) {
+	js.Write(args[0], ".indexOf(", args[1], ")")
+}
+
+// funcParseInt and funcParseFloat return null for strings that are not
+// entirely a number, rather than parsing a prefix of them like javascript.
+
+func funcParseInt(js JSWriter, args []ast.Node) {
+	js.Write("(function(s) { return /^[-+]?[0-9]+$/.test(s) ? parseInt(s, 10) : null; })(String(",
+		args[0], "))")
+}
+
+func funcParseFloat(js JSWriter, args []ast.Node) {
+	js.Write("(function(s) { return /^[-+]?([0-9]+(\\.[0-9]*)?|\\.[0-9]+)([eE][-+]?[0-9]+)?$/.test(s) ?",
+		" parseFloat(s) : null; })(String(", args[0], "))")
+}
+
+func funcSqrt(js JSWriter, args []ast.Node) {
+	js.Write("Math.sqrt(", args[0], ")")
+}
+
+func funcPow(js JSWriter, args []ast.Node) {
+	js.Write("Math.pow(", args[0], ", ", args[1], ")")
+}
+
+func funcAbs(js JSWriter, args []ast.Node) {
+	js.Write("Math.abs(", args[0], ")")
+}
+
+func funcMapToLegacyObjectMap(js JSWriter, args []ast.Node) {
+	js.Write(args[0])
+}
+
+func funcCheckNotNull(js JSWriter, args []ast.Node) {
+	js.Write("(function(v) { if (v == null) { throw Error('unexpected null value'); } return v; })(",
+		args[0], ")")
+}
+
+// The bidi functions assume that the global directionality is left-to-right,
+// like soyhtml's.  In particular, bidiDirAttr passes 1 (ltr) rather than 0
+// (unknown) to soy.$$bidiDirAttr, so that left-to-right text gets no dir
+// attribute rather than dir="ltr".
+
 func funcBidiGlobalDir(js JSWriter, args []ast.Node) {
 	js.Write("1")
 }
 
 func funcBidiDirAttr(js JSWriter, args []ast.Node) {
-	js.Write("soy.$$bidiDirAttr(0, ", args[0], ")")
+	if len(args) == 2 {
+		js.Write("soy.$$bidiDirAttr(1, ", args[0], ", ", args[1], ")")
+		return
+	}
+	js.Write("soy.$$bidiDirAttr(1, ", args[0], ")")
 }
 
 func funcBidiStartEdge(js JSWriter, args []ast.Node) {
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io"
 
+	"github.com/robfig/soy/plugin"
 	"github.com/robfig/soy/soymsg"
 	"github.com/robfig/soy/template"
 )
@@ -11,14 +12,21 @@ import (
 // Options for js source generation.
 // When no Formatter is defined, soyjs
 // will default to ES5Formatter from exec.go
+// When no Funcs or PrintDirectives are defined, soyjs
+// will default to the package's Funcs and PrintDirectives.
+// The javascript emitters of any Plugins are added to them.
 type Options struct {
-	Messages  soymsg.Bundle
-	Formatter JSFormatter
+	Messages        soymsg.Bundle
+	Formatter       JSFormatter
+	Funcs           map[string]Func
+	PrintDirectives map[string]PrintDirective
+	Plugins         *plugin.Set
 }
 
 // Generator provides an interface to a template registry capable of generating
 // javascript to execute the embodied templates.
-// The generated javascript requires lib/soyutils.js to already have been loaded.
+// The generated javascript requires lib/soyutils.js, and then
+// lib/soyutils_ext.js, to already have been loaded.
 type Generator struct {
 	registry *template.Registry
 }
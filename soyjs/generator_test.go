@@ -2,6 +2,8 @@ package soyjs
 
 import (
 	"bytes"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/robertkrimen/otto"
@@ -66,3 +68,94 @@ soy.$$escapeHtml = function(arg) { return arg; };
 		t.Errorf("Got %q, expected Hello, World", output.String())
 	}
 }
+
+func TestOptionsFuncs(t *testing.T) {
+	var otto = otto.New()
+	var _, err = otto.Run(`
+var soy = {};
+soy.$$escapeHtml = function(arg) { return arg; };
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	soyfile, err := parse.SoyFile("name.soy", `
+{namespace test}
+{template .funcs}
+{twice('ab')|shout}
+{/template}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = Write(&buf, soyfile, Options{
+		Funcs: map[string]Func{"twice": {"twice", func(js JSWriter, args []ast.Node) {
+			js.Write("(", args[0], " + ", args[0], ")")
+		}, []int{1}}},
+		PrintDirectives: map[string]PrintDirective{"shout": {"shout", []int{0}, false}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Funcs["twice"]; ok {
+		t.Error("expected the default functions to be unchanged")
+	}
+
+	_, err = otto.Run("function shout(s) { return s.toUpperCase(); }\n" + buf.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := otto.Run(`test.funcs();`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != "ABAB" {
+		t.Errorf("Got %q, expected ABAB", output.String())
+	}
+
+	// The default functions are not available in place of the given ones.
+	err = Write(&buf, soyfile, Options{Funcs: map[string]Func{}})
+	if err == nil {
+		t.Error("expected an error for an unknown function")
+	}
+}
+
+func TestArities(t *testing.T) {
+	var funcs, directives = Arities(Options{
+		PrintDirectives: map[string]PrintDirective{"shout": {"shout", []int{0, 1}, false}},
+	})
+	if !reflect.DeepEqual(funcs["round"], []int{1, 2}) {
+		t.Errorf("round: expected [1 2], got %v", funcs["round"])
+	}
+	if !reflect.DeepEqual(directives, map[string][]int{"shout": {0, 1}}) {
+		t.Errorf("expected only shout with [0 1], got %v", directives)
+	}
+	if _, directives = Arities(Options{}); !reflect.DeepEqual(directives["truncate"], []int{1, 2}) {
+		t.Errorf("truncate: expected [1 2], got %v", directives["truncate"])
+	}
+}
+
+func TestBidiDirAttr(t *testing.T) {
+	soyfile, err := parse.SoyFile("name.soy", `
+{namespace test}
+/** @param text */
+{template .bidi}
+  <p {bidiDirAttr($text)}></p><p {bidiDirAttr($text, true)}></p>
+{/template}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = Write(&buf, soyfile, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"soy.$$bidiDirAttr(1, opt_data.text)",
+		"soy.$$bidiDirAttr(1, opt_data.text, true)",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, buf.String())
+		}
+	}
+}
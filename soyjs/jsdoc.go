@@ -0,0 +1,137 @@
+package soyjs
+
+import (
+	"strings"
+
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/types"
+)
+
+// writeJSDoc writes the JSDoc for the given template function, typing its
+// params from the SoyDoc or header params of the template.  Params declared in
+// SoyDoc have the unknown type.
+func (s *state) writeJSDoc(node *ast.TemplateNode, soydoc *ast.SoyDocNode, allOptionalParams bool) {
+	var fields []string
+	if soydoc != nil {
+		for _, param := range soydoc.Params {
+			fields = append(fields, param.Name+": ?")
+		}
+	}
+	for _, param := range node.Params {
+		var alternatives = jsTypes(headerParamType(param))
+		if (param.Optional || param.Default != nil) && alternatives[0] != "?" && alternatives[0] != "*" {
+			alternatives = append(alternatives, "undefined")
+		}
+		fields = append(fields, param.Name+": "+joinTypes(alternatives))
+	}
+
+	var dataType = "Object<string, *>="
+	if len(fields) > 0 {
+		dataType = "{" + strings.Join(fields, ", ") + "}"
+		if allOptionalParams {
+			dataType += "="
+		}
+	}
+	s.jsln("/**")
+	s.jsln(" * @param {", dataType, "} opt_data")
+	s.jsln(" * @param {?=} opt_sb")
+	s.jsln(" * @param {Object=} opt_ijData")
+	s.jsln(" * @return {string}")
+	if node.Private {
+		s.jsln(" * @private")
+	}
+	s.jsln(" */")
+}
+
+// headerParamType returns the Soy type of the given header param.  Params
+// without a declared type take the type of a literal default value.
+func headerParamType(param *ast.HeaderParamNode) types.Type {
+	var typ = param.Type.Type
+	if typ == nil {
+		switch param.Default.(type) {
+		case *ast.BoolNode:
+			typ = types.Bool
+		case *ast.IntNode:
+			typ = types.Int
+		case *ast.FloatNode:
+			typ = types.Float
+		case *ast.StringNode:
+			typ = types.String
+		default:
+			typ = types.Unknown
+		}
+	}
+	if param.Optional && param.Default == nil {
+		typ = types.NewUnion(typ, types.Null)
+	}
+	return typ
+}
+
+// sanitizedTypes maps the content kind types to the soydata classes that
+// represent them, which may also be passed as strings.
+var sanitizedTypes = map[types.Primitive]string{
+	types.HTML:       "!soydata.SanitizedHtml",
+	types.Attributes: "!soydata.SanitizedHtmlAttribute",
+	types.JS:         "!soydata.SanitizedJs",
+	types.CSS:        "!soydata.SanitizedCss",
+	types.URI:        "!soydata.SanitizedUri",
+}
+
+// jsType returns the Closure type expression for the given Soy type.
+func jsType(t types.Type) string {
+	return joinTypes(jsTypes(t))
+}
+
+// jsTypes returns the alternatives of the Closure type expression for the
+// given Soy type.
+func jsTypes(t types.Type) []string {
+	switch t := t.(type) {
+	case types.Primitive:
+		switch t {
+		case types.Any:
+			return []string{"*"}
+		case types.Null:
+			return []string{"null"}
+		case types.Bool:
+			return []string{"boolean"}
+		case types.Int, types.Float:
+			return []string{"number"}
+		case types.String:
+			return []string{"string"}
+		}
+		if sanitized, ok := sanitizedTypes[t]; ok {
+			return []string{sanitized, "string"}
+		}
+	case types.List:
+		return []string{"!Array<" + jsType(t.Elem) + ">"}
+	case types.Map:
+		return []string{"!Object<" + jsType(t.Key) + ", " + jsType(t.Value) + ">"}
+	case types.Record:
+		var fields = make([]string, len(t.Fields))
+		for i, field := range t.Fields {
+			fields[i] = field.Name + ": " + jsType(field.Type)
+		}
+		return []string{"{" + strings.Join(fields, ", ") + "}"}
+	case types.Union:
+		var alternatives []string
+		var seen = make(map[string]bool)
+		for _, member := range t.Members {
+			for _, alt := range jsTypes(member) {
+				if !seen[alt] {
+					seen[alt] = true
+					alternatives = append(alternatives, alt)
+				}
+			}
+		}
+		return alternatives
+	}
+	return []string{"?"}
+}
+
+// joinTypes returns the union of the given type expressions.
+func joinTypes(alternatives []string) string {
+	if len(alternatives) == 1 {
+		return alternatives[0]
+	}
+	return "(" + strings.Join(alternatives, "|") + ")"
+}
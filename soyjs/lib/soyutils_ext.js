@@ -0,0 +1,214 @@
+/**
+ * @fileoverview
+ * The runtime of the functions and print directives that soyjs supports, but
+ * that the vendored soyutils.js and soyutils_usegoog.js do not provide:
+ * strReplaceAll(), |formatNum and |filterImageDataUri.
+ *
+ * <p>
+ * This file must be loaded after soyutils.js or soyutils_usegoog.js, which are
+ * kept identical to the upstream Closure Templates runtime.
+ */
+
+
+/**
+ * Replaces each occurrence of a substring.  An empty substring is not replaced,
+ * rather than splitting the string between its UTF-16 code units, which the Go
+ * backend could not represent.
+ *
+ * @param {*} str The string to replace within.
+ * @param {*} substring The substring to replace.
+ * @param {*} replacement The replacement for each occurrence of the substring.
+ * @return {string} The string with the substring replaced.
+ */
+soy.$$strReplaceAll = function(str, substring, replacement) {
+  str = String(str);
+  substring = String(substring);
+  if (substring == '') {
+    return str;
+  }
+  return str.split(substring).join(String(replacement));
+};
+
+
+/**
+ * Allows only data-protocol image URI's.
+ *
+ * @param {*} value The value to process. May not be a string, but the value
+ *     will be coerced to a string.
+ * @return {!soydata.SanitizedUri} An escaped version of value.
+ */
+soy.$$filterImageDataUri = function(value) {
+  // NOTE: Even if it's a SanitizedUri, we will still filter it.
+  return soydata.VERY_UNSAFE.ordainSanitizedUri(
+      soy.esc.$$filterImageDataUriHelper(value));
+};
+
+
+/**
+ * A pattern that vets values produced by the named directives.
+ * @type RegExp
+ * @private
+ */
+soy.esc.$$FILTER_FOR_FILTER_IMAGE_DATA_URI_ = /^data:image\/(?:bmp|gif|jpe?g|png|tiff|webp);base64,[a-z0-9+\/]+=*$/i;
+
+
+/**
+ * A helper for the Soy directive |filterImageDataUri
+ * @param {*} value Can be of any type but will be coerced to a string.
+ * @return {string} The escaped text.
+ */
+soy.esc.$$filterImageDataUriHelper = function(value) {
+  var str = String(value);
+  if (!soy.esc.$$FILTER_FOR_FILTER_IMAGE_DATA_URI_.test(str)) {
+    goog.asserts.fail('Bad value `%s` for |filterImageDataUri', [str]);
+    return 'data:image/gif;base64,zSoyz';
+  }
+  return str;
+};
+
+
+/**
+ * Formats a number in the en locale.
+ *
+ * @param {*} value The number to format.
+ * @param {string=} opt_formatType The type of format: 'decimal' (the default),
+ *     'currency', 'percent' or 'scientific'.
+ * @param {string=} opt_numbersKeyword The numbering system, which is ignored.
+ * @param {number=} opt_minFractionDigits The minimum number of fraction digits,
+ *     which must be an integer.
+ * @param {number=} opt_maxFractionDigits The maximum number of fraction digits,
+ *     which must be an integer, and defaults to the minimum if that is given.
+ * @return {string} The formatted number.
+ */
+soy.$$formatNum = function(value, opt_formatType, opt_numbersKeyword,
+    opt_minFractionDigits, opt_maxFractionDigits) {
+  var format = opt_formatType == null ? 'decimal' : String(opt_formatType);
+  var prefix = '', suffix = '';
+  var minDigits = 0, maxDigits = 3;
+  switch (format) {
+    case 'decimal': case 'scientific':
+      break;
+    case 'currency':
+      prefix = '$';
+      minDigits = maxDigits = 2;
+      break;
+    case 'percent':
+      suffix = '%';
+      maxDigits = 0;
+      break;
+    default:
+      throw Error('unknown number format "' + format + '"');
+  }
+  if (opt_minFractionDigits != null) {
+    minDigits = maxDigits = soy.$$fractionDigits_(opt_minFractionDigits);
+  }
+  if (opt_maxFractionDigits != null) {
+    maxDigits = soy.$$fractionDigits_(opt_maxFractionDigits);
+  }
+
+  var num = Number(value);
+  var sign = '';
+  if (num < 0) {
+    sign = '-';
+    num = -num;
+  }
+  if (format == 'percent') {
+    num *= 100;
+  }
+  if (format == 'scientific') {
+    var mantissa = num, exponent = 0;
+    while (mantissa >= 10) {
+      mantissa /= 10;
+      exponent++;
+    }
+    while (mantissa > 0 && mantissa < 1) {
+      mantissa *= 10;
+      exponent--;
+    }
+    mantissa = soy.$$roundDigits_(mantissa, maxDigits);
+    if (mantissa >= 10) {
+      mantissa /= 10;
+      exponent++;
+    }
+    return sign + soy.$$formatDigits_(mantissa, minDigits, maxDigits) +
+        'E' + exponent;
+  }
+  var digits = soy.$$formatDigits_(
+      soy.$$roundDigits_(num, maxDigits), minDigits, maxDigits);
+  return sign + prefix + soy.$$groupThousands_(digits) + suffix;
+};
+
+
+/**
+ * Private helper for $$formatNum() to check that a number of fraction digits is
+ * an integer.
+ * @param {*} digits The number of fraction digits.
+ * @return {number} The number of fraction digits.
+ * @private
+ */
+soy.$$fractionDigits_ = function(digits) {
+  if (typeof digits != 'number' || Math.floor(digits) !== digits) {
+    throw Error('fraction digits must be an integer, got ' + digits);
+  }
+  return digits;
+};
+
+
+/**
+ * Private helper for $$formatNum() to round a non-negative number to the given
+ * number of fraction digits, rounding halves up.
+ * @param {number} num The number to round.
+ * @param {number} digits The number of fraction digits.
+ * @return {number} The rounded number.
+ * @private
+ */
+soy.$$roundDigits_ = function(num, digits) {
+  var pow = Math.pow(10, digits);
+  return Math.floor(num * pow + 0.5) / pow;
+};
+
+
+/**
+ * Private helper for $$formatNum() to format a non-negative number with at
+ * most maxDigits fraction digits, removing trailing zeros beyond minDigits.
+ * @param {number} num The number to format.
+ * @param {number} minDigits The minimum number of fraction digits.
+ * @param {number} maxDigits The maximum number of fraction digits.
+ * @return {string} The formatted number.
+ * @private
+ */
+soy.$$formatDigits_ = function(num, minDigits, maxDigits) {
+  var str = num.toFixed(maxDigits);
+  if (maxDigits > minDigits) {
+    str = str.replace(/0+$/, '');
+    var fraction = str.length - str.indexOf('.') - 1;
+    for (; fraction < minDigits; fraction++) {
+      str += '0';
+    }
+    str = str.replace(/\.$/, '');
+  }
+  return str;
+};
+
+
+/**
+ * Private helper for $$formatNum() to separate the thousands in the integer
+ * part of a formatted number with commas.
+ * @param {string} num The formatted number.
+ * @return {string} The number with its thousands separated.
+ * @private
+ */
+soy.$$groupThousands_ = function(num) {
+  var end = num.indexOf('.');
+  if (end == -1) {
+    end = num.length;
+  }
+  var result = '';
+  for (var i = 0; i < end; i++) {
+    if (i > 0 && (end - i) % 3 == 0) {
+      result += ',';
+    }
+    result += num.charAt(i);
+  }
+  return result + num.substring(end);
+};
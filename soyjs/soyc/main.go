@@ -0,0 +1,198 @@
+// soyc is a tool to compile Soy templates to Javascript.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robfig/soy"
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/errortypes"
+	"github.com/robfig/soy/soyjs"
+	"github.com/robfig/soy/soymsg/pomsg"
+)
+
+var (
+	globals   = flag.String("globals", "", "file of compile-time globals")
+	formatter = flag.String("formatter", "es5", "output format: es5, es6, closure (goog.provide), or googmodule (goog.module)")
+	outdir    = flag.String("outdir", ".", "directory to write the generated Javascript to")
+	layout    = flag.String("layout", "file", "output layout: file (one .js per .soy file) or namespace (one .js per namespace)")
+	messages  = flag.String("messages", "", "directory of <locale>.po message files")
+	locale    = flag.String("locale", "", "locale of the messages to use, if -messages is given")
+	dts       = flag.Bool("dts", false, "also write TypeScript declarations (.d.ts) for each output")
+)
+
+var formatters = map[string]soyjs.JSFormatter{
+	"es5":        soyjs.ES5Formatter{},
+	"es6":        soyjs.ES6Formatter{},
+	"closure":    soyjs.ClosureFormatter{},
+	"googmodule": soyjs.GoogModuleFormatter{},
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `soyc is a tool to compile Soy templates to Javascript.
+
+Usage:
+
+	soyc [FLAGS] [INPUTPATH]...
+
+INPUTPATH elements may be files or directories. Input directories will be
+recursively searched for *.soy files, and their output is written to the same
+relative path within the output directory.  Files written to the same output
+are merged, so they must declare the same namespace.
+
+Flags:
+
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(1)
+	}
+
+	var options soyjs.Options
+	var ok bool
+	if options.Formatter, ok = formatters[*formatter]; !ok {
+		exit(fmt.Errorf("unknown formatter %q", *formatter))
+	}
+	if *layout != "file" && *layout != "namespace" {
+		exit(fmt.Errorf("unknown layout %q", *layout))
+	}
+	if *messages != "" {
+		var provider, err = pomsg.Dir(*messages)
+		if err != nil {
+			exit(err)
+		}
+		if options.Messages = provider.Bundle(*locale); options.Messages == nil {
+			exit(fmt.Errorf("no messages found for locale %q in %s", *locale, *messages))
+		}
+	}
+
+	// Add all the sources to the bundle, recording the output file for each.
+	var bundle = soy.NewBundle().AllowFuncs(soyjs.Arities(options))
+	if *globals != "" {
+		bundle.AddGlobalsFile(*globals)
+	}
+	var outputs = make(map[string]string)
+	for _, src := range flag.Args() {
+		var err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || path != src && !strings.HasSuffix(path, ".soy") {
+				return nil
+			}
+			var rel = filepath.Base(path)
+			if path != src {
+				rel, _ = filepath.Rel(src, path)
+			}
+			outputs[path] = strings.TrimSuffix(rel, ".soy") + ".js"
+			bundle.AddTemplateFile(path)
+			return nil
+		})
+		if err != nil {
+			exit(err)
+		}
+	}
+	var registry, err = bundle.Compile()
+	if err != nil {
+		exit(err)
+	}
+
+	// Group the files by output, and generate each one.  Files with the same
+	// output are merged, which requires them to share a namespace.
+	var (
+		order []string
+		files = make(map[string][]*ast.SoyFileNode)
+	)
+	for _, soyfile := range registry.SoyFiles {
+		var output = outputs[soyfile.Name]
+		if *layout == "namespace" {
+			output = namespace(soyfile) + ".js"
+		}
+		if others, ok := files[output]; !ok {
+			order = append(order, output)
+		} else if namespace(others[0]) != namespace(soyfile) {
+			exit(fmt.Errorf("%s and %s are both written to %s, but declare different namespaces: %s, %s",
+				others[0].Name, soyfile.Name, output, namespace(others[0]), namespace(soyfile)))
+		}
+		files[output] = append(files[output], soyfile)
+	}
+	for _, output := range order {
+		var node = files[output][0]
+		if len(files[output]) > 1 {
+			node = merge(files[output])
+		}
+		var buf bytes.Buffer
+		if err = soyjs.Write(&buf, node, options); err != nil {
+			exit(err)
+		}
+		var filename = filepath.Join(*outdir, output)
+		if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			exit(err)
+		}
+		if err = ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			exit(err)
+		}
+		if *dts {
+			buf.Reset()
+			if err = soyjs.WriteDeclarations(&buf, node, options); err != nil {
+				exit(err)
+			}
+			filename = strings.TrimSuffix(filename, ".js") + ".d.ts"
+			if err = ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+				exit(err)
+			}
+		}
+	}
+}
+
+// namespace returns the namespace declared by the given Soy file.
+func namespace(soyfile *ast.SoyFileNode) string {
+	for _, node := range soyfile.Body {
+		if ns, ok := node.(*ast.NamespaceNode); ok {
+			return ns.Name
+		}
+	}
+	return ""
+}
+
+// merge combines the given Soy files, which share a namespace, into one.
+func merge(soyfiles []*ast.SoyFileNode) *ast.SoyFileNode {
+	var names []string
+	for _, soyfile := range soyfiles {
+		names = append(names, soyfile.Name)
+	}
+	var merged = &ast.SoyFileNode{Name: strings.Join(names, ", ")}
+	for i, soyfile := range soyfiles {
+		for _, node := range soyfile.Body {
+			if _, ok := node.(*ast.NamespaceNode); ok && i > 0 {
+				continue
+			}
+			merged.Body = append(merged.Body, node)
+		}
+	}
+	return merged
+}
+
+// exit prints the given error, including its position if known, and exits.
+func exit(err error) {
+	if errs := errortypes.ToErrList(err); errs != nil {
+		for _, pos := range errs {
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: %v\n", pos.File(), pos.Line(), pos.Col(), pos)
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(1)
+}
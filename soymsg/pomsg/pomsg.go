@@ -2,8 +2,10 @@
 package pomsg
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path"
@@ -72,6 +74,47 @@ func (o fsFileOpener) Open(locale string) (io.ReadCloser, error) {
 	}
 }
 
+// fsysFileOpener is a FileOpener based on a fs.FS and rooted at dir
+type fsysFileOpener struct {
+	fsys fs.FS
+	dir  string
+}
+
+func (o fsysFileOpener) Open(locale string) (io.ReadCloser, error) {
+	switch f, err := o.fsys.Open(path.Join(o.dir, locale+".po")); {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	default:
+		return f, nil
+	}
+}
+
+// FSFileOpener returns a FileOpener that opens po files of the form
+// <dir>/<locale>.po within the given file system, e.g. one embedded with
+// go:embed.
+func FSFileOpener(fsys fs.FS, dir string) FileOpener {
+	return fsysFileOpener{fsys, dir}
+}
+
+// FS returns a soymsg.Provider that takes translations from the po files in
+// the given directory of the given file system, named as for Dir.
+func FS(fsys fs.FS, dir string) (soymsg.Provider, error) {
+	var files, err = fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var locales []string
+	for _, fi := range files {
+		var name = fi.Name()
+		if !fi.IsDir() && strings.HasSuffix(name, ".po") {
+			locales = append(locales, name[:len(name)-3])
+		}
+	}
+	return Load(FSFileOpener(fsys, dir), locales)
+}
+
 // Dir returns a soymsg.Provider that takes translations from the given path.
 // For example, if dir is "/usr/local/msgs", po files should be of the form:
 //   /usr/local/msgs/<lang>.po
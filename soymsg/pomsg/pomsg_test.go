@@ -1,8 +1,10 @@
 package pomsg
 
 import (
+	"io/ioutil"
 	"reflect"
 	"testing"
+	"testing/fstest"
 
 	"github.com/robfig/soy/soymsg"
 )
@@ -151,3 +153,33 @@ func TestNewMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestFS(t *testing.T) {
+	var po, err = ioutil.ReadFile("testdata/zz.po")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fsys = fstest.MapFS{
+		"msgs/zz.po":  &fstest.MapFile{Data: po},
+		"msgs/README": &fstest.MapFile{Data: []byte("not a po file")},
+	}
+	pomsgs, err := FS(fsys, "msgs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle = pomsgs.Bundle("zz")
+	if bundle == nil {
+		t.Fatal("expected a bundle for zz")
+	}
+	var expected = newMessage(6936162475751860807, "", []string{"zHello z{NAME}!"})
+	if actual := bundle.Message(6936162475751860807); !reflect.DeepEqual(&expected, actual) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, actual)
+	}
+	if bundle = pomsgs.Bundle("xx"); bundle != nil {
+		t.Errorf("expected null bundle, got %#v", bundle)
+	}
+
+	if r, err := FSFileOpener(fsys, "msgs").Open("xx"); r != nil || err != nil {
+		t.Errorf("expected no file and no error for a missing locale, got %v, %v", r, err)
+	}
+}
@@ -0,0 +1,198 @@
+package soy
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/robfig/soy/soyjs"
+)
+
+type stdlibTest struct {
+	expr   string
+	output string // the error expected, if err is true
+	err    bool
+}
+
+// stdlibTests exercise the standard functions, which must produce the same
+// output through soyhtml and soyjs.  Functions returning lists are joined to
+// print them, since the backends print lists differently.  Strings that are
+// indexed are ASCII, since otto does not index other strings in UTF-16 code
+// units.
+var stdlibTests = []stdlibTest{
+	{"isNonnull(null)", "false", false},
+	{"isNonnull('a')", "true", false},
+	{"length([1, 2, 3])", "3", false},
+	{"join(keys(['a': 1]), ',')", "a", false},
+	{"join(mapKeys(['a': 1]), ',')", "a", false},
+	{"length(keys(augmentMap(['a': 1], ['b': 2])))", "2", false},
+	{"join(keys(mapToLegacyObjectMap(['a': 1])), ',')", "a", false},
+	{"round(1.5)", "2", false},
+	{"round(-1.4)", "-1", false},
+	{"round(3.14159, 2)", "3.14", false},
+	{"round(1234, -2)", "1200", false},
+	{"floor(2.7)", "2", false},
+	{"ceiling(2.2)", "3", false},
+	{"min(2, 1)", "1", false},
+	{"max(2, 1.5)", "2", false},
+	{"randomInt(1)", "0", false},
+	{"sqrt(16)", "4", false},
+	{"sqrt(2.25)", "1.5", false},
+	{"pow(2, 10)", "1024", false},
+	{"pow(4, 0.5)", "2", false},
+	{"abs(-3)", "3", false},
+	{"abs(-2.5)", "2.5", false},
+	{"abs(4)", "4", false},
+	{"hasData()", "true", false},
+	{"join(range(3), ',')", "0,1,2", false},
+	{"join(range(2, 5), ',')", "2,3,4", false},
+	{"join(range(1, 10, 4), ',')", "1,5,9", false},
+	{"length(range(5, 2))", "0", false},
+
+	{"strContains('hello', 'ell')", "true", false},
+	{"strContains('hello', 'x')", "false", false},
+	{"strIndexOf('hello', 'l')", "2", false},
+	{"strIndexOf('hello', 'x')", "-1", false},
+	{"strSub('hello', 1)", "ello", false},
+	{"strSub('hello', 1, 3)", "el", false},
+	{"strSub('hello', 3, 1)", "el", false},
+	{"strSub('hello', -2, 20)", "hello", false},
+	{"strLen('hello')", "5", false},
+	{"strToUpperCase('Hello')", "HELLO", false},
+	{"strToLowerCase('Hello')", "hello", false},
+	{"strToUpperCase('straße ﬁ')", "STRASSE FI", false},
+	{"strToLowerCase('İstanbul')", "i\u0307stanbul", false},
+	{"strToLowerCase('ΟΔΟΣ ΑΣ.')", "οδος ας.", false},
+	{"strReplaceAll('a.b.c', '.', '-')", "a-b-c", false},
+	{"strReplaceAll('aaa', 'aa', 'b')", "ba", false},
+	{"strReplaceAll('abc', '', '-')", "abc", false},
+
+	{"join(['a', 'b', 'c'], ', ')", "a, b, c", false},
+	{"join([1, null, 2], '-')", "1--2", false},
+	{"join(concatLists([1, 2], [3]), ',')", "1,2,3", false},
+	{"listContains([1, 2], 2)", "true", false},
+	{"listContains(['a'], 'b')", "false", false},
+	{"listIndexOf(['a', 'b'], 'b')", "1", false},
+	{"listIndexOf(['a', 'b'], 'c')", "-1", false},
+
+	{"parseInt('42')", "42", false},
+	{"parseInt('-7')", "-7", false},
+	{"parseInt('4.2')", "null", false},
+	{"parseInt('42px')", "null", false},
+	{"parseInt('') ?: 'none'", "none", false},
+	{"parseFloat('4.25')", "4.25", false},
+	{"parseFloat('-.5')", "-0.5", false},
+	{"parseFloat('1e3')", "1000", false},
+	{"parseFloat('abc')", "null", false},
+
+	{"checkNotNull('a')", "a", false},
+	{"checkNotNull(null)", "unexpected null value", true},
+
+	{"bidiGlobalDir()", "1", false},
+	{"bidiStartEdge()", "left", false},
+	{"bidiEndEdge()", "right", false},
+	{"bidiDirAttr('hello')", "", false},
+	{"bidiDirAttr('שלום עולם')", `dir="rtl"`, false},
+	{"bidiDirAttr('שלום world wide web')", "", false},
+	{"bidiDirAttr('<b class=\"x\">שלום</b>', true)", `dir="rtl"`, false},
+	{"bidiDirAttr('')", "", false},
+}
+
+// directiveTests exercise the standard print directives, which must produce
+// the same output through soyhtml and soyjs.  Their exprs include the print
+// directives to apply.
+var directiveTests = []stdlibTest{
+	{`'<a href="x">' |escapeHtmlAttribute`, "&lt;a href=&quot;x&quot;&gt;", false},
+	{`'a b=c' |escapeHtmlAttributeNospace`, "a&#32;b&#61;c", false},
+	{`'it\'s' |escapeJsValue`, `'it\x27s'`, false},
+	{`3 |escapeJsValue`, " 3 ", false},
+	{`null |escapeJsValue`, " null ", false},
+	{`'a.b*c' |escapeJsRegex`, `a\x2eb\x2ac`, false},
+	{`'a"b' |escapeCssString`, `a\22 b`, false},
+	{`'red' |filterCssValue`, "red", false},
+	{`'-10.5px' |filterCssValue`, "-10.5px", false},
+	{`'expression(alert(1))' |filterCssValue`, "zSoyz", false},
+	{`'javascript:alert(1)' |filterNormalizeUri`, "#zSoyz", false},
+	{`'http://x/a b' |filterNormalizeUri`, "http://x/a%20b", false},
+	{`'a b(c)' |normalizeUri`, "a%20b%28c%29", false},
+	{`'data:image/png;base64,iVBORw0K' |filterImageDataUri`, "data:image/png;base64,iVBORw0K", false},
+	{`'http://x/a.png' |filterImageDataUri`, "data:image/gif;base64,zSoyz", false},
+	{`'<b>' |text`, "&lt;b&gt;", false},
+
+	{`1234.5678 |formatNum`, "1,234.568", false},
+	{`-1234567 |formatNum`, "-1,234,567", false},
+	{`0 |formatNum`, "0", false},
+	{`999.9996 |formatNum`, "1,000", false},
+	{`1234.5 |formatNum:'currency'`, "$1,234.50", false},
+	{`-2 |formatNum:'currency'`, "-$2.00", false},
+	{`0.256 |formatNum:'percent'`, "26%", false},
+	{`12345 |formatNum:'scientific'`, "1.235E4", false},
+	{`0.00012 |formatNum:'scientific'`, "1.2E-4", false},
+	{`9.9999 |formatNum:'scientific'`, "1E1", false},
+	{`2.5 |formatNum:'decimal','latn',0,0`, "3", false},
+	{`1.5 |formatNum:'decimal','latn',2`, "1.50", false},
+	{`1.23456 |formatNum:'decimal','latn',1,3`, "1.235", false},
+	{`1 |formatNum:'decimal','latn',1,3`, "1.0", false},
+	{`1 |formatNum:'bog' + 'us'`, "unknown number format", true},
+	{`1 |formatNum:'decimal','latn',3 / 2`, "fraction digits must be an integer, got 1.5", true},
+	{`1 |formatNum:'decimal','latn',4 / 2`, "1.00", false},
+}
+
+// stdlibTemplates returns a Soy file with a template printing each of the
+// stdlibTests, and then each of the directiveTests.
+func stdlibTemplates() string {
+	var buf bytes.Buffer
+	buf.WriteString("{namespace test.stdlib}\n")
+	for i, test := range stdlibTests {
+		fmt.Fprintf(&buf, "{template .t%d}{%s |noAutoescape}{/template}\n", i, test.expr)
+	}
+	for i, test := range directiveTests {
+		fmt.Fprintf(&buf, "{template .t%d}{%s}{/template}\n", len(stdlibTests)+i, test.expr)
+	}
+	return buf.String()
+}
+
+func TestStdlib(t *testing.T) {
+	var bundle = NewBundle().AddTemplateString("stdlib.soy", stdlibTemplates())
+	var tofu, err = bundle.CompileToTofu()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry, _ = bundle.Compile()
+	var otto = initJs(t)
+	for _, soyfile := range registry.SoyFiles {
+		var buf bytes.Buffer
+		if err = soyjs.Write(&buf, soyfile, soyjs.Options{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = otto.Run(buf.String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, test := range append(stdlibTests[:len(stdlibTests):len(stdlibTests)], directiveTests...) {
+		var name = fmt.Sprintf("test.stdlib.t%d", i)
+
+		var buf bytes.Buffer
+		err = tofu.Render(&buf, name, nil)
+		checkStdlibResult(t, "soyhtml", test, buf.String(), err)
+
+		var result, jsErr = otto.Run(name + "();")
+		checkStdlibResult(t, "soyjs", test, result.String(), jsErr)
+	}
+}
+
+func checkStdlibResult(t *testing.T, backend string, test stdlibTest, actual string, err error) {
+	t.Helper()
+	switch {
+	case test.err && err == nil:
+		t.Errorf("%s: {%s}: expected error %q, got %q", backend, test.expr, test.output, actual)
+	case test.err && !strings.Contains(err.Error(), test.output):
+		t.Errorf("%s: {%s}: expected error %q, got %v", backend, test.expr, test.output, err)
+	case !test.err && err != nil:
+		t.Errorf("%s: {%s}: %v", backend, test.expr, err)
+	case !test.err && actual != test.output:
+		t.Errorf("%s: {%s}: expected %q, got %q", backend, test.expr, test.output, actual)
+	}
+}
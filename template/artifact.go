@@ -0,0 +1,182 @@
+package template
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/robfig/soy/ast"
+	"github.com/robfig/soy/data"
+	"github.com/robfig/soy/types"
+)
+
+// ArtifactVersion is the version of the format written by WriteArtifact.  It
+// changes whenever the format, or the AST within it, changes incompatibly.
+const ArtifactVersion = 1
+
+// artifactMagic identifies a stream as a registry artifact.
+const artifactMagic = "soy registry artifact"
+
+// ErrArtifactVersion is returned when reading an artifact written in a format
+// version other than ArtifactVersion.  The artifact must be generated again.
+var ErrArtifactVersion = errors.New("unsupported artifact version")
+
+// artifactHeader begins an artifact, so that the version may be checked before
+// the rest of it is decoded.
+type artifactHeader struct {
+	Magic   string
+	Version int
+}
+
+// artifactFile is a Soy file in an artifact, along with the hash of its source
+// and the positions of its templates.
+type artifactFile struct {
+	Node      *ast.SoyFileNode
+	Hash      [sha256.Size]byte
+	Templates []artifactTemplate
+}
+
+// artifactTemplate locates the nodes of a template in the body of its file.
+// Templates without SoyDoc have theirs in the artifact instead.
+type artifactTemplate struct {
+	Node, Doc, Namespace int
+	ImplicitDoc          *ast.SoyDocNode
+}
+
+// WriteArtifact writes the registry to the given writer in a binary format,
+// from which it may be read by ReadArtifact without parsing or checking the
+// templates again.  The registry is written as it is, so any globals and
+// message IDs set on the templates are kept.
+func (r *Registry) WriteArtifact(w io.Writer) error {
+	var files = make([]artifactFile, len(r.SoyFiles))
+	var fileIndex = make(map[*ast.TemplateNode]int)
+	var nodeIndex = make(map[ast.Node]int)
+	for i, soyfile := range r.SoyFiles {
+		files[i] = artifactFile{Node: soyfile, Hash: sha256.Sum256([]byte(soyfile.Text))}
+		for j, node := range soyfile.Body {
+			nodeIndex[node] = j
+			if tn, ok := node.(*ast.TemplateNode); ok {
+				fileIndex[tn] = i
+			}
+		}
+	}
+	for _, t := range r.Templates {
+		var i, ok = fileIndex[t.Node]
+		if !ok {
+			return fmt.Errorf("template %v: file not found in registry", t.Node.Name)
+		}
+		var at = artifactTemplate{
+			Node:      nodeIndex[t.Node],
+			Doc:       -1,
+			Namespace: nodeIndex[t.Namespace],
+		}
+		if j, ok := nodeIndex[t.Doc]; ok {
+			at.Doc = j
+		} else {
+			at.ImplicitDoc = t.Doc
+		}
+		files[i].Templates = append(files[i].Templates, at)
+	}
+
+	var enc = gob.NewEncoder(w)
+	if err := enc.Encode(artifactHeader{artifactMagic, ArtifactVersion}); err != nil {
+		return err
+	}
+	return enc.Encode(files)
+}
+
+// ReadArtifact reads a registry written by WriteArtifact.  An error is returned
+// if the artifact was written in another format version, or if the source of
+// any file does not match the hash recorded for it.
+func ReadArtifact(rd io.Reader) (*Registry, error) {
+	var (
+		dec    = gob.NewDecoder(rd)
+		header artifactHeader
+		files  []artifactFile
+	)
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("reading artifact: %v", err)
+	}
+	if header.Magic != artifactMagic {
+		return nil, errors.New("not a registry artifact")
+	}
+	if header.Version != ArtifactVersion {
+		return nil, fmt.Errorf("%w %d (expected %d)", ErrArtifactVersion, header.Version, ArtifactVersion)
+	}
+	if err := dec.Decode(&files); err != nil {
+		return nil, fmt.Errorf("reading artifact: %v", err)
+	}
+
+	var r = &Registry{}
+	for _, file := range files {
+		var soyfile = file.Node
+		if sha256.Sum256([]byte(soyfile.Text)) != file.Hash {
+			return nil, fmt.Errorf("artifact: %s does not match its hash", soyfile.Name)
+		}
+		r.SoyFiles = append(r.SoyFiles, soyfile)
+		for _, at := range file.Templates {
+			var t Template
+			var ok bool
+			if t.Node, ok = artifactNode(soyfile, at.Node).(*ast.TemplateNode); !ok {
+				return nil, fmt.Errorf("artifact: %s: template not found", soyfile.Name)
+			}
+			if t.Namespace, ok = artifactNode(soyfile, at.Namespace).(*ast.NamespaceNode); !ok {
+				return nil, fmt.Errorf("artifact: %s: namespace not found", soyfile.Name)
+			}
+			t.Doc = at.ImplicitDoc
+			if at.Doc >= 0 {
+				if t.Doc, ok = artifactNode(soyfile, at.Doc).(*ast.SoyDocNode); !ok {
+					return nil, fmt.Errorf("artifact: %s: soydoc not found", soyfile.Name)
+				}
+			}
+			r.addTemplate(soyfile, t)
+		}
+	}
+	return r, nil
+}
+
+// artifactNode returns the node at the given index of the file's body, or nil
+// if there is none.
+func artifactNode(soyfile *ast.SoyFileNode, i int) ast.Node {
+	if i < 0 || i >= len(soyfile.Body) {
+		return nil
+	}
+	return soyfile.Body[i]
+}
+
+func init() {
+	// Register the implementations of the interfaces within the AST.
+	for _, v := range []interface{}{
+		&ast.ListNode{}, &ast.RawTextNode{}, &ast.NamespaceNode{},
+		&ast.DelPackageNode{}, &ast.TemplateNode{}, &ast.HeaderParamNode{},
+		&ast.SoyDocNode{}, &ast.SoyDocParamNode{}, &ast.PrintNode{},
+		&ast.PrintDirectiveNode{}, &ast.LiteralNode{}, &ast.CssNode{},
+		&ast.LogNode{}, &ast.DebuggerNode{}, &ast.LetValueNode{},
+		&ast.LetContentNode{}, &ast.IdentNode{}, &ast.MsgNode{},
+		&ast.MsgPlaceholderNode{}, &ast.MsgHtmlTagNode{}, &ast.MsgPluralNode{},
+		&ast.MsgPluralCaseNode{}, &ast.CallNode{}, &ast.DelCallNode{},
+		&ast.CallParamValueNode{}, &ast.CallParamContentNode{}, &ast.IfNode{},
+		&ast.IfCondNode{}, &ast.SwitchNode{}, &ast.SwitchCaseNode{},
+		&ast.ForNode{}, &ast.NullNode{}, &ast.BoolNode{}, &ast.IntNode{},
+		&ast.FloatNode{}, &ast.StringNode{}, &ast.GlobalNode{},
+		&ast.FunctionNode{}, &ast.ListLiteralNode{}, &ast.MapLiteralNode{},
+		&ast.DataRefNode{}, &ast.DataRefIndexNode{}, &ast.DataRefExprNode{},
+		&ast.DataRefKeyNode{}, &ast.NotNode{}, &ast.NegateNode{},
+		&ast.MulNode{}, &ast.DivNode{}, &ast.ModNode{}, &ast.AddNode{},
+		&ast.SubNode{}, &ast.EqNode{}, &ast.NotEqNode{}, &ast.GtNode{},
+		&ast.GteNode{}, &ast.LtNode{}, &ast.LteNode{}, &ast.OrNode{},
+		&ast.AndNode{}, &ast.ElvisNode{}, &ast.TernNode{},
+
+		data.Undefined{}, data.Null{}, data.Bool(false), data.Int(0),
+		data.Float(0), data.String(""), data.List{}, data.Map{},
+		data.SanitizedHTML(""), data.SanitizedHTMLAttributes(""),
+		data.SanitizedJS(""), data.SanitizedCSS(""), data.SanitizedURI(""),
+
+		types.Primitive(0), types.List{}, types.Map{}, types.Record{},
+		types.Union{},
+	} {
+		gob.Register(v)
+	}
+}
@@ -17,20 +17,22 @@ type Registry struct {
 	// sourceByTemplateName maps FQ template name to the input source it came from.
 	sourceByTemplateName map[string]string
 	fileByTemplateName   map[string]string
+
+	// templateIndex and delegateIndex map template names and delegate names to
+	// the positions of their templates in Templates.  They cover the first
+	// numIndexed templates, so lookups fall back to scanning Templates if
+	// templates were added to it directly.
+	templateIndex map[string]int
+	delegateIndex map[string][]int
+	numIndexed    int
 }
 
 // Add the given Soy file node (and all contained templates) to this registry.
 func (r *Registry) Add(soyfile *ast.SoyFileNode) error {
-	if r.sourceByTemplateName == nil {
-		r.sourceByTemplateName = make(map[string]string)
-	}
-	if r.fileByTemplateName == nil {
-		r.fileByTemplateName = make(map[string]string)
-	}
 	var ns *ast.NamespaceNode
 	for _, node := range soyfile.Body {
 		switch node := node.(type) {
-		case *ast.SoyDocNode:
+		case *ast.SoyDocNode, *ast.DelPackageNode:
 			continue
 		case *ast.NamespaceNode:
 			ns = node
@@ -80,10 +82,104 @@ func (r *Registry) Add(soyfile *ast.SoyFileNode) error {
 			return fmt.Errorf("template may not have both soydoc and header params specified")
 		}
 		tn.Body.Nodes = tn.Body.Nodes[len(headerParams):]
+		tn.Params = headerParams
+
+		if err := r.checkDelegate(tn); err != nil {
+			return err
+		}
+		r.addTemplate(soyfile, Template{sdn, tn, ns})
+	}
+	return nil
+}
+
+// AddFrom adds the given Soy file node, which was previously added to the
+// other registry, to this registry, along with its templates.  Unlike Add, the
+// file is not processed again, so this allows registries to share files that
+// have not changed.
+func (r *Registry) AddFrom(other *Registry, soyfile *ast.SoyFileNode) error {
+	var nodes = make(map[*ast.TemplateNode]bool)
+	for _, node := range soyfile.Body {
+		if tn, ok := node.(*ast.TemplateNode); ok {
+			nodes[tn] = true
+		}
+	}
+	var templates []Template
+	for _, t := range other.Templates {
+		if nodes[t.Node] {
+			templates = append(templates, t)
+		}
+	}
+	if len(templates) != len(nodes) {
+		return fmt.Errorf("%s was not added to the other registry", soyfile.Name)
+	}
 
-		r.Templates = append(r.Templates, Template{sdn, tn, ns})
-		r.sourceByTemplateName[tn.Name] = soyfile.Text
-		r.fileByTemplateName[tn.Name] = soyfile.Name
+	r.SoyFiles = append(r.SoyFiles, soyfile)
+	for _, t := range templates {
+		if err := r.checkDelegate(t.Node); err != nil {
+			return err
+		}
+		r.addTemplate(soyfile, t)
+	}
+	return nil
+}
+
+// addTemplate appends the given template, declared in the given file, to the
+// registry and its indexes.
+func (r *Registry) addTemplate(soyfile *ast.SoyFileNode, t Template) {
+	if r.sourceByTemplateName == nil {
+		r.sourceByTemplateName = make(map[string]string)
+	}
+	if r.fileByTemplateName == nil {
+		r.fileByTemplateName = make(map[string]string)
+	}
+	if r.numIndexed != len(r.Templates) || r.templateIndex == nil {
+		r.reindex()
+	}
+	r.Templates = append(r.Templates, t)
+	r.sourceByTemplateName[t.Node.Name] = soyfile.Text
+	r.fileByTemplateName[t.Node.Name] = soyfile.Name
+	r.index(len(r.Templates) - 1)
+}
+
+// reindex rebuilds the indexes of the templates in the registry.
+func (r *Registry) reindex() {
+	r.templateIndex = make(map[string]int)
+	r.delegateIndex = make(map[string][]int)
+	r.numIndexed = 0
+	for i := range r.Templates {
+		r.index(i)
+	}
+}
+
+// index adds the template at the given position of Templates to the indexes.
+// The first template of each name is the one found by lookups.
+func (r *Registry) index(i int) {
+	var tn = r.Templates[i].Node
+	if _, ok := r.templateIndex[tn.Name]; !ok {
+		r.templateIndex[tn.Name] = i
+	}
+	if tn.Delegate != nil {
+		r.delegateIndex[tn.Delegate.Name] = append(r.delegateIndex[tn.Delegate.Name], i)
+	}
+	r.numIndexed = i + 1
+}
+
+// indexed returns true if the indexes cover all of the templates.
+func (r *Registry) indexed() bool {
+	return r.templateIndex != nil && r.numIndexed == len(r.Templates)
+}
+
+// checkDelegate returns an error if the given template implements a delegate
+// that is already implemented by a template in the registry.
+func (r *Registry) checkDelegate(tn *ast.TemplateNode) error {
+	if tn.Delegate == nil {
+		return nil
+	}
+	for _, t := range r.Delegates(tn.Delegate.Name) {
+		if *t.Node.Delegate == *tn.Delegate {
+			return fmt.Errorf("duplicate deltemplate %s (variant %q, delpackage %q)",
+				tn.Delegate.Name, tn.Delegate.Variant, tn.Delegate.Package)
+		}
 	}
 	return nil
 }
@@ -91,6 +187,12 @@ func (r *Registry) Add(soyfile *ast.SoyFileNode) error {
 // Template allows lookup by (fully-qualified) template name.
 // The resulting template is returned and a boolean indicating if it was found.
 func (r *Registry) Template(name string) (Template, bool) {
+	if r.indexed() {
+		if i, ok := r.templateIndex[name]; ok {
+			return r.Templates[i], true
+		}
+		return Template{}, false
+	}
 	for _, t := range r.Templates {
 		if t.Node.Name == name {
 			return t, true
@@ -99,6 +201,198 @@ func (r *Registry) Template(name string) (Template, bool) {
 	return Template{}, false
 }
 
+// Delegates returns all implementations of the given delegate template, across
+// all variants and delegate packages.
+func (r *Registry) Delegates(name string) []Template {
+	var result []Template
+	if r.indexed() {
+		for _, i := range r.delegateIndex[name] {
+			result = append(result, r.Templates[i])
+		}
+		return result
+	}
+	for _, t := range r.Templates {
+		if t.Node.Delegate != nil && t.Node.Delegate.Name == name {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// Delegate returns the implementation of the given delegate template that
+// should be rendered for the given variant when the given delegate packages are
+// active.  Implementations in an active delegate package take priority over
+// those without a package, and implementations in inactive packages are never
+// used.  If there is no implementation of the variant, the default ("") variant
+// is used instead.  The resulting template is returned and a boolean
+// indicating if it was found.  There is no priority between active packages,
+// whatever their order, so it is an error for more than one active package to
+// implement the same delegate and variant.
+func (r *Registry) Delegate(name, variant string, activePackages []string) (Template, bool, error) {
+	var tmpl, ok, err = r.delegate(name, variant, activePackages)
+	if !ok && err == nil && variant != "" {
+		return r.delegate(name, "", activePackages)
+	}
+	return tmpl, ok, err
+}
+
+func (r *Registry) delegate(name, variant string, activePackages []string) (Template, bool, error) {
+	var (
+		result   Template
+		found    = false
+		priority = -1
+	)
+	for _, t := range r.Delegates(name) {
+		var del = t.Node.Delegate
+		if del.Variant != variant {
+			continue
+		}
+		var p = 0
+		if del.Package != "" {
+			if !inStringSlice(del.Package, activePackages) {
+				continue
+			}
+			p = 1
+		}
+		switch {
+		case p == priority:
+			return Template{}, false, fmt.Errorf(
+				"delegate %s (variant %q) is implemented in more than one active delpackage: %q, %q",
+				name, variant, result.Node.Delegate.Package, del.Package)
+		case p > priority:
+			result, found, priority = t, true, p
+		}
+	}
+	return result, found, nil
+}
+
+// NamespaceTemplates returns the templates in the given namespace, in the order
+// that they were added.
+func (r *Registry) NamespaceTemplates(namespace string) []Template {
+	var result []Template
+	for _, t := range r.Templates {
+		if t.Namespace.Name == namespace {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// FileTemplates returns the templates declared in the named file, in the order
+// that they were added.
+func (r *Registry) FileTemplates(filename string) []Template {
+	var result []Template
+	for _, t := range r.Templates {
+		if r.fileByTemplateName[t.Node.Name] == filename {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// File returns the Soy file of the given name, and a boolean indicating if it
+// was found.
+func (r *Registry) File(filename string) (*ast.SoyFileNode, bool) {
+	for _, soyfile := range r.SoyFiles {
+		if soyfile.Name == filename {
+			return soyfile, true
+		}
+	}
+	return nil, false
+}
+
+// NamespaceFile returns the first Soy file that declares the given namespace,
+// and a boolean indicating if it was found.
+func (r *Registry) NamespaceFile(namespace string) (*ast.SoyFileNode, bool) {
+	for _, soyfile := range r.SoyFiles {
+		for _, node := range soyfile.Body {
+			if ns, ok := node.(*ast.NamespaceNode); ok && ns.Name == namespace {
+				return soyfile, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// RemoveFile removes the Soy file of the given name, and the templates declared
+// in it, from the registry.  It returns false if there was no such file.
+func (r *Registry) RemoveFile(filename string) bool {
+	var (
+		files   []*ast.SoyFileNode
+		removed = make(map[*ast.TemplateNode]bool)
+	)
+	for _, soyfile := range r.SoyFiles {
+		if soyfile.Name != filename {
+			files = append(files, soyfile)
+			continue
+		}
+		for _, node := range soyfile.Body {
+			if tn, ok := node.(*ast.TemplateNode); ok {
+				removed[tn] = true
+			}
+		}
+	}
+	if len(files) == len(r.SoyFiles) {
+		return false
+	}
+
+	// The slices and maps are copied, so that a registry sharing them is not
+	// changed.
+	var (
+		templates []Template
+		sources   = copyStrings(r.sourceByTemplateName)
+		filenames = copyStrings(r.fileByTemplateName)
+	)
+	for _, t := range r.Templates {
+		if removed[t.Node] {
+			delete(sources, t.Node.Name)
+			delete(filenames, t.Node.Name)
+			continue
+		}
+		templates = append(templates, t)
+	}
+	r.SoyFiles, r.Templates = files, templates
+	r.sourceByTemplateName, r.fileByTemplateName = sources, filenames
+	r.reindex()
+	return true
+}
+
+// ReplaceFile replaces the Soy file of the same name as the given one, and the
+// templates declared in it, with the given file and its templates, which are
+// added after the others.  If there is no such file, the given one is added.
+// The registry is left unchanged if the given file can not be added.
+func (r *Registry) ReplaceFile(soyfile *ast.SoyFileNode) error {
+	var prev = *r
+	if !r.RemoveFile(soyfile.Name) {
+		// Add changes the maps in place, so keep a copy to restore.
+		prev.sourceByTemplateName = copyStrings(r.sourceByTemplateName)
+		prev.fileByTemplateName = copyStrings(r.fileByTemplateName)
+	}
+	if err := r.Add(soyfile); err != nil {
+		*r = prev
+		r.reindex()
+		return err
+	}
+	return nil
+}
+
+func copyStrings(m map[string]string) map[string]string {
+	var result = make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+func inStringSlice(item string, group []string) bool {
+	for _, x := range group {
+		if x == item {
+			return true
+		}
+	}
+	return false
+}
+
 // LineNumber computes the line number in the input source for the given node
 // within the given template.
 func (r *Registry) LineNumber(templateName string, node ast.Node) int {
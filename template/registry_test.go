@@ -0,0 +1,145 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/robfig/soy/parse"
+)
+
+func TestRegistryQueries(t *testing.T) {
+	var reg Registry
+	for _, file := range [][2]string{
+		{"a.soy", "{namespace a}\n{template .x}{/template}\n{template .y}{/template}"},
+		{"b.soy", "{namespace b}\n{deltemplate d}{/deltemplate}\n{template .x}{/template}"},
+		{"a2.soy", "{namespace a}\n{template .z}{/template}"},
+		{"c.soy", "{namespace c}\n{deltemplate d variant=\"'v'\"}{/deltemplate}"},
+	} {
+		var tree, err = parse.SoyFile(file[0], file[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = reg.Add(tree); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertNames(t, "namespace a", reg.NamespaceTemplates("a"), "a.x", "a.y", "a.z")
+	assertNames(t, "file b.soy", reg.FileTemplates("b.soy"), "b.__deltemplate____d__", "b.x")
+	assertNames(t, "delegates d", reg.Delegates("d"), "b.__deltemplate____d__", "c.__deltemplate____d__v")
+	if file, ok := reg.NamespaceFile("a"); !ok || file.Name != "a.soy" {
+		t.Errorf("expected namespace a in a.soy, got %v", file)
+	}
+	if _, ok := reg.NamespaceFile("z"); ok {
+		t.Error("expected namespace z not to be found")
+	}
+
+	// Templates added to the slice directly are found too.
+	var extra = reg.Templates[0]
+	reg.Templates = append(reg.Templates, extra)
+	if tmpl, ok := reg.Template("b.x"); !ok || tmpl.Node.Name != "b.x" {
+		t.Errorf("expected to find b.x, got %v", tmpl.Node)
+	}
+	reg.Templates = reg.Templates[:len(reg.Templates)-1]
+
+	// A copy of the registry is not changed by removing files from it.
+	var other = reg
+	if !other.RemoveFile("b.soy") {
+		t.Error("expected b.soy to be removed from the copy")
+	}
+	if reg.Filename("b.x") != "b.soy" || reg.LineNumber("b.x", reg.FileTemplates("b.soy")[1].Node) != 3 {
+		t.Error("expected the position of b.x to be known after removing it from a copy")
+	}
+
+	// Removed and replaced files take their templates with them.
+	if !reg.RemoveFile("a2.soy") || reg.RemoveFile("a2.soy") {
+		t.Error("expected a2.soy to be removed once")
+	}
+	assertNames(t, "namespace a after removal", reg.NamespaceTemplates("a"), "a.x", "a.y")
+	if _, ok := reg.Template("a.z"); ok {
+		t.Error("expected a.z to be removed")
+	}
+
+	var tree, _ = parse.SoyFile("b.soy", "{namespace b}\n{template .w}{/template}")
+	if err := reg.ReplaceFile(tree); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, "file b.soy after replacement", reg.FileTemplates("b.soy"), "b.w")
+	assertNames(t, "delegates d after replacement", reg.Delegates("d"), "c.__deltemplate____d__v")
+	if _, ok := reg.Template("b.x"); ok {
+		t.Error("expected b.x to be replaced")
+	}
+	if reg.Filename("b.w") != "b.soy" || reg.LineNumber("b.w", tree.Body[1]) != 2 {
+		t.Error("expected the position of b.w to be known")
+	}
+
+	// A file that can not be added leaves the registry unchanged.
+	tree, _ = parse.SoyFile("a.soy", "{template .v}{/template}")
+	if err := reg.ReplaceFile(tree); err == nil {
+		t.Error("expected an error replacing a.soy with a file without a namespace")
+	}
+	assertNames(t, "file a.soy after failed replacement", reg.FileTemplates("a.soy"), "a.x", "a.y")
+	if tmpl, ok := reg.Template("a.y"); !ok || tmpl.Node.Name != "a.y" {
+		t.Error("expected a.y to be kept")
+	}
+}
+
+func TestDelegate(t *testing.T) {
+	var reg Registry
+	for _, file := range [][2]string{
+		{"p1.soy", "{delpackage p1}\n{namespace p1}\n{deltemplate d}{/deltemplate}\n{deltemplate d variant=\"'v'\"}{/deltemplate}"},
+		{"n.soy", "{namespace n}\n{deltemplate d}{/deltemplate}\n{deltemplate d variant=\"'v'\"}{/deltemplate}"},
+		{"p2.soy", "{delpackage p2}\n{namespace p2}\n{deltemplate d}{/deltemplate}"},
+	} {
+		var tree, err = parse.SoyFile(file[0], file[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = reg.Add(tree); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		variant  string
+		packages []string
+		expected string // the name of the template, or "" for an error
+	}{
+		{"", nil, "n.__deltemplate____d__"},
+		{"v", nil, "n.__deltemplate____d__v"},
+		{"w", nil, "n.__deltemplate____d__"},
+		{"", []string{"other"}, "n.__deltemplate____d__"},
+		{"", []string{"p1"}, "p1.__deltemplate__p1__d__"},
+		{"v", []string{"p1"}, "p1.__deltemplate__p1__d__v"},
+		{"w", []string{"p1"}, "p1.__deltemplate__p1__d__"},
+		{"", []string{"p2"}, "p2.__deltemplate__p2__d__"},
+		{"v", []string{"p2"}, "n.__deltemplate____d__v"},
+
+		// Active packages have no priority between them, whatever their order.
+		{"v", []string{"p1", "p2"}, "p1.__deltemplate__p1__d__v"},
+		{"", []string{"p1", "p2"}, ""},
+		{"", []string{"p2", "p1"}, ""},
+		{"w", []string{"p2", "p1"}, ""},
+	}
+	for _, test := range tests {
+		var tmpl, ok, err = reg.Delegate("d", test.variant, test.packages)
+		switch {
+		case test.expected == "" && err == nil:
+			t.Errorf("variant %q, packages %v: expected an error, got %v", test.variant, test.packages, tmpl.Node)
+		case test.expected != "" && err != nil:
+			t.Errorf("variant %q, packages %v: unexpected error: %v", test.variant, test.packages, err)
+		case test.expected != "" && (!ok || tmpl.Node.Name != test.expected):
+			t.Errorf("variant %q, packages %v: expected %v, got %v", test.variant, test.packages, test.expected, tmpl.Node)
+		}
+	}
+}
+
+func assertNames(t *testing.T, name string, templates []Template, expected ...string) {
+	var actual []string
+	for _, tmpl := range templates {
+		actual = append(actual, tmpl.Node.Name)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%s: expected %v, got %v", name, expected, actual)
+	}
+}
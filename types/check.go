@@ -0,0 +1,237 @@
+package types
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/robfig/soy/data"
+)
+
+// Assignable returns true if a value of type from may be used where a value of
+// type to is expected.
+//
+// The unknown type is assignable to and from anything, since it is checked at
+// runtime.  Ints are assignable to floats, and strings are assignable to and
+// from the sanitized content kinds, since they are escaped as necessary when
+// printed.
+func Assignable(to, from Type) bool {
+	if to == Unknown || to == Any || from == Unknown {
+		return true
+	}
+	if union, ok := from.(Union); ok {
+		for _, member := range union.Members {
+			if !Assignable(to, member) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch to := to.(type) {
+	case Union:
+		for _, member := range to.Members {
+			if Assignable(member, from) {
+				return true
+			}
+		}
+		return false
+	case Primitive:
+		var from, ok = from.(Primitive)
+		switch {
+		case !ok:
+			return false
+		case to == from:
+			return true
+		case to == Float:
+			return from == Int
+		case to == String:
+			return isContentKind(from)
+		case isContentKind(to):
+			return from == String
+		}
+		return false
+	case List:
+		var from, ok = from.(List)
+		return ok && Assignable(to.Elem, from.Elem)
+	case Map:
+		switch from := from.(type) {
+		case Map:
+			return Assignable(to.Key, from.Key) && Assignable(to.Value, from.Value)
+		case Record:
+			if !Assignable(to.Key, String) {
+				return false
+			}
+			for _, field := range from.Fields {
+				if !Assignable(to.Value, field.Type) {
+					return false
+				}
+			}
+			return true
+		}
+		return false
+	case Record:
+		var from, ok = from.(Record)
+		if !ok {
+			return false
+		}
+		for _, field := range to.Fields {
+			var fromType, ok = from.Field(field.Name)
+			if !ok {
+				fromType = Null
+			}
+			if !Assignable(field.Type, fromType) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func isContentKind(t Primitive) bool {
+	switch t {
+	case HTML, Attributes, JS, CSS, URI:
+		return true
+	}
+	return false
+}
+
+var contentKinds = map[Primitive]data.ContentKind{
+	HTML:       data.KindHTML,
+	Attributes: data.KindAttributes,
+	JS:         data.KindJS,
+	CSS:        data.KindCSS,
+	URI:        data.KindURI,
+}
+
+// ContentKind returns the type of content of the given kind.  Text and
+// unspecified content is a string.
+func ContentKind(kind data.ContentKind) Type {
+	for t, k := range contentKinds {
+		if k == kind {
+			return t
+		}
+	}
+	return String
+}
+
+// Validate returns an error describing the first part of the given value that
+// does not match the given type.  Undefined values are treated as null.
+func Validate(t Type, val data.Value) error {
+	return validate(t, val, "")
+}
+
+func validate(t Type, val data.Value, path string) error {
+	if _, ok := val.(data.Undefined); ok {
+		val = data.Null{}
+	}
+	switch t := t.(type) {
+	case Primitive:
+		if validPrimitive(t, val) {
+			return nil
+		}
+	case Union:
+		for _, member := range t.Members {
+			if validate(member, val, path) == nil {
+				return nil
+			}
+		}
+	case List:
+		if list, ok := val.(data.List); ok {
+			for i, item := range list {
+				if err := validate(t.Elem, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case Map:
+		if m, ok := val.(data.Map); ok {
+			for key, item := range m {
+				if err := validate(t.Value, item, path+"["+key+"]"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case Record:
+		if m, ok := val.(data.Map); ok {
+			for _, field := range t.Fields {
+				if err := validate(field.Type, m.Key(field.Name), path+"."+field.Name); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%sexpected %v, got %v", pathPrefix(path), t, describe(val))
+}
+
+func validPrimitive(t Primitive, val data.Value) bool {
+	switch t {
+	case Unknown, Any:
+		return true
+	case Null:
+		_, ok := val.(data.Null)
+		return ok
+	case Bool:
+		_, ok := val.(data.Bool)
+		return ok
+	case Int:
+		// Numbers decoded from JSON are floats, so accept those that are whole.
+		switch val := val.(type) {
+		case data.Int:
+			return true
+		case data.Float:
+			return float64(val) == math.Trunc(float64(val))
+		}
+	case Float:
+		switch val.(type) {
+		case data.Int, data.Float:
+			return true
+		}
+	case String:
+		switch val.(type) {
+		case data.String, data.SanitizedContent:
+			return true
+		}
+	default:
+		switch val := val.(type) {
+		case data.String:
+			return true
+		case data.SanitizedContent:
+			return val.Kind() == contentKinds[t]
+		}
+	}
+	return false
+}
+
+func pathPrefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return path + ": "
+}
+
+// describe returns the name of the type of the given value, for errors.
+func describe(val data.Value) string {
+	switch val := val.(type) {
+	case data.Null:
+		return "null"
+	case data.Bool:
+		return "bool"
+	case data.Int:
+		return "int"
+	case data.Float:
+		return "float"
+	case data.String:
+		return "string"
+	case data.List:
+		return "list"
+	case data.Map:
+		return "map"
+	case data.SanitizedContent:
+		return val.Kind().String()
+	}
+	return fmt.Sprintf("%T", val)
+}
@@ -0,0 +1,177 @@
+package types
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// Parse returns the type described by the given type expression, for example
+// "list<string>", "map<string, int>", "[name: string, age: int]",
+// "string|null", or "html?".
+//
+// A trailing "?" makes the preceding type nullable, while a lone "?" is the
+// unknown type.
+func Parse(expr string) (t Type, err error) {
+	var p = &typeParser{input: expr}
+	defer func() {
+		if e := recover(); e != nil {
+			if perr, ok := e.(typeError); ok {
+				err = fmt.Errorf("type %q: %s", expr, string(perr))
+				return
+			}
+			panic(e)
+		}
+	}()
+	t = p.parseUnion()
+	if tok := p.next(); tok != "" {
+		p.errorf("unexpected %q", tok)
+	}
+	return t, nil
+}
+
+// typeError is a panic value used to report type syntax errors.
+type typeError string
+
+// typeParser is a recursive-descent parser for type expressions.
+type typeParser struct {
+	input string
+	pos   int
+}
+
+func (p *typeParser) errorf(format string, args ...interface{}) {
+	panic(typeError(fmt.Sprintf(format, args...)))
+}
+
+// next returns the next token: an identifier, a punctuation character, or ""
+// at the end of input.
+func (p *typeParser) next() string {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+	if p.pos == len(p.input) {
+		return ""
+	}
+	var start = p.pos
+	if !isIdentChar(p.input[p.pos]) {
+		p.pos++
+		return p.input[start:p.pos]
+	}
+	for p.pos < len(p.input) && isIdentChar(p.input[p.pos]) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// peek returns the next token without consuming it.
+func (p *typeParser) peek() string {
+	var pos = p.pos
+	var tok = p.next()
+	p.pos = pos
+	return tok
+}
+
+func (p *typeParser) expect(tok string) {
+	if next := p.next(); next != tok {
+		if next == "" {
+			p.errorf("expected %q, got end of type", tok)
+		}
+		p.errorf("expected %q, got %q", tok, next)
+	}
+}
+
+// parseUnion parses one or more types separated by "|".
+func (p *typeParser) parseUnion() Type {
+	var members = []Type{p.parseNullable()}
+	for p.peek() == "|" {
+		p.next()
+		members = append(members, p.parseNullable())
+	}
+	if len(members) == 1 {
+		return members[0]
+	}
+	return NewUnion(members...)
+}
+
+// parseNullable parses a type optionally followed by "?".
+func (p *typeParser) parseNullable() Type {
+	var t = p.parsePrimary()
+	if p.peek() == "?" {
+		p.next()
+		t = NewUnion(t, Null)
+	}
+	return t
+}
+
+func (p *typeParser) parsePrimary() Type {
+	switch tok := p.next(); tok {
+	case "":
+		p.errorf("unexpected end of type")
+	case "?":
+		return Unknown
+	case "(":
+		var t = p.parseUnion()
+		p.expect(")")
+		return t
+	case "[":
+		return p.parseRecord()
+	case "number":
+		return NewUnion(Int, Float)
+	case "list":
+		p.expect("<")
+		var elem = p.parseUnion()
+		p.expect(">")
+		return List{elem}
+	case "map":
+		p.expect("<")
+		var key = p.parseUnion()
+		p.expect(",")
+		var value = p.parseUnion()
+		p.expect(">")
+		return Map{key, value}
+	default:
+		for primitive, name := range primitiveNames {
+			if name == tok {
+				return primitive
+			}
+		}
+		p.errorf("unknown type %q", tok)
+	}
+	panic("unreachable")
+}
+
+// parseRecord parses the fields of a record type.  '[' has just been read.
+func (p *typeParser) parseRecord() Type {
+	var fields []Field
+	for {
+		var name = p.next()
+		if name == "" || !isIdentChar(name[0]) {
+			p.errorf("expected record field name, got %q", name)
+		}
+		for _, field := range fields {
+			if field.Name == name {
+				p.errorf("duplicate record field %q", name)
+			}
+		}
+		p.expect(":")
+		fields = append(fields, Field{name, p.parseUnion()})
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+	p.expect("]")
+	return NewRecord(fields...)
+}
+
+func isIdentChar(ch byte) bool {
+	return ch == '_' || 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9'
+}
+
+// MustParse is like Parse but panics if the type expression is invalid.
+func MustParse(expr string) Type {
+	var t, err = Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
@@ -0,0 +1,190 @@
+// Package types defines the Soy type system used to declare template
+// parameters, e.g. {@param name: list<string>}.
+package types
+
+import (
+	"sort"
+	"strings"
+)
+
+// Type is a Soy type.
+type Type interface {
+	// String returns the type expression that describes this type.
+	String() string
+}
+
+// Primitive is one of the built-in, non-parameterized types.
+type Primitive int
+
+const (
+	Unknown    Primitive = iota // ?, the type is not known until runtime
+	Any                         // any
+	Null                        // null
+	Bool                        // bool
+	Int                         // int
+	Float                       // float
+	String                      // string
+	HTML                        // html
+	Attributes                  // attributes
+	JS                          // js
+	CSS                         // css
+	URI                         // uri
+)
+
+var primitiveNames = map[Primitive]string{
+	Unknown:    "?",
+	Any:        "any",
+	Null:       "null",
+	Bool:       "bool",
+	Int:        "int",
+	Float:      "float",
+	String:     "string",
+	HTML:       "html",
+	Attributes: "attributes",
+	JS:         "js",
+	CSS:        "css",
+	URI:        "uri",
+}
+
+func (t Primitive) String() string {
+	return primitiveNames[t]
+}
+
+// List is a list with elements of a single type.
+type List struct {
+	Elem Type
+}
+
+func (t List) String() string {
+	return "list<" + t.Elem.String() + ">"
+}
+
+// Map is a map from keys of one type to values of another.
+type Map struct {
+	Key, Value Type
+}
+
+func (t Map) String() string {
+	return "map<" + t.Key.String() + ", " + t.Value.String() + ">"
+}
+
+// Field is a named member of a record.
+type Field struct {
+	Name string
+	Type Type
+}
+
+// Record is a map with a fixed set of named fields, sorted by name.
+type Record struct {
+	Fields []Field
+}
+
+// Field returns the type of the named field, and false if there is no such
+// field.
+func (t Record) Field(name string) (Type, bool) {
+	for _, field := range t.Fields {
+		if field.Name == name {
+			return field.Type, true
+		}
+	}
+	return nil, false
+}
+
+func (t Record) String() string {
+	var fields = make([]string, len(t.Fields))
+	for i, field := range t.Fields {
+		fields[i] = field.Name + ": " + field.Type.String()
+	}
+	return "[" + strings.Join(fields, ", ") + "]"
+}
+
+// Union is a value that may be of any of the member types.
+type Union struct {
+	Members []Type
+}
+
+func (t Union) String() string {
+	var members = make([]string, len(t.Members))
+	for i, member := range t.Members {
+		members[i] = member.String()
+	}
+	return strings.Join(members, "|")
+}
+
+// NewRecord returns a record with the given fields.
+func NewRecord(fields ...Field) Record {
+	var sorted = append([]Field(nil), fields...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return Record{sorted}
+}
+
+// NewUnion returns the union of the given types.  Nested unions are flattened
+// and duplicate members removed.  If a member is unknown or any, that is
+// returned instead, and if only one member remains, it is returned alone.
+func NewUnion(types ...Type) Type {
+	var members []Type
+	var seen = make(map[string]bool)
+	var add func(t Type) Type
+	add = func(t Type) Type {
+		switch t := t.(type) {
+		case Primitive:
+			if t == Unknown || t == Any {
+				return t
+			}
+		case Union:
+			for _, member := range t.Members {
+				if result := add(member); result != nil {
+					return result
+				}
+			}
+			return nil
+		}
+		if !seen[t.String()] {
+			seen[t.String()] = true
+			members = append(members, t)
+		}
+		return nil
+	}
+	for _, t := range types {
+		if result := add(t); result != nil {
+			return result
+		}
+	}
+	switch len(members) {
+	case 0:
+		return Unknown
+	case 1:
+		return members[0]
+	}
+	return Union{members}
+}
+
+// Nullable returns true if null is a valid value of the given type.
+func Nullable(t Type) bool {
+	switch t := t.(type) {
+	case Primitive:
+		return t == Unknown || t == Any || t == Null
+	case Union:
+		for _, member := range t.Members {
+			if Nullable(member) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NonNull returns the given type without null.
+func NonNull(t Type) Type {
+	var union, ok = t.(Union)
+	if !ok {
+		return t
+	}
+	var members []Type
+	for _, member := range union.Members {
+		if member != Null {
+			members = append(members, member)
+		}
+	}
+	return NewUnion(members...)
+}
@@ -0,0 +1,129 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/robfig/soy/data"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		input, output string
+	}{
+		{"?", "?"},
+		{"any", "any"},
+		{" int ", "int"},
+		{"number", "int|float"},
+		{"string|null", "string|null"},
+		{"html?", "html|null"},
+		{"list<string>", "list<string>"},
+		{"list<list<int|null>>", "list<list<int|null>>"},
+		{"map<string,  list<uri>>", "map<string, list<uri>>"},
+		{"[name: string, age: int]", "[age: int, name: string]"},
+		{"[a: [b: bool?]]", "[a: [b: bool|null]]"},
+		{"(int|string)|int", "int|string"},
+		{"list<?>", "list<?>"},
+		{"attributes|js|css", "attributes|js|css"},
+	}
+	for _, test := range tests {
+		var typ, err = Parse(test.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.input, err)
+			continue
+		}
+		if typ.String() != test.output {
+			t.Errorf("%q: expected %q, got %q", test.input, test.output, typ.String())
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var tests = []string{
+		"",
+		"integer",
+		"list<int",
+		"list<int>>",
+		"map<int>",
+		"[a int]",
+		"[a: int, a: string]",
+		"int|",
+		"list<>",
+	}
+	for _, test := range tests {
+		if typ, err := Parse(test); err == nil {
+			t.Errorf("%q: expected an error, got %v", test, typ)
+		}
+	}
+}
+
+func TestAssignable(t *testing.T) {
+	var tests = []struct {
+		to, from string
+		ok       bool
+	}{
+		{"int", "int", true},
+		{"int", "?", true},
+		{"any", "list<int>", true},
+		{"float", "int", true},
+		{"int", "float", false},
+		{"int", "string", false},
+		{"string", "html", true},
+		{"html", "string", true},
+		{"html", "uri", false},
+		{"int|null", "null", true},
+		{"int", "int|null", false},
+		{"int|string", "int", true},
+		{"number", "int|float", true},
+		{"list<float>", "list<int>", true},
+		{"list<int>", "list<string>", false},
+		{"list<int>", "map<int, int>", false},
+		{"map<string, int>", "[a: int, b: int]", true},
+		{"map<string, int>", "[a: int, b: string]", false},
+		{"[a: int]", "[a: int, b: string]", true},
+		{"[a: int, b: string]", "[a: int]", false},
+		{"[a: int, b: string|null]", "[a: int]", true},
+	}
+	for _, test := range tests {
+		var ok = Assignable(MustParse(test.to), MustParse(test.from))
+		if ok != test.ok {
+			t.Errorf("Assignable(%v, %v) => %v, expected %v", test.to, test.from, ok, test.ok)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		typ   string
+		value interface{}
+		err   string
+	}{
+		{"int", 1, ""},
+		{"int", 1.0, ""},
+		{"int", 1.5, "expected int, got float"},
+		{"int", "1", "expected int, got string"},
+		{"int", nil, "expected int, got null"},
+		{"int", data.Undefined{}, "expected int, got null"},
+		{"int?", nil, ""},
+		{"float", 1, ""},
+		{"string", data.SanitizedHTML("<b>"), ""},
+		{"html", "a", ""},
+		{"html", data.SanitizedHTML("<b>"), ""},
+		{"html", data.SanitizedURI("/a"), "expected html, got uri"},
+		{"list<int>", []interface{}{1, 2, "3"}, "[2]: expected int, got string"},
+		{"map<string, bool>", map[string]interface{}{"a": true, "b": 1}, "[b]: expected bool, got int"},
+		{"[a: int, b: list<string>]", map[string]interface{}{"a": 1, "b": []string{"x"}}, ""},
+		{"[a: int, b: string]", map[string]interface{}{"a": 1}, ".b: expected string, got null"},
+		{"[a: [b: int]]", map[string]interface{}{"a": map[string]interface{}{"b": true}}, ".a.b: expected int, got bool"},
+		{"list<int>", map[string]interface{}{}, "expected list<int>, got map"},
+	}
+	for _, test := range tests {
+		var err = Validate(MustParse(test.typ), data.New(test.value))
+		var errStr string
+		if err != nil {
+			errStr = err.Error()
+		}
+		if errStr != test.err {
+			t.Errorf("Validate(%v, %v) => %q, expected %q", test.typ, test.value, errStr, test.err)
+		}
+	}
+}